@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"os"
 
 	"github.com/markliederbach/qrkdns/pkg/controllers"
@@ -15,6 +16,14 @@ var (
 	// Commands contains the base commands to attach to this CLI
 	Commands = []*cli.Command{
 		controllers.SyncCommand(),
+		controllers.StatusCommand(),
+		controllers.RecordsCommand(),
+		controllers.HistoryCommand(),
+		controllers.RollbackCommand(),
+		controllers.DoctorCommand(),
+		controllers.ServeCommand(),
+		controllers.ServeIPCommand(),
+		controllers.CtlCommand(),
 	}
 )
 
@@ -23,6 +32,10 @@ func main() {
 	err := app.Run(os.Args)
 	if err != nil {
 		log.Error(err)
+		var exitCoder cli.ExitCoder
+		if errors.As(err, &exitCoder) {
+			os.Exit(exitCoder.ExitCode())
+		}
 		os.Exit(1)
 	}
 	os.Exit(0)
@@ -7,8 +7,10 @@ require (
 	github.com/go-co-op/gocron v1.9.0
 	github.com/markliederbach/go-envy v0.1.0
 	github.com/onsi/gomega v1.16.0
+	github.com/oschwald/maxminddb-golang v1.3.1
 	github.com/sirupsen/logrus v1.8.1
 	github.com/urfave/cli/v2 v2.3.0
+	golang.org/x/net v0.0.0-20210825183410-e898025ed96a
 )
 
 require (
@@ -140,7 +142,6 @@ require (
 	github.com/uudashr/gocognit v1.0.5 // indirect
 	github.com/yeya24/promlinter v0.1.0 // indirect
 	golang.org/x/mod v0.5.0 // indirect
-	golang.org/x/net v0.0.0-20210825183410-e898025ed96a // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 	golang.org/x/sys v0.0.0-20210831042530-f4d43177bf5e // indirect
 	golang.org/x/text v0.3.7 // indirect
@@ -6,12 +6,27 @@ import (
 	sdk "github.com/cloudflare/cloudflare-go"
 )
 
+// AuthType labels the ways the client can authenticate with Cloudflare
+type AuthType string
+
+const (
+	// AuthTypeAPIToken authenticates with a scoped API token
+	AuthTypeAPIToken AuthType = "api-token"
+
+	// AuthTypeAPIKey authenticates with the Global API Key and the
+	// account's email address
+	AuthTypeAPIKey AuthType = "api-key"
+)
+
 // SDKClient wraps the SDK client for Cloudflare
 type SDKClient interface {
-	ZoneIDByName(zoneName string) (string, error)
+	ListZonesContext(ctx context.Context, opts ...sdk.ReqOption) (sdk.ZonesResponse, error)
 	DNSRecords(ctx context.Context, zoneID string, rr sdk.DNSRecord) ([]sdk.DNSRecord, error)
 	DNSRecord(ctx context.Context, zoneID string, recordID string) (sdk.DNSRecord, error)
 	CreateDNSRecord(ctx context.Context, zoneID string, rr sdk.DNSRecord) (*sdk.DNSRecordResponse, error)
 	UpdateDNSRecord(ctx context.Context, zoneID string, recordID string, rr sdk.DNSRecord) error
 	DeleteDNSRecord(ctx context.Context, zoneID string, recordID string) error
+	VerifyAPIToken(ctx context.Context) (sdk.APITokenVerifyBody, error)
+	UserDetails(ctx context.Context) (sdk.User, error)
+	ZoneDetails(ctx context.Context, zoneID string) (sdk.Zone, error)
 }
@@ -3,6 +3,7 @@ package cloudflare
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	sdk "github.com/cloudflare/cloudflare-go"
 	"github.com/markliederbach/qrkdns/pkg/clients/dns"
@@ -16,7 +17,9 @@ var (
 // DefaultClient implements the cloudflare client
 type DefaultClient struct {
 	// Client *sdk.API
-	Client     SDKClient
+	Client   SDKClient
+	AuthType AuthType
+	// AccountID scopes the zone lookup to a single account
 	AccountID  string
 	DomainName string
 	ZoneID     string
@@ -25,29 +28,75 @@ type DefaultClient struct {
 // LoadOption allows for modifying the client after it's created
 type LoadOption func(client *DefaultClient) error
 
-// WithTokenLoader is a load option for initializing the client with a token
-func withTokenLoader(token string) LoadOption {
-	loadOption := func(client *DefaultClient) error {
+// WithAPIToken is a load option authenticating with a scoped API token
+func WithAPIToken(token string) LoadOption {
+	return func(client *DefaultClient) error {
 		cloudflareClient, err := sdk.NewWithAPIToken(token)
 		if err != nil {
 			return err
 		}
 		client.Client = cloudflareClient
+		client.AuthType = AuthTypeAPIToken
+		return nil
+	}
+}
+
+// WithAPIKey is a load option authenticating with the Global API Key and
+// the email address of the account, for accounts without API tokens
+func WithAPIKey(key, email string) LoadOption {
+	return func(client *DefaultClient) error {
+		cloudflareClient, err := sdk.New(key, email)
+		if err != nil {
+			return err
+		}
+		client.Client = cloudflareClient
+		client.AuthType = AuthTypeAPIKey
+		return nil
+	}
+}
+
+// WithZoneID is a load option skipping the zone lookup, for tokens that
+// can edit DNS records without being able to read the zone
+func WithZoneID(zoneID string) LoadOption {
+	return func(client *DefaultClient) error {
+		client.ZoneID = zoneID
 		return nil
 	}
-	return loadOption
 }
 
 // NewClientWithToken is an initializer specifically for using an API token
 func NewClientWithToken(ctx context.Context, accountID, domain, token string, opts ...LoadOption) (*DefaultClient, error) {
-	newOpts := []LoadOption{withTokenLoader(token)}
-	newOpts = append(newOpts, opts...)
-	return newClient(ctx, accountID, domain, newOpts...)
+	return NewClient(ctx, accountID, domain, append([]LoadOption{WithAPIToken(token)}, opts...)...)
+}
+
+// NewClientWithAPIKey is an initializer specifically for using the Global
+// API Key and email
+func NewClientWithAPIKey(ctx context.Context, accountID, domain, key, email string, opts ...LoadOption) (*DefaultClient, error) {
+	return NewClient(ctx, accountID, domain, append([]LoadOption{WithAPIKey(key, email)}, opts...)...)
 }
 
-// newClient returns a new cloudflare client based on credentials
-func newClient(ctx context.Context, accountID, domain string, opts ...LoadOption) (*DefaultClient, error) {
-	client := DefaultClient{
+// NewClient returns a new cloudflare client, authenticated by one of the
+// options, and looks up the zone ID
+func NewClient(ctx context.Context, accountID, domain string, opts ...LoadOption) (*DefaultClient, error) {
+	client, err := NewLazyClient(accountID, domain, opts...)
+	if err != nil {
+		return &DefaultClient{}, err
+	}
+
+	// Preload Zone ID
+	_, err = client.GetZoneID(ctx)
+	if err != nil {
+		return &DefaultClient{}, err
+	}
+
+	return client, nil
+}
+
+// NewLazyClient is like NewClient, but doesn't look up the zone ID until
+// it's needed, so that the client can be built to diagnose credentials
+// that can't see the zone
+func NewLazyClient(accountID, domain string, opts ...LoadOption) (*DefaultClient, error) {
+	client := &DefaultClient{
 		Client:     &sdk.API{},
 		AccountID:  accountID,
 		DomainName: domain,
@@ -55,38 +104,63 @@ func newClient(ctx context.Context, accountID, domain string, opts ...LoadOption
 	}
 
 	for _, opt := range opts {
-		if err := opt(&client); err != nil {
+		if err := opt(client); err != nil {
 			return &DefaultClient{}, err
 		}
 	}
 
-	// Preload Zone ID
-	_, err := client.GetZoneID(ctx)
+	// Cloudflare stores internationalized names in their A-label form
+	domainName, err := dns.ToASCII(domain)
 	if err != nil {
 		return &DefaultClient{}, err
 	}
-
-	return &client, nil
+	client.DomainName = domainName
+	return client, nil
 }
 
-// GetZoneID returns and caches the Zone ID for the current client
+// GetZoneID returns and caches the Zone ID for the current client. The
+// lookup is scoped to the client's account, if any, and fails rather than
+// guessing when zones of the same name exist in several accounts.
 func (c *DefaultClient) GetZoneID(ctx context.Context) (string, error) {
 	if c.ZoneID != "" {
 		return c.ZoneID, nil
 	}
 
-	zoneID, err := c.Client.ZoneIDByName(c.DomainName)
+	response, err := c.Client.ListZonesContext(ctx, sdk.WithZoneFilters(c.DomainName, c.AccountID, ""))
 	if err != nil {
 		return "", err
 	}
 
-	c.ZoneID = zoneID
-	return c.ZoneID, nil
+	switch len(response.Result) {
+	case 0:
+		if c.AccountID != "" {
+			return "", fmt.Errorf("zone %v not found in account %v", c.DomainName, c.AccountID)
+		}
+		return "", fmt.Errorf("zone %v not found", c.DomainName)
+	case 1:
+		c.ZoneID = response.Result[0].ID
+		return c.ZoneID, nil
+	default:
+		accounts := []string{}
+		for _, zone := range response.Result {
+			accounts = append(accounts, zone.Account.ID)
+		}
+		return "", fmt.Errorf(
+			"zone %v exists in several accounts (%v), set the account ID to choose one",
+			c.DomainName,
+			strings.Join(accounts, ", "),
+		)
+	}
 }
 
 // ListDNSARecords returns all DNS records for the provided subdomain
 func (c *DefaultClient) ListDNSARecords(ctx context.Context, subdomain string) ([]sdk.DNSRecord, error) {
-	records, err := c.Client.DNSRecords(ctx, c.ZoneID, sdk.DNSRecord{Type: string(dns.RecordTypeA), Name: fqdn(subdomain, c.DomainName)})
+	subdomain, err := dns.ToASCII(subdomain)
+	if err != nil {
+		return []sdk.DNSRecord{}, err
+	}
+
+	records, err := c.Client.DNSRecords(ctx, c.ZoneID, sdk.DNSRecord{Type: string(dns.RecordTypeA), Name: dns.FQDN(subdomain, c.DomainName)})
 	if err != nil {
 		return []sdk.DNSRecord{}, err
 	}
@@ -94,6 +168,59 @@ func (c *DefaultClient) ListDNSARecords(ctx context.Context, subdomain string) (
 	return records, nil
 }
 
+// GetDNSARecords returns the A records currently published for the subdomain
+func (c *DefaultClient) GetDNSARecords(ctx context.Context, subdomain string) ([]dns.Record, error) {
+	sdkRecords, err := c.ListDNSARecords(ctx, subdomain)
+	if err != nil {
+		return []dns.Record{}, err
+	}
+	return ConvertDNSRecordList(sdkRecords), nil
+}
+
+// ListRecords returns every record in the zone matching the filter
+func (c *DefaultClient) ListRecords(ctx context.Context, filter dns.RecordFilter) ([]dns.Record, error) {
+	name, err := dns.ToASCII(filter.Name)
+	if err != nil {
+		return []dns.Record{}, err
+	}
+
+	records, err := c.Client.DNSRecords(ctx, c.ZoneID, sdk.DNSRecord{Type: string(filter.Type), Name: name})
+	if err != nil {
+		return []dns.Record{}, err
+	}
+
+	return ConvertDNSRecordList(records), nil
+}
+
+// CreateRecord creates a record of any type
+func (c *DefaultClient) CreateRecord(ctx context.Context, record dns.Record) (dns.Record, error) {
+	name, err := dns.ToASCII(record.Name)
+	if err != nil {
+		return dns.Record{}, err
+	}
+	record.Name = name
+	return c.CreateDNSARecord(ctx, record)
+}
+
+// UpdateRecord modifies a record of any type in place, keeping its ID
+func (c *DefaultClient) UpdateRecord(ctx context.Context, record dns.Record) (dns.Record, error) {
+	name, err := dns.ToASCII(record.Name)
+	if err != nil {
+		return dns.Record{}, err
+	}
+	record.Name = name
+	err = c.UpdateDNSARecord(ctx, record.ID, record)
+	if err != nil {
+		return dns.Record{}, err
+	}
+	return record, nil
+}
+
+// DeleteRecord deletes a record of any type
+func (c *DefaultClient) DeleteRecord(ctx context.Context, record dns.Record) error {
+	return c.DeleteDNSARecord(ctx, record)
+}
+
 // GetDNSRecord retrieves a DNS record by ID
 func (c *DefaultClient) GetDNSRecord(ctx context.Context, recordID string) (dns.Record, error) {
 	response, err := c.Client.DNSRecord(ctx, c.ZoneID, recordID)
@@ -136,17 +263,29 @@ func (c *DefaultClient) DeleteDNSARecord(ctx context.Context, record dns.Record)
 
 // ApplyDNSARecord creates or updates a DNS record without creating a duplicate. It will also delete
 // other A records for the domain that don't match the provided IP address
-func (c *DefaultClient) ApplyDNSARecord(ctx context.Context, subdomain, ipAddress string) (dns.Record, error) {
+func (c *DefaultClient) ApplyDNSARecord(ctx context.Context, subdomain, ipAddress string) (dns.ApplyResult, error) {
+	subdomain, err := dns.ToASCII(subdomain)
+	if err != nil {
+		return dns.ApplyResult{}, err
+	}
+
 	expectedRecord := BuildDNSARecord(subdomain, c.DomainName, ipAddress)
-	contextLog := log.WithField("expected_record", expectedRecord)
+	contextLog := log.WithFields(log.Fields{
+		"expected_record": expectedRecord,
+		"name":            dns.ToUnicode(expectedRecord.Name),
+	})
 
 	sdkRecords, err := c.ListDNSARecords(ctx, subdomain)
 	if err != nil {
-		return dns.Record{}, err
+		return dns.ApplyResult{}, err
 	}
 
 	existingRecords := ConvertDNSRecordList(sdkRecords)
 
+	result := dns.ApplyResult{
+		Previous: existingRecords,
+		Deleted:  []dns.Record{},
+	}
 	chosenRecord := dns.Record{}
 
 	// First, look for any record with a matching IP address because
@@ -169,13 +308,14 @@ func (c *DefaultClient) ApplyDNSARecord(ctx context.Context, subdomain, ipAddres
 			contextLog.Debugf("Updating record")
 			err = c.UpdateDNSARecord(ctx, chosenRecord.ID, expectedRecord)
 			if err != nil {
-				return dns.Record{}, err
+				return dns.ApplyResult{}, err
 			}
+			result.Updated = true
 
 			// Update local copy of record
 			chosenRecord, err = c.GetDNSRecord(ctx, chosenRecord.ID)
 			if err != nil {
-				return dns.Record{}, err
+				return dns.ApplyResult{}, err
 			}
 		} else {
 			contextLog.Debugf("Record is already up to date")
@@ -185,8 +325,9 @@ func (c *DefaultClient) ApplyDNSARecord(ctx context.Context, subdomain, ipAddres
 		contextLog.Debugf("Creating new record")
 		chosenRecord, err = c.CreateDNSARecord(ctx, expectedRecord)
 		if err != nil {
-			return dns.Record{}, err
+			return dns.ApplyResult{}, err
 		}
+		result.Created = true
 		contextLog = contextLog.WithField("chosen_record", chosenRecord)
 	}
 
@@ -200,29 +341,27 @@ func (c *DefaultClient) ApplyDNSARecord(ctx context.Context, subdomain, ipAddres
 		contextLog.WithField("existing_record", record).Debugf("Deleting extra record")
 		err = c.DeleteDNSARecord(ctx, record)
 		if err != nil {
-			return dns.Record{}, err
+			return dns.ApplyResult{}, err
 		}
+		result.Deleted = append(result.Deleted, record)
 	}
 
-	return chosenRecord, nil
+	result.Record = chosenRecord
+	return result, nil
 }
 
-// BuildDNSARecord constructs a consistent DNS record across the client
+// BuildDNSARecord constructs a consistent DNS record across the client. The
+// names are expected in their A-label form.
 func BuildDNSARecord(subdomain, domainName, ipAddress string) dns.Record {
 	return dns.Record{
 		Type:    dns.RecordTypeA,
-		Name:    fqdn(subdomain, domainName),
+		Name:    dns.FQDN(subdomain, domainName),
 		Content: ipAddress,
 		TTL:     1,
 		Proxied: false,
 	}
 }
 
-// fqdn concatenates a subdomain name with the base domain and returns the FQDN
-func fqdn(subdomain, domainName string) string {
-	return fmt.Sprintf("%v.%v", subdomain, domainName)
-}
-
 // ConvertDNSRecordList converts a list of Cloudflare DNS records to
 // locally-managed DNS Records
 func ConvertDNSRecordList(sdkRecords []sdk.DNSRecord) []dns.Record {
@@ -242,7 +381,7 @@ func FromCloudFlareDNSRecord(record sdk.DNSRecord) dns.Record {
 		Name:    record.Name,
 		Content: record.Content,
 		TTL:     record.TTL,
-		Proxied: *record.Proxied,
+		Proxied: record.Proxied != nil && *record.Proxied,
 	}
 }
 
@@ -8,6 +8,7 @@ import (
 	sdk "github.com/cloudflare/cloudflare-go"
 	"github.com/markliederbach/go-envy"
 	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
 	"github.com/markliederbach/qrkdns/pkg/mocks"
 	. "github.com/onsi/gomega"
 )
@@ -45,6 +46,81 @@ func TestFile(t *testing.T) {
 				g.Expect(records).To(Equal(mocks.DefaultDNSRecords))
 			},
 		},
+		{
+			testCase: "gets provider-neutral dns records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				ctx := context.Background()
+
+				client, err := cloudflare.NewClientWithToken(
+					ctx,
+					"account1234",
+					"foo.net",
+					"token1234",
+					withMockSDKClient,
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				records, err := client.GetDNSARecords(ctx, "bar")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(records).To(Equal(cloudflare.ConvertDNSRecordList(mocks.DefaultDNSRecords)))
+
+				err = envy.AddErrorReturns("DNSRecords", fmt.Errorf("nope"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				_, err = client.GetDNSARecords(ctx, "bar")
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
+		{
+			testCase: "lists, creates, updates and deletes records of any type",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				ctx := context.Background()
+
+				client, err := cloudflare.NewClientWithToken(
+					ctx,
+					"account1234",
+					"foo.net",
+					"token1234",
+					withMockSDKClient,
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				records, err := client.ListRecords(ctx, dns.RecordFilter{Type: dns.RecordTypeTXT})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(records).To(Equal(cloudflare.ConvertDNSRecordList(mocks.DefaultDNSRecords)))
+
+				record, err := client.CreateRecord(ctx, records[0])
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(record).To(Equal(records[0]))
+
+				record.Content = "owner=other"
+				updated, err := client.UpdateRecord(ctx, record)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(updated).To(Equal(record))
+
+				err = envy.AddErrorReturns("UpdateDNSRecord", fmt.Errorf("nope"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				_, err = client.UpdateRecord(ctx, record)
+				g.Expect(err).To(MatchError("nope"))
+
+				err = envy.AddErrorReturns("DeleteDNSRecord", fmt.Errorf("nope"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.DeleteRecord(ctx, record)
+				g.Expect(err).To(MatchError("nope"))
+
+				err = envy.AddErrorReturns("DNSRecords", fmt.Errorf("nope"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				_, err = client.ListRecords(ctx, dns.RecordFilter{})
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
 		{
 			testCase: "returns cached zone ID",
 			runner: func(tt *testing.T) {
@@ -52,7 +128,7 @@ func TestFile(t *testing.T) {
 
 				ctx := context.Background()
 
-				err := envy.AddObjectReturns("ZoneIDByName", "newzone")
+				err := envy.AddObjectReturns("ListZonesContext", sdk.ZonesResponse{Result: []sdk.Zone{{ID: "newzone"}}})
 				g.Expect(err).NotTo(HaveOccurred())
 
 				client, err := cloudflare.NewClientWithToken(
@@ -70,6 +146,71 @@ func TestFile(t *testing.T) {
 				g.Expect(zoneID).To(Equal("newzone"))
 			},
 		},
+		{
+			testCase: "scopes the zone lookup to the account",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				ctx := context.Background()
+
+				g.Expect(envy.AddObjectReturns("ListZonesContext", sdk.ZonesResponse{})).To(Succeed())
+				_, err := cloudflare.NewClientWithToken(ctx, "account1234", "foo.net", "token1234", withMockSDKClient)
+				g.Expect(err).To(MatchError("zone foo.net not found in account account1234"))
+
+				g.Expect(envy.AddObjectReturns("ListZonesContext", sdk.ZonesResponse{})).To(Succeed())
+				_, err = cloudflare.NewClientWithToken(ctx, "", "foo.net", "token1234", withMockSDKClient)
+				g.Expect(err).To(MatchError("zone foo.net not found"))
+
+				// Zones of the same name in several accounts aren't guessed
+				g.Expect(envy.AddObjectReturns("ListZonesContext", sdk.ZonesResponse{Result: []sdk.Zone{
+					{ID: "zone1", Account: sdk.Account{ID: "account1"}},
+					{ID: "zone2", Account: sdk.Account{ID: "account2"}},
+				}})).To(Succeed())
+				_, err = cloudflare.NewClientWithToken(ctx, "", "foo.net", "token1234", withMockSDKClient)
+				g.Expect(err).To(MatchError("zone foo.net exists in several accounts (account1, account2), set the account ID to choose one"))
+			},
+		},
+		{
+			testCase: "uses an explicit zone ID without looking it up",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				ctx := context.Background()
+
+				// Would otherwise fail the lookup
+				g.Expect(envy.AddErrorReturns("ListZonesContext", fmt.Errorf("no no no"))).To(Succeed())
+				client, err := cloudflare.NewClientWithToken(ctx, "account1234", "foo.net", "token1234", withMockSDKClient, cloudflare.WithZoneID("zone5678"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.ZoneID).To(Equal("zone5678"))
+
+				// Drain the unused error
+				_, err = client.Client.ListZonesContext(ctx)
+				g.Expect(err).To(MatchError("no no no"))
+			},
+		},
+		{
+			testCase: "authenticates with the Global API Key",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				ctx := context.Background()
+
+				client, err := cloudflare.NewLazyClient("account1234", "foo.net", cloudflare.WithAPIKey("key1234", "user@foo.net"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.AuthType).To(Equal(cloudflare.AuthTypeAPIKey))
+				g.Expect(client.Client.(*sdk.API).APIKey).To(Equal("key1234"))
+				g.Expect(client.Client.(*sdk.API).APIEmail).To(Equal("user@foo.net"))
+
+				client, err = cloudflare.NewClientWithAPIKey(ctx, "account1234", "foo.net", "key1234", "user@foo.net", withMockSDKClient)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.AuthType).To(Equal(cloudflare.AuthTypeAPIKey))
+				g.Expect(client.ZoneID).To(Equal(mocks.DefaultZoneID))
+
+				// Both the key and the email are required
+				_, err = cloudflare.NewClientWithAPIKey(ctx, "account1234", "foo.net", "key1234", "", withMockSDKClient)
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
 		{
 			testCase: "load option returns error",
 			runner: func(tt *testing.T) {
@@ -112,7 +253,7 @@ func TestFile(t *testing.T) {
 
 				ctx := context.Background()
 
-				err := envy.AddErrorReturns("ZoneIDByName", fmt.Errorf("no no no"))
+				err := envy.AddErrorReturns("ListZonesContext", fmt.Errorf("no no no"))
 				g.Expect(err).NotTo(HaveOccurred())
 
 				_, err = cloudflare.NewClientWithToken(
@@ -180,9 +321,71 @@ func TestFile(t *testing.T) {
 				)
 				g.Expect(err).NotTo(HaveOccurred())
 
-				record, err := client.ApplyDNSARecord(ctx, "bar", "1.2.3.4")
+				result, err := client.ApplyDNSARecord(ctx, "bar", "1.2.3.4")
 				g.Expect(err).NotTo(HaveOccurred())
-				g.Expect(record).To(Equal(expectedRecord))
+				g.Expect(result.Record).To(Equal(expectedRecord))
+				g.Expect(result.Created).To(BeTrue())
+				g.Expect(result.Changed()).To(BeTrue())
+			},
+		},
+		{
+			testCase: "normalizes internationalized names",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				ctx := context.Background()
+
+				client, err := cloudflare.NewClientWithToken(ctx, "account1234", "Bücher.example", "token1234", withMockSDKClient)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.DomainName).To(Equal("xn--bcher-kva.example"))
+
+				// Cloudflare returns the A-label form of the name
+				existing := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("xn--br-via", "xn--bcher-kva.example", "1.2.3.4"))
+				existing.ID = "1234"
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{existing})).To(Succeed())
+
+				result, err := client.ApplyDNSARecord(ctx, "BÄR", "1.2.3.4")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Changed()).To(BeFalse())
+				g.Expect(result.Record.Name).To(Equal("xn--br-via.xn--bcher-kva.example"))
+			},
+		},
+		{
+			testCase: "returns errors for invalid internationalized names",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				ctx := context.Background()
+
+				_, err := cloudflare.NewLazyClient("account1234", "xn--zz.example", withMockSDKClient)
+				g.Expect(err).To(MatchError(ContainSubstring("invalid name xn--zz.example: label xn--zz can't be converted to an A-label")))
+
+				client, err := cloudflare.NewLazyClient("account1234", "foo.net", withMockSDKClient, cloudflare.WithZoneID("zone1234"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				_, err = client.ApplyDNSARecord(ctx, "xn--zz", "1.2.3.4")
+				g.Expect(err).To(MatchError(ContainSubstring("invalid name xn--zz")))
+
+				_, err = client.GetDNSARecords(ctx, "xn--zz")
+				g.Expect(err).To(MatchError(ContainSubstring("invalid name xn--zz")))
+
+				_, err = client.ListRecords(ctx, dns.RecordFilter{Name: "xn--zz.foo.net"})
+				g.Expect(err).To(MatchError(ContainSubstring("invalid name xn--zz.foo.net")))
+
+				_, err = client.CreateRecord(ctx, dns.Record{Type: dns.RecordTypeTXT, Name: "xn--zz.foo.net"})
+				g.Expect(err).To(MatchError(ContainSubstring("invalid name xn--zz.foo.net")))
+
+				_, err = client.UpdateRecord(ctx, dns.Record{Type: dns.RecordTypeA, Name: "xn--zz.foo.net"})
+				g.Expect(err).To(MatchError(ContainSubstring("invalid name xn--zz.foo.net")))
+			},
+		},
+		{
+			testCase: "builds records for the apex and wildcards",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				g.Expect(cloudflare.BuildDNSARecord("@", "foo.net", "1.2.3.4").Name).To(Equal("foo.net"))
+				g.Expect(cloudflare.BuildDNSARecord("*.office", "foo.net", "1.2.3.4").Name).To(Equal("*.office.foo.net"))
 			},
 		},
 		{
@@ -221,12 +424,15 @@ func TestFile(t *testing.T) {
 				)
 				g.Expect(err).NotTo(HaveOccurred())
 
-				record, err := client.ApplyDNSARecord(ctx, "bar", "1.2.3.4")
+				result, err := client.ApplyDNSARecord(ctx, "bar", "1.2.3.4")
 				g.Expect(err).NotTo(HaveOccurred())
 
 				// Mock won't actually update IP, so we just
 				// expect the mocked value we passed in
-				g.Expect(record).To(Equal(updateRecord))
+				g.Expect(result.Record).To(Equal(updateRecord))
+				g.Expect(result.Updated).To(BeTrue())
+				g.Expect(result.Deleted).To(Equal([]dns.Record{deleteRecord}))
+				g.Expect(result.OldContent()).To(Equal("4.3.2.1"))
 			},
 		},
 		{
@@ -257,9 +463,12 @@ func TestFile(t *testing.T) {
 				)
 				g.Expect(err).NotTo(HaveOccurred())
 
-				record, err := client.ApplyDNSARecord(ctx, "bar", "1.2.3.4")
+				result, err := client.ApplyDNSARecord(ctx, "bar", "1.2.3.4")
 				g.Expect(err).NotTo(HaveOccurred())
-				g.Expect(record).To(Equal(equalRecord))
+				g.Expect(result.Record).To(Equal(equalRecord))
+				g.Expect(result.Updated).To(BeFalse())
+				g.Expect(result.Created).To(BeFalse())
+				g.Expect(result.Previous).To(HaveLen(2))
 			},
 		},
 		{
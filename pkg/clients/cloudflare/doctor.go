@@ -0,0 +1,190 @@
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/qrkdns/pkg/clients/doctor"
+)
+
+const (
+	// permissionDNSEdit lets a token change the zone's DNS records
+	permissionDNSEdit string = "#dns_records:edit"
+
+	// tokenRemedy tells where to fix a token's configuration
+	tokenRemedy string = "Edit the token at https://dash.cloudflare.com/profile/api-tokens"
+)
+
+var (
+	// Assert client matches the correct interface
+	_ doctor.Diagnoser = &DefaultClient{}
+)
+
+// Diagnose implements doctor.Diagnoser. It verifies the credentials, then
+// checks that they can see the zone, read and edit its DNS records, and
+// that the zone belongs to the configured account. Checks depending on a
+// failed one are skipped.
+func (c *DefaultClient) Diagnose(ctx context.Context) []doctor.Check {
+	checks := []doctor.Check{c.checkCredentials(ctx)}
+	if checks[0].Status == doctor.StatusFail {
+		return append(checks, skipped("Zone visibility", "DNS read permission", "DNS edit permission", "Account ownership")...)
+	}
+
+	zone, check := c.checkZone(ctx)
+	checks = append(checks, check)
+	if check.Status == doctor.StatusFail {
+		return append(checks, skipped("DNS read permission", "DNS edit permission", "Account ownership")...)
+	}
+
+	return append(checks,
+		c.checkDNSRead(ctx, zone),
+		checkEditPermission(zone),
+		c.checkAccount(zone),
+	)
+}
+
+// checkCredentials verifies the token, or the Global API Key
+func (c *DefaultClient) checkCredentials(ctx context.Context) doctor.Check {
+	if c.AuthType == AuthTypeAPIKey {
+		check := doctor.Check{Name: "Cloudflare API key"}
+		user, err := c.Client.UserDetails(ctx)
+		if err != nil {
+			check.Status = doctor.StatusFail
+			check.Message = fmt.Sprintf("the API key couldn't be verified: %v", err)
+			check.Remedy = "Check --cf-api-key and --cf-api-email. The email must be the one of the account owning the key"
+			return check
+		}
+		check.Status = doctor.StatusPass
+		check.Message = fmt.Sprintf("the API key belongs to %v", user.Email)
+		return check
+	}
+
+	check := doctor.Check{Name: "Cloudflare token"}
+	token, err := c.Client.VerifyAPIToken(ctx)
+	if err != nil {
+		check.Status = doctor.StatusFail
+		check.Message = fmt.Sprintf("the token couldn't be verified: %v", err)
+		check.Remedy = "Check --cf-api-token. A Global API Key goes in --cf-api-key, along with --cf-api-email"
+		return check
+	}
+	if token.Status != "active" {
+		check.Status = doctor.StatusFail
+		check.Message = fmt.Sprintf("the token is %v", token.Status)
+		check.Remedy = tokenRemedy
+		return check
+	}
+
+	check.Status = doctor.StatusPass
+	check.Message = "the token is active"
+	if !token.ExpiresOn.IsZero() {
+		check.Message = fmt.Sprintf("the token is active until %v", token.ExpiresOn.UTC().Format("2006-01-02 15:04:05 MST"))
+	}
+	return check
+}
+
+// checkZone checks that the credentials can see the zone of the domain.
+// A zone ID set explicitly only needs to be readable to check the account.
+func (c *DefaultClient) checkZone(ctx context.Context) (sdk.Zone, doctor.Check) {
+	explicit := c.ZoneID != ""
+	check := doctor.Check{
+		Name:   "Zone visibility",
+		Status: doctor.StatusFail,
+		Remedy: fmt.Sprintf("Add the %v zone to the token's Zone Resources, or set --cf-zone-id. %v", c.DomainName, tokenRemedy),
+	}
+	zoneID, err := c.GetZoneID(ctx)
+	if err != nil {
+		check.Message = fmt.Sprintf("the %v zone isn't visible: %v", c.DomainName, err)
+		return sdk.Zone{}, check
+	}
+	zone, err := c.Client.ZoneDetails(ctx, zoneID)
+	if err != nil && explicit {
+		return sdk.Zone{ID: zoneID, Name: c.DomainName}, doctor.Check{
+			Name:    "Zone visibility",
+			Status:  doctor.StatusWarn,
+			Message: fmt.Sprintf("using zone ID %v, whose details couldn't be read: %v", zoneID, err),
+		}
+	}
+	if err != nil {
+		check.Message = fmt.Sprintf("the details of the %v zone couldn't be read: %v", c.DomainName, err)
+		return sdk.Zone{}, check
+	}
+
+	return zone, doctor.Check{
+		Name:    "Zone visibility",
+		Status:  doctor.StatusPass,
+		Message: fmt.Sprintf("found the %v zone (%v)", zone.Name, zone.ID),
+	}
+}
+
+// checkDNSRead checks that the records of the zone can be listed
+func (c *DefaultClient) checkDNSRead(ctx context.Context, zone sdk.Zone) doctor.Check {
+	if _, err := c.Client.DNSRecords(ctx, zone.ID, sdk.DNSRecord{Type: "A"}); err != nil {
+		return doctor.Check{
+			Name:    "DNS read permission",
+			Status:  doctor.StatusFail,
+			Message: fmt.Sprintf("the DNS records of %v couldn't be listed: %v", zone.Name, err),
+			Remedy:  fmt.Sprintf("Grant the token the Zone / DNS / Read permission. %v", tokenRemedy),
+		}
+	}
+	return doctor.Check{
+		Name:    "DNS read permission",
+		Status:  doctor.StatusPass,
+		Message: fmt.Sprintf("the token can read the DNS records of %v", zone.Name),
+	}
+}
+
+// checkEditPermission looks for the permission to edit DNS records among
+// those the zone reports for the credentials
+func checkEditPermission(zone sdk.Zone) doctor.Check {
+	check := doctor.Check{Name: "DNS edit permission"}
+	if len(zone.Permissions) == 0 {
+		check.Status = doctor.StatusWarn
+		check.Message = fmt.Sprintf("Cloudflare didn't report the token's permissions on %v", zone.Name)
+		return check
+	}
+	for _, granted := range zone.Permissions {
+		if granted == permissionDNSEdit {
+			check.Status = doctor.StatusPass
+			check.Message = fmt.Sprintf("the token can edit the DNS records of %v", zone.Name)
+			return check
+		}
+	}
+	check.Status = doctor.StatusFail
+	check.Message = fmt.Sprintf("the token can't edit the DNS records of %v (granted: %v)", zone.Name, strings.Join(zone.Permissions, ", "))
+	check.Remedy = fmt.Sprintf("Grant the token the Zone / DNS / Edit permission. %v", tokenRemedy)
+	return check
+}
+
+// checkAccount checks that the zone belongs to the configured account
+func (c *DefaultClient) checkAccount(zone sdk.Zone) doctor.Check {
+	check := doctor.Check{Name: "Account ownership"}
+	if zone.Account.ID == "" {
+		check.Status = doctor.StatusWarn
+		check.Message = fmt.Sprintf("the account owning zone %v couldn't be read", zone.ID)
+		return check
+	}
+	if zone.Account.ID != c.AccountID {
+		check.Status = doctor.StatusFail
+		check.Message = fmt.Sprintf("the %v zone belongs to account %v, not %v", zone.Name, zone.Account.ID, c.AccountID)
+		check.Remedy = fmt.Sprintf("Set --cf-account-id to %v", zone.Account.ID)
+		return check
+	}
+	check.Status = doctor.StatusPass
+	check.Message = fmt.Sprintf("the %v zone belongs to account %v", zone.Name, c.AccountID)
+	return check
+}
+
+// skipped returns skipped checks
+func skipped(names ...string) []doctor.Check {
+	checks := []doctor.Check{}
+	for _, name := range names {
+		checks = append(checks, doctor.Check{
+			Name:    name,
+			Status:  doctor.StatusSkip,
+			Message: "skipped because an earlier check failed",
+		})
+	}
+	return checks
+}
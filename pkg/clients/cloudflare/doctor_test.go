@@ -0,0 +1,159 @@
+package cloudflare_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/doctor"
+	. "github.com/onsi/gomega"
+)
+
+// statuses returns the status of each check
+func statuses(checks []doctor.Check) []doctor.Status {
+	result := []doctor.Status{}
+	for _, check := range checks {
+		result = append(result, check.Status)
+	}
+	return result
+}
+
+func TestDiagnose(t *testing.T) {
+	ctx := context.Background()
+	pass, fail, warn, skip := doctor.StatusPass, doctor.StatusFail, doctor.StatusWarn, doctor.StatusSkip
+
+	newClient := func(g *WithT) *cloudflare.DefaultClient {
+		client, err := cloudflare.NewLazyClient("foo", "foo.net", cloudflare.WithAPIToken("token1234"), withMockSDKClient)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(client.ZoneID).To(BeEmpty())
+		return client
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "passes with a valid token",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				checks := newClient(g).Diagnose(ctx)
+				g.Expect(statuses(checks)).To(Equal([]doctor.Status{pass, pass, pass, pass, pass}))
+				g.Expect(checks[0].Message).To(Equal("the token is active"))
+				g.Expect(checks[1].Message).To(Equal("found the foo.net zone (zone1234)"))
+				g.Expect(checks[4].Message).To(Equal("the foo.net zone belongs to account foo"))
+
+				expires := time.Date(2027, 1, 2, 3, 4, 5, 0, time.UTC)
+				g.Expect(envy.AddObjectReturns("VerifyAPIToken", sdk.APITokenVerifyBody{Status: "active", ExpiresOn: expires})).To(Succeed())
+				checks = newClient(g).Diagnose(ctx)
+				g.Expect(checks[0].Message).To(Equal("the token is active until 2027-01-02 03:04:05 UTC"))
+			},
+		},
+		{
+			testCase: "fails with an invalid token",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				g.Expect(envy.AddErrorReturns("VerifyAPIToken", errors.New("Invalid API Token (1000)"))).To(Succeed())
+				checks := newClient(g).Diagnose(ctx)
+				g.Expect(statuses(checks)).To(Equal([]doctor.Status{fail, skip, skip, skip, skip}))
+				g.Expect(checks[0].Message).To(Equal("the token couldn't be verified: Invalid API Token (1000)"))
+
+				g.Expect(envy.AddObjectReturns("VerifyAPIToken", sdk.APITokenVerifyBody{Status: "disabled"})).To(Succeed())
+				checks = newClient(g).Diagnose(ctx)
+				g.Expect(statuses(checks)).To(Equal([]doctor.Status{fail, skip, skip, skip, skip}))
+				g.Expect(checks[0].Message).To(Equal("the token is disabled"))
+			},
+		},
+		{
+			testCase: "fails when the zone isn't visible",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				g.Expect(envy.AddErrorReturns("ListZonesContext", errors.New("Zone could not be found"))).To(Succeed())
+				checks := newClient(g).Diagnose(ctx)
+				g.Expect(statuses(checks)).To(Equal([]doctor.Status{pass, fail, skip, skip, skip}))
+				g.Expect(checks[1].Message).To(Equal("the foo.net zone isn't visible: Zone could not be found"))
+				g.Expect(checks[1].Remedy).To(ContainSubstring("Zone Resources"))
+
+				g.Expect(envy.AddErrorReturns("ZoneDetails", errors.New("nope"))).To(Succeed())
+				checks = newClient(g).Diagnose(ctx)
+				g.Expect(statuses(checks)).To(Equal([]doctor.Status{pass, fail, skip, skip, skip}))
+				g.Expect(checks[1].Message).To(Equal("the details of the foo.net zone couldn't be read: nope"))
+			},
+		},
+		{
+			testCase: "checks DNS permissions and the account",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				readOnly := sdk.Zone{ID: "zone1234", Name: "foo.net", Permissions: []string{"#dns_records:read"}, Account: sdk.Account{ID: "bar"}}
+				g.Expect(envy.AddObjectReturns("ZoneDetails", readOnly)).To(Succeed())
+				checks := newClient(g).Diagnose(ctx)
+				g.Expect(statuses(checks)).To(Equal([]doctor.Status{pass, pass, pass, fail, fail}))
+				g.Expect(checks[3].Message).To(Equal("the token can't edit the DNS records of foo.net (granted: #dns_records:read)"))
+				g.Expect(checks[4].Message).To(Equal("the foo.net zone belongs to account bar, not foo"))
+				g.Expect(checks[4].Remedy).To(Equal("Set --cf-account-id to bar"))
+
+				unknown := sdk.Zone{ID: "zone1234", Name: "foo.net", Account: sdk.Account{ID: "foo"}}
+				g.Expect(envy.AddObjectReturns("ZoneDetails", unknown)).To(Succeed())
+				g.Expect(envy.AddErrorReturns("DNSRecords", errors.New("Authentication error (10000)"))).To(Succeed())
+				checks = newClient(g).Diagnose(ctx)
+				g.Expect(statuses(checks)).To(Equal([]doctor.Status{pass, pass, fail, warn, pass}))
+				g.Expect(checks[2].Message).To(Equal("the DNS records of foo.net couldn't be listed: Authentication error (10000)"))
+				g.Expect(checks[3].Message).To(Equal("Cloudflare didn't report the token's permissions on foo.net"))
+			},
+		},
+		{
+			testCase: "verifies the Global API Key",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := cloudflare.NewLazyClient("foo", "foo.net", cloudflare.WithAPIKey("key1234", "user@foo.net"), withMockSDKClient)
+				g.Expect(err).NotTo(HaveOccurred())
+				client.AuthType = cloudflare.AuthTypeAPIKey
+
+				checks := client.Diagnose(ctx)
+				g.Expect(statuses(checks)).To(Equal([]doctor.Status{pass, pass, pass, pass, pass}))
+				g.Expect(checks[0]).To(Equal(doctor.Check{Name: "Cloudflare API key", Status: pass, Message: "the API key belongs to user@foo.net"}))
+
+				g.Expect(envy.AddErrorReturns("UserDetails", errors.New("Unknown X-Auth-Key or X-Auth-Email (9103)"))).To(Succeed())
+				checks = client.Diagnose(ctx)
+				g.Expect(statuses(checks)).To(Equal([]doctor.Status{fail, skip, skip, skip, skip}))
+				g.Expect(checks[0].Message).To(Equal("the API key couldn't be verified: Unknown X-Auth-Key or X-Auth-Email (9103)"))
+			},
+		},
+		{
+			testCase: "warns when an explicit zone can't be read",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := cloudflare.NewLazyClient("foo", "foo.net", cloudflare.WithAPIToken("token1234"), withMockSDKClient, cloudflare.WithZoneID("zone5678"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				g.Expect(envy.AddErrorReturns("ZoneDetails", errors.New("Authentication error (10000)"))).To(Succeed())
+				checks := client.Diagnose(ctx)
+				g.Expect(statuses(checks)).To(Equal([]doctor.Status{pass, warn, pass, warn, warn}))
+				g.Expect(checks[1].Message).To(Equal("using zone ID zone5678, whose details couldn't be read: Authentication error (10000)"))
+				g.Expect(checks[4].Message).To(Equal("the account owning zone zone5678 couldn't be read"))
+			},
+		},
+		{
+			testCase: "lazy client returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, err := cloudflare.NewLazyClient("foo", "foo.net", func(client *cloudflare.DefaultClient) error {
+					return errors.New("foo")
+				})
+				g.Expect(err).To(MatchError("foo"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,155 @@
+package control
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dampening"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/election"
+	"github.com/markliederbach/qrkdns/pkg/clients/health"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+)
+
+const (
+	// SyncPath triggers an immediate sync
+	SyncPath string = "/v1/sync"
+
+	// PausePath pauses the scheduled syncs
+	PausePath string = "/v1/pause"
+
+	// ResumePath resumes the scheduled syncs
+	ResumePath string = "/v1/resume"
+
+	// StatusPath returns the state of the agent and its last sync
+	StatusPath string = "/v1/status"
+
+	// HistoryPath returns the most recent history entries
+	HistoryPath string = "/v1/history"
+
+	// MetricsPath returns the state of the agent in the Prometheus text format
+	MetricsPath string = "/metrics"
+
+	// UnixPrefix marks addresses of unix sockets (e.g., unix:/run/qrkdns.sock)
+	UnixPrefix string = "unix:"
+
+	// DefaultHistoryLimit is the number of history entries returned when
+	// no limit is given
+	DefaultHistoryLimit int = 20
+)
+
+// Trigger tells what started a sync
+type Trigger string
+
+const (
+	// TriggerSchedule is a sync started by the cron schedule
+	TriggerSchedule Trigger = "schedule"
+
+	// TriggerControl is a sync requested through the control API
+	TriggerControl Trigger = "control"
+
+	// TriggerSignal is a sync requested by a signal (SIGUSR1)
+	TriggerSignal Trigger = "signal"
+
+	// TriggerHealth is a sync started by a change of health of a failover
+	// address
+	TriggerHealth Trigger = "health"
+)
+
+// Run describes a single sync performed by the agent
+type Run struct {
+	Trigger  Trigger   `json:"trigger"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// IP is the address published by the sync, if it got that far
+	IP string `json:"ip,omitempty"`
+	// Error is the reason the sync failed, if it did
+	Error   string            `json:"error,omitempty"`
+	Results []dns.ApplyResult `json:"results,omitempty"`
+	// Internal is the outcome of the internal view of a split-horizon
+	// setup, if configured
+	Internal *View `json:"internal,omitempty"`
+}
+
+// View is an address published to a set of records
+type View struct {
+	IP      string            `json:"ip"`
+	Results []dns.ApplyResult `json:"results"`
+}
+
+// Status is the state of the agent
+type Status struct {
+	Schedule string `json:"schedule"`
+	// Paused is true while scheduled syncs are skipped
+	Paused bool `json:"paused"`
+	// Running is true while a sync is in progress
+	Running bool `json:"running"`
+	// Runs counts the syncs performed since the agent started
+	Runs    int       `json:"runs"`
+	Started time.Time `json:"started"`
+	LastRun *Run      `json:"last_run,omitempty"`
+	// LastReload is the last attempt to reload the configuration
+	LastReload *Reload `json:"last_reload,omitempty"`
+	// Election is the outcome of the last leader election, if enabled
+	Election *election.State `json:"election,omitempty"`
+	// Health holds the health of the failover addresses, if configured
+	Health []health.Target `json:"health,omitempty"`
+	// Rejections counts the discovered addresses refused by the guard
+	Rejections int `json:"rejections"`
+	// Dampening is the persisted dampening state, if enabled
+	Dampening *dampening.State `json:"dampening,omitempty"`
+}
+
+// Reload describes an attempt to reload the configuration of the agent
+type Reload struct {
+	Time time.Time `json:"time"`
+	// Error is the reason the new configuration was rejected, if it was
+	Error string `json:"error,omitempty"`
+}
+
+// Agent is the running process controlled through the API
+type Agent interface {
+	// Sync performs a sync right away, waiting for any sync in progress
+	Sync(ctx context.Context) Run
+
+	// Pause skips scheduled syncs until resumed
+	Pause() Status
+
+	// Resume runs scheduled syncs again
+	Resume() Status
+
+	// Status returns the state of the agent
+	Status() Status
+
+	// History returns up to limit of the most recent history entries
+	History(limit int) ([]history.Entry, error)
+}
+
+// Controller talks to a running agent
+type Controller interface {
+	// Sync asks the agent to sync right away, returning the outcome
+	Sync(ctx context.Context) (Run, error)
+
+	// Pause asks the agent to skip scheduled syncs
+	Pause(ctx context.Context) (Status, error)
+
+	// Resume asks the agent to run scheduled syncs again
+	Resume(ctx context.Context) (Status, error)
+
+	// Status returns the state of the agent
+	Status(ctx context.Context) (Status, error)
+
+	// History returns up to limit of the agent's most recent history entries
+	History(ctx context.Context, limit int) ([]history.Entry, error)
+}
+
+// HTTPClient wraps the HTTP client used to make calls
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// errorResponse is the body of failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,281 @@
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+)
+
+var (
+	// Assert server and client match the correct interfaces
+	_ http.Handler = &DefaultServer{}
+	_ Controller   = &DefaultClient{}
+)
+
+// DefaultServer exposes an agent on a local address
+type DefaultServer struct {
+	Agent Agent
+	// Token authenticates every request as a bearer token
+	Token []byte
+	// ShutdownTimeout bounds the wait for running requests when stopping
+	ShutdownTimeout time.Duration
+	mux             *http.ServeMux
+}
+
+// ServerLoadOption allows for modifying the server after it's created
+type ServerLoadOption func(server *DefaultServer) error
+
+// NewServer returns a new control server. A token is always required, as
+// every local user may reach a loopback address.
+func NewServer(agent Agent, token string, opts ...ServerLoadOption) (*DefaultServer, error) {
+	if token == "" {
+		return nil, errors.New("a token is required to serve the control API")
+	}
+	server := &DefaultServer{
+		Agent:           agent,
+		Token:           []byte(token),
+		ShutdownTimeout: 10 * time.Second,
+	}
+
+	server.mux = http.NewServeMux()
+	server.mux.HandleFunc("POST "+SyncPath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, server.Agent.Sync(r.Context()))
+	})
+	server.mux.HandleFunc("POST "+PausePath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, server.Agent.Pause())
+	})
+	server.mux.HandleFunc("POST "+ResumePath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, server.Agent.Resume())
+	})
+	server.mux.HandleFunc("GET "+StatusPath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, server.Agent.Status())
+	})
+	server.mux.HandleFunc("GET "+HistoryPath, server.history)
+	server.mux.HandleFunc("GET "+MetricsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MetricsContentType)
+		_ = WriteMetrics(w, server.Agent.Status())
+	})
+
+	for _, opt := range opts {
+		if err := opt(server); err != nil {
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+// Listen listens on a unix socket (unix:/path) or a loopback address. A
+// socket left behind by a previous run is replaced, and the new one is
+// only accessible to the current user, on top of the token.
+func Listen(address string) (net.Listener, error) {
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	if network != "unix" {
+		return net.Listen(network, addr)
+	}
+
+	if info, err := os.Lstat(addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial(network, addr); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("control socket %v is in use", addr)
+		}
+		// Listening fails below if the socket can't be removed
+		_ = os.Remove(addr)
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.Chmod(addr, 0o600)
+	return listener, nil
+}
+
+// Serve answers requests on the listener until the context is done
+func (s *DefaultServer) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	failed := make(chan error, 1)
+	go func() { failed <- server.Serve(listener) }()
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-failed:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+	return errors.Join(err, server.Shutdown(shutdownCtx))
+}
+
+// ServeHTTP implements http.Handler
+func (s *DefaultServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), s.Token) != 1 {
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid token"})
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// history answers with the most recent history entries
+func (s *DefaultServer) history(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid limit %v", value)})
+			return
+		}
+		limit = parsed
+	}
+
+	entries, err := s.Agent.History(limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// DefaultClient implements the control client
+type DefaultClient struct {
+	Token  string
+	Client HTTPClient
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient returns a new client of the agent listening on the address
+func NewClient(address, token string, opts ...LoadOption) (*DefaultClient, error) {
+	network, addr, err := parseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{}
+	client := &DefaultClient{
+		Token: token,
+		Client: &http.Client{
+			Transport: &http.Transport{
+				// Every request goes to the agent, whatever the URL
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, network, addr)
+				},
+			},
+		},
+	}
+	for _, opt := range opts {
+		if err := opt(client); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// Sync implements Controller
+func (c *DefaultClient) Sync(ctx context.Context) (Run, error) {
+	run := Run{}
+	err := c.do(ctx, http.MethodPost, SyncPath, &run)
+	return run, err
+}
+
+// Pause implements Controller
+func (c *DefaultClient) Pause(ctx context.Context) (Status, error) {
+	status := Status{}
+	err := c.do(ctx, http.MethodPost, PausePath, &status)
+	return status, err
+}
+
+// Resume implements Controller
+func (c *DefaultClient) Resume(ctx context.Context) (Status, error) {
+	status := Status{}
+	err := c.do(ctx, http.MethodPost, ResumePath, &status)
+	return status, err
+}
+
+// Status implements Controller
+func (c *DefaultClient) Status(ctx context.Context) (Status, error) {
+	status := Status{}
+	err := c.do(ctx, http.MethodGet, StatusPath, &status)
+	return status, err
+}
+
+// History implements Controller
+func (c *DefaultClient) History(ctx context.Context, limit int) ([]history.Entry, error) {
+	entries := []history.Entry{}
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("%v?limit=%v", HistoryPath, limit), &entries)
+	return entries, err
+}
+
+// do sends an authenticated request and decodes the answer
+func (c *DefaultClient) do(ctx context.Context, method, path string, out interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, method, "http://qrkdns"+path, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+c.Token)
+
+	response, err := c.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		failure := errorResponse{}
+		if err := json.NewDecoder(response.Body).Decode(&failure); err != nil || failure.Error == "" {
+			return fmt.Errorf("control API answered %v", response.Status)
+		}
+		return fmt.Errorf("control API answered %v: %v", response.Status, failure.Error)
+	}
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// parseAddress returns the network and address of a unix socket or a
+// loopback address, refusing anything reachable from other hosts
+func parseAddress(address string) (string, string, error) {
+	if path, ok := strings.CutPrefix(address, UnixPrefix); ok {
+		if path == "" {
+			return "", "", fmt.Errorf("invalid control address %v", address)
+		}
+		return "unix", path, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid control address %v", address)
+	}
+	if host != "localhost" {
+		addr, err := netip.ParseAddr(host)
+		if err != nil || !addr.IsLoopback() {
+			return "", "", fmt.Errorf("control address %v is neither a unix socket nor a loopback address", address)
+		}
+	}
+	return "tcp", address, nil
+}
+
+// writeJSON answers with a JSON document
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
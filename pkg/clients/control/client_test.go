@@ -0,0 +1,325 @@
+package control_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/control"
+	"github.com/markliederbach/qrkdns/pkg/clients/dampening"
+	"github.com/markliederbach/qrkdns/pkg/clients/election"
+	"github.com/markliederbach/qrkdns/pkg/clients/health"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// fakeAgent records the operations it receives
+type fakeAgent struct {
+	paused     bool
+	limit      int
+	historyErr error
+}
+
+func (a *fakeAgent) Sync(ctx context.Context) control.Run {
+	return control.Run{Trigger: control.TriggerControl, IP: "1.2.3.4"}
+}
+
+func (a *fakeAgent) Pause() control.Status {
+	a.paused = true
+	return a.Status()
+}
+
+func (a *fakeAgent) Resume() control.Status {
+	a.paused = false
+	return a.Status()
+}
+
+func (a *fakeAgent) Status() control.Status {
+	return control.Status{Schedule: "* * * * *", Paused: a.paused}
+}
+
+func (a *fakeAgent) History(limit int) ([]history.Entry, error) {
+	a.limit = limit
+	if a.historyErr != nil {
+		return nil, a.historyErr
+	}
+	return []history.Entry{{Type: history.EntryTypeIPChanged, NewIP: "1.2.3.4"}}, nil
+}
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("boom")
+}
+
+// serve runs a server for the agent on the address until the test ends
+func serve(tt *testing.T, g *WithT, agent control.Agent, address string) {
+	server, err := control.NewServer(agent, "s3cret")
+	g.Expect(err).NotTo(HaveOccurred())
+	listener, err := control.Listen(address)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, listener) }()
+	tt.Cleanup(func() {
+		cancel()
+		g.Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+	})
+}
+
+func TestControl(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "controls the agent through a unix socket",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				ctx := context.Background()
+
+				agent := &fakeAgent{}
+				socket := filepath.Join(tt.TempDir(), "control.sock")
+				serve(tt, g, agent, control.UnixPrefix+socket)
+
+				info, err := os.Stat(socket)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(info.Mode().Perm()).To(Equal(os.FileMode(0o600)))
+
+				client, err := control.NewClient(control.UnixPrefix+socket, "s3cret")
+				g.Expect(err).NotTo(HaveOccurred())
+
+				run, err := client.Sync(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(run).To(Equal(control.Run{Trigger: control.TriggerControl, IP: "1.2.3.4"}))
+
+				status, err := client.Pause(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(status.Paused).To(BeTrue())
+
+				status, err = client.Status(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(status).To(Equal(control.Status{Schedule: "* * * * *", Paused: true}))
+
+				status, err = client.Resume(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(status.Paused).To(BeFalse())
+
+				entries, err := client.History(ctx, 5)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(Equal([]history.Entry{{Type: history.EntryTypeIPChanged, NewIP: "1.2.3.4"}}))
+				g.Expect(agent.limit).To(Equal(5))
+
+				// A running agent keeps its socket
+				_, err = control.Listen(control.UnixPrefix + socket)
+				g.Expect(err).To(MatchError("control socket " + socket + " is in use"))
+			},
+		},
+		{
+			testCase: "replaces sockets left behind",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				socket := filepath.Join(tt.TempDir(), "control.sock")
+				stale, err := net.Listen("unix", socket)
+				g.Expect(err).NotTo(HaveOccurred())
+				stale.(*net.UnixListener).SetUnlinkOnClose(false)
+				g.Expect(stale.Close()).To(Succeed())
+
+				listener, err := control.Listen(control.UnixPrefix + socket)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(listener.Close()).To(Succeed())
+
+				// Sockets can't be created where directories are missing
+				_, err = control.Listen(control.UnixPrefix + filepath.Join(socket, "missing", "control.sock"))
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "rejects unauthenticated and invalid requests",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				ctx := context.Background()
+
+				agent := &fakeAgent{}
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				g.Expect(err).NotTo(HaveOccurred())
+				address := listener.Addr().String()
+				g.Expect(listener.Close()).To(Succeed())
+				serve(tt, g, agent, address)
+
+				client, err := control.NewClient(address, "wrong")
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.Status(ctx)
+				g.Expect(err).To(MatchError("control API answered 401 Unauthorized: invalid token"))
+
+				client.Token = "s3cret"
+				_, err = client.History(ctx, 0)
+				g.Expect(err).To(MatchError("control API answered 400 Bad Request: invalid limit 0"))
+
+				agent.historyErr = errors.New("history is disabled")
+				_, err = client.History(ctx, 5)
+				g.Expect(err).To(MatchError("control API answered 500 Internal Server Error: history is disabled"))
+
+				// Requests are checked against the routes
+				request, err := http.NewRequest(http.MethodGet, "http://"+address+control.SyncPath, nil)
+				g.Expect(err).NotTo(HaveOccurred())
+				request.Header.Set("Authorization", "Bearer s3cret")
+				response, err := http.DefaultClient.Do(request)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(response.Body.Close()).To(Succeed())
+				g.Expect(response.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+
+				// Answers of anything but the agent aren't decoded
+				client.Client = http.DefaultClient
+				_, err = client.Status(ctx)
+				g.Expect(err).To(HaveOccurred())
+				unixClient, err := control.NewClient(control.UnixPrefix+filepath.Join(tt.TempDir(), "missing.sock"), "s3cret")
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = unixClient.Status(ctx)
+				g.Expect(err).To(HaveOccurred())
+				textServer := httptest.NewServer(http.NotFoundHandler())
+				defer textServer.Close()
+				textClient, err := control.NewClient(strings.TrimPrefix(textServer.URL, "http://"), "s3cret")
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = textClient.Status(ctx)
+				g.Expect(err).To(MatchError("control API answered 404 Not Found"))
+				_, err = client.Status(nil) //nolint
+				g.Expect(err).To(HaveOccurred())
+
+				_, err = control.NewClient(address, "s3cret", func(client *control.DefaultClient) error {
+					return errors.New("foo")
+				})
+				g.Expect(err).To(MatchError("foo"))
+			},
+		},
+		{
+			testCase: "exposes metrics",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				server, err := control.NewServer(&fakeAgent{paused: true}, "s3cret")
+				g.Expect(err).NotTo(HaveOccurred())
+				request := httptest.NewRequest(http.MethodGet, control.MetricsPath, nil)
+				request.Header.Set("Authorization", "Bearer s3cret")
+				recorder := httptest.NewRecorder()
+				server.ServeHTTP(recorder, request)
+				g.Expect(recorder.Code).To(Equal(http.StatusOK))
+				g.Expect(recorder.Header().Get("Content-Type")).To(Equal(control.MetricsContentType))
+				g.Expect(recorder.Body.String()).To(ContainSubstring("# TYPE qrkdns_paused gauge\nqrkdns_paused 1\n"))
+				g.Expect(recorder.Body.String()).NotTo(ContainSubstring("qrkdns_leader"))
+
+				finished := time.Unix(1700000000, 0)
+				output := &strings.Builder{}
+				g.Expect(control.WriteMetrics(output, control.Status{
+					Runs:     3,
+					Started:  finished.Add(-time.Hour),
+					LastRun:  &control.Run{Finished: finished, Error: "boom"},
+					Election: &election.State{Role: election.RoleLeader},
+					Health: []health.Target{
+						{Address: "1.2.3.4", Status: health.StatusHealthy, Transitions: 2},
+						{Address: "1.2.3.5", Status: health.StatusUnhealthy, Transitions: 1},
+					},
+					Rejections: 2,
+					Dampening:  &dampening.State{Candidate: "5.6.7.8", Observations: 1, Suppressed: 4},
+				})).To(Succeed())
+				g.Expect(output.String()).To(Equal(strings.Join([]string{
+					"# HELP qrkdns_syncs_total Syncs performed since the agent started.",
+					"# TYPE qrkdns_syncs_total counter",
+					"qrkdns_syncs_total 3",
+					"# HELP qrkdns_paused Whether scheduled syncs are paused.",
+					"# TYPE qrkdns_paused gauge",
+					"qrkdns_paused 0",
+					"# HELP qrkdns_start_time_seconds Start time of the agent since the epoch.",
+					"# TYPE qrkdns_start_time_seconds gauge",
+					"qrkdns_start_time_seconds 1699996400",
+					"# HELP qrkdns_guard_rejections_total Discovered addresses refused by the publication guard.",
+					"# TYPE qrkdns_guard_rejections_total counter",
+					"qrkdns_guard_rejections_total 2",
+					"# HELP qrkdns_last_sync_success Whether the last sync succeeded.",
+					"# TYPE qrkdns_last_sync_success gauge",
+					"qrkdns_last_sync_success 0",
+					"# HELP qrkdns_last_sync_time_seconds Completion time of the last sync since the epoch.",
+					"# TYPE qrkdns_last_sync_time_seconds gauge",
+					"qrkdns_last_sync_time_seconds 1700000000",
+					"# HELP qrkdns_leader Whether the agent holds the leader lease and performs the mutations.",
+					"# TYPE qrkdns_leader gauge",
+					"qrkdns_leader 1",
+					"# HELP qrkdns_failover_address_healthy Whether the failover address passes its health checks.",
+					"# TYPE qrkdns_failover_address_healthy gauge",
+					`qrkdns_failover_address_healthy{address="1.2.3.4"} 1`,
+					`qrkdns_failover_address_healthy{address="1.2.3.5"} 0`,
+					"# HELP qrkdns_failover_address_transitions_total Changes between healthy and unhealthy of the failover address.",
+					"# TYPE qrkdns_failover_address_transitions_total counter",
+					`qrkdns_failover_address_transitions_total{address="1.2.3.4"} 2`,
+					`qrkdns_failover_address_transitions_total{address="1.2.3.5"} 1`,
+					"# HELP qrkdns_dampening_suppressed_total Address changes held back by the dampening policy.",
+					"# TYPE qrkdns_dampening_suppressed_total counter",
+					"qrkdns_dampening_suppressed_total 4",
+					"# HELP qrkdns_dampening_pending Whether a new address waits to be published by the dampening policy.",
+					"# TYPE qrkdns_dampening_pending gauge",
+					"qrkdns_dampening_pending 1",
+					"",
+				}, "\n")))
+
+				g.Expect(control.WriteMetrics(failingWriter{}, control.Status{})).To(MatchError("boom"))
+				g.Expect(control.WriteMetrics(failingWriter{}, control.Status{Health: []health.Target{{Address: "1.2.3.4"}}})).To(MatchError("boom"))
+			},
+		},
+		{
+			testCase: "only listens on local addresses",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for address, message := range map[string]string{
+					"unix:":            "invalid control address unix:",
+					"nope":             "invalid control address nope",
+					"0.0.0.0:8053":     "control address 0.0.0.0:8053 is neither a unix socket nor a loopback address",
+					"example.com:8053": "control address example.com:8053 is neither a unix socket nor a loopback address",
+				} {
+					_, err := control.Listen(address)
+					g.Expect(err).To(MatchError(message), address)
+					_, err = control.NewClient(address, "s3cret")
+					g.Expect(err).To(MatchError(message), address)
+				}
+
+				listener, err := control.Listen("localhost:0")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(listener.Close()).To(Succeed())
+
+				_, err = control.NewServer(&fakeAgent{}, "")
+				g.Expect(err).To(MatchError("a token is required to serve the control API"))
+				_, err = control.NewServer(&fakeAgent{}, "s3cret", func(server *control.DefaultServer) error {
+					return errors.New("foo")
+				})
+				g.Expect(err).To(MatchError("foo"))
+
+				// Serving stops when the listener fails
+				server, err := control.NewServer(&fakeAgent{}, "s3cret")
+				g.Expect(err).NotTo(HaveOccurred())
+				listener, err = control.Listen("127.0.0.1:0")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(listener.Close()).To(Succeed())
+				g.Expect(server.Serve(context.Background(), listener)).NotTo(Succeed())
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/election"
+	"github.com/markliederbach/qrkdns/pkg/clients/health"
+)
+
+const (
+	// MetricsContentType is the content type of the Prometheus text format
+	MetricsContentType string = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// WriteMetrics writes the state of the agent in the Prometheus text format
+func WriteMetrics(w io.Writer, status Status) error {
+	metrics := &metricsWriter{w: w}
+	metrics.write("qrkdns_syncs_total", "Syncs performed since the agent started.", "counter", float64(status.Runs))
+	metrics.write("qrkdns_paused", "Whether scheduled syncs are paused.", "gauge", boolValue(status.Paused))
+	metrics.write("qrkdns_start_time_seconds", "Start time of the agent since the epoch.", "gauge", float64(status.Started.Unix()))
+	metrics.write("qrkdns_guard_rejections_total", "Discovered addresses refused by the publication guard.", "counter", float64(status.Rejections))
+	if status.LastRun != nil {
+		metrics.write("qrkdns_last_sync_success", "Whether the last sync succeeded.", "gauge", boolValue(status.LastRun.Error == ""))
+		metrics.write("qrkdns_last_sync_time_seconds", "Completion time of the last sync since the epoch.", "gauge", float64(status.LastRun.Finished.Unix()))
+	}
+	if status.Election != nil {
+		metrics.write("qrkdns_leader", "Whether the agent holds the leader lease and performs the mutations.", "gauge", boolValue(status.Election.Role == election.RoleLeader))
+	}
+	if len(status.Health) > 0 {
+		healthy := []labeledValue{}
+		transitions := []labeledValue{}
+		for _, target := range status.Health {
+			labels := fmt.Sprintf("address=%q", target.Address)
+			healthy = append(healthy, labeledValue{labels, boolValue(target.Status == health.StatusHealthy)})
+			transitions = append(transitions, labeledValue{labels, float64(target.Transitions)})
+		}
+		metrics.writeLabeled("qrkdns_failover_address_healthy", "Whether the failover address passes its health checks.", "gauge", healthy)
+		metrics.writeLabeled("qrkdns_failover_address_transitions_total", "Changes between healthy and unhealthy of the failover address.", "counter", transitions)
+	}
+	if status.Dampening != nil {
+		metrics.write("qrkdns_dampening_suppressed_total", "Address changes held back by the dampening policy.", "counter", float64(status.Dampening.Suppressed))
+		metrics.write("qrkdns_dampening_pending", "Whether a new address waits to be published by the dampening policy.", "gauge", boolValue(status.Dampening.Candidate != ""))
+	}
+	return metrics.err
+}
+
+// labeledValue is the value of a metric for a set of labels
+type labeledValue struct {
+	labels string
+	value  float64
+}
+
+// metricsWriter writes metrics, remembering the first error
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+// write writes a metric without labels, along with its help and type
+func (m *metricsWriter) write(name, help, kind string, value float64) {
+	if m.err != nil {
+		return
+	}
+	_, m.err = fmt.Fprintf(
+		m.w, "# HELP %v %v\n# TYPE %v %v\n%v %v\n",
+		name, help, name, kind, name, strconv.FormatFloat(value, 'f', -1, 64),
+	)
+}
+
+// writeLabeled writes a metric with a value for each set of labels, along
+// with its help and type
+func (m *metricsWriter) writeLabeled(name, help, kind string, values []labeledValue) {
+	if m.err != nil {
+		return
+	}
+	metric := &strings.Builder{}
+	fmt.Fprintf(metric, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+	for _, value := range values {
+		fmt.Fprintf(metric, "%v{%v} %v\n", name, value.labels, strconv.FormatFloat(value.value, 'f', -1, 64))
+	}
+	_, m.err = io.WriteString(m.w, metric.String())
+}
+
+// boolValue converts a boolean to a metric value
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package dampening
+
+import (
+	"time"
+)
+
+// Policy configures how long a new address must be stable before it is
+// published. Zero values disable the corresponding check.
+type Policy struct {
+	// Observations is the number of consecutive discoveries of a new
+	// address required before it is published
+	Observations int
+	// Hold is the minimum time a new address must be observed before it
+	// is published. Either Observations or Hold being met is enough.
+	Hold time.Duration
+	// MaxChangesPerHour limits how often the published address may change
+	MaxChangesPerHour int
+}
+
+// Enabled returns true if the policy can suppress any change
+func (p *Policy) Enabled() bool {
+	return p.Observations > 1 || p.Hold > 0 || p.MaxChangesPerHour > 0
+}
+
+// State is persisted between runs to track a new address until it is published
+type State struct {
+	// Candidate is the new address waiting to be published
+	Candidate string `json:"candidate,omitempty"`
+	// Observations counts the consecutive discoveries of the candidate
+	Observations int `json:"observations,omitempty"`
+	// FirstSeen is when the candidate was first discovered
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	// Changes holds the times of the changes published during the last hour
+	Changes []time.Time `json:"changes,omitempty"`
+	// Suppressed counts every change held back by the policy
+	Suppressed int `json:"suppressed"`
+	// LastReason explains why the last change was held back
+	LastReason string `json:"last_reason,omitempty"`
+	// LastSuppressed is when the last change was held back
+	LastSuppressed *time.Time `json:"last_suppressed,omitempty"`
+}
+
+// Decision is the outcome of observing a discovered address
+type Decision struct {
+	// Publish is true if the address may be applied to the provider
+	Publish bool
+	// Reason explains why the address was held back
+	Reason string
+}
+
+// Dampener decides whether a discovered address is stable enough to publish
+type Dampener interface {
+	// Observe records a discovery of address, given the addresses that are
+	// currently published, and decides whether it may be published
+	Observe(published []string, address string) (Decision, error)
+
+	// Published records that address was applied to the provider
+	Published(address string) error
+
+	// State returns the persisted state
+	State() (State, error)
+}
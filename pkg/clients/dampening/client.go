@@ -0,0 +1,213 @@
+package dampening
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FileName is the name of the state file inside the state directory
+	FileName string = "dampening.json"
+)
+
+var (
+	// Assert client matches the correct interface
+	_ Dampener = &DefaultClient{}
+)
+
+// DefaultClient applies a dampening policy, persisting its state to a JSON file
+type DefaultClient struct {
+	// Path is the location of the state file. Empty keeps the state in memory.
+	Path string
+	// Policy decides when a new address is stable
+	Policy Policy
+	// Now returns the current time
+	Now func() time.Time
+
+	mu    sync.Mutex
+	state State
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient returns a new client storing its state in stateDir. An empty
+// stateDir keeps the state in memory, so it only survives as long as the process.
+func NewClient(stateDir string, policy Policy, opts ...LoadOption) (*DefaultClient, error) {
+	client := &DefaultClient{
+		Policy: policy,
+		Now:    time.Now,
+	}
+	if stateDir != "" {
+		client.Path = filepath.Join(stateDir, FileName)
+	}
+	for _, opt := range opts {
+		if err := opt(client); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// Observe records a discovery of address and decides whether it may be
+// published. Addresses that are already published, or that would create
+// the first record, are never held back.
+func (c *DefaultClient) Observe(published []string, address string) (Decision, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, err := c.load()
+	if err != nil {
+		return Decision{}, err
+	}
+	now := c.Now().UTC()
+	state.Changes = since(state.Changes, now.Add(-time.Hour))
+
+	for _, content := range published {
+		if content == address {
+			// The address came back before a change was published
+			state.Candidate = ""
+			state.Observations = 0
+			state.FirstSeen = nil
+			return Decision{Publish: true}, c.save(state)
+		}
+	}
+
+	if state.Candidate != address {
+		state.Candidate = address
+		state.Observations = 0
+		state.FirstSeen = &now
+	}
+	state.Observations++
+
+	// Nothing can flap while no record is published
+	reason := ""
+	if len(published) > 0 {
+		reason = c.holdReason(state, now)
+	}
+	if reason == "" && len(published) > 0 && c.Policy.MaxChangesPerHour > 0 && len(state.Changes) >= c.Policy.MaxChangesPerHour {
+		reason = fmt.Sprintf("%v changes were published in the last hour, the maximum is %v", len(state.Changes), c.Policy.MaxChangesPerHour)
+	}
+	if reason == "" {
+		return Decision{Publish: true}, c.save(state)
+	}
+
+	state.Suppressed++
+	state.LastReason = reason
+	state.LastSuppressed = &now
+	return Decision{Reason: reason}, c.save(state)
+}
+
+// Published records that address was applied to the provider, counting
+// it towards the change rate if it was the candidate
+func (c *DefaultClient) Published(address string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, err := c.load()
+	if err != nil {
+		return err
+	}
+	if state.Candidate != address {
+		return nil
+	}
+
+	now := c.Now().UTC()
+	state.Changes = append(since(state.Changes, now.Add(-time.Hour)), now)
+	state.Candidate = ""
+	state.Observations = 0
+	state.FirstSeen = nil
+	return c.save(state)
+}
+
+// State returns the persisted state
+func (c *DefaultClient) State() (State, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.load()
+}
+
+// holdReason explains why the candidate isn't stable yet, or returns an
+// empty string if it is
+func (c *DefaultClient) holdReason(state State, now time.Time) string {
+	observations := c.Policy.Observations > 1
+	hold := c.Policy.Hold > 0
+	if !observations && !hold {
+		return ""
+	}
+
+	held := now.Sub(*state.FirstSeen)
+	if (observations && state.Observations >= c.Policy.Observations) || (hold && held >= c.Policy.Hold) {
+		return ""
+	}
+
+	reasons := []string{}
+	if observations {
+		reasons = append(reasons, fmt.Sprintf("observed %v of %v times", state.Observations, c.Policy.Observations))
+	}
+	if hold {
+		reasons = append(reasons, fmt.Sprintf("held for %v of %v", held.Round(time.Second), c.Policy.Hold))
+	}
+	return strings.Join(reasons, " and ")
+}
+
+// load reads the state file. A missing file holds an empty state.
+func (c *DefaultClient) load() (State, error) {
+	if c.Path == "" {
+		return c.state, nil
+	}
+
+	data, err := os.ReadFile(c.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+
+	state := State{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return State{}, fmt.Errorf("%v: %w", c.Path, err)
+	}
+	return state, nil
+}
+
+// save replaces the state file
+func (c *DefaultClient) save(state State) error {
+	if c.Path == "" {
+		c.state = state
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial state
+	temporary := c.Path + ".tmp"
+	if err := os.WriteFile(temporary, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temporary, c.Path)
+}
+
+// since returns the times at or after from
+func since(times []time.Time, from time.Time) []time.Time {
+	recent := []time.Time{}
+	for _, t := range times {
+		if !t.Before(from) {
+			recent = append(recent, t)
+		}
+	}
+	return recent
+}
@@ -0,0 +1,260 @@
+package dampening_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dampening"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+var start = time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+
+// clock returns a load option pinning the client's time to *now
+func clock(now *time.Time) dampening.LoadOption {
+	return func(client *dampening.DefaultClient) error {
+		client.Now = func() time.Time { return *now }
+		return nil
+	}
+}
+
+func TestClient(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "requires consecutive observations",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				now := start
+				client, err := dampening.NewClient(tt.TempDir(), dampening.Policy{Observations: 3}, clock(&now))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				published := []string{"1.1.1.1"}
+				decision, err := client.Observe(published, "2.2.2.2")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision).To(Equal(dampening.Decision{Reason: "observed 1 of 3 times"}))
+
+				decision, err = client.Observe(published, "2.2.2.2")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision.Publish).To(BeFalse())
+
+				// Bouncing to another address starts over
+				decision, err = client.Observe(published, "3.3.3.3")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision.Reason).To(Equal("observed 1 of 3 times"))
+
+				// Coming back to the published address drops the candidate
+				decision, err = client.Observe(published, "1.1.1.1")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision.Publish).To(BeTrue())
+
+				state, err := client.State()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state.Candidate).To(BeEmpty())
+				g.Expect(state.Suppressed).To(Equal(3))
+				g.Expect(state.LastReason).To(Equal("observed 1 of 3 times"))
+				g.Expect(*state.LastSuppressed).To(Equal(start))
+
+				for i := 0; i < 2; i++ {
+					decision, err = client.Observe(published, "2.2.2.2")
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(decision.Publish).To(BeFalse())
+				}
+				decision, err = client.Observe(published, "2.2.2.2")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision.Publish).To(BeTrue())
+
+				state, err = client.State()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state.Candidate).To(Equal("2.2.2.2"))
+				g.Expect(state.Observations).To(Equal(3))
+				g.Expect(*state.FirstSeen).To(Equal(start))
+
+				g.Expect(client.Published("2.2.2.2")).To(Succeed())
+				state, err = client.State()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state.Candidate).To(BeEmpty())
+				g.Expect(state.Changes).To(Equal([]time.Time{start}))
+
+				// Addresses that aren't the candidate aren't counted as changes
+				g.Expect(client.Published("2.2.2.2")).To(Succeed())
+				state, err = client.State()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state.Changes).To(HaveLen(1))
+			},
+		},
+		{
+			testCase: "requires a minimum hold time",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				now := start
+				client, err := dampening.NewClient("", dampening.Policy{Observations: 10, Hold: 5 * time.Minute}, clock(&now))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				published := []string{"1.1.1.1"}
+				decision, err := client.Observe(published, "2.2.2.2")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision.Reason).To(Equal("observed 1 of 10 times and held for 0s of 5m0s"))
+
+				now = start.Add(2 * time.Minute)
+				decision, err = client.Observe(published, "2.2.2.2")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision.Reason).To(Equal("observed 2 of 10 times and held for 2m0s of 5m0s"))
+
+				// Either condition is enough
+				now = start.Add(5 * time.Minute)
+				decision, err = client.Observe(published, "2.2.2.2")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision.Publish).To(BeTrue())
+			},
+		},
+		{
+			testCase: "limits the change rate",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				now := start
+				client, err := dampening.NewClient(tt.TempDir(), dampening.Policy{MaxChangesPerHour: 2}, clock(&now))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				for i, address := range []string{"2.2.2.2", "3.3.3.3"} {
+					now = start.Add(time.Duration(i) * 10 * time.Minute)
+					decision, err := client.Observe([]string{"1.1.1.1"}, address)
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(decision.Publish).To(BeTrue())
+					g.Expect(client.Published(address)).To(Succeed())
+				}
+
+				now = start.Add(30 * time.Minute)
+				decision, err := client.Observe([]string{"3.3.3.3"}, "4.4.4.4")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision.Reason).To(Equal("2 changes were published in the last hour, the maximum is 2"))
+
+				// The first change drops out of the window
+				now = start.Add(time.Hour + time.Minute)
+				decision, err = client.Observe([]string{"3.3.3.3"}, "4.4.4.4")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision.Publish).To(BeTrue())
+			},
+		},
+		{
+			testCase: "never holds back the first record",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				policy := dampening.Policy{Observations: 3, MaxChangesPerHour: 1}
+				g.Expect(policy.Enabled()).To(BeTrue())
+				g.Expect((&dampening.Policy{Observations: 1}).Enabled()).To(BeFalse())
+
+				client, err := dampening.NewClient("", policy)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				decision, err := client.Observe([]string{}, "2.2.2.2")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision.Publish).To(BeTrue())
+
+				// No policy never holds anything back
+				client, err = dampening.NewClient("", dampening.Policy{})
+				g.Expect(err).NotTo(HaveOccurred())
+				decision, err = client.Observe([]string{"1.1.1.1"}, "2.2.2.2")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(decision.Publish).To(BeTrue())
+			},
+		},
+		{
+			testCase: "persists state across clients",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := filepath.Join(tt.TempDir(), "state")
+				policy := dampening.Policy{Observations: 2}
+				for _, publish := range []bool{false, true} {
+					client, err := dampening.NewClient(stateDir, policy)
+					g.Expect(err).NotTo(HaveOccurred())
+					decision, err := client.Observe([]string{"1.1.1.1"}, "2.2.2.2")
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(decision.Publish).To(Equal(publish))
+				}
+				g.Expect(filepath.Join(stateDir, dampening.FileName)).To(BeARegularFile())
+			},
+		},
+		{
+			testCase: "returns file system errors",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				// The state file is corrupted
+				stateDir := tt.TempDir()
+				g.Expect(os.WriteFile(filepath.Join(stateDir, dampening.FileName), []byte("{"), 0o600)).To(Succeed())
+				client, err := dampening.NewClient(stateDir, dampening.Policy{})
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.Observe([]string{}, "1.1.1.1")
+				g.Expect(err).To(MatchError(ContainSubstring(dampening.FileName)))
+				g.Expect(client.Published("1.1.1.1")).NotTo(Succeed())
+
+				// The state file is a directory
+				stateDir = tt.TempDir()
+				g.Expect(os.Mkdir(filepath.Join(stateDir, dampening.FileName), 0o700)).To(Succeed())
+				client, err = dampening.NewClient(stateDir, dampening.Policy{})
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.State()
+				g.Expect(err).To(HaveOccurred())
+
+				// The state directory is a regular file
+				stateDir = filepath.Join(tt.TempDir(), "file")
+				g.Expect(os.WriteFile(stateDir, []byte{}, 0o600)).To(Succeed())
+				client, err = dampening.NewClient(filepath.Join(stateDir, "state"), dampening.Policy{})
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.Observe([]string{}, "1.1.1.1")
+				g.Expect(err).To(HaveOccurred())
+
+				// The state directory is a dangling symlink
+				stateDir = filepath.Join(tt.TempDir(), "link")
+				g.Expect(os.Symlink(filepath.Join(tt.TempDir(), "missing"), stateDir)).To(Succeed())
+				client, err = dampening.NewClient(stateDir, dampening.Policy{})
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.Observe([]string{}, "1.1.1.1")
+				g.Expect(err).To(HaveOccurred())
+
+				// The temporary file can't be written
+				stateDir = tt.TempDir()
+				g.Expect(os.Mkdir(filepath.Join(stateDir, dampening.FileName+".tmp"), 0o700)).To(Succeed())
+				client, err = dampening.NewClient(stateDir, dampening.Policy{})
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.Observe([]string{}, "1.1.1.1")
+				g.Expect(err).To(HaveOccurred())
+
+				// Times past year 9999 have no JSON encoding
+				late := time.Date(10000, time.January, 1, 0, 0, 0, 0, time.UTC)
+				client, err = dampening.NewClient(tt.TempDir(), dampening.Policy{Observations: 2}, clock(&late))
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.Observe([]string{"1.1.1.1"}, "2.2.2.2")
+				g.Expect(err).To(MatchError(ContainSubstring("year outside of range [0,9999]")))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, err := dampening.NewClient("", dampening.Policy{}, func(client *dampening.DefaultClient) error {
+					return errors.New("foo")
+				})
+				g.Expect(err).To(MatchError("foo"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
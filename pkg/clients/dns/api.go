@@ -3,6 +3,7 @@ package dns
 import (
 	"context"
 	"reflect"
+	"strings"
 )
 
 // ProviderType labels specific supported DNS providers
@@ -26,6 +27,15 @@ type RecordType string
 const (
 	// RecordTypeA is the DNS record type A
 	RecordTypeA RecordType = "A"
+
+	// RecordTypeAAAA is the DNS record type AAAA
+	RecordTypeAAAA RecordType = "AAAA"
+
+	// RecordTypeTXT is the DNS record type TXT
+	RecordTypeTXT RecordType = "TXT"
+
+	// RecordTypeCNAME is the DNS record type CNAME
+	RecordTypeCNAME RecordType = "CNAME"
 )
 
 // Record stores only the managed fields from a DNS record
@@ -42,14 +52,69 @@ type Record struct {
 type Provider interface {
 	// ApplyDNSARecord creates or updates a DNS record without creating a duplicate. It will also delete
 	// other A records for the domain that don't match the provided IP address
-	ApplyDNSARecord(ctx context.Context, subdomain, ipAddress string) (Record, error)
+	ApplyDNSARecord(ctx context.Context, subdomain, ipAddress string) (ApplyResult, error)
+
+	// GetDNSARecords returns the A records currently published for the subdomain
+	GetDNSARecords(ctx context.Context, subdomain string) ([]Record, error)
+
+	// ListRecords returns every record in the zone matching the filter
+	ListRecords(ctx context.Context, filter RecordFilter) ([]Record, error)
+
+	// CreateRecord creates a record of any type
+	CreateRecord(ctx context.Context, record Record) (Record, error)
+
+	// UpdateRecord modifies a record of any type in place, keeping its ID
+	UpdateRecord(ctx context.Context, record Record) (Record, error)
+
+	// DeleteRecord deletes a record of any type
+	DeleteRecord(ctx context.Context, record Record) error
+}
+
+// RecordFilter narrows down a record listing. Empty fields match everything.
+type RecordFilter struct {
+	Name string     `json:"name"`
+	Type RecordType `json:"type"`
+}
+
+// ApplyResult describes the changes made to a provider while applying a record
+type ApplyResult struct {
+	// Record is the record left in place after applying
+	Record Record `json:"record"`
+	// Previous holds the records that existed before any change was made
+	Previous []Record `json:"previous"`
+	// Created is true when a new record was created
+	Created bool `json:"created"`
+	// Updated is true when an existing record was modified in place
+	Updated bool `json:"updated"`
+	// Deleted holds every extra record that was removed
+	Deleted []Record `json:"deleted"`
+}
+
+// Changed reports whether applying the record mutated the provider in any way
+func (r *ApplyResult) Changed() bool {
+	return r.Created || r.Updated || len(r.Deleted) > 0
 }
 
-// Equal checks whether two records are equal (except for unmanaged fields)
+// OldContent returns the first previously published content that differs
+// from the applied record, or an empty string if there was none
+func (r *ApplyResult) OldContent() string {
+	for _, record := range r.Previous {
+		if record.Content != r.Record.Content {
+			return record.Content
+		}
+	}
+	return ""
+}
+
+// Equal checks whether two records are equal (except for unmanaged fields).
+// Names are compared case-insensitively.
 func (d *Record) Equal(other Record, matchID bool) bool {
 	if !matchID {
 		// Temporarily copy ID
 		other.ID = d.ID
 	}
+	if strings.EqualFold(d.Name, other.Name) {
+		other.Name = d.Name
+	}
 	return reflect.DeepEqual(*d, other)
 }
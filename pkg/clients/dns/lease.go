@@ -0,0 +1,180 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// LeasePrefix is prepended to a shared name to build the name of the
+	// TXT records holding the heartbeats of the agents sharing it
+	LeasePrefix string = "_qrkdns-lease."
+)
+
+// Lease is the heartbeat of an agent publishing its address to a shared name
+type Lease struct {
+	// Record is the TXT record holding the lease
+	Record Record
+	// Agent identifies the agent holding the lease
+	Agent string
+	// IP is the address the agent publishes
+	IP string
+	// Renewed is the time of the last heartbeat
+	Renewed time.Time
+}
+
+// Expired reports whether the lease wasn't renewed within ttl
+func (l *Lease) Expired(ttl time.Duration, now time.Time) bool {
+	return now.Sub(l.Renewed) > ttl
+}
+
+// LeaseRecordName returns the name of the TXT records holding the leases of a shared name
+func LeaseRecordName(name string) string {
+	return MetadataRecordName(LeasePrefix, name)
+}
+
+// LeaseContent returns the TXT content of the lease of an agent
+func LeaseContent(agent, ip string, renewed time.Time) string {
+	return fmt.Sprintf("%v,agent=%v,ip=%v,renewed=%v", ownershipHeritage, agent, ip, renewed.Unix())
+}
+
+// ParseLease returns the lease held by record, if record is a lease record
+func ParseLease(record Record) (Lease, bool) {
+	if record.Type != RecordTypeTXT || !strings.HasPrefix(record.Name, LeasePrefix) {
+		return Lease{}, false
+	}
+
+	content := strings.Trim(record.Content, `"`)
+	if !strings.HasPrefix(content, ownershipHeritage+",") {
+		return Lease{}, false
+	}
+
+	lease := Lease{Record: record}
+	renewed := ""
+	for _, field := range strings.Split(content, ",") {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "agent":
+			lease.Agent = value
+		case "ip":
+			lease.IP = value
+		case "renewed":
+			renewed = value
+		}
+	}
+	var seconds int64
+	if _, err := fmt.Sscan(renewed, &seconds); err != nil || lease.Agent == "" || lease.IP == "" {
+		return Lease{}, false
+	}
+	lease.Renewed = time.Unix(seconds, 0)
+	return lease, true
+}
+
+// ApplySharedARecord publishes ip to name next to the addresses of other
+// agents sharing it, then renews the lease of agent in place, creating it
+// only if agent holds none. Only the A record of agent is managed: it is
+// replaced when the address changes. The records of agents whose lease
+// expired are garbage-collected, unless another live lease still claims
+// their address. A records without any lease are left alone.
+//
+// Previous holds the records of agent and of expired leases, so that the
+// result only reports changes made on behalf of agent.
+func ApplySharedARecord(ctx context.Context, provider Provider, name, ip, agent string, ttl time.Duration, now time.Time) (ApplyResult, error) {
+	existing, err := provider.ListRecords(ctx, RecordFilter{Name: name, Type: RecordTypeA})
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	leaseRecords, err := provider.ListRecords(ctx, RecordFilter{Name: LeaseRecordName(name), Type: RecordTypeTXT})
+	if err != nil {
+		return ApplyResult{}, err
+	}
+
+	// Addresses of live leases are never removed
+	live := map[string]bool{ip: true}
+	var own *Lease
+	stale := []Lease{}
+	for _, record := range leaseRecords {
+		lease, ok := ParseLease(record)
+		if !ok {
+			continue
+		}
+		if lease.Agent == agent && own == nil {
+			own = &lease
+			continue
+		}
+		if lease.Agent == agent || lease.Expired(ttl, now) {
+			stale = append(stale, lease)
+			continue
+		}
+		live[lease.IP] = true
+	}
+	released := map[string]bool{}
+	for _, lease := range stale {
+		if !live[lease.IP] {
+			released[lease.IP] = true
+		}
+	}
+	if own != nil && !live[own.IP] {
+		released[own.IP] = true
+	}
+
+	result := ApplyResult{
+		Previous: []Record{},
+		Deleted:  []Record{},
+	}
+	found := false
+	for _, record := range existing {
+		if record.Content != ip && !released[record.Content] {
+			continue
+		}
+		result.Previous = append(result.Previous, record)
+		if record.Content == ip && !found {
+			result.Record = record
+			found = true
+			continue
+		}
+		if err := provider.DeleteRecord(ctx, record); err != nil {
+			return ApplyResult{}, err
+		}
+		result.Deleted = append(result.Deleted, record)
+	}
+
+	if !found {
+		result.Record, err = provider.CreateRecord(ctx, Record{
+			Type:    RecordTypeA,
+			Name:    name,
+			Content: ip,
+			TTL:     1,
+		})
+		if err != nil {
+			return ApplyResult{}, err
+		}
+		result.Created = true
+	}
+
+	// The lease is renewed in place, so that another agent never sees the
+	// address unclaimed
+	if own != nil {
+		renewed := own.Record
+		renewed.Content = LeaseContent(agent, ip, now)
+		_, err = provider.UpdateRecord(ctx, renewed)
+	} else {
+		_, err = provider.CreateRecord(ctx, Record{
+			Type:    RecordTypeTXT,
+			Name:    LeaseRecordName(name),
+			Content: LeaseContent(agent, ip, now),
+			TTL:     1,
+		})
+	}
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	for _, lease := range stale {
+		if err := provider.DeleteRecord(ctx, lease.Record); err != nil {
+			return ApplyResult{}, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,237 @@
+package dns_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	. "github.com/onsi/gomega"
+)
+
+func TestLease(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	lease := 15 * time.Minute
+
+	aRecord := func(id, ip string) dns.Record {
+		return dns.Record{ID: id, Type: dns.RecordTypeA, Name: "www.foo.net", Content: ip, TTL: 1}
+	}
+	leaseRecord := func(id, agent, ip string, renewed time.Time) dns.Record {
+		return dns.Record{
+			ID:      id,
+			Type:    dns.RecordTypeTXT,
+			Name:    dns.LeaseRecordName("www.foo.net"),
+			Content: fmt.Sprintf(`"%v"`, dns.LeaseContent(agent, ip, renewed)),
+			TTL:     1,
+		}
+	}
+	newLease := func(agent, ip string) dns.Record {
+		return dns.Record{
+			Type:    dns.RecordTypeTXT,
+			Name:    "_qrkdns-lease.www.foo.net",
+			Content: fmt.Sprintf("heritage=qrkdns,agent=%v,ip=%v,renewed=1700000000", agent, ip),
+			TTL:     1,
+		}
+	}
+	renewedLease := func(id, agent, ip string) dns.Record {
+		record := newLease(agent, ip)
+		record.ID = id
+		return record
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "parses lease records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				record := leaseRecord("1", "paris", "1.2.3.4", now)
+				parsed, ok := dns.ParseLease(record)
+				g.Expect(ok).To(BeTrue())
+				g.Expect(parsed).To(Equal(dns.Lease{Record: record, Agent: "paris", IP: "1.2.3.4", Renewed: now}))
+				g.Expect(parsed.Expired(lease, now.Add(lease))).To(BeFalse())
+				g.Expect(parsed.Expired(lease, now.Add(lease+time.Second))).To(BeTrue())
+
+				g.Expect(dns.LeaseRecordName("*.office.foo.net")).To(Equal("_qrkdns-lease._wildcard.office.foo.net"))
+
+				for _, record := range []dns.Record{
+					aRecord("1", "1.2.3.4"),
+					{Type: dns.RecordTypeTXT, Name: dns.LeaseRecordName("www.foo.net"), Content: "v=spf1"},
+					{Type: dns.RecordTypeTXT, Name: dns.LeaseRecordName("www.foo.net"), Content: "heritage=qrkdns,agent=paris,ip=1.2.3.4"},
+					{Type: dns.RecordTypeTXT, Name: dns.LeaseRecordName("www.foo.net"), Content: "heritage=qrkdns,ip=1.2.3.4,renewed=1"},
+					{Type: dns.RecordTypeTXT, Name: dns.OwnershipRecordName("www.foo.net"), Content: dns.OwnershipContent("paris")},
+				} {
+					_, ok := dns.ParseLease(record)
+					g.Expect(ok).To(BeFalse(), record.Content)
+				}
+			},
+		},
+		{
+			testCase: "publishes next to other agents",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				provider := &fakeProvider{records: []dns.Record{
+					aRecord("1", "5.6.7.8"),
+					leaseRecord("2", "berlin", "5.6.7.8", now.Add(-time.Minute)),
+				}}
+				result, err := dns.ApplySharedARecord(context.Background(), provider, "www.foo.net", "1.2.3.4", "paris", lease, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result).To(Equal(dns.ApplyResult{
+					Record:   dns.Record{Type: dns.RecordTypeA, Name: "www.foo.net", Content: "1.2.3.4", TTL: 1},
+					Previous: []dns.Record{},
+					Created:  true,
+					Deleted:  []dns.Record{},
+				}))
+				g.Expect(provider.records).To(Equal([]dns.Record{
+					aRecord("1", "5.6.7.8"),
+					leaseRecord("2", "berlin", "5.6.7.8", now.Add(-time.Minute)),
+					{Type: dns.RecordTypeA, Name: "www.foo.net", Content: "1.2.3.4", TTL: 1},
+					newLease("paris", "1.2.3.4"),
+				}))
+			},
+		},
+		{
+			testCase: "renews the lease of a published address",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				provider := &fakeProvider{records: []dns.Record{
+					aRecord("1", "1.2.3.4"),
+					leaseRecord("2", "paris", "1.2.3.4", now.Add(-time.Minute)),
+					{ID: "3", Type: dns.RecordTypeTXT, Name: dns.LeaseRecordName("www.foo.net"), Content: "v=spf1"},
+				}}
+				result, err := dns.ApplySharedARecord(context.Background(), provider, "www.foo.net", "1.2.3.4", "paris", lease, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Changed()).To(BeFalse())
+				g.Expect(result.Record).To(Equal(aRecord("1", "1.2.3.4")))
+				g.Expect(provider.records).To(Equal([]dns.Record{
+					aRecord("1", "1.2.3.4"),
+					renewedLease("2", "paris", "1.2.3.4"),
+					{ID: "3", Type: dns.RecordTypeTXT, Name: dns.LeaseRecordName("www.foo.net"), Content: "v=spf1"},
+				}))
+			},
+		},
+		{
+			testCase: "replaces its own record when the address changes",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				provider := &fakeProvider{records: []dns.Record{
+					aRecord("1", "1.2.3.4"),
+					leaseRecord("2", "paris", "1.2.3.4", now.Add(-time.Minute)),
+					aRecord("3", "5.6.7.8"),
+					leaseRecord("4", "berlin", "5.6.7.8", now.Add(-time.Minute)),
+					aRecord("5", "9.9.9.9"),
+				}}
+				result, err := dns.ApplySharedARecord(context.Background(), provider, "www.foo.net", "1.2.3.5", "paris", lease, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Created).To(BeTrue())
+				g.Expect(result.Previous).To(Equal([]dns.Record{aRecord("1", "1.2.3.4")}))
+				g.Expect(result.Deleted).To(Equal([]dns.Record{aRecord("1", "1.2.3.4")}))
+				g.Expect(result.OldContent()).To(Equal("1.2.3.4"))
+
+				// Records of other agents and records without a lease stay
+				g.Expect(provider.records).To(Equal([]dns.Record{
+					renewedLease("2", "paris", "1.2.3.5"),
+					aRecord("3", "5.6.7.8"),
+					leaseRecord("4", "berlin", "5.6.7.8", now.Add(-time.Minute)),
+					aRecord("5", "9.9.9.9"),
+					{Type: dns.RecordTypeA, Name: "www.foo.net", Content: "1.2.3.5", TTL: 1},
+				}))
+			},
+		},
+		{
+			testCase: "garbage-collects expired leases",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				provider := &fakeProvider{records: []dns.Record{
+					aRecord("1", "1.2.3.4"),
+					leaseRecord("2", "paris", "1.2.3.4", now.Add(-time.Minute)),
+					aRecord("3", "5.6.7.8"),
+					leaseRecord("4", "berlin", "5.6.7.8", now.Add(-lease-time.Minute)),
+					// Another agent behind the same address keeps it alive
+					aRecord("5", "9.9.9.9"),
+					leaseRecord("6", "rome", "9.9.9.9", now.Add(-lease-time.Minute)),
+					leaseRecord("7", "milan", "9.9.9.9", now),
+				}}
+				result, err := dns.ApplySharedARecord(context.Background(), provider, "www.foo.net", "1.2.3.4", "paris", lease, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Created).To(BeFalse())
+				g.Expect(result.Deleted).To(Equal([]dns.Record{aRecord("3", "5.6.7.8")}))
+				g.Expect(provider.records).To(Equal([]dns.Record{
+					aRecord("1", "1.2.3.4"),
+					renewedLease("2", "paris", "1.2.3.4"),
+					aRecord("5", "9.9.9.9"),
+					leaseRecord("7", "milan", "9.9.9.9", now),
+				}))
+			},
+		},
+		{
+			testCase: "removes duplicate leases of the agent",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				provider := &fakeProvider{records: []dns.Record{
+					aRecord("1", "1.2.3.4"),
+					leaseRecord("2", "paris", "1.2.3.4", now.Add(-time.Minute)),
+					leaseRecord("3", "paris", "1.2.3.4", now.Add(-time.Minute)),
+				}}
+				result, err := dns.ApplySharedARecord(context.Background(), provider, "www.foo.net", "1.2.3.4", "paris", lease, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Changed()).To(BeFalse())
+				g.Expect(provider.records).To(Equal([]dns.Record{
+					aRecord("1", "1.2.3.4"),
+					renewedLease("2", "paris", "1.2.3.4"),
+				}))
+			},
+		},
+		{
+			testCase: "returns error listing records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for _, recordType := range []dns.RecordType{dns.RecordTypeA, dns.RecordTypeTXT} {
+					provider := &fakeProvider{err: fmt.Errorf("nope"), errType: recordType}
+					_, err := dns.ApplySharedARecord(context.Background(), provider, "www.foo.net", "1.2.3.4", "paris", lease, now)
+					g.Expect(err).To(MatchError("nope"), string(recordType))
+				}
+			},
+		},
+		{
+			testCase: "returns error writing records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				ctx := context.Background()
+				for _, records := range [][]dns.Record{
+					// creating the A record
+					{},
+					// deleting the previous A record
+					{aRecord("1", "1.2.3.5"), leaseRecord("2", "paris", "1.2.3.5", now)},
+					// creating the lease
+					{aRecord("1", "1.2.3.4")},
+					// renewing the lease
+					{aRecord("1", "1.2.3.4"), leaseRecord("2", "paris", "1.2.3.4", now)},
+				} {
+					provider := &fakeProvider{records: records, writeErr: fmt.Errorf("nope")}
+					_, err := dns.ApplySharedARecord(ctx, provider, "www.foo.net", "1.2.3.4", "paris", lease, now)
+					g.Expect(err).To(MatchError("nope"))
+				}
+
+				provider := &fakeProvider{
+					records:   []dns.Record{aRecord("1", "1.2.3.4"), leaseRecord("2", "paris", "1.2.3.4", now), leaseRecord("3", "paris", "1.2.3.4", now)},
+					deleteErr: fmt.Errorf("nope"),
+				}
+				_, err := dns.ApplySharedARecord(ctx, provider, "www.foo.net", "1.2.3.4", "paris", lease, now)
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
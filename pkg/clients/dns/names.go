@@ -0,0 +1,180 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	// ApexName designates the zone apex among names relative to a domain
+	ApexName string = "@"
+
+	// WildcardLabel matches any name at its level. It may only be the
+	// leftmost label of a name.
+	WildcardLabel string = "*"
+
+	// maxNameLength is the longest name allowed, without the trailing dot
+	maxNameLength int = 253
+
+	// maxLabelLength is the longest label allowed
+	maxLabelLength int = 63
+
+	// acePrefix starts the A-label form of an internationalized label
+	acePrefix string = "xn--"
+)
+
+var (
+	// lookupProfile maps internationalized labels as UTS #46 does for
+	// lookups. Host name rules are checked separately, to allow wildcards
+	// and give clearer errors.
+	lookupProfile = idna.New(idna.MapForLookup(), idna.Transitional(false), idna.StrictDomainName(false))
+)
+
+// FQDN returns the fully qualified name of a name relative to the domain
+func FQDN(name, domain string) string {
+	if name == ApexName {
+		return domain
+	}
+	return fmt.Sprintf("%v.%v", name, domain)
+}
+
+// RelativeName returns the name of a fully qualified name relative to the
+// domain, or false if it's outside of the domain. Both are expected in their
+// A-label form.
+func RelativeName(fqdn, domain string) (string, bool) {
+	if fqdn == domain {
+		return ApexName, true
+	}
+	name := strings.TrimSuffix(fqdn, "."+domain)
+	if name == fqdn || name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+// ToASCII returns the lowercase A-label form of a name, as providers
+// store it. Internationalized labels are mapped with UTS #46.
+func ToASCII(name string) (string, error) {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if isASCII(label) && !strings.HasPrefix(strings.ToLower(label), acePrefix) {
+			labels[i] = strings.ToLower(label)
+			continue
+		}
+		ascii, err := lookupProfile.ToASCII(label)
+		if err != nil {
+			return "", fmt.Errorf("invalid name %v: label %v can't be converted to an A-label: %w", name, label, err)
+		}
+		labels[i] = ascii
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// ToUnicode returns the U-label form of a name for display, or the name
+// itself if it can't be converted
+func ToUnicode(name string) string {
+	unicode, err := lookupProfile.ToUnicode(name)
+	if err != nil {
+		return name
+	}
+	return unicode
+}
+
+// ValidateName checks a name relative to the domain against the host name
+// syntax of RFC 1123, once converted to its A-label form. The apex and a
+// leftmost wildcard label are allowed too.
+func ValidateName(name, domain string) error {
+	fqdn := FQDN(name, domain)
+	ascii, err := ToASCII(fqdn)
+	if err != nil {
+		return err
+	}
+	if len(ascii) > maxNameLength {
+		return fmt.Errorf("invalid name %v: longer than %v characters", fqdn, maxNameLength)
+	}
+	for i, label := range strings.Split(ascii, ".") {
+		if i == 0 && label == WildcardLabel && name != ApexName {
+			continue
+		}
+		if err := validateLabel(label); err != nil {
+			return fmt.Errorf("invalid name %v: %w", fqdn, err)
+		}
+	}
+	return nil
+}
+
+// isASCII returns true if the label needs no IDNA mapping
+func isASCII(label string) bool {
+	for i := 0; i < len(label); i++ {
+		if label[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// validateLabel checks a single label: letters, digits and inner hyphens
+func validateLabel(label string) error {
+	if label == "" {
+		return fmt.Errorf("empty label")
+	}
+	if len(label) > maxLabelLength {
+		return fmt.Errorf("label %v is longer than %v characters", label, maxLabelLength)
+	}
+	for _, char := range label {
+		isLetter := (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+		isDigit := char >= '0' && char <= '9'
+		if !isLetter && !isDigit && char != '-' {
+			return fmt.Errorf("label %v contains %q", label, char)
+		}
+	}
+	if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+		return fmt.Errorf("label %v starts or ends with a hyphen", label)
+	}
+	return nil
+}
+
+// ApplyCNAME makes name an alias of target, replacing any other CNAME
+// record of the name. A name can only hold a single CNAME, so the old
+// record is deleted before the new one is created.
+func ApplyCNAME(ctx context.Context, provider Provider, name, target string) (ApplyResult, error) {
+	existing, err := provider.ListRecords(ctx, RecordFilter{Name: name, Type: RecordTypeCNAME})
+	if err != nil {
+		return ApplyResult{}, err
+	}
+
+	result := ApplyResult{
+		Previous: existing,
+		Deleted:  []Record{},
+	}
+	found := false
+	for _, record := range existing {
+		if strings.EqualFold(record.Content, target) && !found {
+			result.Record = record
+			found = true
+			continue
+		}
+		if err := provider.DeleteRecord(ctx, record); err != nil {
+			return ApplyResult{}, err
+		}
+		result.Deleted = append(result.Deleted, record)
+	}
+
+	if !found {
+		result.Record, err = provider.CreateRecord(ctx, Record{
+			Type:    RecordTypeCNAME,
+			Name:    name,
+			Content: target,
+			TTL:     1,
+		})
+		if err != nil {
+			return ApplyResult{}, err
+		}
+		result.Created = true
+	}
+	return result, nil
+}
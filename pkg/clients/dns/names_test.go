@@ -0,0 +1,190 @@
+package dns_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	. "github.com/onsi/gomega"
+)
+
+func TestNames(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "builds fully qualified names",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				g.Expect(dns.FQDN("bar", "foo.net")).To(Equal("bar.foo.net"))
+				g.Expect(dns.FQDN("@", "foo.net")).To(Equal("foo.net"))
+				g.Expect(dns.FQDN("*.office", "foo.net")).To(Equal("*.office.foo.net"))
+			},
+		},
+		{
+			testCase: "finds names relative to the domain",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for fqdn, expected := range map[string]string{
+					"bar.foo.net":        "bar",
+					"foo.net":            "@",
+					"*.office.foo.net":   "*.office",
+					"xn--br-via.foo.net": "xn--br-via",
+				} {
+					name, ok := dns.RelativeName(fqdn, "foo.net")
+					g.Expect(ok).To(BeTrue(), fqdn)
+					g.Expect(name).To(Equal(expected), fqdn)
+				}
+
+				for _, fqdn := range []string{"bar.other.net", "barfoo.net", ".foo.net", "net"} {
+					_, ok := dns.RelativeName(fqdn, "foo.net")
+					g.Expect(ok).To(BeFalse(), fqdn)
+				}
+			},
+		},
+		{
+			testCase: "accepts valid names",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for _, name := range []string{"bar", "@", "*", "*.office", "1st-floor.office", strings.Repeat("a", 63)} {
+					g.Expect(dns.ValidateName(name, "foo.net")).To(Succeed(), name)
+				}
+			},
+		},
+		{
+			testCase: "rejects invalid names",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				tests := map[string]string{
+					"office.*":                      "invalid name office.*.foo.net: label * contains '*'",
+					"*.*":                           "invalid name *.*.foo.net: label * contains '*'",
+					"under_score":                   "invalid name under_score.foo.net: label under_score contains '_'",
+					"-bar":                          "invalid name -bar.foo.net: label -bar starts or ends with a hyphen",
+					"bar-":                          "invalid name bar-.foo.net: label bar- starts or ends with a hyphen",
+					"bar..baz":                      "invalid name bar..baz.foo.net: empty label",
+					"":                              "invalid name .foo.net: empty label",
+					strings.Repeat("a", 64):         fmt.Sprintf("invalid name %v.foo.net: label %v is longer than 63 characters", strings.Repeat("a", 64), strings.Repeat("a", 64)),
+					strings.Repeat("a.", 126) + "a": fmt.Sprintf("invalid name %va.foo.net: longer than 253 characters", strings.Repeat("a.", 126)),
+				}
+				for name, message := range tests {
+					g.Expect(dns.ValidateName(name, "foo.net")).To(MatchError(message), name)
+				}
+			},
+		},
+		{
+			testCase: "converts internationalized names",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				tests := map[string]string{
+					"Bücher.Example":         "xn--bcher-kva.example",
+					"*.BÄR.foo.net":          "*.xn--br-via.foo.net",
+					"xn--bcher-kva.example":  "xn--bcher-kva.example",
+					"ＡＢＣ.foo.net":            "abc.foo.net",
+					"_qrkdns.bücher.example": "_qrkdns.xn--bcher-kva.example",
+				}
+				for name, ascii := range tests {
+					converted, err := dns.ToASCII(name)
+					g.Expect(err).NotTo(HaveOccurred(), name)
+					g.Expect(converted).To(Equal(ascii), name)
+				}
+
+				g.Expect(dns.ToUnicode("*.xn--br-via.xn--bcher-kva.example")).To(Equal("*.bär.bücher.example"))
+				g.Expect(dns.ToUnicode("bar.foo.net")).To(Equal("bar.foo.net"))
+				g.Expect(dns.ToUnicode("xn--zz.foo.net")).To(Equal("xn--zz.foo.net"))
+			},
+		},
+		{
+			testCase: "validates internationalized names",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				g.Expect(dns.ValidateName("bär", "bücher.example")).To(Succeed())
+				g.Expect(dns.ValidateName("*.BÄR", "foo.net")).To(Succeed())
+
+				err := dns.ValidateName("xn--zz", "foo.net")
+				g.Expect(err).To(MatchError(`invalid name xn--zz.foo.net: label xn--zz can't be converted to an A-label: idna: invalid label "zz"`))
+
+				err = dns.ValidateName("bär\u0080", "foo.net")
+				g.Expect(err).To(MatchError(ContainSubstring("invalid name bär\u0080.foo.net: label bär\u0080 can't be converted to an A-label")))
+
+				err = dns.ValidateName("bär_baz", "foo.net")
+				g.Expect(err).To(MatchError("invalid name bär_baz.foo.net: label xn--br_baz-bua contains '_'"))
+			},
+		},
+		{
+			testCase: "compares record names case-insensitively",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				record := dns.Record{ID: "1", Type: dns.RecordTypeA, Name: "Bar.foo.net", Content: "1.2.3.4"}
+				g.Expect(record.Equal(dns.Record{ID: "2", Type: dns.RecordTypeA, Name: "bar.FOO.net", Content: "1.2.3.4"}, false)).To(BeTrue())
+				g.Expect(record.Equal(dns.Record{ID: "2", Type: dns.RecordTypeA, Name: "baz.foo.net", Content: "1.2.3.4"}, false)).To(BeFalse())
+			},
+		},
+		{
+			testCase: "creates a missing CNAME",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				provider := &fakeProvider{}
+				result, err := dns.ApplyCNAME(context.Background(), provider, "www.foo.net", "bar.foo.net")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Created).To(BeTrue())
+				g.Expect(result.Record).To(Equal(dns.Record{Type: dns.RecordTypeCNAME, Name: "www.foo.net", Content: "bar.foo.net", TTL: 1}))
+				g.Expect(provider.records).To(Equal([]dns.Record{result.Record}))
+			},
+		},
+		{
+			testCase: "keeps a matching CNAME and replaces others",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				ctx := context.Background()
+				current := dns.Record{ID: "1", Type: dns.RecordTypeCNAME, Name: "www.foo.net", Content: "Bar.foo.net", TTL: 1}
+				provider := &fakeProvider{records: []dns.Record{current}}
+
+				result, err := dns.ApplyCNAME(ctx, provider, "www.foo.net", "bar.foo.net")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Changed()).To(BeFalse())
+				g.Expect(result.Record).To(Equal(current))
+
+				result, err = dns.ApplyCNAME(ctx, provider, "www.foo.net", "baz.foo.net")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Created).To(BeTrue())
+				g.Expect(result.Deleted).To(Equal([]dns.Record{current}))
+				g.Expect(provider.records).To(Equal([]dns.Record{
+					{Type: dns.RecordTypeCNAME, Name: "www.foo.net", Content: "baz.foo.net", TTL: 1},
+				}))
+			},
+		},
+		{
+			testCase: "returns errors applying a CNAME",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				ctx := context.Background()
+				_, err := dns.ApplyCNAME(ctx, &fakeProvider{err: fmt.Errorf("nope")}, "www.foo.net", "bar.foo.net")
+				g.Expect(err).To(MatchError("nope"))
+
+				_, err = dns.ApplyCNAME(ctx, &fakeProvider{writeErr: fmt.Errorf("nope")}, "www.foo.net", "bar.foo.net")
+				g.Expect(err).To(MatchError("nope"))
+
+				provider := &fakeProvider{
+					records:  []dns.Record{{ID: "1", Type: dns.RecordTypeCNAME, Name: "www.foo.net", Content: "baz.foo.net"}},
+					writeErr: fmt.Errorf("nope"),
+				}
+				_, err = dns.ApplyCNAME(ctx, provider, "www.foo.net", "bar.foo.net")
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// OwnershipPrefix is prepended to a managed name to build the name of
+	// the TXT record marking it as managed by qrkdns
+	OwnershipPrefix string = "_qrkdns."
+
+	// ownershipHeritage identifies TXT content written by qrkdns
+	ownershipHeritage string = "heritage=qrkdns"
+
+	// ownershipWildcard replaces the wildcard label in the name of an
+	// ownership record, where it wouldn't be the leftmost label anymore
+	ownershipWildcard string = "_wildcard"
+)
+
+// OwnershipRecordName returns the name of the TXT record that marks name as managed
+func OwnershipRecordName(name string) string {
+	return MetadataRecordName(OwnershipPrefix, name)
+}
+
+// MetadataRecordName returns the name of a TXT record holding metadata
+// about name, made of the prefix and name. The wildcard label of name is
+// replaced, as it must stay the leftmost label.
+func MetadataRecordName(prefix, name string) string {
+	if rest, found := strings.CutPrefix(name, WildcardLabel+"."); found {
+		name = ownershipWildcard + "." + rest
+	}
+	return prefix + name
+}
+
+// OwnershipContent returns the TXT content identifying the owner of a managed name
+func OwnershipContent(owner string) string {
+	return fmt.Sprintf("%v,owner=%v", ownershipHeritage, owner)
+}
+
+// ParseOwnership returns the managed name and its owner if record is an ownership record
+func ParseOwnership(record Record) (string, string, bool) {
+	if record.Type != RecordTypeTXT || !strings.HasPrefix(record.Name, OwnershipPrefix) {
+		return "", "", false
+	}
+
+	content := strings.Trim(record.Content, `"`)
+	if !strings.HasPrefix(content, ownershipHeritage+",") {
+		return "", "", false
+	}
+
+	owner := ""
+	for _, field := range strings.Split(content, ",") {
+		if value, found := strings.CutPrefix(field, "owner="); found {
+			owner = value
+		}
+	}
+	name := strings.TrimPrefix(record.Name, OwnershipPrefix)
+	if rest, found := strings.CutPrefix(name, ownershipWildcard+"."); found {
+		name = WildcardLabel + "." + rest
+	}
+	return name, owner, true
+}
+
+// Owners maps every managed name found in records to its owner
+func Owners(records []Record) map[string]string {
+	owners := make(map[string]string)
+	for _, record := range records {
+		if name, owner, ok := ParseOwnership(record); ok {
+			owners[name] = owner
+		}
+	}
+	return owners
+}
+
+// EnsureOwnership creates the ownership record for name if the owner doesn't have one yet
+func EnsureOwnership(ctx context.Context, provider Provider, name, owner string) error {
+	records, err := provider.ListRecords(ctx, RecordFilter{Name: OwnershipRecordName(name), Type: RecordTypeTXT})
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if _, recordOwner, ok := ParseOwnership(record); ok && recordOwner == owner {
+			return nil
+		}
+	}
+
+	_, err = provider.CreateRecord(ctx, Record{
+		Type:    RecordTypeTXT,
+		Name:    OwnershipRecordName(name),
+		Content: OwnershipContent(owner),
+		TTL:     1,
+	})
+	return err
+}
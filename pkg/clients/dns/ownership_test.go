@@ -0,0 +1,168 @@
+package dns_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// fakeProvider keeps records in memory
+type fakeProvider struct {
+	dns.Provider
+	records []dns.Record
+	err     error
+	// errType restricts err to listings of a single record type
+	errType dns.RecordType
+	// writeErr is returned when creating, updating or deleting records
+	writeErr error
+	// deleteErr is returned when deleting records
+	deleteErr error
+}
+
+func (p *fakeProvider) ListRecords(ctx context.Context, filter dns.RecordFilter) ([]dns.Record, error) {
+	results := []dns.Record{}
+	for _, record := range p.records {
+		if (filter.Name == "" || record.Name == filter.Name) && (filter.Type == "" || record.Type == filter.Type) {
+			results = append(results, record)
+		}
+	}
+	if p.errType != "" && p.errType != filter.Type {
+		return results, nil
+	}
+	return results, p.err
+}
+
+func (p *fakeProvider) CreateRecord(ctx context.Context, record dns.Record) (dns.Record, error) {
+	if p.writeErr != nil {
+		return dns.Record{}, p.writeErr
+	}
+	p.records = append(p.records, record)
+	return record, nil
+}
+
+func (p *fakeProvider) UpdateRecord(ctx context.Context, record dns.Record) (dns.Record, error) {
+	if p.writeErr != nil {
+		return dns.Record{}, p.writeErr
+	}
+	for i, existing := range p.records {
+		if existing.ID == record.ID {
+			p.records[i] = record
+		}
+	}
+	return record, nil
+}
+
+func (p *fakeProvider) DeleteRecord(ctx context.Context, record dns.Record) error {
+	if p.writeErr != nil {
+		return p.writeErr
+	}
+	if p.deleteErr != nil {
+		return p.deleteErr
+	}
+	for i, existing := range p.records {
+		if existing.ID == record.ID {
+			p.records = append(p.records[:i], p.records[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func TestOwnership(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "parses ownership records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				record := dns.Record{
+					Type:    dns.RecordTypeTXT,
+					Name:    dns.OwnershipRecordName("bar.foo.net"),
+					Content: fmt.Sprintf(`"%v"`, dns.OwnershipContent("office")),
+				}
+				name, owner, ok := dns.ParseOwnership(record)
+				g.Expect(ok).To(BeTrue())
+				g.Expect(name).To(Equal("bar.foo.net"))
+				g.Expect(owner).To(Equal("office"))
+
+				_, _, ok = dns.ParseOwnership(dns.Record{Type: dns.RecordTypeTXT, Name: "_qrkdns.bar.foo.net", Content: "v=spf1"})
+				g.Expect(ok).To(BeFalse())
+
+				_, _, ok = dns.ParseOwnership(dns.Record{Type: dns.RecordTypeA, Name: "bar.foo.net", Content: "1.2.3.4"})
+				g.Expect(ok).To(BeFalse())
+			},
+		},
+		{
+			testCase: "parses ownership records of wildcards",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				record := dns.Record{
+					Type:    dns.RecordTypeTXT,
+					Name:    dns.OwnershipRecordName("*.office.foo.net"),
+					Content: dns.OwnershipContent("office"),
+				}
+				g.Expect(record.Name).To(Equal("_qrkdns._wildcard.office.foo.net"))
+
+				name, _, ok := dns.ParseOwnership(record)
+				g.Expect(ok).To(BeTrue())
+				g.Expect(name).To(Equal("*.office.foo.net"))
+			},
+		},
+		{
+			testCase: "maps managed names to owners",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				owners := dns.Owners([]dns.Record{
+					{Type: dns.RecordTypeTXT, Name: dns.OwnershipRecordName("a.foo.net"), Content: dns.OwnershipContent("one")},
+					{Type: dns.RecordTypeTXT, Name: dns.OwnershipRecordName("b.foo.net"), Content: dns.OwnershipContent("two")},
+					{Type: dns.RecordTypeA, Name: "a.foo.net", Content: "1.2.3.4"},
+				})
+				g.Expect(owners).To(Equal(map[string]string{"a.foo.net": "one", "b.foo.net": "two"}))
+			},
+		},
+		{
+			testCase: "creates the ownership record only once",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				ctx := context.Background()
+				provider := &fakeProvider{}
+
+				g.Expect(dns.EnsureOwnership(ctx, provider, "bar.foo.net", "office")).To(Succeed())
+				g.Expect(dns.EnsureOwnership(ctx, provider, "bar.foo.net", "office")).To(Succeed())
+				g.Expect(provider.records).To(Equal([]dns.Record{
+					{
+						Type:    dns.RecordTypeTXT,
+						Name:    "_qrkdns.bar.foo.net",
+						Content: "heritage=qrkdns,owner=office",
+						TTL:     1,
+					},
+				}))
+			},
+		},
+		{
+			testCase: "returns error listing ownership records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				provider := &fakeProvider{err: fmt.Errorf("nope")}
+				err := dns.EnsureOwnership(context.Background(), provider, "bar.foo.net", "office")
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"context"
+)
+
+// SetResult describes the changes made to a provider while applying a
+// set of records
+type SetResult struct {
+	// Records are the records left in place after applying, in the order
+	// of the contents
+	Records []Record `json:"records"`
+	// Previous holds the records that existed before any change was made
+	Previous []Record `json:"previous"`
+	// Created holds every record that was created
+	Created []Record `json:"created"`
+	// Deleted holds every record that was removed
+	Deleted []Record `json:"deleted"`
+}
+
+// Changed reports whether applying the set mutated the provider in any way
+func (r *SetResult) Changed() bool {
+	return len(r.Created) > 0 || len(r.Deleted) > 0
+}
+
+// ApplyARecordSet makes the A records of name match addresses, creating
+// the missing records before deleting the others, so that the name always
+// resolves to some address
+func ApplyARecordSet(ctx context.Context, provider Provider, name string, addresses []string) (SetResult, error) {
+	return ApplyRecordSet(ctx, provider, RecordTypeA, name, addresses)
+}
+
+// ApplyRecordSet makes the records of the given type of name match
+// contents, creating the missing records before deleting the others
+func ApplyRecordSet(ctx context.Context, provider Provider, recordType RecordType, name string, contents []string) (SetResult, error) {
+	existing, err := provider.ListRecords(ctx, RecordFilter{Name: name, Type: recordType})
+	if err != nil {
+		return SetResult{}, err
+	}
+
+	result := SetResult{
+		Records:  []Record{},
+		Previous: existing,
+		Created:  []Record{},
+		Deleted:  []Record{},
+	}
+	kept := map[string]Record{}
+	extra := []Record{}
+	for _, record := range existing {
+		if _, seen := kept[record.Content]; seen || !contains(contents, record.Content) {
+			extra = append(extra, record)
+			continue
+		}
+		kept[record.Content] = record
+	}
+
+	for _, content := range contents {
+		record, found := kept[content]
+		if !found {
+			record, err = provider.CreateRecord(ctx, Record{
+				Type:    recordType,
+				Name:    name,
+				Content: content,
+				TTL:     1,
+			})
+			if err != nil {
+				return SetResult{}, err
+			}
+			result.Created = append(result.Created, record)
+			kept[content] = record
+		}
+		result.Records = append(result.Records, record)
+	}
+
+	for _, record := range extra {
+		if err := provider.DeleteRecord(ctx, record); err != nil {
+			return SetResult{}, err
+		}
+		result.Deleted = append(result.Deleted, record)
+	}
+	return result, nil
+}
+
+// contains reports whether values holds value
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
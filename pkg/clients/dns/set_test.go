@@ -0,0 +1,91 @@
+package dns_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	. "github.com/onsi/gomega"
+)
+
+func TestRecordSet(t *testing.T) {
+	ctx := context.Background()
+	record := func(id, name, ip string) dns.Record {
+		return dns.Record{ID: id, Type: dns.RecordTypeA, Name: name, Content: ip, TTL: 1}
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "creates missing records before deleting the others",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				existing := []dns.Record{
+					record("a", "www.foo.net", "1.2.3.4"),
+					record("b", "www.foo.net", "1.2.3.6"),
+					record("c", "www.foo.net", "1.2.3.4"),
+					record("d", "api.foo.net", "1.2.3.6"),
+				}
+				provider := &fakeProvider{records: append([]dns.Record{}, existing...)}
+				result, err := dns.ApplyARecordSet(ctx, provider, "www.foo.net", []string{"1.2.3.4", "1.2.3.5"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Changed()).To(BeTrue())
+				g.Expect(result.Previous).To(Equal(existing[:3]))
+				g.Expect(result.Records).To(Equal([]dns.Record{existing[0], record("", "www.foo.net", "1.2.3.5")}))
+				g.Expect(result.Created).To(Equal([]dns.Record{record("", "www.foo.net", "1.2.3.5")}))
+				g.Expect(result.Deleted).To(Equal([]dns.Record{existing[1], existing[2]}))
+				g.Expect(provider.records).To(Equal([]dns.Record{existing[0], existing[3], record("", "www.foo.net", "1.2.3.5")}))
+			},
+		},
+		{
+			testCase: "leaves a matching set alone",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				existing := []dns.Record{record("a", "www.foo.net", "1.2.3.4")}
+				provider := &fakeProvider{records: existing}
+				result, err := dns.ApplyARecordSet(ctx, provider, "www.foo.net", []string{"1.2.3.4"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Changed()).To(BeFalse())
+				g.Expect(result.Records).To(Equal(existing))
+			},
+		},
+		{
+			testCase: "applies records of other types",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				existing := []dns.Record{
+					record("a", "nas.foo.net", "1.2.3.4"),
+					{ID: "b", Type: dns.RecordTypeAAAA, Name: "nas.foo.net", Content: "2001:db8::10", TTL: 1},
+				}
+				provider := &fakeProvider{records: append([]dns.Record{}, existing...)}
+				result, err := dns.ApplyRecordSet(ctx, provider, dns.RecordTypeAAAA, "nas.foo.net", []string{"2001:db8:1::10"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Created).To(Equal([]dns.Record{{Type: dns.RecordTypeAAAA, Name: "nas.foo.net", Content: "2001:db8:1::10", TTL: 1}}))
+				g.Expect(result.Deleted).To(Equal([]dns.Record{existing[1]}))
+				g.Expect(provider.records).To(Equal([]dns.Record{existing[0], result.Created[0]}))
+			},
+		},
+		{
+			testCase: "returns errors",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				existing := []dns.Record{record("a", "www.foo.net", "1.2.3.4")}
+				_, err := dns.ApplyARecordSet(ctx, &fakeProvider{err: errors.New("boom")}, "www.foo.net", []string{"1.2.3.4"})
+				g.Expect(err).To(MatchError("boom"))
+				_, err = dns.ApplyARecordSet(ctx, &fakeProvider{writeErr: errors.New("boom")}, "www.foo.net", []string{"1.2.3.4"})
+				g.Expect(err).To(MatchError("boom"))
+				_, err = dns.ApplyARecordSet(ctx, &fakeProvider{records: existing, deleteErr: errors.New("boom")}, "www.foo.net", []string{"1.2.3.5"})
+				g.Expect(err).To(MatchError("boom"))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
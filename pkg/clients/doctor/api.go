@@ -0,0 +1,60 @@
+package doctor
+
+import (
+	"context"
+	"net/http"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	// StatusPass means the check succeeded
+	StatusPass Status = "pass"
+
+	// StatusWarn means the check found something that may cause trouble
+	StatusWarn Status = "warn"
+
+	// StatusFail means the check found something that will break syncing
+	StatusFail Status = "fail"
+
+	// StatusSkip means the check couldn't run because an earlier one failed
+	StatusSkip Status = "skip"
+)
+
+// Check is the result of a single preflight check
+type Check struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	// Remedy tells how to fix a failed or suspicious check
+	Remedy string `json:"remedy,omitempty"`
+}
+
+// Diagnoser is implemented by DNS providers able to check their own
+// credentials and permissions
+type Diagnoser interface {
+	// Diagnose checks the provider's configuration without changing anything
+	Diagnose(ctx context.Context) []Check
+}
+
+// IPSource discovers the external IP address
+type IPSource interface {
+	GetExternalIPAddress(ctx context.Context) (string, error)
+}
+
+// HTTPClient wraps the HTTP client used to read the time of a server
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Failed returns how many checks failed
+func Failed(checks []Check) int {
+	failed := 0
+	for _, check := range checks {
+		if check.Status == StatusFail {
+			failed++
+		}
+	}
+	return failed
+}
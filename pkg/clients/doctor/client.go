@@ -0,0 +1,120 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultMaxClockSkew is the largest clock difference considered healthy
+	DefaultMaxClockSkew time.Duration = 30 * time.Second
+)
+
+// DefaultClient runs the preflight checks that don't depend on a provider
+type DefaultClient struct {
+	Client HTTPClient
+	// Now returns the local time compared with the server's
+	Now          func() time.Time
+	MaxClockSkew time.Duration
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient returns a new doctor client
+func NewClient(opts ...LoadOption) (*DefaultClient, error) {
+	client := &DefaultClient{
+		Client:       &http.Client{},
+		Now:          time.Now,
+		MaxClockSkew: DefaultMaxClockSkew,
+	}
+	for _, opt := range opts {
+		if err := opt(client); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// CheckIPSource checks that the IP source can be reached and answers with
+// a valid IPv4 address
+func (c *DefaultClient) CheckIPSource(ctx context.Context, name string, source IPSource) Check {
+	check := Check{Name: fmt.Sprintf("IP source %v", name)}
+	address, err := source.GetExternalIPAddress(ctx)
+	if err != nil {
+		check.Status = StatusFail
+		check.Message = err.Error()
+		check.Remedy = "Make sure the IP source works from this host, or use another one with --ip-source or --ip-service-url"
+		return check
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() == nil {
+		check.Status = StatusFail
+		check.Message = fmt.Sprintf("answered %q, which isn't an IPv4 address", truncate(address, 64))
+		check.Remedy = "Use an IP source answering with the bare address in plain text, such as http://checkip.amazonaws.com"
+		return check
+	}
+
+	check.Status = StatusPass
+	check.Message = fmt.Sprintf("answered %v", address)
+	return check
+}
+
+// CheckClock compares the local time with the Date header of the server
+// at url
+func (c *DefaultClient) CheckClock(ctx context.Context, url string) Check {
+	check := Check{Name: "Clock skew"}
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		check.Status = StatusWarn
+		check.Message = err.Error()
+		return check
+	}
+
+	sent := c.Now()
+	response, err := c.Client.Do(request)
+	if err != nil {
+		check.Status = StatusWarn
+		check.Message = fmt.Sprintf("couldn't read the time from %v: %v", url, err)
+		return check
+	}
+	_ = response.Body.Close()
+	received := c.Now()
+
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		check.Status = StatusWarn
+		check.Message = fmt.Sprintf("%v didn't return a valid Date header", url)
+		return check
+	}
+
+	// The Date header has a one second resolution and is generated
+	// somewhere between sending the request and receiving the response
+	local := sent.Add(received.Sub(sent) / 2)
+	skew := local.Sub(date).Round(time.Second)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > c.MaxClockSkew {
+		check.Status = StatusFail
+		check.Message = fmt.Sprintf("the local clock is %v off from %v", skew, url)
+		check.Remedy = "Synchronize the clock with NTP (e.g., enable systemd-timesyncd or chronyd)"
+		return check
+	}
+
+	check.Status = StatusPass
+	check.Message = fmt.Sprintf("the local clock is within %v of %v", c.MaxClockSkew, url)
+	return check
+}
+
+// truncate shortens long text, such as an HTML error page
+func truncate(text string, length int) string {
+	if len(text) <= length {
+		return text
+	}
+	return text[:length] + "..."
+}
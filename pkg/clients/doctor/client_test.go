@@ -0,0 +1,144 @@
+package doctor_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/doctor"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// staticSource answers with a fixed address or error
+type staticSource struct {
+	address string
+	err     error
+}
+
+func (s *staticSource) GetExternalIPAddress(ctx context.Context) (string, error) {
+	return s.address, s.err
+}
+
+// failingHTTPClient fails every request
+type failingHTTPClient struct{}
+
+func (f *failingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return nil, errors.New("foo")
+}
+
+// dateServer answers with the Date header set to date, or none if empty
+func dateServer(date string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Date"] = []string{date}
+	}))
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	withNow := func(client *doctor.DefaultClient) error {
+		client.Now = func() time.Time { return now }
+		return nil
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "checks IP sources",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := doctor.NewClient()
+				g.Expect(err).NotTo(HaveOccurred())
+
+				check := client.CheckIPSource(ctx, "http://ip", &staticSource{address: "1.2.3.4"})
+				g.Expect(check).To(Equal(doctor.Check{Name: "IP source http://ip", Status: doctor.StatusPass, Message: "answered 1.2.3.4"}))
+
+				check = client.CheckIPSource(ctx, "http://ip", &staticSource{err: errors.New("received status code 500: oops")})
+				g.Expect(check.Status).To(Equal(doctor.StatusFail))
+				g.Expect(check.Message).To(Equal("received status code 500: oops"))
+				g.Expect(check.Remedy).To(ContainSubstring("--ip-service-url"))
+
+				check = client.CheckIPSource(ctx, "http://ip", &staticSource{address: "::1"})
+				g.Expect(check.Status).To(Equal(doctor.StatusFail))
+				g.Expect(check.Message).To(Equal(`answered "::1", which isn't an IPv4 address`))
+
+				// Long answers, such as error pages, are shortened
+				check = client.CheckIPSource(ctx, "http://ip", &staticSource{address: strings.Repeat("x", 100)})
+				g.Expect(check.Message).To(Equal(fmt.Sprintf("answered %q, which isn't an IPv4 address", strings.Repeat("x", 64)+"...")))
+			},
+		},
+		{
+			testCase: "checks clock skew",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := doctor.NewClient(withNow)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				server := dateServer(now.Add(10 * time.Second).Format(http.TimeFormat))
+				defer server.Close()
+				check := client.CheckClock(ctx, server.URL)
+				g.Expect(check.Status).To(Equal(doctor.StatusPass))
+				g.Expect(check.Message).To(Equal("the local clock is within 30s of " + server.URL))
+
+				server = dateServer(now.Add(-2 * time.Minute).Format(http.TimeFormat))
+				defer server.Close()
+				check = client.CheckClock(ctx, server.URL)
+				g.Expect(check.Status).To(Equal(doctor.StatusFail))
+				g.Expect(check.Message).To(Equal("the local clock is 2m0s off from " + server.URL))
+				g.Expect(check.Remedy).To(ContainSubstring("NTP"))
+
+				server = dateServer("yesterday")
+				defer server.Close()
+				check = client.CheckClock(ctx, server.URL)
+				g.Expect(check.Status).To(Equal(doctor.StatusWarn))
+				g.Expect(check.Message).To(Equal(server.URL + " didn't return a valid Date header"))
+
+				check = client.CheckClock(ctx, "http://\x7f")
+				g.Expect(check.Status).To(Equal(doctor.StatusWarn))
+				g.Expect(check.Message).To(ContainSubstring("invalid control character in URL"))
+
+				client.Client = &failingHTTPClient{}
+				check = client.CheckClock(ctx, "http://clock")
+				g.Expect(check.Status).To(Equal(doctor.StatusWarn))
+				g.Expect(check.Message).To(Equal("couldn't read the time from http://clock: foo"))
+			},
+		},
+		{
+			testCase: "counts failed checks",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				g.Expect(doctor.Failed([]doctor.Check{
+					{Status: doctor.StatusPass},
+					{Status: doctor.StatusFail},
+					{Status: doctor.StatusWarn},
+					{Status: doctor.StatusSkip},
+					{Status: doctor.StatusFail},
+				})).To(Equal(2))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, err := doctor.NewClient(func(client *doctor.DefaultClient) error {
+					return errors.New("foo")
+				})
+				g.Expect(err).To(MatchError("foo"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
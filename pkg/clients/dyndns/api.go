@@ -0,0 +1,53 @@
+package dyndns
+
+import (
+	"context"
+)
+
+// Response is the code starting each line of a dyndns2 answer
+type Response string
+
+const (
+	// ResponseGood means the hostname now points to the address
+	ResponseGood Response = "good"
+
+	// ResponseNoChange means the hostname already pointed to the address
+	ResponseNoChange Response = "nochg"
+
+	// ResponseBadAuth means the credentials were missing or wrong
+	ResponseBadAuth Response = "badauth"
+
+	// ResponseNoHost means the user may not update the hostname
+	ResponseNoHost Response = "nohost"
+
+	// ResponseNotFQDN means the hostname is missing or malformed
+	ResponseNotFQDN Response = "notfqdn"
+
+	// ResponseNumHost means too many hostnames were sent at once
+	ResponseNumHost Response = "numhost"
+
+	// ResponseServerError means the update failed, and may be retried later
+	ResponseServerError Response = "911"
+)
+
+const (
+	// UpdatePath is where routers send their updates
+	UpdatePath string = "/nic/update"
+
+	// MaxHosts is the most hostnames a single request may update
+	MaxHosts int = 20
+)
+
+// User may update a fixed set of hostnames
+type User struct {
+	Name     string
+	Password string
+	// Hosts holds fully qualified names, in their A-label form
+	Hosts []string
+}
+
+// Updater publishes the address of a hostname on behalf of a user,
+// returning true if anything changed
+type Updater interface {
+	Update(ctx context.Context, user, hostname, ipAddress string) (bool, error)
+}
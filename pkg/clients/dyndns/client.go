@@ -0,0 +1,256 @@
+package dyndns
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/proxy"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// Assert server matches the correct interface
+	_ http.Handler = &DefaultServer{}
+)
+
+// DefaultServer answers dyndns2 update requests, forwarding them to an Updater
+type DefaultServer struct {
+	// Users maps user names to their credentials and hostnames
+	Users   map[string]User
+	Updater Updater
+	// TrustedProxies may set X-Forwarded-For on behalf of clients
+	TrustedProxies proxy.Trusted
+	// ShutdownTimeout bounds the wait for running requests when stopping
+	ShutdownTimeout time.Duration
+}
+
+// LoadOption allows for modifying the server after it's created
+type LoadOption func(server *DefaultServer) error
+
+// NewServer returns a new dyndns2 server
+func NewServer(users []User, updater Updater, opts ...LoadOption) (*DefaultServer, error) {
+	server := &DefaultServer{
+		Users:           make(map[string]User),
+		Updater:         updater,
+		ShutdownTimeout: 10 * time.Second,
+	}
+	for _, user := range users {
+		if _, found := server.Users[user.Name]; found {
+			return nil, fmt.Errorf("user %v is defined more than once", user.Name)
+		}
+		server.Users[user.Name] = user
+	}
+
+	for _, opt := range opts {
+		if err := opt(server); err != nil {
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+// WithTrustedProxies trusts the X-Forwarded-For header of requests coming
+// from the given addresses or ranges
+func WithTrustedProxies(proxies []string) LoadOption {
+	return func(server *DefaultServer) error {
+		trusted, err := proxy.ParseTrusted(proxies)
+		if err != nil {
+			return err
+		}
+		server.TrustedProxies = append(server.TrustedProxies, trusted...)
+		return nil
+	}
+}
+
+// LoadUsers reads users from a file holding one user:password:hosts line
+// per user, where hosts is a comma-separated list
+func LoadUsers(path string) ([]User, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseUsers(file)
+}
+
+// ParseUsers reads users from user:password:hosts lines. Blank lines and
+// lines starting with # are ignored.
+func ParseUsers(r io.Reader) ([]User, error) {
+	users := []User{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// Passwords may contain colons, user names and hosts can't
+		first, last := strings.Index(text, ":"), strings.LastIndex(text, ":")
+		if first <= 0 || first == last {
+			return nil, fmt.Errorf("line %v: expected user:password:hosts", line)
+		}
+		user := User{Name: text[:first], Password: text[first+1 : last]}
+		for _, host := range strings.Split(text[last+1:], ",") {
+			host, err := dns.ToASCII(strings.TrimSuffix(strings.TrimSpace(host), "."))
+			if err != nil {
+				return nil, fmt.Errorf("line %v: %w", line, err)
+			}
+			if host != "" {
+				user.Hosts = append(user.Hosts, host)
+			}
+		}
+		if user.Password == "" || len(user.Hosts) == 0 {
+			return nil, fmt.Errorf("line %v: user %v needs a password and at least one host", line, user.Name)
+		}
+		users = append(users, user)
+	}
+	return users, scanner.Err()
+}
+
+// ListenAndServe serves updates on the address until the context is done.
+// TLS is used when a certificate and key are given.
+func (s *DefaultServer) ListenAndServe(ctx context.Context, address, certFile, keyFile string) error {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+		defer cancel()
+		stopped <- server.Shutdown(shutdownCtx)
+	}()
+
+	var err error
+	if certFile != "" || keyFile != "" {
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-stopped
+}
+
+// ServeHTTP implements http.Handler, answering each hostname of the update
+// on its own line
+func (s *DefaultServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != UpdatePath {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	user, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="qrkdns"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, ResponseBadAuth)
+		return
+	}
+
+	hostnames := strings.Split(r.URL.Query().Get("hostname"), ",")
+	if len(hostnames) > MaxHosts {
+		fmt.Fprintln(w, ResponseNumHost)
+		return
+	}
+
+	contextLog := log.WithFields(log.Fields{"user": user.Name, "remote_addr": r.RemoteAddr})
+	ipAddress, err := s.address(r)
+	if err != nil {
+		contextLog.WithError(err).Warn("Refusing dyndns2 update")
+		fmt.Fprintln(w, ResponseServerError)
+		return
+	}
+
+	for _, hostname := range hostnames {
+		response := s.update(r.Context(), user, hostname, ipAddress)
+		contextLog.WithFields(log.Fields{
+			"hostname": hostname,
+			"ip":       ipAddress,
+			"response": response,
+		}).Info("Answered dyndns2 update")
+		fmt.Fprintln(w, response)
+	}
+}
+
+// authenticate returns the user matching the basic auth credentials
+func (s *DefaultServer) authenticate(r *http.Request) (User, bool) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return User{}, false
+	}
+	user, found := s.Users[name]
+	if !found {
+		return User{}, false
+	}
+	if subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		return User{}, false
+	}
+	return user, true
+}
+
+// update publishes the address of a single hostname and returns the answer
+func (s *DefaultServer) update(ctx context.Context, user User, hostname, ipAddress string) string {
+	hostname, err := dns.ToASCII(strings.TrimSuffix(strings.TrimSpace(hostname), "."))
+	if err != nil || !strings.Contains(hostname, ".") {
+		return string(ResponseNotFQDN)
+	}
+	if !allowed(user, hostname) {
+		return string(ResponseNoHost)
+	}
+
+	changed, err := s.Updater.Update(ctx, user.Name, hostname, ipAddress)
+	if err != nil {
+		log.WithError(err).WithField("hostname", dns.ToUnicode(hostname)).Error("Failed to apply dyndns2 update")
+		return string(ResponseServerError)
+	}
+	if changed {
+		return fmt.Sprintf("%v %v", ResponseGood, ipAddress)
+	}
+	return fmt.Sprintf("%v %v", ResponseNoChange, ipAddress)
+}
+
+// address returns the IPv4 address to publish: the first one of myip, or
+// else the client's address
+func (s *DefaultServer) address(r *http.Request) (string, error) {
+	if myIP := r.URL.Query().Get("myip"); myIP != "" {
+		for _, value := range strings.Split(myIP, ",") {
+			addr, err := netip.ParseAddr(strings.TrimSpace(value))
+			if err == nil && addr.Is4() {
+				return addr.String(), nil
+			}
+		}
+		return "", fmt.Errorf("myip %v holds no IPv4 address", myIP)
+	}
+
+	addr := s.TrustedProxies.ClientAddress(r)
+	if !addr.Is4() {
+		return "", fmt.Errorf("client address %v isn't an IPv4 address", addr)
+	}
+	return addr.String(), nil
+}
+
+// allowed returns true if the user may update the hostname
+func allowed(user User, hostname string) bool {
+	for _, host := range user.Hosts {
+		if host == hostname {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,278 @@
+package dyndns_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dyndns"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// fakeUpdater records updates, failing for hostnames in errors
+type fakeUpdater struct {
+	updates []string
+	current map[string]string
+	errors  map[string]error
+}
+
+func (u *fakeUpdater) Update(ctx context.Context, user, hostname, ipAddress string) (bool, error) {
+	if err := u.errors[hostname]; err != nil {
+		return false, err
+	}
+	u.updates = append(u.updates, fmt.Sprintf("%v %v %v", user, hostname, ipAddress))
+	if u.current == nil {
+		u.current = make(map[string]string)
+	}
+	changed := u.current[hostname] != ipAddress
+	u.current[hostname] = ipAddress
+	return changed, nil
+}
+
+var users = []dyndns.User{
+	{Name: "router", Password: "s3cr:et", Hosts: []string{"home.example.com", "nas.example.com"}},
+	{Name: "office", Password: "hunter2", Hosts: []string{"office.example.com"}},
+}
+
+// update sends an update request and returns the response code and body
+func update(g *WithT, server *dyndns.DefaultServer, target, user, password string, prepare ...func(*http.Request)) (int, string) {
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	request.RemoteAddr = "203.0.113.7:41234"
+	if user != "" {
+		request.SetBasicAuth(user, password)
+	}
+	for _, fn := range prepare {
+		fn(request)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	g.Expect(recorder.Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestServer(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "applies updates from myip and the client address",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				updater := &fakeUpdater{}
+				server, err := dyndns.NewServer(users, updater)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				code, body := update(g, server, "/nic/update?hostname=home.example.com&myip=1.2.3.4", "router", "s3cr:et")
+				g.Expect(code).To(Equal(http.StatusOK))
+				g.Expect(body).To(Equal("good 1.2.3.4\n"))
+
+				_, body = update(g, server, "/nic/update?hostname=home.example.com&myip=1.2.3.4", "router", "s3cr:et")
+				g.Expect(body).To(Equal("nochg 1.2.3.4\n"))
+
+				// The first IPv4 address of myip is used
+				_, body = update(g, server, "/nic/update?hostname=HOME.example.com.,nas.example.com&myip=2001:db8::1,5.6.7.8", "router", "s3cr:et")
+				g.Expect(body).To(Equal("good 5.6.7.8\ngood 5.6.7.8\n"))
+
+				_, body = update(g, server, "/nic/update?hostname=office.example.com", "office", "hunter2")
+				g.Expect(body).To(Equal("good 203.0.113.7\n"))
+
+				g.Expect(updater.updates).To(Equal([]string{
+					"router home.example.com 1.2.3.4",
+					"router home.example.com 1.2.3.4",
+					"router home.example.com 5.6.7.8",
+					"router nas.example.com 5.6.7.8",
+					"office office.example.com 203.0.113.7",
+				}))
+			},
+		},
+		{
+			testCase: "rejects bad credentials",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				updater := &fakeUpdater{}
+				server, err := dyndns.NewServer(users, updater)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				for _, credentials := range [][]string{{"", ""}, {"router", "wrong"}, {"nobody", "s3cr:et"}} {
+					request := httptest.NewRequest(http.MethodGet, "/nic/update?hostname=home.example.com", nil)
+					if credentials[0] != "" {
+						request.SetBasicAuth(credentials[0], credentials[1])
+					}
+					recorder := httptest.NewRecorder()
+					server.ServeHTTP(recorder, request)
+					g.Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+					g.Expect(recorder.Header().Get("WWW-Authenticate")).To(Equal(`Basic realm="qrkdns"`))
+					g.Expect(recorder.Body.String()).To(Equal("badauth\n"))
+				}
+				g.Expect(updater.updates).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "rejects hostnames the user may not update",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				updater := &fakeUpdater{errors: map[string]error{"nas.example.com": errors.New("boom")}}
+				server, err := dyndns.NewServer(users, updater)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				_, body := update(g, server, "/nic/update?hostname=office.example.com,home.example.com,nas.example.com&myip=1.2.3.4", "router", "s3cr:et")
+				g.Expect(body).To(Equal("nohost\ngood 1.2.3.4\n911\n"))
+
+				_, body = update(g, server, "/nic/update?myip=1.2.3.4", "router", "s3cr:et")
+				g.Expect(body).To(Equal("notfqdn\n"))
+
+				_, body = update(g, server, "/nic/update?hostname=home&myip=1.2.3.4", "router", "s3cr:et")
+				g.Expect(body).To(Equal("notfqdn\n"))
+
+				hosts := strings.Repeat("home.example.com,", dyndns.MaxHosts) + "home.example.com"
+				_, body = update(g, server, "/nic/update?myip=1.2.3.4&hostname="+hosts, "router", "s3cr:et")
+				g.Expect(body).To(Equal("numhost\n"))
+
+				_, body = update(g, server, "/nic/update?hostname=home.example.com&myip=2001:db8::1", "router", "s3cr:et")
+				g.Expect(body).To(Equal("911\n"))
+
+				code, _ := update(g, server, "/other", "router", "s3cr:et")
+				g.Expect(code).To(Equal(http.StatusNotFound))
+				g.Expect(updater.updates).To(Equal([]string{"router home.example.com 1.2.3.4"}))
+			},
+		},
+		{
+			testCase: "reads X-Forwarded-For behind trusted proxies",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				forwarded := func(r *http.Request) {
+					r.Header.Add("X-Forwarded-For", "9.9.9.9, 198.51.100.1")
+					r.Header.Add("X-Forwarded-For", "10.0.0.2")
+				}
+
+				// Untrusted clients can't choose the address
+				server, err := dyndns.NewServer(users, &fakeUpdater{})
+				g.Expect(err).NotTo(HaveOccurred())
+				_, body := update(g, server, "/nic/update?hostname=home.example.com", "router", "s3cr:et", forwarded)
+				g.Expect(body).To(Equal("good 203.0.113.7\n"))
+
+				server, err = dyndns.NewServer(users, &fakeUpdater{}, dyndns.WithTrustedProxies([]string{"203.0.113.7", "10.0.0.0/8"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				_, body = update(g, server, "/nic/update?hostname=home.example.com", "router", "s3cr:et", forwarded)
+				g.Expect(body).To(Equal("good 198.51.100.1\n"))
+
+				// Malformed headers from trusted proxies are refused
+				_, body = update(g, server, "/nic/update?hostname=home.example.com", "router", "s3cr:et", func(r *http.Request) {
+					r.Header.Set("X-Forwarded-For", "198.51.100.1, garbage, 10.0.0.2")
+				})
+				g.Expect(body).To(Equal("911\n"))
+
+				for _, remoteAddr := range []string{"203.0.113.8", "[2001:db8::1]:41234", "nope"} {
+					_, body = update(g, server, "/nic/update?hostname=home.example.com", "router", "s3cr:et", func(r *http.Request) {
+						r.RemoteAddr = remoteAddr
+					})
+					if remoteAddr == "203.0.113.8" {
+						g.Expect(body).To(Equal("good 203.0.113.8\n"))
+					} else {
+						g.Expect(body).To(Equal("911\n"), remoteAddr)
+					}
+				}
+
+				_, err = dyndns.NewServer(users, &fakeUpdater{}, dyndns.WithTrustedProxies([]string{"nope"}))
+				g.Expect(err).To(MatchError("invalid trusted proxy nope"))
+
+				_, err = dyndns.NewServer(append(users, users[0]), &fakeUpdater{})
+				g.Expect(err).To(MatchError("user router is defined more than once"))
+			},
+		},
+		{
+			testCase: "loads users",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				path := filepath.Join(tt.TempDir(), "users")
+				err := os.WriteFile(path, []byte(strings.Join([]string{
+					"# routers",
+					"router:s3cr:et:home.example.com, NAS.example.com.",
+					"",
+					"office:hunter2:bär.example.com",
+				}, "\n")), 0o600)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				loaded, err := dyndns.LoadUsers(path)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(loaded).To(Equal([]dyndns.User{
+					{Name: "router", Password: "s3cr:et", Hosts: []string{"home.example.com", "nas.example.com"}},
+					{Name: "office", Password: "hunter2", Hosts: []string{"xn--br-via.example.com"}},
+				}))
+
+				_, err = dyndns.LoadUsers(filepath.Join(tt.TempDir(), "missing"))
+				g.Expect(err).To(HaveOccurred())
+
+				for line, message := range map[string]string{
+					"router":                        "line 1: expected user:password:hosts",
+					":pass:home.example.com":        "line 1: expected user:password:hosts",
+					"router::home.example.com":      "line 1: user router needs a password and at least one host",
+					"router:pass:xn--a.example.com": "line 1: invalid name xn--a.example.com: label xn--a can't be converted to an A-label: idna: invalid label \"\\u0080\"",
+					"router:pass:":                  "line 1: user router needs a password and at least one host",
+				} {
+					_, err = dyndns.ParseUsers(strings.NewReader(line))
+					g.Expect(err).To(MatchError(message), line)
+				}
+			},
+		},
+		{
+			testCase: "serves until the context is done",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				g.Expect(err).NotTo(HaveOccurred())
+				address := listener.Addr().String()
+				g.Expect(listener.Close()).To(Succeed())
+
+				server, err := dyndns.NewServer(users, &fakeUpdater{})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() { done <- server.ListenAndServe(ctx, address, "", "") }()
+
+				request, err := http.NewRequest(http.MethodGet, "http://"+address+"/nic/update?hostname=home.example.com", nil)
+				g.Expect(err).NotTo(HaveOccurred())
+				request.SetBasicAuth("router", "s3cr:et")
+				var response *http.Response
+				g.Eventually(func() error {
+					response, err = http.DefaultClient.Do(request)
+					return err
+				}, 5*time.Second, 10*time.Millisecond).Should(Succeed())
+				defer response.Body.Close()
+				g.Expect(response.StatusCode).To(Equal(http.StatusOK))
+
+				cancel()
+				g.Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+
+				// A missing certificate fails right away
+				err = server.ListenAndServe(context.Background(), "127.0.0.1:0", "missing.pem", "missing.key")
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package echo
+
+const (
+	// TextPath answers with the caller's address in plain text
+	TextPath string = "/"
+
+	// JSONPath answers with the caller's address in JSON. Requests to
+	// TextPath accepting only application/json get JSON too.
+	JSONPath string = "/json"
+)
+
+// Answer is the JSON answer of the server
+type Answer struct {
+	IP string `json:"ip"`
+	// Version is 4 or 6
+	Version int `json:"version"`
+}
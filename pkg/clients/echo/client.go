@@ -0,0 +1,178 @@
+package echo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	"github.com/markliederbach/qrkdns/pkg/clients/proxy"
+)
+
+var (
+	// Assert server matches the correct interface
+	_ http.Handler = &DefaultServer{}
+)
+
+// DefaultServer answers with the address requests come from
+type DefaultServer struct {
+	// TrustedProxies may report the address of their clients
+	TrustedProxies proxy.Trusted
+	// ProxyProtocol reads the PROXY protocol header sent by trusted proxies
+	ProxyProtocol bool
+	// HMACKey, if set, signs every answer
+	HMACKey []byte
+	// ShutdownTimeout bounds the wait for running requests when stopping
+	ShutdownTimeout time.Duration
+}
+
+// LoadOption allows for modifying the server after it's created
+type LoadOption func(server *DefaultServer) error
+
+// NewServer returns a new echo server
+func NewServer(opts ...LoadOption) (*DefaultServer, error) {
+	server := &DefaultServer{
+		ShutdownTimeout: 10 * time.Second,
+	}
+
+	for _, opt := range opts {
+		if err := opt(server); err != nil {
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+// WithTrustedProxies trusts the X-Forwarded-For header and the PROXY
+// protocol header of connections coming from the given addresses or ranges
+func WithTrustedProxies(proxies []string) LoadOption {
+	return func(server *DefaultServer) error {
+		trusted, err := proxy.ParseTrusted(proxies)
+		if err != nil {
+			return err
+		}
+		server.TrustedProxies = append(server.TrustedProxies, trusted...)
+		return nil
+	}
+}
+
+// WithProxyProtocol reads the PROXY protocol header of connections coming
+// from trusted proxies
+func WithProxyProtocol(enabled bool) LoadOption {
+	return func(server *DefaultServer) error {
+		server.ProxyProtocol = enabled
+		return nil
+	}
+}
+
+// WithHMACKey signs answers with the key, so that clients sharing it can
+// detect tampering
+func WithHMACKey(key string) LoadOption {
+	return func(server *DefaultServer) error {
+		if key != "" {
+			server.HMACKey = []byte(key)
+		}
+		return nil
+	}
+}
+
+// ListenAndServe serves on every address until the context is done. IPv4
+// and IPv6 addresses only listen on their own family, so that both can be
+// given for the same port. TLS is used when a certificate and key are given.
+func (s *DefaultServer) ListenAndServe(ctx context.Context, addresses []string, certFile, keyFile string) error {
+	listeners := []net.Listener{}
+	defer func() {
+		for _, listener := range listeners {
+			_ = listener.Close()
+		}
+	}()
+	for _, address := range addresses {
+		listener, err := net.Listen(listenNetwork(address), address)
+		if err != nil {
+			return err
+		}
+		if s.ProxyProtocol {
+			listener = s.TrustedProxies.Listener(listener)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	failed := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			if certFile != "" || keyFile != "" {
+				failed <- server.ServeTLS(listener, certFile, keyFile)
+			} else {
+				failed <- server.Serve(listener)
+			}
+		}(listener)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-failed:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+	return errors.Join(err, server.Shutdown(shutdownCtx))
+}
+
+// ServeHTTP implements http.Handler
+func (s *DefaultServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != TextPath && r.URL.Path != JSONPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	addr := s.TrustedProxies.ClientAddress(r)
+	if !addr.IsValid() {
+		http.Error(w, "unable to determine the client address", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if len(s.HMACKey) > 0 {
+		w.Header().Set(ip.SignatureHeader, ip.Sign(s.HMACKey, r.Header.Get(ip.NonceHeader), addr.String()))
+	}
+
+	if r.URL.Path == JSONPath || r.Header.Get("Accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Answer{IP: addr.String(), Version: version(addr)})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, addr)
+}
+
+// listenNetwork returns the network to listen on for the address
+func listenNetwork(address string) string {
+	host, _, _ := net.SplitHostPort(address)
+	addr, err := netip.ParseAddr(host)
+	switch {
+	case err != nil:
+		return "tcp"
+	case addr.Is4():
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+// version returns the IP version of the address
+func version(addr netip.Addr) int {
+	if addr.Is4() {
+		return 4
+	}
+	return 6
+}
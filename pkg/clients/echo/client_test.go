@@ -0,0 +1,181 @@
+package echo_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/echo"
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// get sends a request to the server and returns the response
+func get(server *echo.DefaultServer, target, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	request.RemoteAddr = remoteAddr
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// freePort returns a local port nothing listens on
+func freePort(g *WithT) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestServer(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "answers with the caller's address",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				server, err := echo.NewServer()
+				g.Expect(err).NotTo(HaveOccurred())
+
+				response := get(server, "/", "203.0.113.7:41234", nil)
+				g.Expect(response.Code).To(Equal(http.StatusOK))
+				g.Expect(response.Header().Get("Content-Type")).To(Equal("text/plain; charset=utf-8"))
+				g.Expect(response.Header().Get("Cache-Control")).To(Equal("no-store"))
+				g.Expect(response.Header().Get(ip.SignatureHeader)).To(BeEmpty())
+				g.Expect(response.Body.String()).To(Equal("203.0.113.7\n"))
+
+				response = get(server, "/json", "[2001:db8::1]:41234", nil)
+				g.Expect(response.Header().Get("Content-Type")).To(Equal("application/json"))
+				g.Expect(response.Body.String()).To(MatchJSON(`{"ip": "2001:db8::1", "version": 6}`))
+
+				response = get(server, "/", "[::ffff:203.0.113.7]:41234", map[string]string{"Accept": "application/json"})
+				g.Expect(response.Body.String()).To(MatchJSON(`{"ip": "203.0.113.7", "version": 4}`))
+
+				g.Expect(get(server, "/other", "203.0.113.7:41234", nil).Code).To(Equal(http.StatusNotFound))
+			},
+		},
+		{
+			testCase: "reads X-Forwarded-For behind trusted proxies",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				server, err := echo.NewServer(echo.WithTrustedProxies([]string{"10.0.0.0/8"}))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				response := get(server, "/", "10.0.0.2:41234", map[string]string{"X-Forwarded-For": "198.51.100.1"})
+				g.Expect(response.Body.String()).To(Equal("198.51.100.1\n"))
+
+				response = get(server, "/", "203.0.113.7:41234", map[string]string{"X-Forwarded-For": "198.51.100.1"})
+				g.Expect(response.Body.String()).To(Equal("203.0.113.7\n"))
+
+				response = get(server, "/", "10.0.0.2:41234", map[string]string{"X-Forwarded-For": "garbage"})
+				g.Expect(response.Code).To(Equal(http.StatusBadRequest))
+				g.Expect(response.Body.String()).To(Equal("unable to determine the client address\n"))
+
+				_, err = echo.NewServer(echo.WithTrustedProxies([]string{"nope"}))
+				g.Expect(err).To(MatchError("invalid trusted proxy nope"))
+			},
+		},
+		{
+			testCase: "signs answers",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				server, err := echo.NewServer(echo.WithHMACKey("s3cret"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				response := get(server, "/", "203.0.113.7:41234", map[string]string{ip.NonceHeader: "abc"})
+				g.Expect(response.Header().Get(ip.SignatureHeader)).To(Equal(ip.Sign([]byte("s3cret"), "abc", "203.0.113.7")))
+
+				// The IP client verifies the answer
+				httpServer := httptest.NewServer(server)
+				defer httpServer.Close()
+				client, err := ip.NewClient(httpServer.URL, ip.WithHMACKey("s3cret"))
+				g.Expect(err).NotTo(HaveOccurred())
+				ipAddress, err := client.GetExternalIPAddress(context.Background())
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(ipAddress).To(Equal("127.0.0.1"))
+
+				client.HMACKey = []byte("other")
+				_, err = client.GetExternalIPAddress(context.Background())
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "serves IPv4 and IPv6 listeners until the context is done",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				server, err := echo.NewServer(echo.WithTrustedProxies([]string{"127.0.0.1"}), echo.WithProxyProtocol(true))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				port := freePort(g)
+				addresses := []string{fmt.Sprintf("127.0.0.1:%v", port)}
+				ipv6, err := net.Listen("tcp6", "[::1]:0")
+				if err == nil {
+					g.Expect(ipv6.Close()).To(Succeed())
+					addresses = append(addresses, fmt.Sprintf("[::1]:%v", port))
+				}
+
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() { done <- server.ListenAndServe(ctx, addresses, "", "") }()
+
+				for _, address := range addresses {
+					var conn net.Conn
+					g.Eventually(func() error {
+						conn, err = net.Dial("tcp", address)
+						return err
+					}, 5*time.Second, 10*time.Millisecond).Should(Succeed())
+
+					// The trusted proxy reports its client's address
+					_, err = fmt.Fprint(conn, "PROXY TCP4 198.51.100.1 203.0.113.7 41234 80\r\nGET / HTTP/1.0\r\n\r\n")
+					g.Expect(err).NotTo(HaveOccurred())
+					body, err := io.ReadAll(conn)
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(conn.Close()).To(Succeed())
+					if address == addresses[0] {
+						g.Expect(string(body)).To(HaveSuffix("\r\n\r\n198.51.100.1\n"))
+					} else {
+						// ::1 isn't a trusted proxy
+						g.Expect(string(body)).To(ContainSubstring("400 Bad Request"))
+					}
+				}
+
+				cancel()
+				g.Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+
+				// Listening fails right away on busy addresses
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				g.Expect(err).NotTo(HaveOccurred())
+				defer listener.Close()
+				err = server.ListenAndServe(context.Background(), []string{fmt.Sprintf("localhost:%v", freePort(g)), listener.Addr().String()}, "", "")
+				g.Expect(err).To(HaveOccurred())
+
+				// So does serving with a missing certificate
+				err = server.ListenAndServe(context.Background(), []string{"127.0.0.1:0"}, "missing.pem", "missing.key")
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package election
+
+import (
+	"context"
+	"time"
+)
+
+// Role tells whether an agent may mutate records
+type Role string
+
+const (
+	// RoleLeader holds the lease and performs the mutations
+	RoleLeader Role = "leader"
+
+	// RoleFollower stands by until the lease of the leader expires
+	RoleFollower Role = "follower"
+)
+
+// Lease is the claim of the leader on a lock
+type Lease struct {
+	// Holder identifies the agent holding the lease
+	Holder string `json:"holder"`
+	// Acquired is when the holder became the leader
+	Acquired time.Time `json:"acquired"`
+	// Renewed is when the holder last renewed the lease
+	Renewed time.Time `json:"renewed"`
+}
+
+// Expired reports whether the lease wasn't renewed within duration
+func (l *Lease) Expired(duration time.Duration, now time.Time) bool {
+	return now.Sub(l.Renewed) > duration
+}
+
+// State is the outcome of the last election
+type State struct {
+	Role Role `json:"role"`
+	// Leader is the lease in force, if any agent holds it
+	Leader *Lease `json:"leader,omitempty"`
+	// Error is the reason the last attempt to acquire or renew the lease
+	// failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// Lock stores the lease of the leader
+type Lock interface {
+	// Acquire acquires the lease for holder, or renews it if holder already
+	// has it, unless another holder's lease is still live. It returns the
+	// lease in force, which is only the holder's when it is the leader.
+	Acquire(ctx context.Context, holder string, duration time.Duration, now time.Time) (Lease, error)
+
+	// Release gives up the lease of holder, if it has it
+	Release(ctx context.Context, holder string) error
+
+	// String identifies the lock
+	String() string
+}
+
+// Elector decides whether this agent may mutate records
+type Elector interface {
+	// Elect acquires or renews the lease, returning the role of the agent
+	Elect(ctx context.Context) (State, error)
+
+	// State returns the outcome of the last election
+	State() State
+
+	// Release gives up the lease, if held
+	Release(ctx context.Context) error
+}
+
+// adopter is implemented by locks holding state of their own, such as an
+// open file, which moves over when a client adopts another
+type adopter interface {
+	adopt(previous Lock)
+}
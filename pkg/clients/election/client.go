@@ -0,0 +1,128 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// Assert client matches the correct interface
+	_ Elector = &DefaultClient{}
+)
+
+// DefaultClient takes part in the election through a lock
+type DefaultClient struct {
+	Lock Lock
+	// Holder identifies this agent
+	Holder string
+	// LeaseDuration is how long the lease of a leader lasts without being
+	// renewed, before another agent may take over
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps leading while failing to
+	// renew its lease. It is shorter than LeaseDuration, so that the leader
+	// steps down before anyone may take over.
+	RenewDeadline time.Duration
+	// Now returns the current time
+	Now func() time.Time
+
+	mu    sync.Mutex
+	state State
+	// renewed is when this agent last renewed its lease
+	renewed time.Time
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient returns a new client electing holder through the lock
+func NewClient(lock Lock, holder string, leaseDuration, renewDeadline time.Duration, opts ...LoadOption) (*DefaultClient, error) {
+	if leaseDuration <= 0 {
+		return nil, errors.New("the lease duration must be positive")
+	}
+	if renewDeadline <= 0 || renewDeadline >= leaseDuration {
+		return nil, errors.New("the renew deadline must be positive and shorter than the lease duration")
+	}
+
+	client := &DefaultClient{
+		Lock:          lock,
+		Holder:        holder,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		Now:           time.Now,
+		state:         State{Role: RoleFollower},
+	}
+	for _, opt := range opts {
+		if err := opt(client); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// Elect implements Elector. A leader failing to renew its lease keeps
+// leading until the renew deadline, and every other failure makes the
+// agent a follower.
+func (c *DefaultClient) Elect(ctx context.Context) (State, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.Now()
+	lease, err := c.Lock.Acquire(ctx, c.Holder, c.LeaseDuration, now)
+	if err != nil {
+		if c.state.Role == RoleLeader && now.Sub(c.renewed) < c.RenewDeadline {
+			c.state.Error = err.Error()
+			return c.state, nil
+		}
+		c.state = State{Role: RoleFollower, Error: err.Error()}
+		return c.state, err
+	}
+
+	c.state = State{Role: RoleFollower}
+	if lease.Holder != "" {
+		c.state.Leader = &lease
+	}
+	if lease.Holder == c.Holder {
+		c.state.Role = RoleLeader
+		c.renewed = now
+	}
+	return c.state, nil
+}
+
+// State implements Elector
+func (c *DefaultClient) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Release implements Elector
+func (c *DefaultClient) Release(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state.Role != RoleLeader {
+		return nil
+	}
+	c.state = State{Role: RoleFollower}
+	return c.Lock.Release(ctx, c.Holder)
+}
+
+// Adopt takes over the state of a previous client electing the same holder
+// through the same lock, so that replacing the client doesn't interrupt
+// the leadership. It returns false if they differ.
+func (c *DefaultClient) Adopt(previous *DefaultClient) bool {
+	if c.Holder != previous.Holder || c.Lock.String() != previous.Lock.String() {
+		return false
+	}
+
+	previous.mu.Lock()
+	defer previous.mu.Unlock()
+	if lock, ok := c.Lock.(adopter); ok {
+		lock.adopt(previous.Lock)
+	}
+	c.state = previous.state
+	c.renewed = previous.renewed
+	return true
+}
@@ -0,0 +1,491 @@
+package election_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/election"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// fakeProvider keeps records in memory
+type fakeProvider struct {
+	dns.Provider
+	records []dns.Record
+	nextID  int
+	// listErr is returned once the records were listed listAfter times
+	listErr   error
+	listAfter int
+	listings  int
+	// createErr, updateErr and deleteErr are returned when creating,
+	// updating or deleting records
+	createErr error
+	updateErr error
+	deleteErr error
+	// onCreate is called after a record is created
+	onCreate func()
+}
+
+func (p *fakeProvider) ListRecords(ctx context.Context, filter dns.RecordFilter) ([]dns.Record, error) {
+	p.listings++
+	if p.listErr != nil && p.listings > p.listAfter {
+		return nil, p.listErr
+	}
+	results := []dns.Record{}
+	for _, record := range p.records {
+		if record.Name == filter.Name && record.Type == filter.Type {
+			results = append(results, record)
+		}
+	}
+	return results, nil
+}
+
+func (p *fakeProvider) CreateRecord(ctx context.Context, record dns.Record) (dns.Record, error) {
+	if p.createErr != nil {
+		return dns.Record{}, p.createErr
+	}
+	p.nextID++
+	record.ID = fmt.Sprint(p.nextID)
+	p.records = append(p.records, record)
+	if p.onCreate != nil {
+		p.onCreate()
+	}
+	return record, nil
+}
+
+func (p *fakeProvider) UpdateRecord(ctx context.Context, record dns.Record) (dns.Record, error) {
+	if p.updateErr != nil {
+		return dns.Record{}, p.updateErr
+	}
+	for i, existing := range p.records {
+		if existing.ID == record.ID {
+			p.records[i] = record
+		}
+	}
+	return record, nil
+}
+
+func (p *fakeProvider) DeleteRecord(ctx context.Context, record dns.Record) error {
+	if p.deleteErr != nil {
+		return p.deleteErr
+	}
+	for i, existing := range p.records {
+		if existing.ID == record.ID {
+			p.records = append(p.records[:i], p.records[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// claim builds a lock record
+func claim(id, holder string, acquired, renewed time.Time) dns.Record {
+	return dns.Record{
+		ID:      id,
+		Type:    dns.RecordTypeTXT,
+		Name:    election.LockRecordName("www.foo.net"),
+		Content: fmt.Sprintf(`"%v"`, election.LockContent(election.Lease{Holder: holder, Acquired: acquired, Renewed: renewed})),
+	}
+}
+
+// fakeLock answers with a fixed lease or error
+type fakeLock struct {
+	name     string
+	lease    election.Lease
+	err      error
+	released []string
+}
+
+func (l *fakeLock) Acquire(ctx context.Context, holder string, duration time.Duration, now time.Time) (election.Lease, error) {
+	return l.lease, l.err
+}
+
+func (l *fakeLock) Release(ctx context.Context, holder string) error {
+	l.released = append(l.released, holder)
+	return nil
+}
+
+func (l *fakeLock) String() string {
+	return l.name
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	duration := 15 * time.Minute
+
+	withNow := func(now *time.Time) election.LoadOption {
+		return func(client *election.DefaultClient) error {
+			client.Now = func() time.Time { return *now }
+			return nil
+		}
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "validates the durations",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, err := election.NewClient(&fakeLock{}, "paris", 0, time.Minute)
+				g.Expect(err).To(MatchError("the lease duration must be positive"))
+				for _, deadline := range []time.Duration{0, duration, 2 * duration} {
+					_, err = election.NewClient(&fakeLock{}, "paris", duration, deadline)
+					g.Expect(err).To(MatchError("the renew deadline must be positive and shorter than the lease duration"))
+				}
+
+				_, err = election.NewClient(&fakeLock{}, "paris", duration, time.Minute, func(client *election.DefaultClient) error {
+					return errors.New("nope")
+				})
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
+		{
+			testCase: "leads while holding the lease",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				lock := &fakeLock{lease: election.Lease{Holder: "paris", Acquired: now, Renewed: now}}
+				client, err := election.NewClient(lock, "paris", duration, 10*time.Minute)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.State()).To(Equal(election.State{Role: election.RoleFollower}))
+
+				state, err := client.Elect(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state).To(Equal(election.State{Role: election.RoleLeader, Leader: &lock.lease}))
+				g.Expect(client.State()).To(Equal(state))
+
+				g.Expect(client.Release(ctx)).To(Succeed())
+				g.Expect(client.State().Role).To(Equal(election.RoleFollower))
+				g.Expect(lock.released).To(Equal([]string{"paris"}))
+
+				// Followers have nothing to release
+				g.Expect(client.Release(ctx)).To(Succeed())
+				g.Expect(lock.released).To(HaveLen(1))
+			},
+		},
+		{
+			testCase: "follows the holder of the lease",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				lock := &fakeLock{lease: election.Lease{Holder: "berlin", Acquired: now, Renewed: now}}
+				client, err := election.NewClient(lock, "paris", duration, 10*time.Minute)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				state, err := client.Elect(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state).To(Equal(election.State{Role: election.RoleFollower, Leader: &lock.lease}))
+
+				// A lock nobody holds has no leader
+				lock.lease = election.Lease{}
+				state, err = client.Elect(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state).To(Equal(election.State{Role: election.RoleFollower}))
+			},
+		},
+		{
+			testCase: "keeps leading through failed renewals until the deadline",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				clock := now
+				lock := &fakeLock{lease: election.Lease{Holder: "paris", Acquired: now, Renewed: now}}
+				client, err := election.NewClient(lock, "paris", duration, 10*time.Minute, withNow(&clock))
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.Elect(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				lock.err = errors.New("boom")
+				clock = now.Add(9 * time.Minute)
+				state, err := client.Elect(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state.Role).To(Equal(election.RoleLeader))
+				g.Expect(state.Error).To(Equal("boom"))
+
+				clock = now.Add(10 * time.Minute)
+				state, err = client.Elect(ctx)
+				g.Expect(err).To(MatchError("boom"))
+				g.Expect(state).To(Equal(election.State{Role: election.RoleFollower, Error: "boom"}))
+			},
+		},
+		{
+			testCase: "adopts the state of a client sharing the lock",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				lock := &fakeLock{name: "dns:www.foo.net", lease: election.Lease{Holder: "paris", Acquired: now, Renewed: now}}
+				previous, err := election.NewClient(lock, "paris", duration, 10*time.Minute)
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = previous.Elect(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				client, err := election.NewClient(&fakeLock{name: "dns:www.foo.net"}, "paris", time.Hour, time.Minute)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Adopt(previous)).To(BeTrue())
+				g.Expect(client.Lock).NotTo(BeIdenticalTo(lock))
+				g.Expect(client.State().Role).To(Equal(election.RoleLeader))
+				g.Expect(client.LeaseDuration).To(Equal(time.Hour))
+
+				other, err := election.NewClient(&fakeLock{name: "dns:www.foo.net"}, "berlin", duration, time.Minute)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(other.Adopt(previous)).To(BeFalse())
+				other, err = election.NewClient(&fakeLock{name: "file:/run/qrkdns.lock"}, "paris", duration, time.Minute)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(other.Adopt(previous)).To(BeFalse())
+			},
+		},
+		{
+			testCase: "acquires and renews a DNS lock",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				provider := &fakeProvider{records: []dns.Record{
+					// An expired claim and records that aren't claims
+					claim("a", "berlin", now.Add(-time.Hour), now.Add(-time.Hour)),
+					{ID: "b", Type: dns.RecordTypeTXT, Name: election.LockRecordName("www.foo.net"), Content: "v=spf1"},
+					{ID: "c", Type: dns.RecordTypeTXT, Name: election.LockRecordName("www.foo.net"), Content: "heritage=qrkdns,holder=rome"},
+					{ID: "d", Type: dns.RecordTypeTXT, Name: election.LockRecordName("www.foo.net"), Content: "heritage=qrkdns,holder=rome,acquired=x,renewed=1"},
+				}}
+				lock := election.NewDNSLock(provider, "www.foo.net")
+				g.Expect(lock.String()).To(Equal("dns:www.foo.net"))
+
+				lease, err := lock.Acquire(ctx, "paris", duration, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease).To(Equal(election.Lease{Holder: "paris", Acquired: now, Renewed: now}))
+
+				later := now.Add(5 * time.Minute)
+				lease, err = lock.Acquire(ctx, "paris", duration, later)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease).To(Equal(election.Lease{Holder: "paris", Acquired: now, Renewed: later}))
+				g.Expect(provider.records).To(Equal([]dns.Record{
+					{ID: "b", Type: dns.RecordTypeTXT, Name: "_qrkdns-leader.www.foo.net", Content: "v=spf1"},
+					{ID: "c", Type: dns.RecordTypeTXT, Name: "_qrkdns-leader.www.foo.net", Content: "heritage=qrkdns,holder=rome"},
+					{ID: "d", Type: dns.RecordTypeTXT, Name: "_qrkdns-leader.www.foo.net", Content: "heritage=qrkdns,holder=rome,acquired=x,renewed=1"},
+					{
+						ID:      "1",
+						Type:    dns.RecordTypeTXT,
+						Name:    "_qrkdns-leader.www.foo.net",
+						Content: fmt.Sprintf("heritage=qrkdns,holder=paris,acquired=%v,renewed=%v", now.Unix(), later.Unix()),
+						TTL:     1,
+					},
+				}))
+				// The claim is renewed in place
+				g.Expect(provider.nextID).To(Equal(1))
+
+				// Others follow until the lease expires
+				lease, err = lock.Acquire(ctx, "berlin", duration, later.Add(duration))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease.Holder).To(Equal("paris"))
+				lease, err = lock.Acquire(ctx, "berlin", duration, later.Add(duration+time.Second))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease.Holder).To(Equal("berlin"))
+
+				g.Expect(lock.Release(ctx, "paris")).To(Succeed())
+				g.Expect(provider.records).To(HaveLen(4))
+				g.Expect(lock.Release(ctx, "berlin")).To(Succeed())
+				g.Expect(election.LockRecordName("*.office.foo.net")).To(Equal("_qrkdns-leader._wildcard.office.foo.net"))
+				g.Expect(provider.records).To(HaveLen(3))
+			},
+		},
+		{
+			testCase: "reclaims a DNS lock with its expired claim",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				provider := &fakeProvider{records: []dns.Record{
+					claim("a", "paris", now.Add(-time.Hour), now.Add(-time.Hour)),
+					claim("b", "paris", now.Add(-time.Hour), now.Add(-time.Hour)),
+				}}
+				lease, err := election.NewDNSLock(provider, "www.foo.net").Acquire(ctx, "paris", duration, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease).To(Equal(election.Lease{Holder: "paris", Acquired: now, Renewed: now}))
+				g.Expect(provider.nextID).To(Equal(0))
+				g.Expect(provider.records).To(Equal([]dns.Record{{
+					ID:      "a",
+					Type:    dns.RecordTypeTXT,
+					Name:    "_qrkdns-leader.www.foo.net",
+					Content: fmt.Sprintf("heritage=qrkdns,holder=paris,acquired=%v,renewed=%v", now.Unix(), now.Unix()),
+				}}))
+			},
+		},
+		{
+			testCase: "withdraws from a DNS lock claimed at the same time",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				provider := &fakeProvider{}
+				provider.onCreate = func() {
+					provider.onCreate = nil
+					provider.records = append(provider.records, claim("z", "berlin", now, now))
+				}
+				lock := election.NewDNSLock(provider, "www.foo.net")
+				lease, err := lock.Acquire(ctx, "paris", duration, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease.Holder).To(Equal("berlin"))
+				g.Expect(provider.records).To(Equal([]dns.Record{claim("z", "berlin", now, now)}))
+			},
+		},
+		{
+			testCase: "returns error using a DNS lock",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				boom := errors.New("boom")
+				for description, provider := range map[string]*fakeProvider{
+					"listing claims":    {listErr: boom},
+					"creating a claim":  {createErr: boom},
+					"renewing a claim":  {records: []dns.Record{claim("a", "paris", now, now)}, updateErr: boom},
+					"deleting a claim":  {records: []dns.Record{claim("a", "paris", now, now), claim("b", "paris", now, now)}, deleteErr: boom},
+					"listing again":     {listErr: boom, listAfter: 1},
+					"withdrawing claim": {deleteErr: boom},
+				} {
+					if description == "withdrawing claim" {
+						provider := provider
+						provider.onCreate = func() {
+							provider.onCreate = nil
+							provider.records = append(provider.records, claim("z", "berlin", now.Add(-time.Minute), now))
+						}
+					}
+					_, err := election.NewDNSLock(provider, "www.foo.net").Acquire(ctx, "paris", duration, now)
+					g.Expect(err).To(MatchError("boom"), description)
+				}
+
+				lock := election.NewDNSLock(&fakeProvider{listErr: boom}, "www.foo.net")
+				g.Expect(lock.Release(ctx, "paris")).To(MatchError("boom"))
+				lock = election.NewDNSLock(&fakeProvider{records: []dns.Record{claim("a", "paris", now, now)}, deleteErr: boom}, "www.foo.net")
+				g.Expect(lock.Release(ctx, "paris")).To(MatchError("boom"))
+			},
+		},
+		{
+			testCase: "locks a local file",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				path := filepath.Join(tt.TempDir(), "qrkdns.lock")
+				first := election.NewFileLock(path)
+				second := election.NewFileLock(path)
+				g.Expect(first.String()).To(Equal("file:" + path))
+
+				lease, err := first.Acquire(ctx, "paris", duration, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease).To(Equal(election.Lease{Holder: "paris", Acquired: now, Renewed: now}))
+				lease, err = first.Acquire(ctx, "paris", duration, now.Add(time.Minute))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease.Renewed).To(Equal(now.Add(time.Minute)))
+
+				pid := fmt.Sprintf("(pid %d)", os.Getpid())
+				lease, err = second.Acquire(ctx, "berlin", duration, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease).To(Equal(election.Lease{Holder: "paris " + pid}))
+
+				// Another process never reads as the holder, even sharing its name
+				lease, err = second.Acquire(ctx, "paris", duration, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease).To(Equal(election.Lease{Holder: "paris " + pid}))
+
+				// Only the holder releases the lock
+				g.Expect(first.Release(ctx, "berlin")).To(Succeed())
+				g.Expect(second.Release(ctx, "berlin")).To(Succeed())
+				g.Expect(first.Release(ctx, "paris")).To(Succeed())
+				lease, err = second.Acquire(ctx, "berlin", duration, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease.Holder).To(Equal("berlin"))
+
+				content, err := os.ReadFile(path)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(string(content)).To(Equal("berlin " + pid + "\n"))
+			},
+		},
+		{
+			testCase: "adopts the file of a previous lock",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				path := filepath.Join(tt.TempDir(), "qrkdns.lock")
+				previous, err := election.NewClient(election.NewFileLock(path), "paris", duration, 10*time.Minute)
+				g.Expect(err).NotTo(HaveOccurred())
+				state, err := previous.Elect(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state.Role).To(Equal(election.RoleLeader))
+
+				client, err := election.NewClient(election.NewFileLock(path), "paris", duration, 10*time.Minute)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Adopt(previous)).To(BeTrue())
+				g.Expect(client.Adopt(client)).To(BeTrue())
+				state, err = client.Elect(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state.Role).To(Equal(election.RoleLeader))
+
+				// The previous lock no longer holds the file
+				g.Expect(previous.Lock.Release(ctx, "paris")).To(Succeed())
+				state, err = client.Elect(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state.Role).To(Equal(election.RoleLeader))
+				_, err = election.NewFileLock(path).Acquire(ctx, "berlin", duration, now)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				// Locks of another kind hold nothing to adopt
+				other, err := election.NewClient(&fakeLock{name: "file:" + path}, "paris", duration, 10*time.Minute)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Adopt(other)).To(BeTrue())
+			},
+		},
+		{
+			testCase: "returns error locking a missing directory",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				lock := election.NewFileLock(filepath.Join(tt.TempDir(), "missing", "qrkdns.lock"))
+				_, err := lock.Acquire(ctx, "paris", duration, now)
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "returns error writing the lock file",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				path := filepath.Join(tt.TempDir(), "qrkdns.lock")
+				lock := election.NewFileLock(path)
+
+				// The holder can't be read
+				lock.Open = func(path string) (*os.File, error) {
+					return nil, election.ErrLocked
+				}
+				_, err := lock.Acquire(ctx, "paris", duration, now)
+				g.Expect(os.IsNotExist(err)).To(BeTrue())
+
+				g.Expect(os.WriteFile(path, []byte("berlin\n"), 0o600)).To(Succeed())
+				for _, flag := range []int{os.O_RDONLY, os.O_WRONLY | os.O_APPEND} {
+					flag := flag
+					lock.Open = func(path string) (*os.File, error) {
+						return os.OpenFile(path, flag, 0)
+					}
+					_, err = lock.Acquire(ctx, "paris", duration, now)
+					g.Expect(err).To(HaveOccurred(), fmt.Sprint(flag))
+				}
+
+				// Failed attempts hold nothing
+				lease, err := election.NewFileLock(path).Acquire(ctx, "berlin", duration, now)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease.Holder).To(Equal("berlin"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,208 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+)
+
+const (
+	// LockPrefix is prepended to a managed name to build the name of the
+	// TXT records holding the lease of its leader
+	LockPrefix string = "_qrkdns-leader."
+
+	// heritage identifies TXT content written by qrkdns, like ownership records
+	heritage string = "heritage=qrkdns"
+)
+
+var (
+	// Assert lock matches the correct interface
+	_ Lock = &DNSLock{}
+)
+
+// DNSLock stores the lease in TXT records of the DNS provider, next to the
+// name being managed, so that agents on different hosts can share it
+type DNSLock struct {
+	Provider dns.Provider
+	// Name is the fully qualified name the agents manage
+	Name string
+}
+
+// NewDNSLock returns a lock electing the leader of a name
+func NewDNSLock(provider dns.Provider, name string) *DNSLock {
+	return &DNSLock{Provider: provider, Name: name}
+}
+
+// LockRecordName returns the name of the TXT records holding the lease of the leader of name
+func LockRecordName(name string) string {
+	return dns.MetadataRecordName(LockPrefix, name)
+}
+
+// LockContent returns the TXT content holding a lease
+func LockContent(lease Lease) string {
+	return fmt.Sprintf("%v,holder=%v,acquired=%v,renewed=%v", heritage, lease.Holder, lease.Acquired.Unix(), lease.Renewed.Unix())
+}
+
+// claim is a lease along with the record holding it
+type claim struct {
+	Lease
+	record dns.Record
+}
+
+// parseClaim returns the lease held by record, if record is a lock record
+func parseClaim(record dns.Record) (claim, bool) {
+	content := strings.Trim(record.Content, `"`)
+	if record.Type != dns.RecordTypeTXT || !strings.HasPrefix(content, heritage+",") {
+		return claim{}, false
+	}
+
+	result := claim{record: record}
+	var acquired, renewed int64
+	fields := 0
+	for _, field := range strings.Split(content, ",") {
+		key, value, _ := strings.Cut(field, "=")
+		var err error
+		switch key {
+		case "holder":
+			result.Holder = value
+			fields++
+		case "acquired":
+			_, err = fmt.Sscan(value, &acquired)
+			fields++
+		case "renewed":
+			_, err = fmt.Sscan(value, &renewed)
+			fields++
+		}
+		if err != nil {
+			return claim{}, false
+		}
+	}
+	if fields != 3 || result.Holder == "" {
+		return claim{}, false
+	}
+	result.Acquired = time.Unix(acquired, 0)
+	result.Renewed = time.Unix(renewed, 0)
+	return result, true
+}
+
+// String implements Lock
+func (l *DNSLock) String() string {
+	return "dns:" + l.Name
+}
+
+// Acquire implements Lock. The holder renews its claim in place, and only
+// writes one when it holds none. Agents claiming the lock at the same time
+// may all write a claim: the live claim acquired first wins, ties going to
+// the lowest holder, and the others withdraw theirs.
+func (l *DNSLock) Acquire(ctx context.Context, holder string, duration time.Duration, now time.Time) (Lease, error) {
+	claims, err := l.claims(ctx)
+	if err != nil {
+		return Lease{}, err
+	}
+	current, found := leader(claims, duration, now)
+	if found && current.Holder != holder {
+		return current.Lease, nil
+	}
+
+	lease := Lease{Holder: holder, Acquired: now, Renewed: now}
+	var own *claim
+	if found {
+		lease.Acquired = current.Acquired
+		own = &current
+	} else {
+		for i := range claims {
+			if claims[i].Holder == holder {
+				own = &claims[i]
+				break
+			}
+		}
+	}
+
+	var written dns.Record
+	if own != nil {
+		renewed := own.record
+		renewed.Content = LockContent(lease)
+		written, err = l.Provider.UpdateRecord(ctx, renewed)
+	} else {
+		written, err = l.Provider.CreateRecord(ctx, dns.Record{
+			Type:    dns.RecordTypeTXT,
+			Name:    LockRecordName(l.Name),
+			Content: LockContent(lease),
+			TTL:     1,
+		})
+	}
+	if err != nil {
+		return Lease{}, err
+	}
+	for _, previous := range claims {
+		if own != nil && previous.record.ID == own.record.ID {
+			continue
+		}
+		if previous.Holder == holder || previous.Expired(duration, now) {
+			if err := l.Provider.DeleteRecord(ctx, previous.record); err != nil {
+				return Lease{}, err
+			}
+		}
+	}
+
+	claims, err = l.claims(ctx)
+	if err != nil {
+		return Lease{}, err
+	}
+	if current, found := leader(claims, duration, now); found && current.Holder != holder {
+		return current.Lease, l.Provider.DeleteRecord(ctx, written)
+	}
+	return lease, nil
+}
+
+// Release implements Lock
+func (l *DNSLock) Release(ctx context.Context, holder string) error {
+	claims, err := l.claims(ctx)
+	if err != nil {
+		return err
+	}
+	for _, claim := range claims {
+		if claim.Holder != holder {
+			continue
+		}
+		if err := l.Provider.DeleteRecord(ctx, claim.record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claims lists the claims on the lock
+func (l *DNSLock) claims(ctx context.Context) ([]claim, error) {
+	records, err := l.Provider.ListRecords(ctx, dns.RecordFilter{Name: LockRecordName(l.Name), Type: dns.RecordTypeTXT})
+	if err != nil {
+		return nil, err
+	}
+	claims := []claim{}
+	for _, record := range records {
+		if claim, ok := parseClaim(record); ok {
+			claims = append(claims, claim)
+		}
+	}
+	return claims, nil
+}
+
+// leader returns the live claim acquired first, ties going to the lowest holder
+func leader(claims []claim, duration time.Duration, now time.Time) (claim, bool) {
+	var result claim
+	found := false
+	for _, claim := range claims {
+		if claim.Expired(duration, now) {
+			continue
+		}
+		if !found || claim.Acquired.Before(result.Acquired) ||
+			(claim.Acquired.Equal(result.Acquired) && claim.Holder < result.Holder) {
+			result = claim
+			found = true
+		}
+	}
+	return result, found
+}
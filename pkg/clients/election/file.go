@@ -0,0 +1,113 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Assert lock matches the correct interfaces
+	_ Lock    = &FileLock{}
+	_ adopter = &FileLock{}
+
+	// ErrLocked is returned when another process holds the file lock
+	ErrLocked = errors.New("file is locked")
+)
+
+// FileLock holds an exclusive lock on a local file, electing a leader
+// among agents on the same host. The operating system releases the lock
+// when its holder exits, so leases never need to expire.
+type FileLock struct {
+	// Path is the location of the lock file, which holds the name and
+	// process ID of the leader
+	Path string
+	// Open opens and locks the file, returning ErrLocked while another
+	// process holds it
+	Open func(path string) (*os.File, error)
+
+	mu    sync.Mutex
+	file  *os.File
+	lease Lease
+}
+
+// NewFileLock returns a lock on the file at path
+func NewFileLock(path string) *FileLock {
+	return &FileLock{Path: path, Open: lockFile}
+}
+
+// String implements Lock
+func (l *FileLock) String() string {
+	return "file:" + l.Path
+}
+
+// Acquire implements Lock. The lock is kept until released, so only its
+// renewal time changes once acquired.
+func (l *FileLock) Acquire(ctx context.Context, holder string, duration time.Duration, now time.Time) (Lease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		l.lease.Renewed = now
+		return l.lease, nil
+	}
+
+	file, err := l.Open(l.Path)
+	if errors.Is(err, ErrLocked) {
+		content, err := os.ReadFile(l.Path)
+		if err != nil {
+			return Lease{}, err
+		}
+		// Agents on the same host may share a name, so the process ID
+		// tells the leader apart from holder
+		return Lease{Holder: strings.TrimSpace(string(content))}, nil
+	}
+	if err != nil {
+		return Lease{}, err
+	}
+
+	if err := file.Truncate(0); err != nil {
+		_ = file.Close()
+		return Lease{}, err
+	}
+	if _, err := file.WriteAt([]byte(fmt.Sprintf("%v (pid %d)\n", holder, os.Getpid())), 0); err != nil {
+		_ = file.Close()
+		return Lease{}, err
+	}
+	l.file = file
+	l.lease = Lease{Holder: holder, Acquired: now, Renewed: now}
+	return l.lease, nil
+}
+
+// Release implements Lock. The file stays, as removing it would let
+// another process lock a new file while one still holds the old one.
+func (l *FileLock) Release(ctx context.Context, holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil || l.lease.Holder != holder {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	l.lease = Lease{}
+	return err
+}
+
+// adopt takes over the file held by a previous lock on the same path
+func (l *FileLock) adopt(previous Lock) {
+	other, ok := previous.(*FileLock)
+	if !ok || other == l {
+		return
+	}
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.file, l.lease = other.file, other.lease
+	other.file, other.lease = nil, Lease{}
+}
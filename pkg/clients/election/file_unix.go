@@ -0,0 +1,26 @@
+//go:build !windows
+
+package election
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile opens the file and locks it, without waiting for another
+// process to release it
+func lockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			err = ErrLocked
+		}
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package election
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errorSharingViolation is returned when another process has the file open
+// without sharing write access
+const errorSharingViolation syscall.Errno = 32
+
+// lockFile opens the file for exclusive write access, which lasts until
+// the file is closed. Other processes may still read it.
+func lockFile(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(
+		name,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		syscall.FILE_SHARE_READ,
+		nil,
+		syscall.OPEN_ALWAYS,
+		syscall.FILE_ATTRIBUTE_NORMAL,
+		0,
+	)
+	if errors.Is(err, errorSharingViolation) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
@@ -0,0 +1,11 @@
+package email
+
+import (
+	"context"
+	"net/smtp"
+)
+
+// SMTPClient wraps the function used to deliver mail
+type SMTPClient interface {
+	SendMail(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
@@ -0,0 +1,140 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+)
+
+var (
+	_ notify.Notifier = &DefaultClient{}
+	_ SMTPClient      = &netSMTPClient{}
+)
+
+// DefaultClient implements an SMTP email notifier
+type DefaultClient struct {
+	Address string
+	Auth    smtp.Auth
+	From    string
+	To      []string
+	Client  SMTPClient
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// netSMTPClient delivers mail through the standard library
+type netSMTPClient struct{}
+
+// SendMail implements SMTPClient like smtp.SendMail, giving up once ctx
+// is done
+func (n *netSMTPClient) SendMail(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	// Closing the connection interrupts the exchange
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.Close()
+	})
+	defer stop()
+
+	host, _, _ := net.SplitHostPort(addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if a != nil {
+		if err := client.Auth(a); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(msg)
+	if err := errors.Join(err, writer.Close()); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// WithPlainAuth authenticates against the server with a username and password
+func WithPlainAuth(username, password string) LoadOption {
+	return func(client *DefaultClient) error {
+		host, _, err := net.SplitHostPort(client.Address)
+		if err != nil {
+			return err
+		}
+		client.Auth = smtp.PlainAuth("", username, password, host)
+		return nil
+	}
+}
+
+// NewClient returns a new email client
+func NewClient(host string, port int, from string, to []string, opts ...LoadOption) (*DefaultClient, error) {
+	if len(to) == 0 {
+		return &DefaultClient{}, fmt.Errorf("at least one recipient is required")
+	}
+	client := DefaultClient{
+		Address: net.JoinHostPort(host, strconv.Itoa(port)),
+		From:    from,
+		To:      to,
+		Client:  &netSMTPClient{},
+	}
+	for _, opt := range opts {
+		if err := opt(&client); err != nil {
+			return &DefaultClient{}, err
+		}
+	}
+	return &client, nil
+}
+
+// Notify sends the event as a plain-text email
+func (c *DefaultClient) Notify(ctx context.Context, event notify.Event) error {
+	return c.Client.SendMail(ctx, c.Address, c.Auth, c.From, c.To, c.buildMessage(event))
+}
+
+// buildMessage renders an RFC 5322 message for the event
+func (c *DefaultClient) buildMessage(event notify.Event) []byte {
+	lines := []string{
+		fmt.Sprintf("From: %v", c.From),
+		fmt.Sprintf("To: %v", strings.Join(c.To, ", ")),
+		fmt.Sprintf("Subject: %v", event.Summary()),
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		fmt.Sprintf("Event: %v", event.Type),
+		fmt.Sprintf("Name: %v", event.Name),
+		fmt.Sprintf("Provider: %v", event.Provider),
+		fmt.Sprintf("Old IP: %v", event.OldIP),
+		fmt.Sprintf("New IP: %v", event.NewIP),
+		fmt.Sprintf("Error: %v", event.Error),
+		fmt.Sprintf("Time: %v", event.Time),
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
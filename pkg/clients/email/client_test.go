@@ -0,0 +1,237 @@
+package email_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/email"
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// smtpServer serves SMTP on a local port until the test ends, replying to
+// the commands with the replies overriding the defaults. Without replies,
+// the server never answers.
+func smtpServer(tt *testing.T, replies map[string]string) (string, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tt.Fatal(err)
+	}
+	tt.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, replies)
+		}
+	}()
+	address := listener.Addr().(*net.TCPAddr)
+	return address.IP.String(), address.Port
+}
+
+// serveSMTP answers the commands of a single connection
+func serveSMTP(conn net.Conn, replies map[string]string) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	if replies == nil {
+		_, _ = io.Copy(io.Discard, conn)
+		return
+	}
+
+	reply := func(command string) string {
+		if reply, ok := replies[command]; ok {
+			return reply
+		}
+		defaults := map[string]string{
+			"EHLO": "250-fake\r\n250 AUTH PLAIN",
+			"AUTH": "235 accepted",
+			"MAIL": "250 ok",
+			"RCPT": "250 ok",
+			"DATA": "354 go ahead",
+			".":    "250 queued",
+			"QUIT": "221 bye",
+		}
+		if reply, ok := defaults[command]; ok {
+			return reply
+		}
+		return "502 unknown command"
+	}
+
+	_ = text.PrintfLine("220 fake")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, _, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+		answer := reply(command)
+		if command == "DATA" && strings.HasPrefix(answer, "354") {
+			_ = text.PrintfLine("%v", answer)
+			if _, err := text.ReadDotBytes(); err != nil {
+				return
+			}
+			answer = reply(".")
+		}
+		_ = text.PrintfLine("%v", answer)
+	}
+}
+
+func TestFile(t *testing.T) {
+	event := notify.Event{Type: notify.EventTypeSyncFailed, Name: "bar.foo.net", Error: "boom", Failures: 3}
+
+	tests := []testRunner{
+		{
+			testCase: "sends the event by email",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				sender := &mocks.MockSMTPClient{}
+
+				client, err := email.NewClient(
+					"smtp.foo.net",
+					587,
+					"qrkdns@foo.net",
+					[]string{"ops@foo.net"},
+					email.WithPlainAuth("user", "pass"),
+					func(client *email.DefaultClient) error {
+						client.Client = sender
+						return nil
+					},
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Address).To(Equal("smtp.foo.net:587"))
+				g.Expect(client.Auth).NotTo(BeNil())
+
+				err = client.Notify(context.Background(), event)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(sender.Messages).To(HaveLen(1))
+				g.Expect(string(sender.Messages[0])).To(ContainSubstring("Subject: qrkdns: sync of bar.foo.net failed 3 times in a row: boom"))
+				g.Expect(string(sender.Messages[0])).To(ContainSubstring("To: ops@foo.net"))
+			},
+		},
+		{
+			testCase: "returns error from mail sender",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := email.NewClient("smtp.foo.net", 25, "qrkdns@foo.net", []string{"ops@foo.net"}, func(client *email.DefaultClient) error {
+					client.Client = &mocks.MockSMTPClient{}
+					return nil
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = envy.AddErrorReturns("SendMail", fmt.Errorf("relay denied"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Notify(context.Background(), event)
+				g.Expect(err).To(MatchError("relay denied"))
+			},
+		},
+		{
+			testCase: "uses the standard library sender by default",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := email.NewClient("127.0.0.1", 1, "qrkdns@foo.net", []string{"ops@foo.net"})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				// Nothing listens on port 1, so the dial fails
+				err = client.Notify(context.Background(), event)
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "delivers mail over SMTP",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				host, port := smtpServer(tt, map[string]string{})
+
+				client, err := email.NewClient(host, port, "qrkdns@foo.net", []string{"ops@foo.net"}, email.WithPlainAuth("user", "pass"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Notify(context.Background(), event)).To(Succeed())
+			},
+		},
+		{
+			testCase: "returns errors from the SMTP server",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				// The server fails STARTTLS, which isn't in the defaults
+				for message, replies := range map[string]map[string]string{
+					"unknown command":  {"EHLO": "250-fake\r\n250 STARTTLS"},
+					"bad credentials":  {"AUTH": "535 bad credentials"},
+					"sender denied":    {"MAIL": "550 sender denied"},
+					"relay denied":     {"RCPT": "550 relay denied"},
+					"no data":          {"DATA": "554 no data"},
+					"message rejected": {".": "554 message rejected"},
+				} {
+					host, port := smtpServer(tt, replies)
+					client, err := email.NewClient(host, port, "qrkdns@foo.net", []string{"ops@foo.net"}, email.WithPlainAuth("user", "pass"))
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(client.Notify(context.Background(), event)).To(MatchError(ContainSubstring(message)), message)
+				}
+			},
+		},
+		{
+			testCase: "gives up once the context is done",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				host, port := smtpServer(tt, nil)
+
+				client, err := email.NewClient(host, port, "qrkdns@foo.net", []string{"ops@foo.net"})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+				g.Expect(client.Notify(ctx, event)).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "requires a recipient",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := email.NewClient("smtp.foo.net", 587, "qrkdns@foo.net", []string{})
+				g.Expect(err).To(MatchError("at least one recipient is required"))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := email.NewClient("smtp.foo.net", 587, "qrkdns@foo.net", []string{"ops@foo.net"}, func(client *email.DefaultClient) error {
+					return fmt.Errorf("oh no")
+				})
+				g.Expect(err).To(MatchError("oh no"))
+			},
+		},
+		{
+			testCase: "returns error for plain auth with invalid address",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := email.NewClient("smtp.foo.net", 587, "qrkdns@foo.net", []string{"ops@foo.net"}, func(client *email.DefaultClient) error {
+					client.Address = "missing-port"
+					return nil
+				}, email.WithPlainAuth("user", "pass"))
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,107 @@
+package envfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	// namePattern matches valid environment variable names
+	namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Load reads an environment file
+func Load(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return Parse(file)
+}
+
+// Parse reads KEY=VALUE lines, as used by `docker run --env-file`. Blank
+// lines and lines starting with # are ignored, a leading `export` is
+// allowed and values may be wrapped in single or double quotes.
+func Parse(r io.Reader) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return nil, fmt.Errorf("line %v: expected KEY=VALUE", number)
+		}
+		name = strings.TrimSpace(name)
+		if !namePattern.MatchString(name) {
+			return nil, fmt.Errorf("line %v: invalid variable name %q", number, name)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[name] = value
+	}
+	return values, scanner.Err()
+}
+
+// Environment applies environment files to the process environment
+type Environment struct {
+	mu sync.Mutex
+	// applied holds the variables set from a file, which were all unset
+	// before
+	applied map[string]bool
+	values  map[string]string
+}
+
+// NewEnvironment returns an environment no file was applied to yet
+func NewEnvironment() *Environment {
+	return &Environment{
+		applied: map[string]bool{},
+		values:  map[string]string{},
+	}
+}
+
+// Apply sets the variables of a file. Variables set by the process
+// environment itself take precedence. Variables applied by an earlier
+// call are reverted first, so that lines removed from the file no longer
+// apply.
+func (e *Environment) Apply(values map[string]string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for name := range e.applied {
+		_ = os.Unsetenv(name)
+	}
+	e.applied = map[string]bool{}
+
+	for name, value := range values {
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+		e.applied[name] = true
+	}
+	e.values = values
+	return nil
+}
+
+// Values returns the variables of the last file applied
+func (e *Environment) Values() map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.values
+}
@@ -0,0 +1,114 @@
+package envfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/envfile"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+func TestClient(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "parses environment files",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				values, err := envfile.Parse(strings.NewReader(strings.Join([]string{
+					"# records",
+					"NETWORK_ID=home",
+					"",
+					"export DOMAIN_NAME = foo.net ",
+					`SCHEDULE="*/5 * * * *"`,
+					"HOOK_COMMAND='echo \"changed\"'",
+					"EMPTY=",
+					`QUOTE="`,
+					"URL=https://example.com/?a=b",
+				}, "\n")))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(values).To(Equal(map[string]string{
+					"NETWORK_ID":   "home",
+					"DOMAIN_NAME":  "foo.net",
+					"SCHEDULE":     "*/5 * * * *",
+					"HOOK_COMMAND": `echo "changed"`,
+					"EMPTY":        "",
+					"QUOTE":        `"`,
+					"URL":          "https://example.com/?a=b",
+				}))
+			},
+		},
+		{
+			testCase: "returns error for invalid lines",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, err := envfile.Parse(strings.NewReader("A=b\nnope"))
+				g.Expect(err).To(MatchError("line 2: expected KEY=VALUE"))
+				_, err = envfile.Parse(strings.NewReader("1A=b"))
+				g.Expect(err).To(MatchError(`line 1: invalid variable name "1A"`))
+				_, err = envfile.Parse(strings.NewReader("A=" + strings.Repeat("b", 70*1024)))
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "loads files",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				path := filepath.Join(tt.TempDir(), "qrkdns.env")
+				g.Expect(os.WriteFile(path, []byte("NETWORK_ID=home\n"), 0o600)).To(Succeed())
+				values, err := envfile.Load(path)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(values).To(Equal(map[string]string{"NETWORK_ID": "home"}))
+
+				_, err = envfile.Load(filepath.Join(tt.TempDir(), "missing.env"))
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "applies files to the environment",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				tt.Setenv("QRKDNS_TEST_SET", "environment")
+				env := envfile.NewEnvironment()
+				g.Expect(env.Values()).To(BeEmpty())
+
+				first := map[string]string{
+					"QRKDNS_TEST_SET":     "file",
+					"QRKDNS_TEST_REMOVED": "first",
+					"QRKDNS_TEST_KEPT":    "first",
+				}
+				g.Expect(env.Apply(first)).To(Succeed())
+				g.Expect(env.Values()).To(Equal(first))
+				g.Expect(os.Getenv("QRKDNS_TEST_SET")).To(Equal("environment"))
+				g.Expect(os.Getenv("QRKDNS_TEST_REMOVED")).To(Equal("first"))
+				g.Expect(os.Getenv("QRKDNS_TEST_KEPT")).To(Equal("first"))
+
+				// Variables of the previous file don't shadow the new one
+				g.Expect(env.Apply(map[string]string{"QRKDNS_TEST_KEPT": "second"})).To(Succeed())
+				_, removed := os.LookupEnv("QRKDNS_TEST_REMOVED")
+				g.Expect(removed).To(BeFalse())
+				g.Expect(os.Getenv("QRKDNS_TEST_KEPT")).To(Equal("second"))
+				g.Expect(os.Getenv("QRKDNS_TEST_SET")).To(Equal("environment"))
+
+				g.Expect(env.Apply(map[string]string{"QRKDNS_TEST_NUL": "\x00"})).NotTo(Succeed())
+				g.Expect(env.Apply(nil)).To(Succeed())
+				_, kept := os.LookupEnv("QRKDNS_TEST_KEPT")
+				g.Expect(kept).To(BeFalse())
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
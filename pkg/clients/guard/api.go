@@ -0,0 +1,29 @@
+package guard
+
+import (
+	"fmt"
+)
+
+// Rejection explains why an address may not be published
+type Rejection struct {
+	IP     string
+	Reason string
+}
+
+// Error implements the error interface
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("refusing to publish %v: %v", r.IP, r.Reason)
+}
+
+// Network holds what the ASN and country databases know about an address
+type Network struct {
+	ASN          uint64
+	Organization string
+	Country      string
+}
+
+// Guard decides whether an address may be published
+type Guard interface {
+	// Check returns a *Rejection if the address must not be published
+	Check(ipAddress string) error
+}
@@ -0,0 +1,282 @@
+package guard
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/mmdb"
+)
+
+var (
+	// Assert client matches the correct interface
+	_ Guard = &DefaultClient{}
+
+	// ReservedRanges are private, shared, documentation and other special
+	// purpose ranges that are never reachable from the internet
+	ReservedRanges []netip.Prefix = []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/8"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("127.0.0.0/8"),
+		netip.MustParsePrefix("169.254.0.0/16"),
+		netip.MustParsePrefix("172.16.0.0/12"),
+		netip.MustParsePrefix("192.0.0.0/24"),
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("192.88.99.0/24"),
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("198.18.0.0/15"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("203.0.113.0/24"),
+		netip.MustParsePrefix("224.0.0.0/4"),
+		netip.MustParsePrefix("240.0.0.0/4"),
+		netip.MustParsePrefix("::/128"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("64:ff9b::/96"),
+		netip.MustParsePrefix("64:ff9b:1::/48"),
+		netip.MustParsePrefix("100::/64"),
+		netip.MustParsePrefix("2001::/23"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("3fff::/20"),
+		netip.MustParsePrefix("fc00::/7"),
+		netip.MustParsePrefix("fe80::/10"),
+		netip.MustParsePrefix("ff00::/8"),
+	}
+)
+
+// DefaultClient checks addresses against CIDR lists, reserved ranges and
+// ASN/country allow lists
+type DefaultClient struct {
+	// Allow, when not empty, lists the only ranges that may be published.
+	// Addresses in these ranges skip the reserved range check.
+	Allow []netip.Prefix
+	// Deny lists ranges that are never published
+	Deny []netip.Prefix
+	// AllowReserved disables the reserved range check
+	AllowReserved bool
+	// AllowedASNs, when not empty, lists the only ASNs that may be published
+	AllowedASNs map[uint64]bool
+	// AllowedCountries, when not empty, lists the only ISO country codes
+	// that may be published
+	AllowedCountries map[string]bool
+	// Databases are MaxMind or IPinfo databases used to find ASNs and countries
+	Databases []mmdb.Reader
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient returns a new guard. By default, only reserved ranges are rejected.
+func NewClient(opts ...LoadOption) (*DefaultClient, error) {
+	client := &DefaultClient{
+		AllowedASNs:      make(map[uint64]bool),
+		AllowedCountries: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		if err := opt(client); err != nil {
+			return nil, err
+		}
+	}
+
+	if (len(client.AllowedASNs) > 0 || len(client.AllowedCountries) > 0) && len(client.Databases) == 0 {
+		return nil, fmt.Errorf("an ASN or country database is required to restrict ASNs or countries")
+	}
+	return client, nil
+}
+
+// WithAllowedCIDRs only allows addresses in the given ranges
+func WithAllowedCIDRs(cidrs []string) LoadOption {
+	return func(client *DefaultClient) error {
+		prefixes, err := parsePrefixes(cidrs)
+		client.Allow = append(client.Allow, prefixes...)
+		return err
+	}
+}
+
+// WithDeniedCIDRs rejects addresses in the given ranges
+func WithDeniedCIDRs(cidrs []string) LoadOption {
+	return func(client *DefaultClient) error {
+		prefixes, err := parsePrefixes(cidrs)
+		client.Deny = append(client.Deny, prefixes...)
+		return err
+	}
+}
+
+// WithReservedRanges allows or rejects addresses in ReservedRanges
+func WithReservedRanges(allow bool) LoadOption {
+	return func(client *DefaultClient) error {
+		client.AllowReserved = allow
+		return nil
+	}
+}
+
+// WithAllowedASNs only allows addresses announced by the given ASNs
+// (e.g., 3320 or AS3320)
+func WithAllowedASNs(asns []string) LoadOption {
+	return func(client *DefaultClient) error {
+		for _, value := range asns {
+			asn, err := ParseASN(value)
+			if err != nil {
+				return err
+			}
+			client.AllowedASNs[asn] = true
+		}
+		return nil
+	}
+}
+
+// WithAllowedCountries only allows addresses located in the given countries
+// (ISO 3166-1 alpha-2 codes)
+func WithAllowedCountries(countries []string) LoadOption {
+	return func(client *DefaultClient) error {
+		for _, country := range countries {
+			client.AllowedCountries[strings.ToUpper(strings.TrimSpace(country))] = true
+		}
+		return nil
+	}
+}
+
+// WithDatabases loads MaxMind or IPinfo databases from the given paths
+func WithDatabases(paths ...string) LoadOption {
+	return func(client *DefaultClient) error {
+		for _, path := range paths {
+			database, err := mmdb.NewClient(path)
+			if err != nil {
+				return err
+			}
+			client.Databases = append(client.Databases, database)
+		}
+		return nil
+	}
+}
+
+// Check returns a *Rejection if the address must not be published
+func (c *DefaultClient) Check(ipAddress string) error {
+	address, err := netip.ParseAddr(ipAddress)
+	if err != nil {
+		return &Rejection{IP: ipAddress, Reason: "not a valid IP address"}
+	}
+	address = address.Unmap()
+
+	if prefix, ok := containing(c.Deny, address); ok {
+		return &Rejection{IP: ipAddress, Reason: fmt.Sprintf("inside denied range %v", prefix)}
+	}
+
+	if _, ok := containing(c.Allow, address); !ok {
+		if len(c.Allow) > 0 {
+			return &Rejection{IP: ipAddress, Reason: "outside the allowed ranges"}
+		}
+		if prefix, ok := containing(ReservedRanges, address); ok && !c.AllowReserved {
+			return &Rejection{IP: ipAddress, Reason: fmt.Sprintf("inside private or reserved range %v", prefix)}
+		}
+	}
+
+	if len(c.AllowedASNs) == 0 && len(c.AllowedCountries) == 0 {
+		return nil
+	}
+
+	network, found, err := c.Lookup(address)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return &Rejection{IP: ipAddress, Reason: "not found in the ASN or country database"}
+	}
+	if len(c.AllowedASNs) > 0 && !c.AllowedASNs[network.ASN] {
+		return &Rejection{IP: ipAddress, Reason: fmt.Sprintf("AS%v (%v) is not an allowed ASN", network.ASN, network.Organization)}
+	}
+	if len(c.AllowedCountries) > 0 && !c.AllowedCountries[network.Country] {
+		return &Rejection{IP: ipAddress, Reason: fmt.Sprintf("country %q is not an allowed country", network.Country)}
+	}
+	return nil
+}
+
+// Lookup merges what every database knows about the address
+func (c *DefaultClient) Lookup(address netip.Addr) (Network, bool, error) {
+	network := Network{}
+	found := false
+	for _, database := range c.Databases {
+		value, ok, err := database.Lookup(net.IP(address.AsSlice()))
+		if err != nil {
+			return Network{}, false, err
+		}
+		if ok {
+			found = true
+			mergeNetwork(&network, value)
+		}
+	}
+	return network, found, nil
+}
+
+// mergeNetwork copies the fields of a MaxMind (GeoLite2/GeoIP2 ASN and
+// Country) or IPinfo record into the network
+func mergeNetwork(network *Network, value interface{}) {
+	fields, _ := value.(map[string]interface{})
+
+	// MaxMind
+	if asn, ok := fields["autonomous_system_number"].(uint64); ok {
+		network.ASN = asn
+	}
+	if organization, ok := fields["autonomous_system_organization"].(string); ok {
+		network.Organization = organization
+	}
+	if country, ok := fields["country"].(map[string]interface{}); ok {
+		network.Country, _ = country["iso_code"].(string)
+	}
+
+	// IPinfo
+	if asn, ok := fields["asn"].(string); ok {
+		network.ASN, _ = ParseASN(asn)
+	}
+	for _, key := range []string{"as_name", "name"} {
+		if organization, ok := fields[key].(string); ok {
+			network.Organization = organization
+		}
+	}
+	for _, key := range []string{"country", "country_code"} {
+		if country, ok := fields[key].(string); ok {
+			network.Country = country
+		}
+	}
+}
+
+// ParseASN parses an AS number with or without its AS prefix
+func ParseASN(value string) (uint64, error) {
+	trimmed := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "AS")
+	asn, err := strconv.ParseUint(trimmed, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ASN %q", value)
+	}
+	return asn, nil
+}
+
+// parsePrefixes parses a list of CIDRs. Plain addresses are treated as
+// single-address ranges.
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if address, err := netip.ParseAddr(cidr); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(address, address.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// containing returns the first prefix containing the address
+func containing(prefixes []netip.Prefix, address netip.Addr) (netip.Prefix, bool) {
+	for _, prefix := range prefixes {
+		if prefix.Contains(address) {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
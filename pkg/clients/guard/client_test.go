@@ -0,0 +1,161 @@
+package guard_test
+
+import (
+	"errors"
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/guard"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// rejection returns the reason an address was rejected, or an empty string
+func rejection(g *WithT, client *guard.DefaultClient, ipAddress string) string {
+	err := client.Check(ipAddress)
+	if err == nil {
+		return ""
+	}
+	var rejection *guard.Rejection
+	g.Expect(errors.As(err, &rejection)).To(BeTrue())
+	g.Expect(rejection.IP).To(Equal(ipAddress))
+	g.Expect(err.Error()).To(Equal("refusing to publish " + ipAddress + ": " + rejection.Reason))
+	return rejection.Reason
+}
+
+func TestClient(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "rejects reserved ranges by default",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := guard.NewClient()
+				g.Expect(err).NotTo(HaveOccurred())
+
+				g.Expect(rejection(g, client, "1.2.3.4")).To(BeEmpty())
+				g.Expect(rejection(g, client, "2606:4700::1111")).To(BeEmpty())
+				g.Expect(rejection(g, client, "100.72.1.1")).To(Equal("inside private or reserved range 100.64.0.0/10"))
+				g.Expect(rejection(g, client, "192.168.1.10")).To(Equal("inside private or reserved range 192.168.0.0/16"))
+				g.Expect(rejection(g, client, "::ffff:10.0.0.1")).To(Equal("inside private or reserved range 10.0.0.0/8"))
+				g.Expect(rejection(g, client, "fd00::1")).To(Equal("inside private or reserved range fc00::/7"))
+				g.Expect(rejection(g, client, "nope")).To(Equal("not a valid IP address"))
+
+				client, err = guard.NewClient(guard.WithReservedRanges(true))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(rejection(g, client, "100.72.1.1")).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "applies allow and deny lists",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := guard.NewClient(
+					guard.WithAllowedCIDRs([]string{"1.2.0.0/16", " 10.1.2.3", "2001:db8::/32"}),
+					guard.WithDeniedCIDRs([]string{"1.2.3.0/24"}),
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				g.Expect(rejection(g, client, "1.2.4.4")).To(BeEmpty())
+				g.Expect(rejection(g, client, "1.2.3.4")).To(Equal("inside denied range 1.2.3.0/24"))
+				g.Expect(rejection(g, client, "5.6.7.8")).To(Equal("outside the allowed ranges"))
+
+				// Allowed ranges take precedence over the reserved ranges
+				g.Expect(rejection(g, client, "10.1.2.3")).To(BeEmpty())
+				g.Expect(rejection(g, client, "10.1.2.4")).To(Equal("outside the allowed ranges"))
+				g.Expect(rejection(g, client, "2001:db8::1")).To(BeEmpty())
+
+				_, err = guard.NewClient(guard.WithAllowedCIDRs([]string{"1.2.3.0/33"}))
+				g.Expect(err).To(MatchError(`invalid CIDR "1.2.3.0/33"`))
+
+				_, err = guard.NewClient(guard.WithDeniedCIDRs([]string{"nope"}))
+				g.Expect(err).To(MatchError(`invalid CIDR "nope"`))
+			},
+		},
+		{
+			testCase: "checks asns and countries in maxmind databases",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				asnPath := mocks.MMDBFixture(mocks.MMDBASN)
+				countryPath := mocks.MMDBFixture(mocks.MMDBCountry)
+
+				client, err := guard.NewClient(
+					guard.WithDatabases(asnPath, countryPath),
+					guard.WithAllowedASNs([]string{"AS3320"}),
+					guard.WithAllowedCountries([]string{"de"}),
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				g.Expect(rejection(g, client, "1.2.3.4")).To(BeEmpty())
+				g.Expect(rejection(g, client, "5.6.7.8")).To(Equal("AS9009 (M247 Europe SRL) is not an allowed ASN"))
+				g.Expect(rejection(g, client, "8.8.8.8")).To(Equal(`country "US" is not an allowed country`))
+				g.Expect(rejection(g, client, "9.9.9.9")).To(Equal("not found in the ASN or country database"))
+
+				network, found, err := client.Lookup(netip.MustParseAddr("1.2.3.4"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(found).To(BeTrue())
+				g.Expect(network).To(Equal(guard.Network{ASN: 3320, Organization: "Deutsche Telekom AG", Country: "DE"}))
+			},
+		},
+		{
+			testCase: "checks asns and countries in ipinfo databases",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				path := mocks.MMDBFixture(mocks.MMDBIPinfo)
+
+				client, err := guard.NewClient(
+					guard.WithDatabases(path),
+					guard.WithAllowedASNs([]string{"3320"}),
+					guard.WithAllowedCountries([]string{"DE"}),
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				g.Expect(rejection(g, client, "1.2.3.4")).To(BeEmpty())
+				g.Expect(rejection(g, client, "5.6.7.8")).To(Equal(`country "NL" is not an allowed country`))
+			},
+		},
+		{
+			testCase: "returns errors for invalid asn and country options",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, err := guard.NewClient(guard.WithAllowedASNs([]string{"ASnope"}))
+				g.Expect(err).To(MatchError(`invalid ASN "ASnope"`))
+
+				_, err = guard.NewClient(guard.WithAllowedCountries([]string{"DE"}))
+				g.Expect(err).To(MatchError("an ASN or country database is required to restrict ASNs or countries"))
+
+				_, err = guard.NewClient(guard.WithDatabases(filepath.Join(tt.TempDir(), "missing.mmdb")))
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "returns database errors",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				// IPv6 addresses can't be looked up in an IPv4 database
+				path := mocks.MMDBFixture(mocks.MMDBIPinfo)
+
+				client, err := guard.NewClient(guard.WithDatabases(path), guard.WithAllowedASNs([]string{"3320"}))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Check("2a00:1450::1")
+				g.Expect(err).To(MatchError(ContainSubstring("IPv6 address in an IPv4-only database")))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
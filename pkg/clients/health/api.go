@@ -0,0 +1,70 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Status is the health of a target as judged by its checks
+type Status string
+
+const (
+	// StatusUnknown is the status of a target not checked yet
+	StatusUnknown Status = "unknown"
+
+	// StatusHealthy is the status of a target passing its checks
+	StatusHealthy Status = "healthy"
+
+	// StatusUnhealthy is the status of a target failing its checks
+	StatusUnhealthy Status = "unhealthy"
+)
+
+// Checker probes the health of an address
+type Checker interface {
+	// Check returns an error if the address is unhealthy
+	Check(ctx context.Context, address string) error
+
+	// String describes the check
+	String() string
+}
+
+// Target is the health of a checked address
+type Target struct {
+	Address string `json:"address"`
+	Status  Status `json:"status"`
+	// Transitions counts the changes between healthy and unhealthy
+	Transitions int       `json:"transitions"`
+	Checked     time.Time `json:"checked"`
+	// Error is the reason the last check failed, if it did
+	Error string `json:"error,omitempty"`
+
+	// streak counts the consecutive checks contradicting the status
+	streak int
+}
+
+// Transition is a change of status of a target
+type Transition struct {
+	Address string `json:"address"`
+	From    Status `json:"from"`
+	To      Status `json:"to"`
+	// Error is the reason the target became unhealthy, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// Monitor tracks the health of a set of targets
+type Monitor interface {
+	// CheckAll checks every target once, returning the changes of status
+	CheckAll(ctx context.Context) []Transition
+
+	// Targets returns the health of every target
+	Targets() []Target
+
+	// Healthy returns the addresses of the healthy targets
+	Healthy() []string
+}
+
+// HTTPClient wraps the HTTP client used to make calls
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
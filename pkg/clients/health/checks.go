@@ -0,0 +1,163 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// tcpPrefix marks TCP checks (e.g., tcp:443)
+	tcpPrefix string = "tcp:"
+)
+
+var (
+	// Assert checks match the correct interface
+	_ Checker = &TCPCheck{}
+	_ Checker = &HTTPCheck{}
+)
+
+// TCPCheck passes when a TCP connection to the port of the address opens
+type TCPCheck struct {
+	Port    string
+	Timeout time.Duration
+	// Dialer opens the connections
+	Dialer *net.Dialer
+}
+
+// Check implements Checker
+func (t *TCPCheck) Check(ctx context.Context, address string) error {
+	ctx, cancel := context.WithTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	conn, err := t.Dialer.DialContext(ctx, "tcp", net.JoinHostPort(address, t.Port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// String implements Checker
+func (t *TCPCheck) String() string {
+	return tcpPrefix + t.Port
+}
+
+// HTTPCheck passes when a request of the URL sent to the address is
+// answered with an expected status. The host of the URL is sent as the
+// Host header and the TLS server name, so that the address answers as the
+// name it serves. Redirects aren't followed.
+type HTTPCheck struct {
+	URL *url.URL
+	// Expected holds the accepted status codes, as inclusive ranges
+	Expected [][2]int
+	Timeout  time.Duration
+	Client   HTTPClient
+}
+
+// NewHTTPCheck returns a check of rawURL, accepting the expected status
+// codes, e.g., "200,204" or "200-399"
+func NewHTTPCheck(rawURL, expected string, timeout time.Duration) (*HTTPCheck, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return nil, fmt.Errorf("invalid health check URL %q", rawURL)
+	}
+	ranges, err := ParseStatuses(expected)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HTTPCheck{
+		URL:      parsed,
+		Expected: ranges,
+		Timeout:  timeout,
+		Client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{ServerName: parsed.Hostname()},
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+// Check implements Checker
+func (h *HTTPCheck) Check(ctx context.Context, address string) error {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+
+	port := h.URL.Port()
+	if port == "" {
+		port = "80"
+		if h.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	target := *h.URL
+	target.Host = net.JoinHostPort(address, port)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Host = h.URL.Host
+	req.Header.Set("User-Agent", "qrkdns-health-check")
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	for _, expected := range h.Expected {
+		if resp.StatusCode >= expected[0] && resp.StatusCode <= expected[1] {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected status %v", resp.StatusCode)
+}
+
+// String implements Checker
+func (h *HTTPCheck) String() string {
+	return h.URL.String()
+}
+
+// ParseCheck returns the check described by spec: tcp:<port>, or an
+// http:// or https:// URL
+func ParseCheck(spec, expected string, timeout time.Duration) (Checker, error) {
+	if port, ok := strings.CutPrefix(spec, tcpPrefix); ok {
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			return nil, fmt.Errorf("invalid health check port %q", port)
+		}
+		return &TCPCheck{Port: port, Timeout: timeout, Dialer: &net.Dialer{}}, nil
+	}
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return NewHTTPCheck(spec, expected, timeout)
+	}
+	return nil, fmt.Errorf("invalid health check %q, expected tcp:<port> or an http(s):// URL", spec)
+}
+
+// ParseStatuses parses a comma-separated list of status codes and inclusive
+// ranges of status codes, e.g., "200,204,300-399"
+func ParseStatuses(value string) ([][2]int, error) {
+	ranges := [][2]int{}
+	for _, part := range strings.Split(value, ",") {
+		low, high, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if !isRange {
+			high = low
+		}
+		from, fromErr := strconv.Atoi(low)
+		to, toErr := strconv.Atoi(high)
+		if fromErr != nil || toErr != nil || from < 100 || to > 599 || from > to {
+			return nil, fmt.Errorf("invalid expected status %q", part)
+		}
+		ranges = append(ranges, [2]int{from, to})
+	}
+	return ranges, nil
+}
@@ -0,0 +1,185 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// Assert client matches the correct interface
+	_ Monitor = &DefaultClient{}
+)
+
+// DefaultClient checks a set of targets, changing the status of a target
+// only once enough consecutive checks agree
+type DefaultClient struct {
+	Checker Checker
+	// HealthyThreshold is the number of consecutive passing checks making
+	// an unhealthy target healthy again
+	HealthyThreshold int
+	// UnhealthyThreshold is the number of consecutive failing checks making
+	// a healthy target unhealthy
+	UnhealthyThreshold int
+	// Now returns the current time
+	Now func() time.Time
+
+	mu      sync.Mutex
+	targets []*Target
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient returns a new client checking every address with checker
+func NewClient(checker Checker, addresses []string, healthyThreshold, unhealthyThreshold int, opts ...LoadOption) (*DefaultClient, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("at least one address to check is required")
+	}
+	if healthyThreshold < 1 || unhealthyThreshold < 1 {
+		return nil, errors.New("the health thresholds must be at least 1")
+	}
+
+	client := &DefaultClient{
+		Checker:            checker,
+		HealthyThreshold:   healthyThreshold,
+		UnhealthyThreshold: unhealthyThreshold,
+		Now:                time.Now,
+	}
+	for _, address := range addresses {
+		client.targets = append(client.targets, &Target{Address: address, Status: StatusUnknown})
+	}
+	for _, opt := range opts {
+		if err := opt(client); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// CheckAll implements Monitor. Targets are checked concurrently. The first
+// check of a target decides its status, and the thresholds apply from then on.
+func (c *DefaultClient) CheckAll(ctx context.Context) []Transition {
+	c.mu.Lock()
+	addresses := []string{}
+	for _, target := range c.targets {
+		addresses = append(addresses, target.Address)
+	}
+	c.mu.Unlock()
+
+	errs := make([]error, len(addresses))
+	wg := sync.WaitGroup{}
+	for i, address := range addresses {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			errs[i] = c.Checker.Check(ctx, address)
+		}(i, address)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.Now()
+	transitions := []Transition{}
+	for i, target := range c.targets {
+		if transition, changed := c.observe(target, errs[i], now); changed {
+			transitions = append(transitions, transition)
+		}
+	}
+	return transitions
+}
+
+// observe applies the outcome of a check to a target, returning the change
+// of status, if any
+func (c *DefaultClient) observe(target *Target, err error, now time.Time) (Transition, bool) {
+	target.Checked = now
+	status, threshold := StatusHealthy, c.HealthyThreshold
+	target.Error = ""
+	if err != nil {
+		status, threshold = StatusUnhealthy, c.UnhealthyThreshold
+		target.Error = err.Error()
+	}
+
+	if target.Status == status {
+		target.streak = 0
+		return Transition{}, false
+	}
+	target.streak++
+	if target.Status != StatusUnknown && target.streak < threshold {
+		return Transition{}, false
+	}
+
+	transition := Transition{Address: target.Address, From: target.Status, To: status, Error: target.Error}
+	if target.Status != StatusUnknown {
+		target.Transitions++
+	}
+	target.Status = status
+	target.streak = 0
+	return transition, true
+}
+
+// Targets implements Monitor
+func (c *DefaultClient) Targets() []Target {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	targets := []Target{}
+	for _, target := range c.targets {
+		targets = append(targets, *target)
+	}
+	return targets
+}
+
+// Healthy implements Monitor, returning the addresses in the order they
+// were given
+func (c *DefaultClient) Healthy() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	addresses := []string{}
+	for _, target := range c.targets {
+		if target.Status == StatusHealthy {
+			addresses = append(addresses, target.Address)
+		}
+	}
+	return addresses
+}
+
+// Checked reports whether every target was checked at least once
+func (c *DefaultClient) Checked() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, target := range c.targets {
+		if target.Status == StatusUnknown {
+			return false
+		}
+	}
+	return true
+}
+
+// Adopt carries the health of the targets of a previous client using the
+// same check over, so that replacing the client doesn't reset the
+// thresholds. Targets the previous client didn't check start unknown.
+func (c *DefaultClient) Adopt(previous *DefaultClient) {
+	if c.Checker.String() != previous.Checker.String() {
+		return
+	}
+
+	previous.mu.Lock()
+	known := map[string]Target{}
+	for _, target := range previous.targets {
+		known[target.Address] = *target
+	}
+	previous.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, target := range c.targets {
+		if state, ok := known[target.Address]; ok {
+			*target = state
+		}
+	}
+}
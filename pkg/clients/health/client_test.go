@@ -0,0 +1,285 @@
+package health_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/health"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// fakeChecker fails the addresses listed in failing
+type fakeChecker struct {
+	failing map[string]bool
+	name    string
+}
+
+func (f *fakeChecker) Check(ctx context.Context, address string) error {
+	if f.failing[address] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (f *fakeChecker) String() string {
+	return f.name
+}
+
+func TestHealth(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	withNow := func(client *health.DefaultClient) error {
+		client.Now = func() time.Time { return now }
+		return nil
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "changes status once the thresholds are reached",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				checker := &fakeChecker{failing: map[string]bool{"1.2.3.5": true}, name: "tcp:443"}
+				client, err := health.NewClient(checker, []string{"1.2.3.4", "1.2.3.5"}, 2, 3, withNow)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Checked()).To(BeFalse())
+				g.Expect(client.Healthy()).To(BeEmpty())
+
+				// The first check decides
+				g.Expect(client.CheckAll(ctx)).To(Equal([]health.Transition{
+					{Address: "1.2.3.4", From: health.StatusUnknown, To: health.StatusHealthy},
+					{Address: "1.2.3.5", From: health.StatusUnknown, To: health.StatusUnhealthy, Error: "connection refused"},
+				}))
+				g.Expect(client.Checked()).To(BeTrue())
+				g.Expect(client.Healthy()).To(Equal([]string{"1.2.3.4"}))
+
+				checker.failing = map[string]bool{"1.2.3.4": true}
+				g.Expect(client.CheckAll(ctx)).To(BeEmpty())
+				g.Expect(client.CheckAll(ctx)).To(Equal([]health.Transition{
+					{Address: "1.2.3.5", From: health.StatusUnhealthy, To: health.StatusHealthy},
+				}))
+				g.Expect(client.Healthy()).To(Equal([]string{"1.2.3.4", "1.2.3.5"}))
+
+				// A passing check starts over the count of failing ones
+				checker.failing = map[string]bool{}
+				g.Expect(client.CheckAll(ctx)).To(BeEmpty())
+				checker.failing = map[string]bool{"1.2.3.4": true}
+				g.Expect(client.CheckAll(ctx)).To(BeEmpty())
+				g.Expect(client.CheckAll(ctx)).To(BeEmpty())
+				g.Expect(client.CheckAll(ctx)).To(Equal([]health.Transition{
+					{Address: "1.2.3.4", From: health.StatusHealthy, To: health.StatusUnhealthy, Error: "connection refused"},
+				}))
+				g.Expect(client.Healthy()).To(Equal([]string{"1.2.3.5"}))
+				g.Expect(client.Targets()).To(Equal([]health.Target{
+					{Address: "1.2.3.4", Status: health.StatusUnhealthy, Transitions: 1, Checked: now, Error: "connection refused"},
+					{Address: "1.2.3.5", Status: health.StatusHealthy, Transitions: 1, Checked: now},
+				}))
+			},
+		},
+		{
+			testCase: "adopts the health of a previous client",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				checker := &fakeChecker{failing: map[string]bool{"1.2.3.5": true}, name: "tcp:443"}
+				previous, err := health.NewClient(checker, []string{"1.2.3.4", "1.2.3.5"}, 1, 1, withNow)
+				g.Expect(err).NotTo(HaveOccurred())
+				previous.CheckAll(ctx)
+
+				client, err := health.NewClient(checker, []string{"1.2.3.5", "1.2.3.6"}, 1, 1, withNow)
+				g.Expect(err).NotTo(HaveOccurred())
+				client.Adopt(previous)
+				g.Expect(client.Targets()).To(Equal([]health.Target{
+					{Address: "1.2.3.5", Status: health.StatusUnhealthy, Checked: now, Error: "connection refused"},
+					{Address: "1.2.3.6", Status: health.StatusUnknown},
+				}))
+
+				// Another check starts over
+				client, err = health.NewClient(&fakeChecker{name: "tcp:80"}, []string{"1.2.3.5"}, 1, 1)
+				g.Expect(err).NotTo(HaveOccurred())
+				client.Adopt(previous)
+				g.Expect(client.Checked()).To(BeFalse())
+			},
+		},
+		{
+			testCase: "returns error for invalid settings",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				checker := &fakeChecker{}
+				_, err := health.NewClient(checker, []string{}, 1, 1)
+				g.Expect(err).To(MatchError("at least one address to check is required"))
+				_, err = health.NewClient(checker, []string{"1.2.3.4"}, 0, 1)
+				g.Expect(err).To(MatchError("the health thresholds must be at least 1"))
+				_, err = health.NewClient(checker, []string{"1.2.3.4"}, 1, 1, func(client *health.DefaultClient) error {
+					return errors.New("boom")
+				})
+				g.Expect(err).To(MatchError("boom"))
+			},
+		},
+		{
+			testCase: "checks TCP ports",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				g.Expect(err).NotTo(HaveOccurred())
+				_, port, err := net.SplitHostPort(listener.Addr().String())
+				g.Expect(err).NotTo(HaveOccurred())
+
+				checker, err := health.ParseCheck("tcp:"+port, "200", time.Second)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(checker.String()).To(Equal("tcp:" + port))
+				g.Expect(checker.Check(ctx, "127.0.0.1")).To(Succeed())
+
+				g.Expect(listener.Close()).To(Succeed())
+				g.Expect(checker.Check(ctx, "127.0.0.1")).To(MatchError(ContainSubstring("connection refused")))
+			},
+		},
+		{
+			testCase: "checks HTTP status codes",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				hosts := []string{}
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					hosts = append(hosts, r.Host)
+					switch r.URL.Path {
+					case "/healthz":
+						w.WriteHeader(http.StatusNoContent)
+					case "/moved":
+						http.Redirect(w, r, "/healthz", http.StatusFound)
+					default:
+						w.WriteHeader(http.StatusServiceUnavailable)
+					}
+				}))
+				defer server.Close()
+				_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+				g.Expect(err).NotTo(HaveOccurred())
+
+				checker, err := health.ParseCheck("http://www.foo.net:"+port+"/healthz", "200,204", time.Second)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(checker.String()).To(Equal("http://www.foo.net:" + port + "/healthz"))
+				g.Expect(checker.Check(ctx, "127.0.0.1")).To(Succeed())
+				g.Expect(hosts).To(Equal([]string{"www.foo.net:" + port}))
+
+				// Redirects aren't followed
+				checker, err = health.ParseCheck("http://www.foo.net:"+port+"/moved", "200-299", time.Second)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(checker.Check(ctx, "127.0.0.1")).To(MatchError("unexpected status 302"))
+
+				checker, err = health.ParseCheck("http://www.foo.net:"+port+"/down", "200-399", time.Second)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(checker.Check(ctx, "127.0.0.1")).To(MatchError("unexpected status 503"))
+
+				server.Close()
+				g.Expect(checker.Check(ctx, "127.0.0.1")).To(MatchError(ContainSubstring("connection refused")))
+
+				// Addresses that can't make a URL fail before any request
+				g.Expect(checker.Check(ctx, "127.0.0.1\n")).To(MatchError(ContainSubstring("invalid URL escape")))
+			},
+		},
+		{
+			testCase: "checks HTTPS with the host name of the URL",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}))
+				defer server.Close()
+				_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+				g.Expect(err).NotTo(HaveOccurred())
+
+				// The test certificate is issued to example.com
+				checker, err := health.NewHTTPCheck("https://example.com:"+port+"/", "200", time.Second)
+				g.Expect(err).NotTo(HaveOccurred())
+				transport := checker.Client.(*http.Client).Transport.(*http.Transport)
+				transport.TLSClientConfig.RootCAs = server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+				g.Expect(checker.Check(ctx, "127.0.0.1")).To(Succeed())
+
+				checker, err = health.NewHTTPCheck("https://www.foo.net:"+port+"/", "200", time.Second)
+				g.Expect(err).NotTo(HaveOccurred())
+				checker.Client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+					ServerName: "www.foo.net",
+					RootCAs:    transport.TLSClientConfig.RootCAs,
+				}}}
+				g.Expect(checker.Check(ctx, "127.0.0.1")).To(MatchError(ContainSubstring("certificate")))
+
+				// Default ports
+				checker, err = health.NewHTTPCheck("https://www.foo.net/", "200", time.Second)
+				g.Expect(err).NotTo(HaveOccurred())
+				requested := []string{}
+				checker.Client = &recordingClient{requested: &requested}
+				g.Expect(checker.Check(ctx, "127.0.0.1")).To(MatchError("boom"))
+				checker, err = health.NewHTTPCheck("http://www.foo.net/", "200", time.Second)
+				g.Expect(err).NotTo(HaveOccurred())
+				checker.Client = &recordingClient{requested: &requested}
+				g.Expect(checker.Check(ctx, "127.0.0.1")).To(MatchError("boom"))
+				g.Expect(requested).To(Equal([]string{"https://127.0.0.1:443/", "http://127.0.0.1:80/"}))
+			},
+		},
+		{
+			testCase: "returns error for invalid checks",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for spec, message := range map[string]string{
+					"tcp:nope":           `invalid health check port "nope"`,
+					"tcp:70000":          `invalid health check port "70000"`,
+					"icmp":               `invalid health check "icmp", expected tcp:<port> or an http(s):// URL`,
+					"http://":            `invalid health check URL "http://"`,
+					"https://[::1":       `invalid health check URL "https://[::1"`,
+					"http://www.foo.net": "",
+				} {
+					_, err := health.ParseCheck(spec, "200", time.Second)
+					if message == "" {
+						g.Expect(err).NotTo(HaveOccurred(), spec)
+						continue
+					}
+					g.Expect(err).To(MatchError(message), spec)
+				}
+
+				for expected, message := range map[string]string{
+					"nope":    `invalid expected status "nope"`,
+					"200-":    `invalid expected status "200-"`,
+					"99":      `invalid expected status "99"`,
+					"300-200": `invalid expected status "300-200"`,
+				} {
+					_, err := health.ParseCheck("http://www.foo.net", expected, time.Second)
+					g.Expect(err).To(MatchError(message), expected)
+				}
+
+				ranges, err := health.ParseStatuses("200, 300-399")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(ranges).To(Equal([][2]int{{200, 200}, {300, 399}}))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
+
+// recordingClient records the URLs it is asked for, failing every request
+type recordingClient struct {
+	requested *[]string
+}
+
+func (r *recordingClient) Do(req *http.Request) (*http.Response, error) {
+	*r.requested = append(*r.requested, req.URL.String())
+	return nil, errors.New("boom")
+}
@@ -0,0 +1,113 @@
+package history
+
+import (
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+)
+
+// EntryType labels the kind of change recorded in the history
+type EntryType string
+
+const (
+	// EntryTypeIPChanged is recorded when discovery returns a new address
+	EntryTypeIPChanged EntryType = "ip_changed"
+
+	// EntryTypeRecordCreated is recorded when a record is created
+	EntryTypeRecordCreated EntryType = "record_created"
+
+	// EntryTypeRecordUpdated is recorded when a record is modified in place
+	EntryTypeRecordUpdated EntryType = "record_updated"
+
+	// EntryTypeRecordDeleted is recorded when a record is removed
+	EntryTypeRecordDeleted EntryType = "record_deleted"
+
+	// EntryTypeIPRejected is recorded when the publication guard refuses
+	// a discovered address
+	EntryTypeIPRejected EntryType = "ip_rejected"
+)
+
+var (
+	// SupportedEntryTypes lists every entry type that can be recorded
+	SupportedEntryTypes []EntryType = []EntryType{
+		EntryTypeIPChanged,
+		EntryTypeRecordCreated,
+		EntryTypeRecordUpdated,
+		EntryTypeRecordDeleted,
+		EntryTypeIPRejected,
+	}
+)
+
+// Entry is a single line of the history
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Type     EntryType `json:"type"`
+	Name     string    `json:"name"`
+	Provider string    `json:"provider,omitempty"`
+	// Source is the IP service or interface the address was discovered from
+	Source string `json:"source,omitempty"`
+	OldIP  string `json:"old_ip,omitempty"`
+	NewIP  string `json:"new_ip,omitempty"`
+	// Reason explains why an address was rejected
+	Reason string `json:"reason,omitempty"`
+	// Record is the provider's record after it was created or updated,
+	// or the record that was deleted
+	Record *dns.Record `json:"record,omitempty"`
+	// Snapshot holds the provider's records for the name before the mutation
+	Snapshot []dns.Record `json:"snapshot,omitempty"`
+}
+
+// Filter narrows down a history query. Empty fields match everything.
+type Filter struct {
+	Since time.Time
+	Until time.Time
+	Name  string
+	Types []EntryType
+}
+
+// Matches returns true if the entry passes the filter
+func (f *Filter) Matches(entry Entry) bool {
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	if f.Name != "" && entry.Name != f.Name {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, entryType := range f.Types {
+		if entry.Type == entryType {
+			return true
+		}
+	}
+	return false
+}
+
+// StablePeriod is a span of time during which a name kept the same address
+type StablePeriod struct {
+	Name    string    `json:"name"`
+	IP      string    `json:"ip"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Seconds int64     `json:"seconds"`
+}
+
+// Duration returns the length of the period
+func (p *StablePeriod) Duration() time.Duration {
+	return p.To.Sub(p.From)
+}
+
+// Stats summarizes a set of history entries
+type Stats struct {
+	Entries       int           `json:"entries"`
+	IPChanges     int           `json:"ip_changes"`
+	RecordChanges int           `json:"record_changes"`
+	Rejections    int           `json:"rejections"`
+	ChangesPerDay float64       `json:"changes_per_day"`
+	LastChange    *time.Time    `json:"last_change,omitempty"`
+	LongestStable *StablePeriod `json:"longest_stable_period,omitempty"`
+}
@@ -0,0 +1,181 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// FileName is the name of the history file inside the state directory
+	FileName string = "history.jsonl"
+)
+
+// DefaultClient appends to and reads from a JSONL history file
+type DefaultClient struct {
+	// Path is the location of the history file
+	Path string
+	// Now returns the current time, used to timestamp entries
+	Now func() time.Time
+
+	mu sync.Mutex
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient returns a new client storing its history in stateDir
+func NewClient(stateDir string, opts ...LoadOption) (*DefaultClient, error) {
+	client := &DefaultClient{
+		Path: filepath.Join(stateDir, FileName),
+		Now:  time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(client); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// Append writes entries to the end of the history, timestamping
+// entries that don't have a time yet
+func (c *DefaultClient) Append(entries ...Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0o700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(c.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, entry := range entries {
+		if entry.Time.IsZero() {
+			entry.Time = c.Now().UTC()
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return file.Sync()
+}
+
+// Query returns the entries matching filter, oldest first. A missing
+// history file holds no entries.
+func (c *DefaultClient) Query(filter Filter) ([]Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file, err := os.Open(c.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return []Entry{}, err
+	}
+	defer file.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return []Entry{}, fmt.Errorf("%v:%v: %w", c.Path, line, err)
+		}
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return []Entry{}, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// LastIP returns the most recently observed address for name, or an
+// empty string if none was recorded
+func (c *DefaultClient) LastIP(name string) (string, error) {
+	entries, err := c.Query(Filter{Name: name, Types: []EntryType{EntryTypeIPChanged}})
+	if err != nil || len(entries) == 0 {
+		return "", err
+	}
+	return entries[len(entries)-1].NewIP, nil
+}
+
+// Summarize computes change statistics for entries, which must be sorted
+// oldest first. The last address of each name is considered stable until until.
+func Summarize(entries []Entry, until time.Time) Stats {
+	stats := Stats{Entries: len(entries)}
+	if len(entries) == 0 {
+		return stats
+	}
+
+	observed := make(map[string]Entry)
+	for _, entry := range entries {
+		switch entry.Type {
+		case EntryTypeIPChanged:
+		case EntryTypeIPRejected:
+			stats.Rejections++
+			continue
+		default:
+			stats.RecordChanges++
+			continue
+		}
+
+		if entry.OldIP != "" {
+			stats.IPChanges++
+			changed := entry.Time
+			stats.LastChange = &changed
+		}
+
+		if previous, ok := observed[entry.Name]; ok {
+			stats.LongestStable = longest(stats.LongestStable, previous, entry.Time)
+		}
+		observed[entry.Name] = entry
+	}
+	for _, entry := range observed {
+		stats.LongestStable = longest(stats.LongestStable, entry, until)
+	}
+
+	days := until.Sub(entries[0].Time).Hours() / 24
+	if days > 0 {
+		stats.ChangesPerDay = float64(stats.IPChanges) / days
+	}
+	return stats
+}
+
+// longest returns whichever is longer of current and the period during
+// which the address of since was held until to
+func longest(current *StablePeriod, since Entry, to time.Time) *StablePeriod {
+	period := &StablePeriod{
+		Name:    since.Name,
+		IP:      since.NewIP,
+		From:    since.Time,
+		To:      to,
+		Seconds: int64(to.Sub(since.Time).Seconds()),
+	}
+	if current == nil || period.Duration() > current.Duration() {
+		return period
+	}
+	return current
+}
@@ -0,0 +1,201 @@
+package history_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+var start = time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+
+func at(hours int) time.Time {
+	return start.Add(time.Duration(hours) * time.Hour)
+}
+
+func TestFile(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "appends and queries entries",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := history.NewClient(filepath.Join(tt.TempDir(), "state"), func(client *history.DefaultClient) error {
+					client.Now = func() time.Time { return at(5) }
+					return nil
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				record := dns.Record{ID: "1", Type: dns.RecordTypeA, Name: "bar.foo.net", Content: "1.2.3.4"}
+				err = client.Append(
+					history.Entry{Time: at(1), Type: history.EntryTypeIPChanged, Name: "bar.foo.net", NewIP: "1.2.3.4"},
+					history.Entry{Time: at(2), Type: history.EntryTypeRecordCreated, Name: "bar.foo.net", NewIP: "1.2.3.4", Record: &record},
+					history.Entry{Time: at(3), Type: history.EntryTypeIPChanged, Name: "baz.foo.net", NewIP: "5.6.7.8"},
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Append(history.Entry{Type: history.EntryTypeIPChanged, Name: "bar.foo.net", OldIP: "1.2.3.4", NewIP: "2.2.2.2"})).To(Succeed())
+
+				entries, err := client.Query(history.Filter{})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(HaveLen(4))
+				g.Expect(entries[1].Record).To(Equal(&record))
+				g.Expect(entries[3].Time).To(Equal(at(5)))
+
+				entries, err = client.Query(history.Filter{Name: "bar.foo.net", Types: []history.EntryType{history.EntryTypeIPChanged}})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(HaveLen(2))
+
+				entries, err = client.Query(history.Filter{Since: at(2), Until: at(3)})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(HaveLen(2))
+				g.Expect(entries[0].Type).To(Equal(history.EntryTypeRecordCreated))
+
+				lastIP, err := client.LastIP("bar.foo.net")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lastIP).To(Equal("2.2.2.2"))
+
+				lastIP, err = client.LastIP("missing.foo.net")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lastIP).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "missing history has no entries",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := history.NewClient(tt.TempDir())
+				g.Expect(err).NotTo(HaveOccurred())
+
+				entries, err := client.Query(history.Filter{})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "returns error for corrupt lines",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				path := filepath.Join(stateDir, history.FileName)
+				g.Expect(os.WriteFile(path, []byte("{}\n\nnope\n"), 0o600)).To(Succeed())
+
+				client, err := history.NewClient(stateDir)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				_, err = client.Query(history.Filter{})
+				g.Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("%v:3: invalid character", path))))
+
+				_, err = client.LastIP("bar.foo.net")
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "returns file system errors",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				// The state directory is a regular file
+				stateDir := filepath.Join(tt.TempDir(), "file")
+				g.Expect(os.WriteFile(stateDir, []byte{}, 0o600)).To(Succeed())
+
+				client, err := history.NewClient(stateDir)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Append(history.Entry{})).NotTo(Succeed())
+
+				_, err = client.Query(history.Filter{})
+				g.Expect(err).To(HaveOccurred())
+
+				// The history file is a directory
+				stateDir = tt.TempDir()
+				g.Expect(os.Mkdir(filepath.Join(stateDir, history.FileName), 0o700)).To(Succeed())
+
+				client, err = history.NewClient(stateDir)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Append(history.Entry{})).NotTo(Succeed())
+
+				_, err = client.Query(history.Filter{})
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "returns error encoding entries",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := history.NewClient(tt.TempDir())
+				g.Expect(err).NotTo(HaveOccurred())
+
+				// Times past year 9999 have no JSON encoding
+				late := time.Date(10000, time.January, 1, 0, 0, 0, 0, time.UTC)
+				err = client.Append(history.Entry{Name: "bar.foo.net", NewIP: "1.1.1.1", Time: late})
+				g.Expect(err).To(MatchError(ContainSubstring("year outside of range [0,9999]")))
+
+				// Nothing is written for the entry
+				entries, err := client.Query(history.Filter{})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "summarizes changes",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stats := history.Summarize([]history.Entry{
+					{Time: at(0), Type: history.EntryTypeIPChanged, Name: "bar.foo.net", NewIP: "1.1.1.1"},
+					{Time: at(0), Type: history.EntryTypeRecordCreated, Name: "bar.foo.net", NewIP: "1.1.1.1"},
+					{Time: at(10), Type: history.EntryTypeIPChanged, Name: "bar.foo.net", OldIP: "1.1.1.1", NewIP: "2.2.2.2"},
+					{Time: at(12), Type: history.EntryTypeIPChanged, Name: "bar.foo.net", OldIP: "2.2.2.2", NewIP: "3.3.3.3"},
+					{Time: at(12), Type: history.EntryTypeRecordUpdated, Name: "bar.foo.net", OldIP: "2.2.2.2", NewIP: "3.3.3.3"},
+					{Time: at(20), Type: history.EntryTypeIPRejected, Name: "bar.foo.net", NewIP: "100.64.0.1", Reason: "inside private or reserved range 100.64.0.0/10"},
+				}, at(48))
+
+				g.Expect(stats.Entries).To(Equal(6))
+				g.Expect(stats.IPChanges).To(Equal(2))
+				g.Expect(stats.RecordChanges).To(Equal(2))
+				g.Expect(stats.Rejections).To(Equal(1))
+				g.Expect(stats.ChangesPerDay).To(Equal(1.0))
+				g.Expect(*stats.LastChange).To(Equal(at(12)))
+				g.Expect(stats.LongestStable.IP).To(Equal("3.3.3.3"))
+				g.Expect(stats.LongestStable.Duration()).To(Equal(36 * time.Hour))
+				g.Expect(stats.LongestStable.Seconds).To(Equal(int64(36 * 60 * 60)))
+
+				stats = history.Summarize([]history.Entry{
+					{Time: at(0), Type: history.EntryTypeIPChanged, Name: "bar.foo.net", NewIP: "1.1.1.1"},
+					{Time: at(30), Type: history.EntryTypeIPChanged, Name: "bar.foo.net", OldIP: "1.1.1.1", NewIP: "2.2.2.2"},
+				}, at(31))
+				g.Expect(stats.LongestStable.IP).To(Equal("1.1.1.1"))
+				g.Expect(stats.LongestStable.To).To(Equal(at(30)))
+
+				stats = history.Summarize([]history.Entry{}, at(1))
+				g.Expect(stats).To(Equal(history.Stats{}))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := history.NewClient("", func(client *history.DefaultClient) error {
+					return fmt.Errorf("oh no")
+				})
+				g.Expect(err).To(MatchError("oh no"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
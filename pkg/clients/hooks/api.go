@@ -0,0 +1,10 @@
+package hooks
+
+import "context"
+
+// CommandRunner wraps the execution of a shell command
+type CommandRunner interface {
+	// RunCommand runs the command with the provided extra environment and
+	// returns its combined output
+	RunCommand(ctx context.Context, command string, env []string) ([]byte, error)
+}
@@ -0,0 +1,167 @@
+package hooks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	_ CommandRunner = &execRunner{}
+)
+
+// Type labels the point in a sync at which a hook runs
+type Type string
+
+const (
+	// TypePreSync runs after IP discovery and before the provider is called
+	TypePreSync Type = "pre-sync"
+
+	// TypePostChange runs after the provider changed a record
+	TypePostChange Type = "post-change"
+)
+
+// FailurePolicy decides what a failing hook does to the sync
+type FailurePolicy string
+
+const (
+	// FailurePolicyIgnore logs failures at debug level only
+	FailurePolicyIgnore FailurePolicy = "ignore"
+
+	// FailurePolicyWarn logs failures as warnings and continues
+	FailurePolicyWarn FailurePolicy = "warn"
+
+	// FailurePolicyFail aborts the sync with the hook's error
+	FailurePolicyFail FailurePolicy = "fail"
+)
+
+var (
+	// SupportedFailurePolicies defines which failure policies are accepted
+	SupportedFailurePolicies []FailurePolicy = []FailurePolicy{
+		FailurePolicyIgnore,
+		FailurePolicyWarn,
+		FailurePolicyFail,
+	}
+)
+
+// Env holds the values exposed to a hook as environment variables
+type Env struct {
+	OldIP      string
+	NewIP      string
+	RecordName string
+	Provider   string
+}
+
+// DefaultClient implements the hook runner
+type DefaultClient struct {
+	Timeout time.Duration
+	Policy  FailurePolicy
+	Client  CommandRunner
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// execRunner runs commands through the system shell
+type execRunner struct{}
+
+// RunCommand implements CommandRunner
+func (e *execRunner) RunCommand(ctx context.Context, command string, env []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	// Don't wait on children that inherited the output pipe after a timeout
+	cmd.WaitDelay = time.Second
+	return cmd.CombinedOutput()
+}
+
+// NewClient returns a new hook client
+func NewClient(timeout time.Duration, policy string, opts ...LoadOption) (DefaultClient, error) {
+	failurePolicy, err := ParseFailurePolicy(policy)
+	if err != nil {
+		return DefaultClient{}, err
+	}
+	client := DefaultClient{
+		Timeout: timeout,
+		Policy:  failurePolicy,
+		Client:  &execRunner{},
+	}
+	for _, opt := range opts {
+		if err := opt(&client); err != nil {
+			return DefaultClient{}, err
+		}
+	}
+	return client, nil
+}
+
+// ParseFailurePolicy validates a failure policy name
+func ParseFailurePolicy(policy string) (FailurePolicy, error) {
+	for _, supported := range SupportedFailurePolicies {
+		if FailurePolicy(policy) == supported {
+			return supported, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported hook failure policy: %v", policy)
+}
+
+// Run executes a hook command, logging its output. An empty command is a no-op.
+// Errors are only returned when the failure policy is to fail the sync.
+func (c *DefaultClient) Run(ctx context.Context, hookType Type, command string, env Env) error {
+	if command == "" {
+		return nil
+	}
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	contextLog := log.WithFields(log.Fields{
+		"hook":    hookType,
+		"command": command,
+	})
+
+	contextLog.Debug("Running hook")
+	output, err := c.Client.RunCommand(ctx, command, env.toList(hookType))
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		contextLog.WithField("output", scanner.Text()).Info("Hook output")
+	}
+
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		err = fmt.Errorf("hook timed out after %v: %w", c.Timeout, err)
+	}
+
+	switch c.Policy {
+	case FailurePolicyIgnore:
+		contextLog.WithError(err).Debug("Hook failed")
+		return nil
+	case FailurePolicyWarn:
+		contextLog.WithError(err).Warn("Hook failed")
+		return nil
+	default:
+		contextLog.WithError(err).Error("Hook failed")
+		return fmt.Errorf("%v hook failed: %w", hookType, err)
+	}
+}
+
+// toList converts the env to the KEY=value form used by exec
+func (e *Env) toList(hookType Type) []string {
+	return []string{
+		fmt.Sprintf("QRKDNS_HOOK=%v", hookType),
+		fmt.Sprintf("QRKDNS_OLD_IP=%v", e.OldIP),
+		fmt.Sprintf("QRKDNS_NEW_IP=%v", e.NewIP),
+		fmt.Sprintf("QRKDNS_RECORD_NAME=%v", e.RecordName),
+		fmt.Sprintf("QRKDNS_PROVIDER=%v", e.Provider),
+	}
+}
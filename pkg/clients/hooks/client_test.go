@@ -0,0 +1,143 @@
+package hooks_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/hooks"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+func newMockHookClient(policy string) (hooks.DefaultClient, *mocks.MockCommandRunner, error) {
+	runner := &mocks.MockCommandRunner{}
+	client, err := hooks.NewClient(time.Second, policy, func(client *hooks.DefaultClient) error {
+		client.Client = runner
+		return nil
+	})
+	return client, runner, err
+}
+
+func TestFile(t *testing.T) {
+	env := hooks.Env{OldIP: "1.1.1.1", NewIP: "2.2.2.2", RecordName: "bar.foo.net", Provider: "cloudflare"}
+
+	tests := []testRunner{
+		{
+			testCase: "passes sync details as environment variables",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, runner, err := newMockHookClient("fail")
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Run(context.Background(), hooks.TypePostChange, "wg-reload", env)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(runner.Commands).To(Equal([]string{"wg-reload"}))
+				g.Expect(runner.Envs[0]).To(ConsistOf(
+					"QRKDNS_HOOK=post-change",
+					"QRKDNS_OLD_IP=1.1.1.1",
+					"QRKDNS_NEW_IP=2.2.2.2",
+					"QRKDNS_RECORD_NAME=bar.foo.net",
+					"QRKDNS_PROVIDER=cloudflare",
+				))
+			},
+		},
+		{
+			testCase: "skips empty commands",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, runner, err := newMockHookClient("fail")
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Run(context.Background(), hooks.TypePreSync, "", env)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(runner.Commands).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "fails the sync when the policy is fail",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, _, err := newMockHookClient("fail")
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = envy.AddErrorReturns("RunCommand", fmt.Errorf("exit status 1"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Run(context.Background(), hooks.TypePreSync, "false", env)
+				g.Expect(err).To(MatchError("pre-sync hook failed: exit status 1"))
+			},
+		},
+		{
+			testCase: "continues when the policy is warn or ignore",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				for _, policy := range []string{"warn", "ignore"} {
+					client, _, err := newMockHookClient(policy)
+					g.Expect(err).NotTo(HaveOccurred())
+
+					err = envy.AddErrorReturns("RunCommand", fmt.Errorf("exit status 1"))
+					g.Expect(err).NotTo(HaveOccurred())
+
+					err = client.Run(context.Background(), hooks.TypePreSync, "false", env)
+					g.Expect(err).NotTo(HaveOccurred())
+				}
+			},
+		},
+		{
+			testCase: "runs commands through the shell",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := hooks.NewClient(5*time.Second, "fail")
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Run(context.Background(), hooks.TypePostChange, `test "$QRKDNS_NEW_IP" = "2.2.2.2" && echo ok`, env)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Run(context.Background(), hooks.TypePostChange, `test "$QRKDNS_NEW_IP" = "3.3.3.3"`, env)
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "kills hooks that exceed the timeout",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := hooks.NewClient(50*time.Millisecond, "fail")
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Run(context.Background(), hooks.TypePreSync, "sleep 5", env)
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(err.Error()).To(ContainSubstring("hook timed out after 50ms"))
+			},
+		},
+		{
+			testCase: "returns error for unsupported failure policy",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := hooks.NewClient(time.Second, "explode")
+				g.Expect(err).To(MatchError("unsupported hook failure policy: explode"))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := hooks.NewClient(time.Second, "warn", func(client *hooks.DefaultClient) error {
+					return fmt.Errorf("oh no")
+				})
+				g.Expect(err).To(MatchError("oh no"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
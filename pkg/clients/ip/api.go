@@ -2,7 +2,28 @@ package ip
 
 import "net/http"
 
+const (
+	// NonceHeader carries the random challenge the answer of a signing IP
+	// service must include, so that old answers can't be replayed
+	NonceHeader string = "X-Qrkdns-Nonce"
+
+	// SignatureHeader carries the hex encoded HMAC-SHA256 of the nonce and
+	// the address, computed by a signing IP service
+	SignatureHeader string = "X-Qrkdns-Signature"
+)
+
 // HTTPClient wraps the HTTP client used to make calls
 type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
+
+const (
+	// SourceHTTP discovers the address through an IP service
+	SourceHTTP string = "http"
+
+	// SourceInterface reads the address of a network interface of the
+	// host, e.g., its LAN address. It is followed by the name of the
+	// interface (interface:eth0), or nothing for the interface routing to
+	// the internet.
+	SourceInterface string = "interface"
+)
@@ -3,6 +3,10 @@ package ip
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +17,8 @@ type DefaultClient struct {
 	IPServiceURL string
 	// Client       *http.Client
 	Client HTTPClient
+	// HMACKey, if set, is used to verify the signature of answers
+	HMACKey []byte
 }
 
 // LoadOption allows for modifying the client after it's created
@@ -32,12 +38,34 @@ func NewClient(ipServiceURL string, opts ...LoadOption) (DefaultClient, error) {
 	return client, nil
 }
 
+// WithHMACKey requires answers to be signed with the key, as done by
+// `qrkdns serve-ip`
+func WithHMACKey(key string) LoadOption {
+	return func(client *DefaultClient) error {
+		client.HMACKey = []byte(key)
+		return nil
+	}
+}
+
+// Sign returns the signature of an answer to the nonce
+func Sign(key []byte, nonce, ipAddress string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(nonce + "\n" + ipAddress))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 // GetExternalIPAddress returns the preferred outbound IP address used by this machine
 func (c *DefaultClient) GetExternalIPAddress(ctx context.Context) (string, error) {
 	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.IPServiceURL, nil)
 	if err != nil {
 		return "", err
 	}
+	nonce := ""
+	if len(c.HMACKey) > 0 {
+		nonce = rand.Text()
+		request.Header.Set(NonceHeader, nonce)
+	}
+
 	response, err := c.Client.Do(request)
 	if err != nil {
 		return "", err
@@ -56,5 +84,12 @@ func (c *DefaultClient) GetExternalIPAddress(ctx context.Context) (string, error
 	if response.StatusCode != 200 {
 		return "", fmt.Errorf("received status code %v: %v", response.StatusCode, trimmedBody)
 	}
+
+	if len(c.HMACKey) > 0 {
+		expected := Sign(c.HMACKey, nonce, trimmedBody)
+		if !hmac.Equal([]byte(response.Header.Get(SignatureHeader)), []byte(expected)) {
+			return "", fmt.Errorf("answer of %v is not signed with the expected key", c.IPServiceURL)
+		}
+	}
 	return trimmedBody, nil
 }
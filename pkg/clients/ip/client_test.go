@@ -28,6 +28,25 @@ func newMockIPClient() (ip.DefaultClient, error) {
 	return client, nil
 }
 
+// signingHTTPClient answers like a signing IP service
+type signingHTTPClient struct {
+	key     string
+	address string
+	nonces  []string
+}
+
+func (c *signingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	nonce := req.Header.Get(ip.NonceHeader)
+	c.nonces = append(c.nonces, nonce)
+	header := http.Header{}
+	header.Set(ip.SignatureHeader, ip.Sign([]byte(c.key), nonce, c.address))
+	return &http.Response{
+		StatusCode: 200,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(c.address + "\n")),
+	}, nil
+}
+
 func TestFile(t *testing.T) {
 	tests := []testRunner{
 		{
@@ -132,6 +151,46 @@ func TestFile(t *testing.T) {
 				g.Expect(err).To(HaveOccurred())
 			},
 		},
+		{
+			testCase: "verifies signed answers",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				ctx := context.Background()
+
+				signer := &signingHTTPClient{key: "s3cret", address: "1.2.3.4"}
+				client, err := ip.NewClient("some_url", ip.WithHMACKey("s3cret"), func(client *ip.DefaultClient) error {
+					client.Client = signer
+					return nil
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				ipAddress, err := client.GetExternalIPAddress(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(ipAddress).To(Equal("1.2.3.4"))
+
+				// Every request is a new challenge
+				_, err = client.GetExternalIPAddress(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(signer.nonces).To(HaveLen(2))
+				g.Expect(signer.nonces[0]).NotTo(BeEmpty())
+				g.Expect(signer.nonces[0]).NotTo(Equal(signer.nonces[1]))
+
+				signer.key = "other"
+				_, err = client.GetExternalIPAddress(ctx)
+				g.Expect(err).To(MatchError("answer of some_url is not signed with the expected key"))
+
+				// Unsigned answers are rejected too
+				client, err = newMockIPClient()
+				g.Expect(err).NotTo(HaveOccurred())
+				client.HMACKey = []byte("s3cret")
+				g.Expect(envy.AddObjectReturns("Do", &http.Response{
+					StatusCode: 200,
+					Body:       io.NopCloser(strings.NewReader("1.2.3.4")),
+				})).To(Succeed())
+				_, err = client.GetExternalIPAddress(ctx)
+				g.Expect(err).To(MatchError("answer of some_url is not signed with the expected key"))
+			},
+		},
 	}
 	for _, test := range tests {
 		test := test
@@ -0,0 +1,128 @@
+package ip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ParseSource splits an address source into its kind (SourceHTTP or
+// SourceInterface) and, for interfaces, the name of the interface. Empty
+// sources are SourceHTTP.
+func ParseSource(source string) (string, string, error) {
+	kind, name, _ := strings.Cut(source, ":")
+	switch {
+	case source == "" || source == SourceHTTP:
+		return SourceHTTP, "", nil
+	case kind == SourceInterface && (name != "" || source == SourceInterface):
+		return SourceInterface, name, nil
+	default:
+		return "", "", fmt.Errorf("invalid IP source %q, expected %v, %v or %v:<name>", source, SourceHTTP, SourceInterface, SourceInterface)
+	}
+}
+
+// LocalClient reads the addresses of a network interface of the host
+type LocalClient struct {
+	// Interface names the interface. Empty selects the interface routing
+	// to the internet.
+	Interface string
+	// InterfaceAddrs returns the addresses of the named interface
+	InterfaceAddrs func(name string) ([]net.Addr, error)
+	// Dial connects to the address. The connection is only used to learn
+	// the local address of the route, as no packet is sent over UDP.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// LocalLoadOption allows for modifying the local client after it's created
+type LocalLoadOption func(client *LocalClient) error
+
+// NewLocalClient returns a new client of the named interface's address
+func NewLocalClient(name string, opts ...LocalLoadOption) (LocalClient, error) {
+	dialer := &net.Dialer{}
+	client := LocalClient{
+		Interface: name,
+		InterfaceAddrs: func(name string) ([]net.Addr, error) {
+			iface, err := net.InterfaceByName(name)
+			if err != nil {
+				return nil, err
+			}
+			return iface.Addrs()
+		},
+		Dial: dialer.DialContext,
+	}
+	for _, opt := range opts {
+		if err := opt(&client); err != nil {
+			return LocalClient{}, err
+		}
+	}
+	return client, nil
+}
+
+// GetLocalIPAddress returns the IPv4 address of the interface
+func (c *LocalClient) GetLocalIPAddress(ctx context.Context) (string, error) {
+	return c.localAddress(ctx, false)
+}
+
+// GetLocalIPv6Address returns the global IPv6 address of the interface,
+// e.g., to learn the prefix delegated to the network
+func (c *LocalClient) GetLocalIPv6Address(ctx context.Context) (string, error) {
+	return c.localAddress(ctx, true)
+}
+
+// localAddress returns the IPv4 or global IPv6 address of the interface
+func (c *LocalClient) localAddress(ctx context.Context, ipv6 bool) (string, error) {
+	if c.Interface == "" {
+		return c.outboundAddress(ctx, ipv6)
+	}
+
+	addrs, err := c.InterfaceAddrs(c.Interface)
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil {
+			continue
+		}
+		address := prefix.Addr()
+		if ipv6 && isGlobalIPv6(address) {
+			return address.String(), nil
+		}
+		if !ipv6 && address.Is4() && !address.IsLoopback() && !address.IsLinkLocalUnicast() {
+			return address.String(), nil
+		}
+	}
+	if ipv6 {
+		return "", fmt.Errorf("interface %v has no global IPv6 address", c.Interface)
+	}
+	return "", fmt.Errorf("interface %v has no IPv4 address", c.Interface)
+}
+
+// outboundAddress returns the local address of the route to the internet
+func (c *LocalClient) outboundAddress(ctx context.Context, ipv6 bool) (string, error) {
+	network, target := "udp4", "1.1.1.1:53"
+	if ipv6 {
+		network, target = "udp6", "[2606:4700:4700::1111]:53"
+	}
+	conn, err := c.Dial(ctx, network, target)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	addrPort, err := netip.ParseAddrPort(conn.LocalAddr().String())
+	if err != nil {
+		return "", err
+	}
+	return addrPort.Addr().Unmap().String(), nil
+}
+
+// isGlobalIPv6 reports whether address is a global unicast IPv6 address,
+// leaving out unique local addresses
+func isGlobalIPv6(address netip.Addr) bool {
+	return address.Is6() && !address.Is4In6() && address.IsGlobalUnicast() && !address.IsPrivate()
+}
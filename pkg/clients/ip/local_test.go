@@ -0,0 +1,170 @@
+package ip_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	. "github.com/onsi/gomega"
+)
+
+// withAddrs replaces the addresses of every interface
+func withAddrs(addrs ...string) ip.LocalLoadOption {
+	return func(client *ip.LocalClient) error {
+		client.InterfaceAddrs = func(name string) ([]net.Addr, error) {
+			results := []net.Addr{}
+			for _, addr := range addrs {
+				_, network, err := net.ParseCIDR(addr)
+				if err != nil {
+					return nil, err
+				}
+				ipAddress, _, _ := net.ParseCIDR(addr)
+				network.IP = ipAddress
+				results = append(results, network)
+			}
+			return results, nil
+		}
+		return nil
+	}
+}
+
+func TestLocal(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "parses sources",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for source, expected := range map[string][]string{
+					"":               {ip.SourceHTTP, ""},
+					"http":           {ip.SourceHTTP, ""},
+					"interface":      {ip.SourceInterface, ""},
+					"interface:eth0": {ip.SourceInterface, "eth0"},
+				} {
+					kind, name, err := ip.ParseSource(source)
+					g.Expect(err).NotTo(HaveOccurred(), source)
+					g.Expect([]string{kind, name}).To(Equal(expected), source)
+				}
+
+				for _, source := range []string{"nope", "interface:", "http:foo"} {
+					_, _, err := ip.ParseSource(source)
+					g.Expect(err).To(MatchError(`invalid IP source "`+source+`", expected http, interface or interface:<name>`), source)
+				}
+			},
+		},
+		{
+			testCase: "returns the address of the named interface",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				ctx := context.Background()
+
+				client, err := ip.NewLocalClient("eth0", withAddrs("fe80::1/64", "169.254.1.1/16", "127.0.0.1/8", "192.168.1.20/24"))
+				g.Expect(err).NotTo(HaveOccurred())
+				address, err := client.GetLocalIPAddress(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(address).To(Equal("192.168.1.20"))
+
+				client, err = ip.NewLocalClient("eth1", withAddrs("2001:db8::1/64"))
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.GetLocalIPAddress(ctx)
+				g.Expect(err).To(MatchError("interface eth1 has no IPv4 address"))
+
+				address, err = client.GetLocalIPv6Address(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(address).To(Equal("2001:db8::1"))
+
+				// Link-local and unique local addresses don't carry the delegated prefix
+				client, err = ip.NewLocalClient("eth1", withAddrs("fe80::1/64", "fd00::1/64", "192.168.1.20/24"))
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.GetLocalIPv6Address(ctx)
+				g.Expect(err).To(MatchError("interface eth1 has no global IPv6 address"))
+
+				// Addresses that aren't prefixes are skipped
+				client.InterfaceAddrs = func(name string) ([]net.Addr, error) {
+					return []net.Addr{&net.IPAddr{IP: net.ParseIP("10.0.0.1")}}, nil
+				}
+				_, err = client.GetLocalIPAddress(ctx)
+				g.Expect(err).To(MatchError("interface eth1 has no IPv4 address"))
+
+				client, err = ip.NewLocalClient("qrkdns-missing0")
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.GetLocalIPAddress(ctx)
+				g.Expect(err).To(HaveOccurred())
+
+				// Real interfaces are read too, whatever their addresses
+				interfaces, err := net.Interfaces()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(interfaces).NotTo(BeEmpty())
+				client, err = ip.NewLocalClient(interfaces[0].Name)
+				g.Expect(err).NotTo(HaveOccurred())
+				_, _ = client.GetLocalIPAddress(ctx)
+			},
+		},
+		{
+			testCase: "returns the address of the route to the internet",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				ctx := context.Background()
+
+				listener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+				g.Expect(err).NotTo(HaveOccurred())
+				defer listener.Close()
+
+				client, err := ip.NewLocalClient("", func(client *ip.LocalClient) error {
+					client.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+						return net.Dial(network, listener.LocalAddr().String())
+					}
+					return nil
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+				address, err := client.GetLocalIPAddress(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(address).To(Equal("127.0.0.1"))
+
+				networks := []string{}
+				client.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+					networks = append(networks, network+" "+address)
+					return net.Dial("udp4", listener.LocalAddr().String())
+				}
+				_, err = client.GetLocalIPv6Address(ctx)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(networks).To(Equal([]string{"udp6 [2606:4700:4700::1111]:53"}))
+
+				client.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+					return nil, errors.New("network is unreachable")
+				}
+				_, err = client.GetLocalIPAddress(ctx)
+				g.Expect(err).To(MatchError("network is unreachable"))
+
+				client.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+					conn, _ := net.Pipe()
+					return conn, nil
+				}
+				_, err = client.GetLocalIPAddress(ctx)
+				g.Expect(err).To(HaveOccurred())
+
+				// The real dialer needs no network to pick a route
+				client, err = ip.NewLocalClient("")
+				g.Expect(err).NotTo(HaveOccurred())
+				_, _ = client.GetLocalIPAddress(ctx)
+			},
+		},
+		{
+			testCase: "returns error for bad load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, err := ip.NewLocalClient("eth0", func(client *ip.LocalClient) error {
+					return errors.New("foo")
+				})
+				g.Expect(err).To(MatchError("foo"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,38 @@
+package ip
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Prefix returns the prefix of the given length holding address, a global
+// IPv6 address within the prefix delegated to the network
+func Prefix(address string, bits int) (netip.Prefix, error) {
+	parsed, err := netip.ParseAddr(address)
+	if err != nil || !isGlobalIPv6(parsed) {
+		return netip.Prefix{}, fmt.Errorf("%q is not a global IPv6 address", address)
+	}
+	return parsed.Prefix(bits)
+}
+
+// ParseSuffix parses the static suffix of a host's address, such as ::10,
+// making sure it fits in the bits left by a prefix of the given length
+func ParseSuffix(suffix string, bits int) (netip.Addr, error) {
+	parsed, err := netip.ParseAddr(suffix)
+	if err != nil || !parsed.Is6() || parsed.Is4In6() || parsed.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("invalid interface identifier %q, expected an IPv6 suffix such as ::10", suffix)
+	}
+	if overlap, _ := parsed.Prefix(bits); overlap.Addr().IsValid() && !overlap.Addr().IsUnspecified() {
+		return netip.Addr{}, fmt.Errorf("interface identifier %v doesn't fit in the host part of a /%v prefix", suffix, bits)
+	}
+	return parsed, nil
+}
+
+// Combine returns the address made of the prefix and the suffix
+func Combine(prefix netip.Prefix, suffix netip.Addr) netip.Addr {
+	network, host := prefix.Masked().Addr().As16(), suffix.As16()
+	for i := range network {
+		network[i] |= host[i]
+	}
+	return netip.AddrFrom16(network)
+}
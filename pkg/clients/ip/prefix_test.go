@@ -0,0 +1,57 @@
+package ip_test
+
+import (
+	"testing"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	. "github.com/onsi/gomega"
+)
+
+func TestPrefix(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "combines the delegated prefix with host suffixes",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				prefix, err := ip.Prefix("2001:db8:1:2:a:b:c:d", 64)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(prefix.String()).To(Equal("2001:db8:1:2::/64"))
+
+				suffix, err := ip.ParseSuffix("::10", 64)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(ip.Combine(prefix, suffix).String()).To(Equal("2001:db8:1:2::10"))
+
+				// Suffixes can pick a subnet of a shorter delegated prefix
+				prefix, err = ip.Prefix("2001:db8:1:2:a:b:c:d", 56)
+				g.Expect(err).NotTo(HaveOccurred())
+				suffix, err = ip.ParseSuffix("0:0:0:5:211:22ff:fe33:4455", 56)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(ip.Combine(prefix, suffix).String()).To(Equal("2001:db8:1:5:211:22ff:fe33:4455"))
+			},
+		},
+		{
+			testCase: "returns error for invalid addresses and suffixes",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for _, address := range []string{"nope", "1.2.3.4", "fe80::1", "fd00::1", "::1", "::ffff:1.2.3.4"} {
+					_, err := ip.Prefix(address, 64)
+					g.Expect(err).To(MatchError(`"`+address+`" is not a global IPv6 address`), address)
+				}
+
+				for _, suffix := range []string{"nope", "1.2.3.4", "::ffff:1.2.3.4", "fe80::1%eth0"} {
+					_, err := ip.ParseSuffix(suffix, 64)
+					g.Expect(err).To(MatchError(`invalid interface identifier "`+suffix+`", expected an IPv6 suffix such as ::10`), suffix)
+				}
+				_, err := ip.ParseSuffix("0:0:0:1::10", 64)
+				g.Expect(err).To(MatchError("interface identifier 0:0:0:1::10 doesn't fit in the host part of a /64 prefix"))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,23 @@
+package mmdb
+
+import (
+	"net"
+)
+
+// Metadata describes a MaxMind DB file, as read from its metadata section
+type Metadata struct {
+	DatabaseType string
+	IPVersion    uint64
+	RecordSize   uint64
+	NodeCount    uint64
+	BuildEpoch   uint64
+}
+
+// Reader looks up the data stored for an address in a MaxMind DB file.
+// Values are decoded to maps, slices, strings, []byte, bool, float32,
+// float64, int, uint64 or *big.Int.
+type Reader interface {
+	// Lookup returns the data of the network containing ip, or false if
+	// the address isn't in the database
+	Lookup(ip net.IP) (interface{}, bool, error)
+}
@@ -0,0 +1,77 @@
+package mmdb
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var (
+	// Assert client matches the correct interface
+	_ Reader = &DefaultClient{}
+)
+
+// DefaultClient reads a MaxMind DB file (as published by MaxMind and IPinfo)
+// held in memory
+type DefaultClient struct {
+	Metadata Metadata
+
+	reader *maxminddb.Reader
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient reads and validates the database at path
+func NewClient(path string, opts ...LoadOption) (*DefaultClient, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := maxminddb.FromBytes(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+
+	client := &DefaultClient{
+		Metadata: Metadata{
+			DatabaseType: reader.Metadata.DatabaseType,
+			IPVersion:    uint64(reader.Metadata.IPVersion),
+			RecordSize:   uint64(reader.Metadata.RecordSize),
+			NodeCount:    uint64(reader.Metadata.NodeCount),
+			BuildEpoch:   uint64(reader.Metadata.BuildEpoch),
+		},
+		reader: reader,
+	}
+
+	for _, opt := range opts {
+		if err := opt(client); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// Lookup returns the data of the network containing ip
+func (c *DefaultClient) Lookup(ip net.IP) (interface{}, bool, error) {
+	if ip.To16() == nil {
+		return nil, false, fmt.Errorf("invalid IP address %v", ip)
+	}
+
+	offset, err := c.reader.LookupOffset(ip)
+	if err != nil {
+		return nil, false, err
+	}
+	if offset == maxminddb.NotFound {
+		return nil, false, nil
+	}
+
+	var value interface{}
+	if err := c.reader.Decode(offset, &value); err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
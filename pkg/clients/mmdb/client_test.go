@@ -0,0 +1,146 @@
+package mmdb_test
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/mmdb"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+func TestClient(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "looks up addresses in an ipv6 database",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := mmdb.NewClient(mocks.MMDBFixture(mocks.MMDBASN))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Metadata.DatabaseType).To(Equal("GeoLite2-ASN"))
+				g.Expect(client.Metadata.IPVersion).To(BeEquivalentTo(6))
+				g.Expect(client.Metadata.RecordSize).To(BeEquivalentTo(24))
+				g.Expect(client.Metadata.NodeCount).NotTo(BeZero())
+				g.Expect(client.Metadata.BuildEpoch).To(BeEquivalentTo(1630627200))
+
+				value, found, err := client.Lookup(net.ParseIP("1.2.3.4"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(found).To(BeTrue())
+				g.Expect(value).To(Equal(map[string]interface{}{
+					"autonomous_system_number":       uint64(3320),
+					"autonomous_system_organization": "Deutsche Telekom AG",
+				}))
+
+				value, found, err = client.Lookup(net.ParseIP("2a00:1450::1"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(found).To(BeTrue())
+				g.Expect(value).To(Equal(map[string]interface{}{
+					"autonomous_system_number":       uint64(15169),
+					"autonomous_system_organization": "Google LLC",
+				}))
+
+				_, found, err = client.Lookup(net.ParseIP("1.2.4.1"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(found).To(BeFalse())
+			},
+		},
+		{
+			testCase: "looks up addresses in an ipv4 database",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := mmdb.NewClient(mocks.MMDBFixture(mocks.MMDBIPinfo))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Metadata.DatabaseType).To(Equal("ipinfo country_asn.mmdb"))
+				g.Expect(client.Metadata.IPVersion).To(BeEquivalentTo(4))
+
+				value, found, err := client.Lookup(net.ParseIP("5.6.7.8"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(found).To(BeTrue())
+				g.Expect(value).To(Equal(map[string]interface{}{
+					"asn":          "AS3320",
+					"name":         "Deutsche Telekom AG",
+					"country_code": "NL",
+				}))
+			},
+		},
+		{
+			testCase: "returns errors for invalid addresses",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := mmdb.NewClient(mocks.MMDBFixture(mocks.MMDBIPinfo))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				_, _, err = client.Lookup(net.ParseIP("2001:db8::1"))
+				g.Expect(err).To(MatchError(ContainSubstring("IPv6 address in an IPv4-only database")))
+
+				_, _, err = client.Lookup(net.IP{1, 2, 3})
+				g.Expect(err).To(MatchError(ContainSubstring("invalid IP address")))
+			},
+		},
+		{
+			testCase: "returns errors for invalid data",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				content, err := os.ReadFile(mocks.MMDBFixture(mocks.MMDBIPinfo))
+				g.Expect(err).NotTo(HaveOccurred())
+				client, err := mmdb.NewClient(mocks.MMDBFixture(mocks.MMDBIPinfo))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				// Wipe the data section, between the search tree and the metadata
+				start := int(client.Metadata.NodeCount*client.Metadata.RecordSize/4) + 16
+				end := bytes.LastIndex(content, []byte("\xab\xcd\xefMaxMind.com"))
+				for i := start; i < end; i++ {
+					content[i] = 0x1f
+				}
+				path := filepath.Join(tt.TempDir(), "invalid.mmdb")
+				g.Expect(os.WriteFile(path, content, 0o600)).To(Succeed())
+
+				client, err = mmdb.NewClient(path)
+				g.Expect(err).NotTo(HaveOccurred())
+				_, _, err = client.Lookup(net.ParseIP("1.2.3.4"))
+				g.Expect(err).To(MatchError(ContainSubstring("unexpected end of database")))
+			},
+		},
+		{
+			testCase: "returns errors for invalid files",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				dir := tt.TempDir()
+				_, err := mmdb.NewClient(filepath.Join(dir, "missing.mmdb"))
+				g.Expect(os.IsNotExist(err)).To(BeTrue())
+
+				valid, err := os.ReadFile(mocks.MMDBFixture(mocks.MMDBASN))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				for _, content := range [][]byte{{}, []byte("not a database"), valid[:len(valid)/2]} {
+					path := filepath.Join(dir, "invalid.mmdb")
+					g.Expect(os.WriteFile(path, content, 0o600)).To(Succeed())
+					_, err := mmdb.NewClient(path)
+					g.Expect(err).To(MatchError(ContainSubstring(path + ": ")))
+				}
+
+				_, err = mmdb.NewClient(mocks.MMDBFixture(mocks.MMDBASN), func(client *mmdb.DefaultClient) error {
+					return os.ErrPermission
+				})
+				g.Expect(err).To(MatchError(os.ErrPermission))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// EventType labels the sync outcomes that can trigger a notification
+type EventType string
+
+const (
+	// EventTypeIPChanged is sent when a published record moves to a new address
+	EventTypeIPChanged EventType = "ip_changed"
+
+	// EventTypeRecordCreated is sent when a record is published for the first time
+	EventTypeRecordCreated EventType = "record_created"
+
+	// EventTypeRecordDeleted is sent when an extra record is removed
+	EventTypeRecordDeleted EventType = "record_deleted"
+
+	// EventTypeSyncFailed is sent when syncs fail repeatedly
+	EventTypeSyncFailed EventType = "sync_failed"
+
+	// EventTypeIPRejected is sent when the publication guard refuses an address
+	EventTypeIPRejected EventType = "ip_rejected"
+)
+
+// Event describes a single sync outcome worth notifying about
+type Event struct {
+	Type     EventType `json:"type"`
+	Name     string    `json:"name"`
+	Provider string    `json:"provider"`
+	OldIP    string    `json:"old_ip,omitempty"`
+	NewIP    string    `json:"new_ip,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Failures int       `json:"failures,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// State is persisted between runs, so that one-shot syncs started by cron
+// share the failure count, deduplication and rate limit
+type State struct {
+	// Failures counts the consecutive failed syncs
+	Failures int `json:"failures,omitempty"`
+	// LastSeen holds when each event, by key, was last sent
+	LastSeen map[string]time.Time `json:"last_seen,omitempty"`
+	// Sent holds the times of the events sent within the rate window
+	Sent []time.Time `json:"sent,omitempty"`
+}
+
+// Notifier abstracts a backend capable of delivering events
+type Notifier interface {
+	// Notify delivers a single event
+	Notify(ctx context.Context, event Event) error
+}
+
+// Key identifies events that are considered duplicates of each other
+func (e *Event) Key() string {
+	return fmt.Sprintf("%v|%v|%v|%v|%v", e.Type, e.Name, e.OldIP, e.NewIP, e.Error)
+}
+
+// Summary returns a short human-readable description of the event
+func (e *Event) Summary() string {
+	switch e.Type {
+	case EventTypeIPChanged:
+		return fmt.Sprintf("qrkdns: %v changed from %v to %v", e.Name, e.OldIP, e.NewIP)
+	case EventTypeRecordCreated:
+		return fmt.Sprintf("qrkdns: %v created with %v", e.Name, e.NewIP)
+	case EventTypeRecordDeleted:
+		return fmt.Sprintf("qrkdns: %v record %v deleted", e.Name, e.OldIP)
+	case EventTypeSyncFailed:
+		return fmt.Sprintf("qrkdns: sync of %v failed %v times in a row: %v", e.Name, e.Failures, e.Error)
+	case EventTypeIPRejected:
+		return fmt.Sprintf("qrkdns: refused to publish %v for %v: %v", e.NewIP, e.Name, e.Error)
+	default:
+		return fmt.Sprintf("qrkdns: %v event for %v", e.Type, e.Name)
+	}
+}
@@ -0,0 +1,260 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// FileName is the name of the state file inside the state directory
+	FileName string = "notify.json"
+
+	// DefaultTimeout bounds the delivery of an event to each backend
+	DefaultTimeout time.Duration = 30 * time.Second
+)
+
+var (
+	_ Notifier = &DefaultClient{}
+)
+
+// DefaultClient fans events out to every configured backend, dropping
+// duplicates and enforcing a rate limit so that a flapping connection
+// does not flood the receivers
+type DefaultClient struct {
+	Notifiers []Notifier
+
+	// DedupeWindow suppresses identical events seen within the window
+	DedupeWindow time.Duration
+	// RateLimit is the maximum number of events sent within RateWindow.
+	// Zero disables rate limiting.
+	RateLimit  int
+	RateWindow time.Duration
+	// FailureThreshold is the number of consecutive failures required
+	// before a sync failure is reported
+	FailureThreshold int
+	// Timeout bounds the delivery of an event to each backend
+	Timeout time.Duration
+
+	// Path is the location of the state file. Empty keeps the state in memory.
+	Path string
+
+	// Now is used to read the current time
+	Now func() time.Time
+
+	mutex sync.Mutex
+	state State
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// WithDedupeWindow sets the window in which duplicate events are dropped
+func WithDedupeWindow(window time.Duration) LoadOption {
+	return func(client *DefaultClient) error {
+		client.DedupeWindow = window
+		return nil
+	}
+}
+
+// WithRateLimit sets the maximum number of events delivered per window
+func WithRateLimit(limit int, window time.Duration) LoadOption {
+	return func(client *DefaultClient) error {
+		client.RateLimit = limit
+		client.RateWindow = window
+		return nil
+	}
+}
+
+// WithFailureThreshold sets how many consecutive failures are tolerated
+// before a sync failure is reported
+func WithFailureThreshold(threshold int) LoadOption {
+	return func(client *DefaultClient) error {
+		client.FailureThreshold = threshold
+		return nil
+	}
+}
+
+// WithStateDir persists the failure count, deduplication and rate limit in
+// stateDir. An empty stateDir keeps them in memory.
+func WithStateDir(stateDir string) LoadOption {
+	return func(client *DefaultClient) error {
+		if stateDir != "" {
+			client.Path = filepath.Join(stateDir, FileName)
+		}
+		return nil
+	}
+}
+
+// NewClient returns a new notification dispatcher for the given backends
+func NewClient(notifiers []Notifier, opts ...LoadOption) (*DefaultClient, error) {
+	client := DefaultClient{
+		Notifiers:        notifiers,
+		FailureThreshold: 1,
+		Timeout:          DefaultTimeout,
+		Now:              time.Now,
+	}
+	for _, opt := range opts {
+		if err := opt(&client); err != nil {
+			return &DefaultClient{}, err
+		}
+	}
+	return &client, nil
+}
+
+// Notify delivers the event to every backend, unless it is filtered out
+// by the failure threshold, deduplication, or rate limiting
+func (c *DefaultClient) Notify(ctx context.Context, event Event) error {
+	if len(c.Notifiers) == 0 {
+		return nil
+	}
+
+	if event.Time.IsZero() {
+		event.Time = c.Now()
+	}
+	contextLog := log.WithField("event", event)
+
+	if event.Type == EventTypeSyncFailed && event.Failures < c.FailureThreshold {
+		contextLog.Debug("Failure threshold not reached, skipping notification")
+		return nil
+	}
+
+	allowed, err := c.allow(event)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		contextLog.Debug("Notification suppressed")
+		return nil
+	}
+
+	errs := []error{}
+	for _, notifier := range c.Notifiers {
+		deliveryCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+		err := notifier.Notify(deliveryCtx, event)
+		cancel()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Failures returns the number of consecutive failed syncs
+func (c *DefaultClient) Failures() (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state, err := c.load()
+	return state.Failures, err
+}
+
+// SetFailures records the number of consecutive failed syncs
+func (c *DefaultClient) SetFailures(failures int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state, err := c.load()
+	if err != nil {
+		return err
+	}
+	if state.Failures == failures {
+		return nil
+	}
+	state.Failures = failures
+	return c.save(state)
+}
+
+// allow records the event and reports whether it may be sent
+func (c *DefaultClient) allow(event Event) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	state, err := c.load()
+	if err != nil {
+		return false, err
+	}
+	now := event.Time
+	key := event.Key()
+
+	if last, ok := state.LastSeen[key]; ok && c.DedupeWindow > 0 && now.Sub(last) < c.DedupeWindow {
+		return false, nil
+	}
+
+	if c.RateLimit > 0 {
+		recent := []time.Time{}
+		for _, sentAt := range state.Sent {
+			if now.Sub(sentAt) < c.RateWindow {
+				recent = append(recent, sentAt)
+			}
+		}
+		state.Sent = recent
+		if len(state.Sent) >= c.RateLimit {
+			return false, c.save(state)
+		}
+	}
+
+	// Events past the dedupe window don't need to be remembered anymore
+	lastSeen := map[string]time.Time{key: now}
+	for seenKey, seenAt := range state.LastSeen {
+		if seenKey != key && now.Sub(seenAt) < c.DedupeWindow {
+			lastSeen[seenKey] = seenAt
+		}
+	}
+	state.LastSeen = lastSeen
+	if c.RateLimit > 0 {
+		state.Sent = append(state.Sent, now)
+	}
+	return true, c.save(state)
+}
+
+// load reads the state file. A missing file holds an empty state.
+func (c *DefaultClient) load() (State, error) {
+	if c.Path == "" {
+		return c.state, nil
+	}
+
+	data, err := os.ReadFile(c.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+
+	state := State{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return State{}, fmt.Errorf("%v: %w", c.Path, err)
+	}
+	return state, nil
+}
+
+// save replaces the state file
+func (c *DefaultClient) save(state State) error {
+	if c.Path == "" {
+		c.state = state
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a partial state
+	temporary := c.Path + ".tmp"
+	if err := os.WriteFile(temporary, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(temporary, c.Path)
+}
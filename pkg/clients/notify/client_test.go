@@ -0,0 +1,293 @@
+package notify_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+func newClockedClient(notifiers []notify.Notifier, now *time.Time, opts ...notify.LoadOption) (*notify.DefaultClient, error) {
+	client, err := notify.NewClient(notifiers, opts...)
+	if err != nil {
+		return client, err
+	}
+	client.Now = func() time.Time { return *now }
+	return client, nil
+}
+
+// blockingNotifier never delivers an event, waiting for its context instead
+type blockingNotifier struct{}
+
+func (n *blockingNotifier) Notify(ctx context.Context, event notify.Event) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestFile(t *testing.T) {
+	changed := notify.Event{Type: notify.EventTypeIPChanged, Name: "bar.foo.net", OldIP: "1.1.1.1", NewIP: "2.2.2.2"}
+
+	tests := []testRunner{
+		{
+			testCase: "delivers events to every backend",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				first, second := &mocks.MockNotifier{}, &mocks.MockNotifier{}
+
+				client, err := notify.NewClient([]notify.Notifier{first, second})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Notify(context.Background(), changed)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(first.Events).To(HaveLen(1))
+				g.Expect(second.Events).To(HaveLen(1))
+				g.Expect(first.Events[0].Time.IsZero()).To(BeFalse())
+			},
+		},
+		{
+			testCase: "does nothing without backends",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := notify.NewClient([]notify.Notifier{})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Notify(context.Background(), changed)).To(Succeed())
+			},
+		},
+		{
+			testCase: "drops duplicates within the dedupe window",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				backend := &mocks.MockNotifier{}
+				now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+				client, err := newClockedClient([]notify.Notifier{backend}, &now, notify.WithDedupeWindow(time.Hour))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				g.Expect(client.Notify(context.Background(), changed)).To(Succeed())
+				now = now.Add(time.Minute)
+				g.Expect(client.Notify(context.Background(), changed)).To(Succeed())
+				g.Expect(backend.Events).To(HaveLen(1))
+
+				now = now.Add(2 * time.Hour)
+				g.Expect(client.Notify(context.Background(), changed)).To(Succeed())
+				g.Expect(backend.Events).To(HaveLen(2))
+			},
+		},
+		{
+			testCase: "enforces the rate limit",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				backend := &mocks.MockNotifier{}
+				now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+				client, err := newClockedClient([]notify.Notifier{backend}, &now, notify.WithRateLimit(2, time.Hour))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				for i := 0; i < 5; i++ {
+					event := changed
+					event.NewIP = fmt.Sprintf("2.2.2.%v", i)
+					g.Expect(client.Notify(context.Background(), event)).To(Succeed())
+					now = now.Add(time.Minute)
+				}
+				g.Expect(backend.Events).To(HaveLen(2))
+
+				now = now.Add(time.Hour)
+				g.Expect(client.Notify(context.Background(), changed)).To(Succeed())
+				g.Expect(backend.Events).To(HaveLen(3))
+			},
+		},
+		{
+			testCase: "only reports failures past the threshold",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				backend := &mocks.MockNotifier{}
+
+				client, err := notify.NewClient([]notify.Notifier{backend}, notify.WithFailureThreshold(3))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				for failures := 1; failures <= 3; failures++ {
+					event := notify.Event{Type: notify.EventTypeSyncFailed, Name: "bar.foo.net", Error: "boom", Failures: failures}
+					g.Expect(client.Notify(context.Background(), event)).To(Succeed())
+				}
+				g.Expect(backend.Events).To(HaveLen(1))
+				g.Expect(backend.Events[0].Failures).To(Equal(3))
+			},
+		},
+		{
+			testCase: "returns backend errors",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := notify.NewClient([]notify.Notifier{&mocks.MockNotifier{}})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = envy.AddErrorReturns("Notify", fmt.Errorf("unreachable"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Notify(context.Background(), changed)
+				g.Expect(err).To(MatchError("unreachable"))
+			},
+		},
+		{
+			testCase: "persists its state in the state directory",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				backend := &mocks.MockNotifier{}
+				now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+				stateDir := tt.TempDir()
+
+				// Every run builds a new client, like one-shot syncs started by cron
+				run := func() *notify.DefaultClient {
+					client, err := newClockedClient(
+						[]notify.Notifier{backend},
+						&now,
+						notify.WithStateDir(stateDir),
+						notify.WithDedupeWindow(time.Hour),
+						notify.WithRateLimit(2, time.Hour),
+					)
+					g.Expect(err).NotTo(HaveOccurred())
+					return client
+				}
+
+				g.Expect(run().Notify(context.Background(), changed)).To(Succeed())
+				now = now.Add(time.Minute)
+				g.Expect(run().Notify(context.Background(), changed)).To(Succeed())
+				g.Expect(backend.Events).To(HaveLen(1))
+
+				for i := 0; i < 3; i++ {
+					event := changed
+					event.NewIP = fmt.Sprintf("2.2.2.%v", i)
+					g.Expect(run().Notify(context.Background(), event)).To(Succeed())
+				}
+				g.Expect(backend.Events).To(HaveLen(2))
+				g.Expect(filepath.Join(stateDir, notify.FileName)).To(BeAnExistingFile())
+
+				failures, err := run().Failures()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(failures).To(BeZero())
+				g.Expect(run().SetFailures(2)).To(Succeed())
+				g.Expect(run().SetFailures(2)).To(Succeed())
+				failures, err = run().Failures()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(failures).To(Equal(2))
+
+				// Without a state directory, the state lives as long as the client
+				client, err := notify.NewClient([]notify.Notifier{backend}, notify.WithStateDir(""))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Path).To(BeEmpty())
+				g.Expect(client.SetFailures(1)).To(Succeed())
+				failures, err = client.Failures()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(failures).To(Equal(1))
+			},
+		},
+		{
+			testCase: "returns errors reading and writing the state",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				backend := &mocks.MockNotifier{}
+
+				stateDir := tt.TempDir()
+				path := filepath.Join(stateDir, notify.FileName)
+				g.Expect(os.WriteFile(path, []byte("{"), 0o600)).To(Succeed())
+				client, err := notify.NewClient([]notify.Notifier{backend}, notify.WithStateDir(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Notify(context.Background(), changed)).To(MatchError(ContainSubstring(path)))
+				g.Expect(client.SetFailures(1)).To(MatchError(ContainSubstring(path)))
+				_, err = client.Failures()
+				g.Expect(err).To(MatchError(ContainSubstring(path)))
+
+				// The state directory is a file
+				g.Expect(os.Remove(path)).To(Succeed())
+				g.Expect(os.WriteFile(path, []byte{}, 0o600)).To(Succeed())
+				client, err = notify.NewClient([]notify.Notifier{backend}, notify.WithStateDir(path))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.SetFailures(1)).To(HaveOccurred())
+				_, err = client.Failures()
+				g.Expect(err).To(HaveOccurred())
+
+				// The state file can't be replaced
+				g.Expect(os.Remove(path)).To(Succeed())
+				g.Expect(os.Mkdir(path+".tmp", 0o700)).To(Succeed())
+				client, err = notify.NewClient([]notify.Notifier{backend}, notify.WithStateDir(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.SetFailures(1)).To(HaveOccurred())
+
+				// The state directory is a dangling link
+				link := filepath.Join(tt.TempDir(), "state")
+				g.Expect(os.Symlink(filepath.Join(stateDir, "missing"), link)).To(Succeed())
+				client, err = notify.NewClient([]notify.Notifier{backend}, notify.WithStateDir(link))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.SetFailures(1)).To(HaveOccurred())
+
+				// Times past year 9999 have no JSON encoding
+				now := time.Date(10000, time.January, 1, 0, 0, 0, 0, time.UTC)
+				client, err = newClockedClient([]notify.Notifier{backend}, &now, notify.WithStateDir(tt.TempDir()))
+				g.Expect(err).NotTo(HaveOccurred())
+				err = client.Notify(context.Background(), changed)
+				g.Expect(err).To(MatchError(ContainSubstring("year outside of range [0,9999]")))
+				g.Expect(backend.Events).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "bounds the delivery to each backend",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				backend := &blockingNotifier{}
+				client, err := notify.NewClient([]notify.Notifier{backend})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Timeout).To(Equal(notify.DefaultTimeout))
+
+				client.Timeout = 10 * time.Millisecond
+				err = client.Notify(context.Background(), changed)
+				g.Expect(err).To(MatchError(context.DeadlineExceeded))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := notify.NewClient(
+					[]notify.Notifier{},
+					func(client *notify.DefaultClient) error {
+						return fmt.Errorf("oh no")
+					},
+				)
+				g.Expect(err).To(MatchError("oh no"))
+			},
+		},
+		{
+			testCase: "summarizes every event type",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				events := map[notify.EventType]string{
+					notify.EventTypeIPChanged:     "qrkdns: bar.foo.net changed from 1.1.1.1 to 2.2.2.2",
+					notify.EventTypeRecordCreated: "qrkdns: bar.foo.net created with 2.2.2.2",
+					notify.EventTypeRecordDeleted: "qrkdns: bar.foo.net record 1.1.1.1 deleted",
+					notify.EventTypeSyncFailed:    "qrkdns: sync of bar.foo.net failed 2 times in a row: boom",
+					notify.EventTypeIPRejected:    "qrkdns: refused to publish 2.2.2.2 for bar.foo.net: boom",
+					notify.EventType("other"):     "qrkdns: other event for bar.foo.net",
+				}
+				for eventType, expected := range events {
+					event := notify.Event{Type: eventType, Name: "bar.foo.net", OldIP: "1.1.1.1", NewIP: "2.2.2.2", Error: "boom", Failures: 2}
+					g.Expect(event.Summary()).To(Equal(expected))
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
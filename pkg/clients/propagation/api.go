@@ -0,0 +1,38 @@
+package propagation
+
+// ServerStatus is the last answer a nameserver gave for the record
+type ServerStatus struct {
+	Server   string   `json:"server"`
+	Answers  []string `json:"answers"`
+	InSync   bool     `json:"in_sync"`
+	Attempts int      `json:"attempts"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Result reports whether every nameserver serves the expected content
+type Result struct {
+	Name     string         `json:"name"`
+	Expected string         `json:"expected"`
+	Servers  []ServerStatus `json:"servers"`
+}
+
+// Complete returns true if every nameserver answered with the expected content
+func (r Result) Complete() bool {
+	for _, server := range r.Servers {
+		if !server.InSync {
+			return false
+		}
+	}
+	return true
+}
+
+// Pending returns the servers that are not yet serving the expected content
+func (r Result) Pending() []string {
+	pending := []string{}
+	for _, server := range r.Servers {
+		if !server.InSync {
+			pending = append(pending, server.Server)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,121 @@
+package propagation
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/resolver"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPort is the port authoritative nameservers are queried on
+	DefaultPort string = "53"
+)
+
+// DefaultClient verifies that nameservers serve a record
+type DefaultClient struct {
+	// Resolver is used to discover the authoritative nameservers of a zone
+	Resolver resolver.DefaultClient
+	// Interval is the time between two rounds of queries
+	Interval time.Duration
+	// Port is the port authoritative nameservers are queried on
+	Port string
+	// ResolverOptions are applied to the resolver built for every nameserver
+	ResolverOptions []resolver.LoadOption
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient returns a new client, discovering nameservers through discovery
+func NewClient(discovery resolver.DefaultClient, interval time.Duration, opts ...LoadOption) (DefaultClient, error) {
+	client := DefaultClient{
+		Resolver: discovery,
+		Interval: interval,
+		Port:     DefaultPort,
+	}
+	for _, opt := range opts {
+		if err := opt(&client); err != nil {
+			return DefaultClient{}, err
+		}
+	}
+	return client, nil
+}
+
+// Nameservers returns the host:port of every authoritative nameserver of zone
+func (c *DefaultClient) Nameservers(ctx context.Context, zone string) ([]string, error) {
+	hosts, err := c.Resolver.LookupNS(ctx, zone)
+	if err != nil {
+		return []string{}, err
+	}
+	if len(hosts) == 0 {
+		return []string{}, fmt.Errorf("no nameservers found for zone %v", zone)
+	}
+
+	servers := []string{}
+	for _, host := range hosts {
+		addresses, err := c.Resolver.LookupA(ctx, host)
+		if err != nil {
+			return []string{}, err
+		}
+		if len(addresses) == 0 {
+			return []string{}, fmt.Errorf("no address found for nameserver %v", host)
+		}
+		servers = append(servers, net.JoinHostPort(addresses[0], c.Port))
+	}
+	return servers, nil
+}
+
+// Verify queries every server for name until they all answer with expected,
+// or until ctx is done. Servers already serving expected aren't queried again.
+func (c *DefaultClient) Verify(ctx context.Context, name, expected string, servers []string) (Result, error) {
+	result := Result{Name: name, Expected: expected}
+	clients := []resolver.DefaultClient{}
+	for _, server := range servers {
+		client, err := resolver.NewClient(server, c.ResolverOptions...)
+		if err != nil {
+			return Result{}, err
+		}
+		clients = append(clients, client)
+		result.Servers = append(result.Servers, ServerStatus{Server: server, Answers: []string{}})
+	}
+
+	// Query the absolute name so resolvers don't try search domains
+	query := name
+	if query != "" && query[len(query)-1] != '.' {
+		query += "."
+	}
+
+	for {
+		for i := range result.Servers {
+			status := &result.Servers[i]
+			if status.InSync {
+				continue
+			}
+
+			status.Attempts++
+			answers, err := clients[i].LookupA(ctx, query)
+			if err != nil {
+				status.Error = err.Error()
+				continue
+			}
+			status.Error = ""
+			status.Answers = answers
+			status.InSync = len(answers) == 1 && answers[0] == expected
+		}
+
+		if result.Complete() {
+			return result, nil
+		}
+		log.WithField("pending", result.Pending()).Debug("Waiting for record to propagate")
+
+		select {
+		case <-ctx.Done():
+			return result, fmt.Errorf("record %v not served by %v before deadline", name, result.Pending())
+		case <-time.After(c.Interval):
+		}
+	}
+}
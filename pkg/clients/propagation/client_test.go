@@ -0,0 +1,199 @@
+package propagation_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/propagation"
+	"github.com/markliederbach/qrkdns/pkg/clients/resolver"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+func withMockResolver(client *resolver.DefaultClient) error {
+	client.Client = &mocks.MockResolver{}
+	return nil
+}
+
+func newDNSServer(g *WithT, records map[string]string) *mocks.MockDNSServer {
+	server, err := mocks.NewMockDNSServer()
+	g.Expect(err).NotTo(HaveOccurred())
+	for name, address := range records {
+		server.SetA(name, address)
+	}
+	return server
+}
+
+func TestFile(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "discovers authoritative nameservers",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				server := newDNSServer(g, map[string]string{"ns1.foo.net": "127.0.0.1", "ns2.foo.net": "127.0.0.2"})
+				defer server.Close()
+				server.SetNS("foo.net", "ns1.foo.net.", "ns2.foo.net.")
+
+				discovery, err := resolver.NewClient(server.Address)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				client, err := propagation.NewClient(discovery, time.Millisecond, func(client *propagation.DefaultClient) error {
+					client.Port = "5353"
+					return nil
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				servers, err := client.Nameservers(ctx, "foo.net.")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(servers).To(Equal([]string{"127.0.0.1:5353", "127.0.0.2:5353"}))
+			},
+		},
+		{
+			testCase: "returns errors discovering nameservers",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				discovery, err := resolver.NewClient("1.1.1.1:53", withMockResolver)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				client, err := propagation.NewClient(discovery, time.Millisecond)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				ctx := context.Background()
+
+				g.Expect(envy.AddErrorReturns("LookupNS", fmt.Errorf("servfail"))).To(Succeed())
+				_, err = client.Nameservers(ctx, "foo.net")
+				g.Expect(err).To(MatchError("servfail"))
+
+				g.Expect(envy.AddObjectReturns("LookupNS", []*net.NS{})).To(Succeed())
+				_, err = client.Nameservers(ctx, "foo.net")
+				g.Expect(err).To(MatchError("no nameservers found for zone foo.net"))
+
+				g.Expect(envy.AddErrorReturns("LookupIP", fmt.Errorf("servfail"))).To(Succeed())
+				_, err = client.Nameservers(ctx, "foo.net")
+				g.Expect(err).To(MatchError("servfail"))
+
+				g.Expect(envy.AddObjectReturns("LookupIP", []net.IP{})).To(Succeed())
+				_, err = client.Nameservers(ctx, "foo.net")
+				g.Expect(err).To(MatchError("no address found for nameserver ns1.foo.net"))
+			},
+		},
+		{
+			testCase: "waits until every nameserver serves the record",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				fresh := newDNSServer(g, map[string]string{"bar.foo.net": "1.2.3.4"})
+				defer fresh.Close()
+				stale := newDNSServer(g, map[string]string{"bar.foo.net": "5.6.7.8"})
+				defer stale.Close()
+
+				client, err := propagation.NewClient(resolver.DefaultClient{}, 10*time.Millisecond)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					stale.SetA("bar.foo.net", "1.2.3.4")
+				}()
+
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				result, err := client.Verify(ctx, "bar.foo.net", "1.2.3.4", []string{fresh.Address, stale.Address})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(result.Complete()).To(BeTrue())
+				g.Expect(result.Servers[0].Attempts).To(Equal(1))
+				g.Expect(result.Servers[1].Attempts).To(BeNumerically(">", 1))
+				g.Expect(result.Servers[1].Answers).To(Equal([]string{"1.2.3.4"}))
+			},
+		},
+		{
+			testCase: "reports nameservers still serving old content at the deadline",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				fresh := newDNSServer(g, map[string]string{"bar.foo.net": "1.2.3.4"})
+				defer fresh.Close()
+				stale := newDNSServer(g, map[string]string{"bar.foo.net": "5.6.7.8"})
+				defer stale.Close()
+
+				client, err := propagation.NewClient(resolver.DefaultClient{}, 10*time.Millisecond)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+
+				result, err := client.Verify(ctx, "bar.foo.net.", "1.2.3.4", []string{fresh.Address, stale.Address})
+				g.Expect(err).To(MatchError(fmt.Sprintf("record bar.foo.net. not served by [%v] before deadline", stale.Address)))
+				g.Expect(result.Complete()).To(BeFalse())
+				g.Expect(result.Pending()).To(Equal([]string{stale.Address}))
+				g.Expect(result.Servers[1].Answers).To(Equal([]string{"5.6.7.8"}))
+			},
+		},
+		{
+			testCase: "records query errors per nameserver",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := propagation.NewClient(resolver.DefaultClient{}, time.Millisecond, func(client *propagation.DefaultClient) error {
+					client.ResolverOptions = []resolver.LoadOption{withMockResolver}
+					return nil
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				g.Expect(envy.AddErrorReturns("LookupIP", fmt.Errorf("servfail"))).To(Succeed())
+
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				result, err := client.Verify(ctx, "bar.foo.net", "1.2.3.4", []string{"ns1:53"})
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(result.Servers[0].Error).To(Equal("servfail"))
+			},
+		},
+		{
+			testCase: "returns error from resolver options",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := propagation.NewClient(resolver.DefaultClient{}, time.Millisecond, func(client *propagation.DefaultClient) error {
+					client.ResolverOptions = []resolver.LoadOption{func(client *resolver.DefaultClient) error {
+						return fmt.Errorf("oh no")
+					}}
+					return nil
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				_, err = client.Verify(context.Background(), "bar.foo.net", "1.2.3.4", []string{"ns1:53"})
+				g.Expect(err).To(MatchError("oh no"))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := propagation.NewClient(resolver.DefaultClient{}, time.Millisecond, func(client *propagation.DefaultClient) error {
+					return fmt.Errorf("oh no")
+				})
+				g.Expect(err).To(MatchError("oh no"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
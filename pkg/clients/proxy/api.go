@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"net/netip"
+	"time"
+)
+
+const (
+	// ForwardedForHeader lists the addresses a request was forwarded for,
+	// each proxy appending the address it received the request from
+	ForwardedForHeader string = "X-Forwarded-For"
+
+	// headerTimeout bounds the wait for the PROXY protocol header
+	headerTimeout time.Duration = 10 * time.Second
+
+	// maxHeaderV1Length is the longest version 1 header, CRLF included
+	maxHeaderV1Length int = 107
+)
+
+var (
+	// signatureV1 starts a version 1 (text) PROXY protocol header
+	signatureV1 = []byte("PROXY ")
+
+	// signatureV2 starts a version 2 (binary) PROXY protocol header
+	signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// Trusted holds the addresses of the proxies allowed to report the address
+// of their clients
+type Trusted []netip.Prefix
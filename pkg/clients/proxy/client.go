@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Assert connections match the correct interface
+	_ net.Conn = &conn{}
+)
+
+// ParseTrusted parses the addresses or CIDRs of trusted proxies
+func ParseTrusted(values []string) (Trusted, error) {
+	trusted := Trusted{}
+	for _, value := range values {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %v", value)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trusted = append(trusted, prefix.Masked())
+	}
+	return trusted, nil
+}
+
+// Contains returns true if the address is a trusted proxy
+func (t Trusted) Contains(addr netip.Addr) bool {
+	for _, prefix := range t {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientAddress returns the address a request came from. Behind trusted
+// proxies, X-Forwarded-For is read from the right, up to the first address
+// that isn't a trusted proxy. A malformed header yields no address.
+func (t Trusted) ClientAddress(r *http.Request) netip.Addr {
+	addr := parseAddr(r.RemoteAddr)
+
+	values := r.Header.Values(ForwardedForHeader)
+	if len(values) == 0 {
+		return addr
+	}
+
+	forwarded := strings.Split(strings.Join(values, ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && t.Contains(addr); i-- {
+		next, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			return netip.Addr{}
+		}
+		addr = next.Unmap()
+	}
+	return addr
+}
+
+// Listener wraps a listener so that connections from trusted proxies may
+// start with a PROXY protocol header (version 1 or 2), reporting the
+// address of the proxy's client. Other connections are left untouched.
+func (t Trusted) Listener(listener net.Listener) net.Listener {
+	return &proxyListener{Listener: listener, trusted: t}
+}
+
+// proxyListener accepts connections that may start with a PROXY header
+type proxyListener struct {
+	net.Listener
+	trusted Trusted
+}
+
+// Accept implements net.Listener. The header is read on first use of the
+// connection, so that a slow proxy doesn't hold up other connections.
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, trusted: l.trusted, reader: bufio.NewReader(c)}, nil
+}
+
+// conn reads the PROXY header of a connection from a trusted proxy
+type conn struct {
+	net.Conn
+	trusted Trusted
+	reader  *bufio.Reader
+	once    sync.Once
+	remote  net.Addr
+	err     error
+}
+
+// Read implements net.Conn, failing if the PROXY header is malformed
+func (c *conn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr implements net.Conn, returning the address reported by a
+// trusted proxy
+func (c *conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remote
+}
+
+// readHeader reads the PROXY header, if any, once
+func (c *conn) readHeader() {
+	c.once.Do(func() {
+		c.remote = c.Conn.RemoteAddr()
+		if !c.trusted.Contains(parseAddr(c.remote.String())) {
+			return
+		}
+
+		_ = c.Conn.SetReadDeadline(time.Now().Add(headerTimeout))
+		defer func() { _ = c.Conn.SetReadDeadline(time.Time{}) }()
+
+		remote, err := readHeader(c.reader)
+		if err != nil {
+			c.err = err
+			return
+		}
+		if remote != nil {
+			c.remote = remote
+		}
+	})
+}
+
+// readHeader reads a PROXY protocol header, returning the source address
+// it reports. No header, or one reporting no address, yields nil.
+func readHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(signatureV1))
+	if err != nil {
+		// Too short to be a header, let the server handle it
+		return nil, nil
+	}
+	switch {
+	case bytes.Equal(start, signatureV1):
+		return readHeaderV1(r)
+	case bytes.Equal(start, signatureV2[:len(start)]):
+		return readHeaderV2(r)
+	default:
+		return nil, nil
+	}
+}
+
+// readHeaderV1 reads a text header: PROXY TCP4 <src> <dst> <sport> <dport>
+func readHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil || len(line) > maxHeaderV1Length || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("invalid PROXY protocol header")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol header %q", strings.TrimSpace(string(line)))
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol source address %v", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol source port %v", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+// readHeaderV2 reads a binary header. LOCAL connections (e.g., health
+// checks of the proxy) and address families other than TCP/UDP over IPv4
+// or IPv6 report no address.
+func readHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(signatureV2)+4)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:len(signatureV2)], signatureV2) {
+		return nil, fmt.Errorf("invalid PROXY protocol header")
+	}
+	versionCommand, family := header[12], header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol header: %w", err)
+	}
+
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %v", versionCommand>>4)
+	}
+	switch versionCommand & 0x0f {
+	case 0x00:
+		return nil, nil
+	case 0x01:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol command %v", versionCommand&0x0f)
+	}
+
+	var size int
+	switch family >> 4 {
+	case 0x01:
+		size = 4
+	case 0x02:
+		size = 16
+	default:
+		return nil, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, fmt.Errorf("invalid PROXY protocol header: addresses are truncated")
+	}
+	addr, _ := netip.AddrFromSlice(payload[:size])
+	port := binary.BigEndian.Uint16(payload[2*size:])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr.Unmap(), port)), nil
+}
+
+// parseAddr returns the address of a host:port pair, or of a bare host
+func parseAddr(hostPort string) netip.Addr {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host = hostPort
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
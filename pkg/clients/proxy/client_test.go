@@ -0,0 +1,244 @@
+package proxy_test
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/proxy"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// accept sends data through a listener wrapped for the trusted proxies,
+// returning the remote address and the data seen by the server
+func accept(g *WithT, trusted proxy.Trusted, data []byte) (string, string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+	wrapped := trusted.Listener(listener)
+	defer wrapped.Close()
+
+	go func() {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		_, _ = client.Write(data)
+		_ = client.(*net.TCPConn).CloseWrite()
+	}()
+
+	conn, err := wrapped.Accept()
+	g.Expect(err).NotTo(HaveOccurred())
+	defer conn.Close()
+
+	remote := conn.RemoteAddr().String()
+	body, err := io.ReadAll(conn)
+	return remote, string(body), err
+}
+
+// headerV2 builds a binary PROXY protocol header
+func headerV2(versionCommand, family byte, payload []byte) []byte {
+	header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	header = append(header, versionCommand, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// addressesV2 builds the addresses of a binary header
+func addressesV2(source, destination string, sourcePort, destinationPort uint16) []byte {
+	payload := netip.MustParseAddr(source).AsSlice()
+	payload = append(payload, netip.MustParseAddr(destination).AsSlice()...)
+	payload = binary.BigEndian.AppendUint16(payload, sourcePort)
+	return binary.BigEndian.AppendUint16(payload, destinationPort)
+}
+
+func TestProxy(t *testing.T) {
+	local, err := proxy.ParseTrusted([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "parses trusted proxies",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				trusted, err := proxy.ParseTrusted([]string{"203.0.113.7", "10.1.2.3/8", "2001:db8::/32"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(trusted).To(Equal(proxy.Trusted{
+					netip.MustParsePrefix("203.0.113.7/32"),
+					netip.MustParsePrefix("10.0.0.0/8"),
+					netip.MustParsePrefix("2001:db8::/32"),
+				}))
+				g.Expect(trusted.Contains(netip.MustParseAddr("10.9.9.9"))).To(BeTrue())
+				g.Expect(trusted.Contains(netip.MustParseAddr("::ffff:10.9.9.9"))).To(BeTrue())
+				g.Expect(trusted.Contains(netip.MustParseAddr("2001:db8::1"))).To(BeTrue())
+				g.Expect(trusted.Contains(netip.MustParseAddr("203.0.113.8"))).To(BeFalse())
+				g.Expect(trusted.Contains(netip.Addr{})).To(BeFalse())
+
+				_, err = proxy.ParseTrusted([]string{"nope"})
+				g.Expect(err).To(MatchError("invalid trusted proxy nope"))
+			},
+		},
+		{
+			testCase: "reads X-Forwarded-For behind trusted proxies",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				trusted, err := proxy.ParseTrusted([]string{"203.0.113.7", "10.0.0.0/8"})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				address := func(trusted proxy.Trusted, remoteAddr string, forwarded ...string) string {
+					request := httptest.NewRequest(http.MethodGet, "/", nil)
+					request.RemoteAddr = remoteAddr
+					for _, value := range forwarded {
+						request.Header.Add("X-Forwarded-For", value)
+					}
+					return trusted.ClientAddress(request).String()
+				}
+
+				// Untrusted clients can't choose the address
+				g.Expect(address(nil, "203.0.113.7:41234", "9.9.9.9")).To(Equal("203.0.113.7"))
+				g.Expect(address(trusted, "203.0.113.8:41234", "9.9.9.9")).To(Equal("203.0.113.8"))
+
+				g.Expect(address(trusted, "203.0.113.7:41234", "9.9.9.9, 198.51.100.1", "10.0.0.2")).To(Equal("198.51.100.1"))
+				g.Expect(address(trusted, "[::ffff:203.0.113.7]:41234", "2001:db8::1")).To(Equal("2001:db8::1"))
+				g.Expect(address(trusted, "203.0.113.7")).To(Equal("203.0.113.7"))
+
+				// Only proxies are left, or the header is malformed
+				g.Expect(address(trusted, "203.0.113.7:41234", "10.0.0.2")).To(Equal("10.0.0.2"))
+				g.Expect(address(trusted, "203.0.113.7:41234", "198.51.100.1, garbage")).To(Equal("invalid IP"))
+				g.Expect(address(trusted, "nope")).To(Equal("invalid IP"))
+			},
+		},
+		{
+			testCase: "reads version 1 PROXY protocol headers",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				remote, body, err := accept(g, local, []byte("PROXY TCP4 198.51.100.1 203.0.113.7 41234 443\r\nhello"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(remote).To(Equal("198.51.100.1:41234"))
+				g.Expect(body).To(Equal("hello"))
+
+				remote, body, err = accept(g, local, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 41234 443\r\nhello"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(remote).To(Equal("[2001:db8::1]:41234"))
+				g.Expect(body).To(Equal("hello"))
+
+				remote, body, err = accept(g, local, []byte("PROXY UNKNOWN\r\nhello"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(remote).To(HavePrefix("127.0.0.1:"))
+				g.Expect(body).To(Equal("hello"))
+
+				for header, message := range map[string]string{
+					"PROXY TCP4 198.51.100.1 203.0.113.7 41234 443\n":      "invalid PROXY protocol header",
+					"PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n":      "invalid PROXY protocol header",
+					"PROXY UDP4 198.51.100.1 203.0.113.7 41234 443\r\n":    `invalid PROXY protocol header "PROXY UDP4 198.51.100.1 203.0.113.7 41234 443"`,
+					"PROXY TCP4 198.51.100.1 203.0.113.7 41234\r\n":        `invalid PROXY protocol header "PROXY TCP4 198.51.100.1 203.0.113.7 41234"`,
+					"PROXY TCP4 nope 203.0.113.7 41234 443\r\n":            "invalid PROXY protocol source address nope",
+					"PROXY TCP4 198.51.100.1 203.0.113.7 99999 443\r\n":    "invalid PROXY protocol source port 99999",
+					"PROXY TCP4 198.51.100.1 203.0.113.7 41234 443\r\nbye": "",
+				} {
+					_, _, err = accept(g, local, []byte(header))
+					if message == "" {
+						g.Expect(err).NotTo(HaveOccurred(), header)
+					} else {
+						g.Expect(err).To(MatchError(message), header)
+					}
+				}
+			},
+		},
+		{
+			testCase: "reads version 2 PROXY protocol headers",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				header := headerV2(0x21, 0x11, addressesV2("198.51.100.1", "203.0.113.7", 41234, 443))
+				remote, body, err := accept(g, local, append(header, "hello"...))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(remote).To(Equal("198.51.100.1:41234"))
+				g.Expect(body).To(Equal("hello"))
+
+				// Extra TLVs after the addresses are skipped
+				payload := append(addressesV2("2001:db8::1", "2001:db8::2", 41234, 443), 0x04, 0x00, 0x01, 0x00)
+				remote, body, err = accept(g, local, append(headerV2(0x21, 0x21, payload), "hello"...))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(remote).To(Equal("[2001:db8::1]:41234"))
+				g.Expect(body).To(Equal("hello"))
+
+				// Health checks of the proxy and unix sockets report no address
+				for _, header := range [][]byte{
+					headerV2(0x20, 0x00, nil),
+					headerV2(0x21, 0x31, make([]byte, 216)),
+				} {
+					remote, body, err = accept(g, local, append(header, "hello"...))
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(remote).To(HavePrefix("127.0.0.1:"))
+					g.Expect(body).To(Equal("hello"))
+				}
+
+				for _, test := range []struct {
+					header  []byte
+					message string
+				}{
+					{[]byte("\r\n\r\n\x00\r\nQUIT\r"), "invalid PROXY protocol header"},
+					{headerV2(0x21, 0x11, nil)[:15], "invalid PROXY protocol header"},
+					{append(headerV2(0x21, 0x11, nil), 0x01), "invalid PROXY protocol header: addresses are truncated"},
+					{append(headerV2(0x21, 0x11, nil)[:14], 0x00, 0x0c), "invalid PROXY protocol header: EOF"},
+					{headerV2(0x11, 0x11, addressesV2("198.51.100.1", "203.0.113.7", 41234, 443)), "unsupported PROXY protocol version 1"},
+					{headerV2(0x22, 0x11, addressesV2("198.51.100.1", "203.0.113.7", 41234, 443)), "unsupported PROXY protocol command 2"},
+					{headerV2(0x21, 0x21, addressesV2("198.51.100.1", "203.0.113.7", 41234, 443)), "invalid PROXY protocol header: addresses are truncated"},
+				} {
+					_, _, err = accept(g, local, test.header)
+					g.Expect(err).To(MatchError(test.message), test.message)
+				}
+			},
+		},
+		{
+			testCase: "leaves other connections untouched",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for _, data := range []string{"GET / HTTP/1.1\r\n\r\n", "GET", ""} {
+					remote, body, err := accept(g, local, []byte(data))
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(remote).To(HavePrefix("127.0.0.1:"))
+					g.Expect(body).To(Equal(data))
+				}
+
+				// Headers of untrusted clients are just data
+				header := "PROXY TCP4 198.51.100.1 203.0.113.7 41234 443\r\n"
+				remote, body, err := accept(g, nil, []byte(header))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(remote).To(HavePrefix("127.0.0.1:"))
+				g.Expect(body).To(Equal(header))
+
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				g.Expect(err).NotTo(HaveOccurred())
+				wrapped := local.Listener(listener)
+				g.Expect(wrapped.Close()).To(Succeed())
+				_, err = wrapped.Accept()
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
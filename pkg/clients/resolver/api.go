@@ -0,0 +1,12 @@
+package resolver
+
+import (
+	"context"
+	"net"
+)
+
+// Resolver wraps the DNS resolver used to make lookups
+type Resolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
+}
@@ -0,0 +1,87 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+)
+
+var (
+	_ Resolver = &net.Resolver{}
+)
+
+// DefaultClient implements a DNS client bound to a single nameserver
+type DefaultClient struct {
+	// Server is the host:port of the nameserver. Empty means the system resolver.
+	Server string
+	// Client       *net.Resolver
+	Client Resolver
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient returns a new resolver client that sends every query to server
+func NewClient(server string, opts ...LoadOption) (DefaultClient, error) {
+	client := DefaultClient{
+		Server: server,
+		Client: newNetResolver(server),
+	}
+	for _, opt := range opts {
+		if err := opt(&client); err != nil {
+			return DefaultClient{}, err
+		}
+	}
+	return client, nil
+}
+
+// newNetResolver builds a standard library resolver that only talks to server
+func newNetResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// LookupA returns the sorted IPv4 addresses published for name. A name
+// that does not exist resolves to an empty list rather than an error.
+func (c *DefaultClient) LookupA(ctx context.Context, name string) ([]string, error) {
+	ips, err := c.Client.LookupIP(ctx, "ip4", name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return []string{}, nil
+		}
+		return []string{}, err
+	}
+
+	addresses := []string{}
+	for _, ip := range ips {
+		addresses = append(addresses, ip.String())
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// LookupNS returns the sorted nameserver hosts of a zone, without the trailing dot
+func (c *DefaultClient) LookupNS(ctx context.Context, zone string) ([]string, error) {
+	nameservers, err := c.Client.LookupNS(ctx, zone)
+	if err != nil {
+		return []string{}, err
+	}
+
+	hosts := []string{}
+	for _, nameserver := range nameservers {
+		hosts = append(hosts, strings.TrimSuffix(nameserver.Host, "."))
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
@@ -0,0 +1,154 @@
+package resolver_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/resolver"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+func withMockResolver(client *resolver.DefaultClient) error {
+	client.Client = &mocks.MockResolver{}
+	return nil
+}
+
+func TestFile(t *testing.T) {
+	tests := []testRunner{
+		{
+			testCase: "returns sorted ipv4 addresses",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := resolver.NewClient("1.1.1.1:53", withMockResolver)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = envy.AddObjectReturns("LookupIP", []net.IP{net.ParseIP("5.5.5.5"), net.ParseIP("1.2.3.4")})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				addresses, err := client.LookupA(context.Background(), "bar.foo.net")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(addresses).To(Equal([]string{"1.2.3.4", "5.5.5.5"}))
+			},
+		},
+		{
+			testCase: "returns empty list for missing names",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := resolver.NewClient("1.1.1.1:53", withMockResolver)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = envy.AddErrorReturns("LookupIP", &net.DNSError{Err: "no such host", Name: "bar.foo.net", IsNotFound: true})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				addresses, err := client.LookupA(context.Background(), "bar.foo.net")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(addresses).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "returns lookup errors",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := resolver.NewClient("1.1.1.1:53", withMockResolver)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = envy.AddErrorReturns("LookupIP", fmt.Errorf("servfail"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				_, err = client.LookupA(context.Background(), "bar.foo.net")
+				g.Expect(err).To(MatchError("servfail"))
+			},
+		},
+		{
+			testCase: "uses the system resolver without a server",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := resolver.NewClient("")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Client).To(BeIdenticalTo(net.DefaultResolver))
+			},
+		},
+		{
+			testCase: "sends queries to the configured server",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				// Nothing answers on this port, so the query fails
+				client, err := resolver.NewClient("127.0.0.1:1")
+				g.Expect(err).NotTo(HaveOccurred())
+
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				_, err = client.LookupA(ctx, "bar.foo.net")
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "resolves against a local nameserver",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				server, err := mocks.NewMockDNSServer()
+				g.Expect(err).NotTo(HaveOccurred())
+				defer server.Close()
+
+				server.SetA("bar.foo.net", "5.5.5.5", "1.2.3.4")
+				server.SetNS("foo.net", "ns2.foo.net", "ns1.foo.net")
+
+				client, err := resolver.NewClient(server.Address)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				addresses, err := client.LookupA(ctx, "bar.foo.net.")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(addresses).To(Equal([]string{"1.2.3.4", "5.5.5.5"}))
+
+				hosts, err := client.LookupNS(ctx, "foo.net.")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(hosts).To(Equal([]string{"ns1.foo.net", "ns2.foo.net"}))
+
+				addresses, err = client.LookupA(ctx, "missing.foo.net.")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(addresses).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "returns nameserver lookup errors",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := resolver.NewClient("1.1.1.1:53", withMockResolver)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = envy.AddErrorReturns("LookupNS", fmt.Errorf("servfail"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				_, err = client.LookupNS(context.Background(), "foo.net")
+				g.Expect(err).To(MatchError("servfail"))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := resolver.NewClient("", func(client *resolver.DefaultClient) error {
+					return fmt.Errorf("oh no")
+				})
+				g.Expect(err).To(MatchError("oh no"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -7,6 +7,12 @@ type Scheduler interface {
 	// Do specifies the jobFunc that should be called every time the Job runs
 	Do(jobFun interface{}, params ...interface{}) (*gocron.Job, error)
 
+	// Cron schedules a new job with the cron expression
+	Cron(cronExpression string) *gocron.Scheduler
+
+	// Clear stops and removes all jobs, letting running ones finish
+	Clear()
+
 	// StartBlocking starts all jobs and blocks the current thread
 	StartBlocking()
 }
@@ -17,12 +17,11 @@ type LoadOption func(client *DefaultClient) error
 
 // NewClient returns a new scheduler client
 func NewClient(cronSchedule string, opts ...LoadOption) (DefaultClient, error) {
+	if err := Validate(cronSchedule); err != nil {
+		return DefaultClient{}, err
+	}
 	scheduler := gocron.NewScheduler(time.Local)
 	scheduler.Cron(cronSchedule)
-	coreJob := scheduler.Jobs()[0]
-	if coreJob.Error() != nil {
-		return DefaultClient{}, coreJob.Error()
-	}
 
 	client := DefaultClient{
 		CronSchedule: cronSchedule,
@@ -36,6 +35,28 @@ func NewClient(cronSchedule string, opts ...LoadOption) (DefaultClient, error) {
 	return client, nil
 }
 
+// Validate returns an error if the cron schedule can't be parsed
+func Validate(cronSchedule string) error {
+	scheduler := gocron.NewScheduler(time.Local)
+	scheduler.Cron(cronSchedule)
+	return scheduler.Jobs()[0].Error()
+}
+
+// Replace swaps the scheduled jobs for a single job on a new schedule. A
+// running job finishes undisturbed, and the scheduler keeps running.
+func (c *DefaultClient) Replace(cronSchedule string, jobFun interface{}, params ...interface{}) error {
+	if err := Validate(cronSchedule); err != nil {
+		return err
+	}
+	c.Client.Clear()
+	c.Client.Cron(cronSchedule)
+	if _, err := c.Client.Do(jobFun, params...); err != nil {
+		return err
+	}
+	c.CronSchedule = cronSchedule
+	return nil
+}
+
 // GetScheduler returns a pointer to the underlying scheduler
 func (c *DefaultClient) GetScheduler() Scheduler {
 	return c.Client
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/go-co-op/gocron"
+	"github.com/markliederbach/go-envy"
 	"github.com/markliederbach/qrkdns/pkg/clients/scheduler"
 	"github.com/markliederbach/qrkdns/pkg/mocks"
 	. "github.com/onsi/gomega"
@@ -44,6 +46,40 @@ func TestClient(t *testing.T) {
 				g.Expect(err.Error()).To(ContainSubstring("cron expression failed to be parsed"))
 			},
 		},
+		{
+			testCase: "replaces the scheduled job",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := newMockSchedulerClient()
+				g.Expect(err).NotTo(HaveOccurred())
+
+				g.Expect(client.Replace("*/5 * * * *", func() {})).To(Succeed())
+				g.Expect(client.CronSchedule).To(Equal("*/5 * * * *"))
+
+				err = client.Replace("badcron1234", func() {})
+				g.Expect(err.Error()).To(ContainSubstring("cron expression failed to be parsed"))
+				g.Expect(client.CronSchedule).To(Equal("*/5 * * * *"))
+
+				g.Expect(envy.AddErrorReturns("Do", fmt.Errorf("foo"))).To(Succeed())
+				g.Expect(client.Replace("*/10 * * * *", func() {})).To(MatchError("foo"))
+				g.Expect(client.CronSchedule).To(Equal("*/5 * * * *"))
+			},
+		},
+		{
+			testCase: "replaces jobs of a real scheduler",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				client, err := scheduler.NewClient("* * * * *")
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.GetScheduler().Do(func() {})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				g.Expect(client.Replace("*/5 * * * *", func() {})).To(Succeed())
+				g.Expect(client.Client.(*gocron.Scheduler).Jobs()).To(HaveLen(1))
+			},
+		},
 		{
 			testCase: "returns error for bad load option",
 			runner: func(tt *testing.T) {
@@ -0,0 +1,41 @@
+package secrets
+
+import "context"
+
+// Source describes where a secret can be read from. The first non-empty
+// field among Value, File and Command is used.
+type Source struct {
+	// Name identifies the secret in errors (e.g., the flag name)
+	Name string
+	// Value is the secret itself
+	Value string
+	// File is the path of a file containing the secret, such as a
+	// Docker or Kubernetes secret
+	File string
+	// Command is a shell command printing the secret to stdout
+	Command string
+}
+
+// IsSet returns true if any source of the secret is configured
+func (s *Source) IsSet() bool {
+	return s.Value != "" || s.File != "" || s.Command != ""
+}
+
+// CommandRunner wraps the execution of a shell command
+type CommandRunner interface {
+	// RunCommand runs the command and returns its stdout
+	RunCommand(ctx context.Context, command string) ([]byte, error)
+}
+
+// Backend reads secrets from a secret store, such as Vault
+type Backend interface {
+	// Read returns the value referenced by a <scheme>://... URL
+	Read(ctx context.Context, reference string) (string, error)
+}
+
+// Resolver reads secrets from their source
+type Resolver interface {
+	// Resolve returns the secret, reading it again from its file or
+	// command on every call so that rotated secrets are picked up
+	Resolve(ctx context.Context, source Source) (string, error)
+}
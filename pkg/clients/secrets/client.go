@@ -0,0 +1,155 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultCommandTimeout bounds the commands printing secrets, so that a
+	// hung credential helper doesn't block every sync
+	DefaultCommandTimeout time.Duration = 30 * time.Second
+)
+
+var (
+	// Assert client matches the correct interface
+	_ Resolver      = &DefaultClient{}
+	_ CommandRunner = &execRunner{}
+)
+
+// DefaultClient reads secrets from values, files and commands, registering
+// every secret it returns with a redactor
+type DefaultClient struct {
+	Client   CommandRunner
+	Redactor *Redactor
+	// Backends reads references to secret stores, keyed by URL scheme
+	Backends map[string]Backend
+	// CommandTimeout bounds every command printing a secret
+	CommandTimeout time.Duration
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// execRunner runs commands through the system shell
+type execRunner struct{}
+
+// RunCommand implements CommandRunner
+func (e *execRunner) RunCommand(ctx context.Context, command string) ([]byte, error) {
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stderr = stderr
+	// Don't wait on children that inherited the output pipe after a timeout
+	cmd.WaitDelay = time.Second
+	output, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		return output, fmt.Errorf("%w: %v", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, err
+}
+
+// NewClient returns a new client registering secrets with DefaultRedactor
+func NewClient(opts ...LoadOption) (*DefaultClient, error) {
+	client := &DefaultClient{
+		Client:         &execRunner{},
+		Redactor:       DefaultRedactor,
+		Backends:       make(map[string]Backend),
+		CommandTimeout: DefaultCommandTimeout,
+	}
+	for _, opt := range opts {
+		if err := opt(client); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// WithBackend resolves references starting with scheme:// through backend
+func WithBackend(scheme string, backend Backend) LoadOption {
+	return func(client *DefaultClient) error {
+		client.Backends[scheme] = backend
+		return nil
+	}
+}
+
+// WithCommandTimeout bounds the commands printing secrets
+func WithCommandTimeout(timeout time.Duration) LoadOption {
+	return func(client *DefaultClient) error {
+		client.CommandTimeout = timeout
+		return nil
+	}
+}
+
+// Resolve implements Resolver. Surrounding whitespace, such as the trailing
+// newline of a file or command output, is removed. A secret referencing a
+// registered backend (e.g., vault://secret/data/dns#cf_token) is replaced
+// by the value read from it.
+func (c *DefaultClient) Resolve(ctx context.Context, source Source) (string, error) {
+	var secret string
+	switch {
+	case source.Value != "":
+		secret = source.Value
+	case source.File != "":
+		content, err := os.ReadFile(source.File)
+		if err != nil {
+			return "", fmt.Errorf("reading %v: %w", source.Name, err)
+		}
+		secret = strings.TrimSpace(string(content))
+		if secret == "" {
+			return "", fmt.Errorf("%v file %v is empty", source.Name, source.File)
+		}
+	case source.Command != "":
+		commandCtx, cancel := context.WithTimeout(ctx, c.CommandTimeout)
+		defer cancel()
+		output, err := c.Client.RunCommand(commandCtx, source.Command)
+		secret = strings.TrimSpace(string(output))
+		c.Redactor.Add(secret)
+		if err != nil && errors.Is(commandCtx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("running %v command: timed out after %v", source.Name, c.CommandTimeout)
+		}
+		if err != nil {
+			return "", c.Redactor.Error(fmt.Errorf("running %v command: %w", source.Name, err))
+		}
+		if secret == "" {
+			return "", fmt.Errorf("%v command printed nothing", source.Name)
+		}
+	default:
+		return "", nil
+	}
+
+	if backend, ok := c.backend(secret); ok {
+		value, err := backend.Read(ctx, secret)
+		if err != nil {
+			return "", fmt.Errorf("reading %v from %v: %w", source.Name, secret, err)
+		}
+		if value == "" {
+			return "", fmt.Errorf("%v is empty in %v", source.Name, secret)
+		}
+		secret = value
+	}
+
+	c.Redactor.Add(secret)
+	return secret, nil
+}
+
+// IsReference returns true if value references a registered backend
+func (c *DefaultClient) IsReference(value string) bool {
+	_, ok := c.backend(value)
+	return ok
+}
+
+// backend returns the backend referenced by value, if any
+func (c *DefaultClient) backend(value string) (Backend, bool) {
+	scheme, _, ok := strings.Cut(value, "://")
+	if !ok {
+		return nil, false
+	}
+	backend, ok := c.Backends[scheme]
+	return backend, ok
+}
@@ -0,0 +1,217 @@
+package secrets_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/secrets"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// newClient returns a client with its own redactor
+func newClient(g *WithT) (*secrets.DefaultClient, *secrets.Redactor) {
+	redactor := &secrets.Redactor{}
+	client, err := secrets.NewClient(func(client *secrets.DefaultClient) error {
+		client.Redactor = redactor
+		return nil
+	})
+	g.Expect(err).NotTo(HaveOccurred())
+	return client, redactor
+}
+
+// failingFormatter fails to format every entry
+type failingFormatter struct{}
+
+func (f *failingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	return nil, errors.New("foo")
+}
+
+// mapBackend reads references from a map
+type mapBackend map[string]string
+
+func (m mapBackend) Read(ctx context.Context, reference string) (string, error) {
+	value, ok := m[reference]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return value, nil
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []testRunner{
+		{
+			testCase: "reads secrets from values",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, redactor := newClient(g)
+
+				secret, err := client.Resolve(ctx, secrets.Source{Name: "cf-api-token", Value: "token123", File: "ignored"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(secret).To(Equal("token123"))
+				g.Expect(redactor.Redact("Bearer token123")).To(Equal("Bearer [REDACTED]"))
+
+				source := secrets.Source{Name: "cf-api-token"}
+				g.Expect(source.IsSet()).To(BeFalse())
+				secret, err = client.Resolve(ctx, source)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(secret).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "re-reads secrets from files",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, redactor := newClient(g)
+
+				path := filepath.Join(tt.TempDir(), "token")
+				source := secrets.Source{Name: "cf-api-token", File: path}
+				g.Expect(source.IsSet()).To(BeTrue())
+
+				g.Expect(os.WriteFile(path, []byte("token123\n"), 0o600)).To(Succeed())
+				secret, err := client.Resolve(ctx, source)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(secret).To(Equal("token123"))
+
+				// Rotated secrets are picked up, and the old one stays redacted
+				g.Expect(os.WriteFile(path, []byte("token456\n"), 0o600)).To(Succeed())
+				secret, err = client.Resolve(ctx, source)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(secret).To(Equal("token456"))
+				g.Expect(redactor.Redact("token123 token456")).To(Equal("[REDACTED] [REDACTED]"))
+
+				g.Expect(os.WriteFile(path, []byte("\n"), 0o600)).To(Succeed())
+				_, err = client.Resolve(ctx, source)
+				g.Expect(err).To(MatchError("cf-api-token file " + path + " is empty"))
+
+				_, err = client.Resolve(ctx, secrets.Source{Name: "cf-api-token", File: filepath.Join(tt.TempDir(), "missing")})
+				g.Expect(err).To(MatchError(ContainSubstring("reading cf-api-token: ")))
+				g.Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+			},
+		},
+		{
+			testCase: "reads secrets from commands",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, redactor := newClient(g)
+
+				secret, err := client.Resolve(ctx, secrets.Source{Name: "cf-api-token", Command: "echo token123"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(secret).To(Equal("token123"))
+				g.Expect(redactor.Redact("token123")).To(Equal("[REDACTED]"))
+
+				_, err = client.Resolve(ctx, secrets.Source{Name: "cf-api-token", Command: "true"})
+				g.Expect(err).To(MatchError("cf-api-token command printed nothing"))
+
+				// Partial output and stderr never leak through errors
+				_, err = client.Resolve(ctx, secrets.Source{Name: "cf-api-token", Command: "echo token789; echo token789 is expired >&2; exit 3"})
+				g.Expect(err).To(MatchError("running cf-api-token command: exit status 3: [REDACTED] is expired"))
+				var exitError interface{ ExitCode() int }
+				g.Expect(errors.As(err, &exitError)).To(BeTrue())
+				g.Expect(exitError.ExitCode()).To(Equal(3))
+
+				_, err = client.Resolve(ctx, secrets.Source{Name: "cf-api-token", Command: "exit 1"})
+				g.Expect(err).To(MatchError("running cf-api-token command: exit status 1"))
+			},
+		},
+		{
+			testCase: "times out hung commands",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, _ := newClient(g)
+				g.Expect(client.CommandTimeout).To(Equal(secrets.DefaultCommandTimeout))
+
+				client, err := secrets.NewClient(secrets.WithCommandTimeout(100 * time.Millisecond))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				started := time.Now()
+				_, err = client.Resolve(ctx, secrets.Source{Name: "cf-api-token", Command: "sleep 10"})
+				g.Expect(err).To(MatchError("running cf-api-token command: timed out after 100ms"))
+				g.Expect(time.Since(started)).To(BeNumerically("<", 5*time.Second))
+			},
+		},
+		{
+			testCase: "redacts log lines",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				redactor := &secrets.Redactor{}
+				redactor.Add("")
+				redactor.Add("abc")
+				redactor.Add("abc")
+				redactor.Add(`abc"def`)
+				g.Expect(redactor.Redact(`abc"def abc`)).To(Equal("[REDACTED] [REDACTED]"))
+				g.Expect(redactor.Error(nil)).To(BeNil())
+
+				output := &bytes.Buffer{}
+				logger := log.New()
+				logger.Out = output
+				logger.Formatter = &secrets.Formatter{Formatter: &log.JSONFormatter{}, Redactor: redactor}
+				logger.WithField("header", `Bearer abc"def`).WithError(errors.New("bad token abc")).Info("Calling abc")
+				g.Expect(output.String()).NotTo(ContainSubstring("abc"))
+				g.Expect(output.String()).To(ContainSubstring(`"header":"Bearer [REDACTED]"`))
+				g.Expect(output.String()).To(ContainSubstring(`"msg":"Calling [REDACTED]"`))
+
+				formatter := &secrets.Formatter{Formatter: &failingFormatter{}, Redactor: redactor}
+				_, err := formatter.Format(log.NewEntry(logger))
+				g.Expect(err).To(MatchError("foo"))
+			},
+		},
+		{
+			testCase: "reads references from backends",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, redactor := newClient(g)
+				backend := mapBackend{"vault://secret/data/dns#cf_token": "token123", "vault://secret/data/dns#empty": ""}
+				g.Expect(secrets.WithBackend("vault", backend)(client)).To(Succeed())
+
+				g.Expect(client.IsReference("vault://secret/data/dns#cf_token")).To(BeTrue())
+				g.Expect(client.IsReference("file://token")).To(BeFalse())
+				g.Expect(client.IsReference("token123")).To(BeFalse())
+
+				secret, err := client.Resolve(ctx, secrets.Source{Name: "cf-api-token", Value: "vault://secret/data/dns#cf_token"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(secret).To(Equal("token123"))
+				g.Expect(redactor.Redact("Bearer token123")).To(Equal("Bearer [REDACTED]"))
+
+				// References to unregistered schemes are taken literally
+				secret, err = client.Resolve(ctx, secrets.Source{Name: "cf-api-token", Value: "https://example.com"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(secret).To(Equal("https://example.com"))
+
+				_, err = client.Resolve(ctx, secrets.Source{Name: "cf-api-token", Value: "vault://secret/data/dns#missing"})
+				g.Expect(err).To(MatchError("reading cf-api-token from vault://secret/data/dns#missing: not found"))
+
+				_, err = client.Resolve(ctx, secrets.Source{Name: "cf-api-token", Value: "vault://secret/data/dns#empty"})
+				g.Expect(err).To(MatchError("cf-api-token is empty in vault://secret/data/dns#empty"))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, err := secrets.NewClient(func(client *secrets.DefaultClient) error {
+					return errors.New("foo")
+				})
+				g.Expect(err).To(MatchError("foo"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Placeholder replaces secrets in redacted text
+	Placeholder string = "[REDACTED]"
+)
+
+var (
+	// DefaultRedactor collects every secret resolved by the process
+	DefaultRedactor *Redactor = &Redactor{}
+
+	// Assert formatter matches the correct interface
+	_ log.Formatter = &Formatter{}
+)
+
+// Redactor replaces known secrets with Placeholder
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// Add registers a secret. Empty secrets are ignored.
+func (r *Redactor) Add(secret string) {
+	if secret == "" {
+		return
+	}
+
+	// Also catch the secret where it was escaped inside JSON
+	variants := []string{secret}
+	if escaped, err := json.Marshal(secret); err == nil {
+		variants = append(variants, strings.Trim(string(escaped), `"`))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, variant := range variants {
+		if !r.contains(variant) {
+			r.secrets = append(r.secrets, variant)
+		}
+	}
+	// Replace longer secrets first, in case one contains another
+	sort.SliceStable(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+// Redact replaces every known secret in text
+func (r *Redactor) Redact(text string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, Placeholder)
+	}
+	return text
+}
+
+// Error returns err with every known secret removed from its message. The
+// original error can still be unwrapped.
+func (r *Redactor) Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err, message: r.Redact(err.Error())}
+}
+
+// contains returns true if the secret is already registered
+func (r *Redactor) contains(secret string) bool {
+	for _, known := range r.secrets {
+		if known == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedError hides secrets in the message of the error it wraps
+type redactedError struct {
+	err     error
+	message string
+}
+
+// Error implements the error interface
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+// Unwrap returns the original error
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Formatter wraps a log formatter, removing known secrets from every line
+type Formatter struct {
+	Formatter log.Formatter
+	Redactor  *Redactor
+}
+
+// Format implements log.Formatter
+func (f *Formatter) Format(entry *log.Entry) ([]byte, error) {
+	line, err := f.Formatter.Format(entry)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(f.Redactor.Redact(string(line))), nil
+}
@@ -0,0 +1,50 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+	"github.com/markliederbach/qrkdns/pkg/clients/webhook"
+)
+
+var (
+	_ notify.Notifier = &DefaultClient{}
+)
+
+// DefaultClient implements a notifier for Slack-compatible incoming webhooks
+type DefaultClient struct {
+	URL string
+	// Client       *http.Client
+	Client webhook.HTTPClient
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// message is the minimal payload accepted by Slack-compatible webhooks
+type message struct {
+	Text string `json:"text"`
+}
+
+// NewClient returns a new Slack webhook client
+func NewClient(url string, opts ...LoadOption) (*DefaultClient, error) {
+	client := DefaultClient{
+		URL:    url,
+		Client: &http.Client{Timeout: webhook.DefaultTimeout},
+	}
+	for _, opt := range opts {
+		if err := opt(&client); err != nil {
+			return &DefaultClient{}, err
+		}
+	}
+	return &client, nil
+}
+
+// Notify posts the event summary as a Slack message
+func (c *DefaultClient) Notify(ctx context.Context, event notify.Event) error {
+	// Encoding a string can't fail
+	payload, _ := json.Marshal(message{Text: event.Summary()})
+	return webhook.Post(ctx, c.Client, c.URL, payload)
+}
@@ -0,0 +1,80 @@
+package slack_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+	"github.com/markliederbach/qrkdns/pkg/clients/slack"
+	"github.com/markliederbach/qrkdns/pkg/clients/webhook"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+func withMockHTTPClient(client *slack.DefaultClient) error {
+	client.Client = &mocks.MockHTTPClient{}
+	return nil
+}
+
+func TestFile(t *testing.T) {
+	event := notify.Event{Type: notify.EventTypeRecordCreated, Name: "bar.foo.net", NewIP: "2.2.2.2"}
+
+	tests := []testRunner{
+		{
+			testCase: "posts the event summary",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := slack.NewClient("http://slack", withMockHTTPClient)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Notify(context.Background(), event)
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+		},
+		{
+			testCase: "returns error from http client",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := slack.NewClient("http://slack", withMockHTTPClient)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = envy.AddErrorReturns("Do", fmt.Errorf("unreachable"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Notify(context.Background(), event)
+				g.Expect(err).To(MatchError("unreachable"))
+			},
+		},
+		{
+			testCase: "bounds requests with a timeout by default",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := slack.NewClient("http://slack")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Client).To(Equal(&http.Client{Timeout: webhook.DefaultTimeout}))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := slack.NewClient("http://slack", func(client *slack.DefaultClient) error {
+					return fmt.Errorf("oh no")
+				})
+				g.Expect(err).To(MatchError("oh no"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,73 @@
+package vault
+
+import (
+	"context"
+	"net/http"
+)
+
+// AuthMethod labels the ways qrkdns can log in to Vault
+type AuthMethod string
+
+const (
+	// AuthMethodToken uses a token directly
+	AuthMethodToken AuthMethod = "token"
+
+	// AuthMethodAppRole logs in with a role ID and secret ID
+	AuthMethodAppRole AuthMethod = "approle"
+
+	// AuthMethodKubernetes logs in with the pod's service account token
+	AuthMethodKubernetes AuthMethod = "kubernetes"
+)
+
+var (
+	// SupportedAuthMethods defines which auth methods are accepted
+	SupportedAuthMethods []AuthMethod = []AuthMethod{
+		AuthMethodToken,
+		AuthMethodAppRole,
+		AuthMethodKubernetes,
+	}
+)
+
+// Auth holds the credentials used to log in to Vault
+type Auth struct {
+	Method AuthMethod
+	// Mount is the path the auth method is mounted at. Empty uses the
+	// method's default path.
+	Mount string
+	// Token is used by the token auth method
+	Token string
+	// RoleID and SecretID are used by the AppRole auth method
+	RoleID   string
+	SecretID string
+	// Role and JWTPath are used by the Kubernetes auth method
+	Role    string
+	JWTPath string
+}
+
+// HTTPClient abstracts the HTTP client used to talk to Vault
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Reader reads values from Vault
+type Reader interface {
+	// Read returns the value referenced by a vault://<path>#<key> URL
+	Read(ctx context.Context, reference string) (string, error)
+}
+
+// response is the envelope of every Vault API response
+type response struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int64                  `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *authResponse          `json:"auth"`
+	Errors        []string               `json:"errors"`
+}
+
+// authResponse is the auth block returned by logins and token renewals
+type authResponse struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int64  `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
@@ -0,0 +1,356 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Scheme prefixes references to values stored in Vault
+	Scheme string = "vault"
+
+	// DefaultJWTPath is where Kubernetes mounts the service account token
+	DefaultJWTPath string = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// DefaultTimeout bounds a request to Vault, so an unresponsive server
+	// can't hold the startup up
+	DefaultTimeout time.Duration = 10 * time.Second
+)
+
+var (
+	// Assert client matches the correct interface
+	_ Reader = &DefaultClient{}
+)
+
+// DefaultClient reads secrets from the Vault HTTP API. Tokens obtained by
+// logging in are renewed, or replaced by logging in again, before they
+// expire. Renewable tokens given as is are renewed too. Leased secrets are
+// cached and renewed; everything else, such as KV secrets, is fetched again
+// on every read so rotations are picked up.
+type DefaultClient struct {
+	Address   string
+	Namespace string
+	Auth      Auth
+	Client    HTTPClient
+	// Now returns the current time, used to track token and lease expiry
+	Now func() time.Time
+
+	mu     sync.Mutex
+	token  *lease
+	leases map[string]*lease
+}
+
+// lease tracks a token or secret that expires
+type lease struct {
+	id        string
+	value     string
+	data      map[string]interface{}
+	renewable bool
+	// renewAt is when the lease should be renewed, ahead of expiresAt
+	renewAt   time.Time
+	expiresAt time.Time
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient returns a new client for the Vault server at address
+func NewClient(address string, auth Auth, opts ...LoadOption) (*DefaultClient, error) {
+	if address == "" {
+		return nil, fmt.Errorf("a Vault address is required")
+	}
+	if err := validateAuth(&auth); err != nil {
+		return nil, err
+	}
+
+	client := &DefaultClient{
+		Address: strings.TrimSuffix(address, "/"),
+		Auth:    auth,
+		Client:  &http.Client{Timeout: DefaultTimeout},
+		Now:     time.Now,
+		leases:  make(map[string]*lease),
+	}
+	for _, opt := range opts {
+		if err := opt(client); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// WithNamespace sends requests to a Vault Enterprise namespace
+func WithNamespace(namespace string) LoadOption {
+	return func(client *DefaultClient) error {
+		client.Namespace = namespace
+		return nil
+	}
+}
+
+// validateAuth checks that the credentials of the auth method are set,
+// filling in defaults
+func validateAuth(auth *Auth) error {
+	switch auth.Method {
+	case AuthMethodToken:
+		if auth.Token == "" {
+			return fmt.Errorf("token auth requires a Vault token")
+		}
+	case AuthMethodAppRole:
+		if auth.RoleID == "" || auth.SecretID == "" {
+			return fmt.Errorf("approle auth requires a role ID and a secret ID")
+		}
+	case AuthMethodKubernetes:
+		if auth.Role == "" {
+			return fmt.Errorf("kubernetes auth requires a role")
+		}
+		if auth.JWTPath == "" {
+			auth.JWTPath = DefaultJWTPath
+		}
+	default:
+		return fmt.Errorf("unsupported Vault auth method: %v", auth.Method)
+	}
+	if auth.Mount == "" {
+		auth.Mount = string(auth.Method)
+	}
+	return nil
+}
+
+// ParseReference splits a vault://<path>#<key> reference into the secret's
+// path and the key of the value
+func ParseReference(reference string) (string, string, error) {
+	parsed, err := url.Parse(reference)
+	if err != nil || parsed.Scheme != Scheme || parsed.Host == "" {
+		return "", "", fmt.Errorf("invalid Vault reference %q, expected %v://<path>#<key>", reference, Scheme)
+	}
+	path := strings.Trim(parsed.Host+parsed.Path, "/")
+	if parsed.Fragment == "" {
+		return "", "", fmt.Errorf("missing #<key> in Vault reference %q", reference)
+	}
+	return path, parsed.Fragment, nil
+}
+
+// Read implements Reader. KV v1 and v2 secrets are both supported; for
+// v2, the path includes the data/ segment (e.g., secret/data/dns#cf_token).
+func (c *DefaultClient) Read(ctx context.Context, reference string) (string, error) {
+	path, key, err := ParseReference(reference)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := c.secret(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in Vault secret %v", key, path)
+	}
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+// secret returns the data of the secret at path, renewing or fetching it
+// again as needed
+func (c *DefaultClient) secret(ctx context.Context, path string) (map[string]interface{}, error) {
+	now := c.Now()
+	cached, ok := c.leases[path]
+	if ok && now.Before(cached.renewAt) {
+		return cached.data, nil
+	}
+	if ok && cached.renewable && now.Before(cached.expiresAt) {
+		renewed := &response{}
+		err := c.request(ctx, http.MethodPut, "sys/leases/renew", map[string]string{"lease_id": cached.id}, renewed)
+		if err == nil {
+			cached.renewAt, cached.expiresAt = expiry(now, renewed.LeaseDuration)
+			return cached.data, nil
+		}
+		log.WithError(err).WithField("path", path).Warn("Failed to renew Vault lease, fetching the secret again")
+	}
+	delete(c.leases, path)
+
+	secret := &response{}
+	if err := c.request(ctx, http.MethodGet, path, nil, secret); err != nil {
+		return nil, err
+	}
+
+	data := secret.Data
+	// KV v2 wraps the secret in data.data, next to its metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"].(map[string]interface{}); ok {
+			data = nested
+		}
+	}
+	if data == nil {
+		return nil, fmt.Errorf("no data in Vault secret %v", path)
+	}
+
+	// Only real leases are cached. The lease duration of KV secrets is
+	// merely a hint, and they are read again to pick up new versions.
+	if secret.LeaseID != "" && secret.LeaseDuration > 0 {
+		cached := &lease{id: secret.LeaseID, data: data, renewable: secret.Renewable}
+		cached.renewAt, cached.expiresAt = expiry(now, secret.LeaseDuration)
+		c.leases[path] = cached
+	}
+	return data, nil
+}
+
+// request sends an authenticated request, logging in again once if the
+// token was rejected
+func (c *DefaultClient) request(ctx context.Context, method, path string, body map[string]string, out *response) error {
+	token, err := c.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := c.do(ctx, method, path, token, body, out)
+	if status == http.StatusForbidden && c.Auth.Method != AuthMethodToken {
+		log.WithField("path", path).Debug("Vault token was rejected, logging in again")
+		c.token = nil
+		if token, err = c.authenticate(ctx); err != nil {
+			return err
+		}
+		_, err = c.do(ctx, method, path, token, body, out)
+	}
+	return err
+}
+
+// authenticate returns a valid token, renewing it or logging in as needed
+func (c *DefaultClient) authenticate(ctx context.Context) (string, error) {
+	now := c.Now()
+	if c.token != nil && now.Before(c.token.renewAt) {
+		return c.token.value, nil
+	}
+	if c.token != nil && c.token.renewable && now.Before(c.token.expiresAt) {
+		renewed := &response{}
+		_, err := c.do(ctx, http.MethodPost, "auth/token/renew-self", c.token.value, nil, renewed)
+		if err == nil && renewed.Auth != nil {
+			c.token.renewAt, c.token.expiresAt = expiry(now, renewed.Auth.LeaseDuration)
+			return c.token.value, nil
+		}
+		if c.Auth.Method == AuthMethodToken {
+			// A token given as is can't be replaced, so it's used until it expires
+			log.WithError(err).Warn("Failed to renew Vault token")
+			return c.token.value, nil
+		}
+		log.WithError(err).Warn("Failed to renew Vault token, logging in again")
+	}
+	if c.Auth.Method == AuthMethodToken {
+		return c.lookupToken(ctx, now), nil
+	}
+
+	body := map[string]string{}
+	switch c.Auth.Method {
+	case AuthMethodAppRole:
+		body["role_id"] = c.Auth.RoleID
+		body["secret_id"] = c.Auth.SecretID
+	default:
+		// The service account token is rotated by Kubernetes, so read it every time
+		jwt, err := os.ReadFile(c.Auth.JWTPath)
+		if err != nil {
+			return "", fmt.Errorf("reading Kubernetes service account token: %w", err)
+		}
+		body["role"] = c.Auth.Role
+		body["jwt"] = strings.TrimSpace(string(jwt))
+	}
+
+	login := &response{}
+	if _, err := c.do(ctx, http.MethodPost, fmt.Sprintf("auth/%v/login", c.Auth.Mount), "", body, login); err != nil {
+		return "", fmt.Errorf("logging in to Vault with %v auth: %w", c.Auth.Method, err)
+	}
+	if login.Auth == nil || login.Auth.ClientToken == "" {
+		return "", fmt.Errorf("logging in to Vault with %v auth: no token returned", c.Auth.Method)
+	}
+
+	c.token = &lease{value: login.Auth.ClientToken, renewable: login.Auth.Renewable}
+	c.token.renewAt, c.token.expiresAt = expiry(now, login.Auth.LeaseDuration)
+	log.WithField("method", c.Auth.Method).Debug("Logged in to Vault")
+	return c.token.value, nil
+}
+
+// lookupToken reads the TTL of the token given as is, so that it's renewed
+// before it expires. Tokens that can't be looked up are used as they are,
+// without looking them up again.
+func (c *DefaultClient) lookupToken(ctx context.Context, now time.Time) string {
+	lookup := &response{}
+	if _, err := c.do(ctx, http.MethodGet, "auth/token/lookup-self", c.Auth.Token, nil, lookup); err != nil {
+		log.WithError(err).Warn("Failed to look up Vault token, it won't be renewed")
+		c.token = &lease{value: c.Auth.Token}
+		c.token.renewAt, c.token.expiresAt = expiry(now, 0)
+		return c.token.value
+	}
+
+	ttl, _ := lookup.Data["ttl"].(float64)
+	renewable, _ := lookup.Data["renewable"].(bool)
+	c.token = &lease{value: c.Auth.Token, renewable: renewable}
+	c.token.renewAt, c.token.expiresAt = expiry(now, int64(ttl))
+	if !renewable {
+		// Nothing to do before it expires
+		c.token.renewAt = c.token.expiresAt
+	}
+	return c.token.value
+}
+
+// do sends a single request to the Vault API, decoding the response into
+// out and returning the status code
+func (c *DefaultClient) do(ctx context.Context, method, path, token string, body map[string]string, out *response) (int, error) {
+	var payload []byte
+	if body != nil {
+		// Encoding strings can't fail
+		payload, _ = json.Marshal(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%v/v1/%v", c.Address, path), bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Error responses may have an empty body
+	decodeErr := json.NewDecoder(resp.Body).Decode(out)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(out.Errors) > 0 {
+			return resp.StatusCode, fmt.Errorf("vault returned %v for %v: %v", resp.StatusCode, path, strings.Join(out.Errors, "; "))
+		}
+		return resp.StatusCode, fmt.Errorf("vault returned %v for %v", resp.StatusCode, path)
+	}
+	if decodeErr != nil {
+		return resp.StatusCode, fmt.Errorf("invalid Vault response for %v: %w", path, decodeErr)
+	}
+	return resp.StatusCode, nil
+}
+
+// expiry returns when a lease of duration seconds should be renewed, at
+// two thirds of its duration, and when it expires. A zero duration never expires.
+func expiry(now time.Time, duration int64) (time.Time, time.Time) {
+	if duration <= 0 {
+		forever := now.AddDate(100, 0, 0)
+		return forever, forever
+	}
+	ttl := time.Duration(duration) * time.Second
+	return now.Add(ttl * 2 / 3), now.Add(ttl)
+}
@@ -0,0 +1,439 @@
+package vault_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/vault"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+// clock is a manually advanced time source
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+// failingHTTPClient fails every request
+type failingHTTPClient struct{}
+
+func (f *failingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return nil, errors.New("foo")
+}
+
+// failingPathHTTPClient fails the requests to a path, counting them, and
+// sends the others
+type failingPathHTTPClient struct {
+	path     string
+	failures int
+}
+
+func (f *failingPathHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, f.path) {
+		f.failures++
+		return nil, errors.New("foo")
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// newClient returns a client for the server using a manual clock
+func newClient(g *WithT, server *mocks.MockVaultServer, auth vault.Auth, opts ...vault.LoadOption) (*vault.DefaultClient, *clock) {
+	now := &clock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	opts = append(opts, func(client *vault.DefaultClient) error {
+		client.Now = now.Now
+		return nil
+	})
+	client, err := vault.NewClient(server.URL+"/", auth, opts...)
+	g.Expect(err).NotTo(HaveOccurred())
+	return client, now
+}
+
+// count returns how many requests were made to path
+func count(server *mocks.MockVaultServer, request string) int {
+	n := 0
+	for _, r := range server.Requests() {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []testRunner{
+		{
+			testCase: "reads KV v1 and v2 secrets with a token",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				server := mocks.NewMockVaultServer()
+				defer server.Close()
+				server.AddToken("root")
+				server.SetKV1("kv/dns", map[string]interface{}{"account_id": "foo", "port": 53})
+				server.SetKV2("secret/data/dns", map[string]interface{}{"cf_token": "token123"})
+
+				client, _ := newClient(g, server, vault.Auth{Method: vault.AuthMethodToken, Token: "root"}, vault.WithNamespace("team"))
+
+				value, err := client.Read(ctx, "vault://kv/dns#account_id")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(value).To(Equal("foo"))
+
+				value, err = client.Read(ctx, "vault://kv/dns#port")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(value).To(Equal("53"))
+
+				value, err = client.Read(ctx, "vault://secret/data/dns#cf_token")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(value).To(Equal("token123"))
+				g.Expect(server.Namespace()).To(Equal("team"))
+
+				// KV secrets are read again to pick up new versions
+				server.SetKV2("secret/data/dns", map[string]interface{}{"cf_token": "token456"})
+				value, err = client.Read(ctx, "vault://secret/data/dns#cf_token")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(value).To(Equal("token456"))
+				g.Expect(count(server, "GET secret/data/dns")).To(Equal(2))
+
+				_, err = client.Read(ctx, "vault://secret/data/dns#missing")
+				g.Expect(err).To(MatchError(`key "missing" not found in Vault secret secret/data/dns`))
+
+				_, err = client.Read(ctx, "vault://secret/data/other#cf_token")
+				g.Expect(err).To(MatchError("vault returned 404 for secret/data/other"))
+			},
+		},
+		{
+			testCase: "logs in with AppRole and renews the token",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				server := mocks.NewMockVaultServer()
+				defer server.Close()
+				server.TokenTTL = 60
+				server.AddAppRole("role123", "secret123")
+				server.SetKV1("kv/dns", map[string]interface{}{"cf_token": "token123"})
+
+				client, now := newClient(g, server, vault.Auth{Method: vault.AuthMethodAppRole, RoleID: "role123", SecretID: "secret123"})
+
+				for i := 0; i < 2; i++ {
+					value, err := client.Read(ctx, "vault://kv/dns#cf_token")
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(value).To(Equal("token123"))
+				}
+				g.Expect(count(server, "POST auth/approle/login")).To(Equal(1))
+
+				// The token is renewed two thirds into its TTL
+				now.now = now.now.Add(45 * time.Second)
+				_, err := client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(count(server, "POST auth/token/renew-self")).To(Equal(1))
+				g.Expect(count(server, "POST auth/approle/login")).To(Equal(1))
+
+				// An expired token is replaced by logging in again
+				now.now = now.now.Add(2 * time.Minute)
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(count(server, "POST auth/approle/login")).To(Equal(2))
+
+				// A token that can't be renewed is replaced as well
+				now.now = now.now.Add(45 * time.Second)
+				server.RevokeTokens()
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(count(server, "POST auth/approle/login")).To(Equal(3))
+
+				// A rejected token triggers a single login before retrying
+				server.RevokeTokens()
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(count(server, "POST auth/approle/login")).To(Equal(4))
+			},
+		},
+		{
+			testCase: "logs in with Kubernetes",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				server := mocks.NewMockVaultServer()
+				defer server.Close()
+				// Tokens without a TTL never expire
+				server.TokenTTL = 0
+				server.AddKubernetesRole("qrkdns", "jwt123")
+				server.SetKV1("kv/dns", map[string]interface{}{"cf_token": "token123"})
+
+				path := filepath.Join(tt.TempDir(), "token")
+				g.Expect(os.WriteFile(path, []byte("jwt123\n"), 0o600)).To(Succeed())
+
+				client, _ := newClient(g, server, vault.Auth{Method: vault.AuthMethodKubernetes, Mount: "k8s", Role: "qrkdns", JWTPath: path})
+				value, err := client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(value).To(Equal("token123"))
+				g.Expect(count(server, "POST auth/k8s/login")).To(Equal(1))
+
+				// The service account token is read again on every login
+				server.RevokeTokens()
+				g.Expect(os.WriteFile(path, []byte("jwt456\n"), 0o600)).To(Succeed())
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).To(MatchError("logging in to Vault with kubernetes auth: vault returned 400 for auth/k8s/login: invalid credentials"))
+
+				g.Expect(os.Remove(path)).To(Succeed())
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).To(MatchError(ContainSubstring("reading Kubernetes service account token: ")))
+
+				// Logging in again after a rejected token can fail as well
+				server.AddKubernetesRole("qrkdns", "jwt456")
+				g.Expect(os.WriteFile(path, []byte("jwt456\n"), 0o600)).To(Succeed())
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).NotTo(HaveOccurred())
+				server.RevokeTokens()
+				g.Expect(os.Remove(path)).To(Succeed())
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).To(MatchError(ContainSubstring("reading Kubernetes service account token: ")))
+			},
+		},
+		{
+			testCase: "renews a renewable token given as is",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				server := mocks.NewMockVaultServer()
+				defer server.Close()
+				server.TokenTTL = 60
+				server.AddToken("s.periodic")
+				server.SetKV1("kv/dns", map[string]interface{}{"cf_token": "token123"})
+
+				client, now := newClient(g, server, vault.Auth{Method: vault.AuthMethodToken, Token: "s.periodic"})
+
+				for i := 0; i < 2; i++ {
+					value, err := client.Read(ctx, "vault://kv/dns#cf_token")
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(value).To(Equal("token123"))
+				}
+				g.Expect(count(server, "GET auth/token/lookup-self")).To(Equal(1))
+				g.Expect(count(server, "POST auth/token/renew-self")).To(Equal(0))
+
+				// The token is renewed two thirds into its TTL
+				now.now = now.now.Add(45 * time.Second)
+				_, err := client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(count(server, "POST auth/token/renew-self")).To(Equal(1))
+				now.now = now.now.Add(45 * time.Second)
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(count(server, "POST auth/token/renew-self")).To(Equal(2))
+
+				// A failed renewal keeps using the token, and is retried
+				client.Client = &failingPathHTTPClient{path: "renew-self"}
+				now.now = now.now.Add(45 * time.Second)
+				for i := 0; i < 2; i++ {
+					_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+					g.Expect(err).NotTo(HaveOccurred())
+				}
+				g.Expect(count(server, "GET kv/dns")).To(Equal(6))
+				g.Expect(count(server, "GET auth/token/lookup-self")).To(Equal(1))
+
+				// Tokens that can't be renewed or looked up are used as they are
+				failing := &failingPathHTTPClient{path: "lookup-self"}
+				for _, client := range []*vault.DefaultClient{
+					func() *vault.DefaultClient {
+						server.TokenTTL = 0
+						client, _ := newClient(g, server, vault.Auth{Method: vault.AuthMethodToken, Token: "s.periodic"})
+						return client
+					}(),
+					func() *vault.DefaultClient {
+						client, _ := newClient(g, server, vault.Auth{Method: vault.AuthMethodToken, Token: "s.periodic"})
+						client.Client = failing
+						return client
+					}(),
+				} {
+					for i := 0; i < 2; i++ {
+						value, err := client.Read(ctx, "vault://kv/dns#cf_token")
+						g.Expect(err).NotTo(HaveOccurred())
+						g.Expect(value).To(Equal("token123"))
+					}
+				}
+				g.Expect(count(server, "POST auth/token/renew-self")).To(Equal(2))
+
+				// Neither is looked up again
+				g.Expect(count(server, "GET auth/token/lookup-self")).To(Equal(2))
+				g.Expect(failing.failures).To(Equal(1))
+			},
+		},
+		{
+			testCase: "renews leased secrets and fetches them again when renewal fails",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				server := mocks.NewMockVaultServer()
+				defer server.Close()
+				server.AddToken("root")
+				server.SetLeased("database/creds/dns", map[string]interface{}{"password": "pass123"}, 60)
+
+				client, now := newClient(g, server, vault.Auth{Method: vault.AuthMethodToken, Token: "root"})
+
+				for i := 0; i < 2; i++ {
+					value, err := client.Read(ctx, "vault://database/creds/dns#password")
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(value).To(Equal("pass123"))
+				}
+				g.Expect(count(server, "GET database/creds/dns")).To(Equal(1))
+
+				now.now = now.now.Add(45 * time.Second)
+				_, err := client.Read(ctx, "vault://database/creds/dns#password")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(count(server, "PUT sys/leases/renew")).To(Equal(1))
+				g.Expect(count(server, "GET database/creds/dns")).To(Equal(1))
+
+				// The lease is gone, so the secret is fetched again
+				server.SetKV1("database/creds/dns", map[string]interface{}{"password": "pass456"})
+				now.now = now.now.Add(45 * time.Second)
+				value, err := client.Read(ctx, "vault://database/creds/dns#password")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(value).To(Equal("pass456"))
+				g.Expect(count(server, "PUT sys/leases/renew")).To(Equal(2))
+				g.Expect(count(server, "GET database/creds/dns")).To(Equal(2))
+			},
+		},
+		{
+			testCase: "returns errors from the server",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				server := mocks.NewMockVaultServer()
+				defer server.Close()
+				server.AddToken("root")
+				server.SetKV1("kv/empty", nil)
+
+				client, _ := newClient(g, server, vault.Auth{Method: vault.AuthMethodToken, Token: "wrong"})
+				_, err := client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).To(MatchError("vault returned 403 for kv/dns: permission denied"))
+
+				client, _ = newClient(g, server, vault.Auth{Method: vault.AuthMethodToken, Token: "root"})
+				_, err = client.Read(ctx, "vault://kv/empty#cf_token")
+				g.Expect(err).To(MatchError("no data in Vault secret kv/empty"))
+
+				_, err = client.Read(ctx, "vault://kv/dns")
+				g.Expect(err).To(MatchError(`missing #<key> in Vault reference "vault://kv/dns"`))
+
+				client, _ = newClient(g, server, vault.Auth{Method: vault.AuthMethodAppRole, RoleID: "foo", SecretID: "bar"})
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).To(MatchError("logging in to Vault with approle auth: vault returned 400 for auth/approle/login: invalid credentials"))
+
+				client, _ = newClient(g, server, vault.Auth{Method: vault.AuthMethodToken, Token: "root"}, func(client *vault.DefaultClient) error {
+					client.Client = &failingHTTPClient{}
+					return nil
+				})
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).To(MatchError("foo"))
+			},
+		},
+		{
+			testCase: "returns errors from invalid responses",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				responses := map[string]string{}
+				statuses := map[string]int{}
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					path := strings.TrimPrefix(r.URL.Path, "/v1/")
+					if status, ok := statuses[path]; ok {
+						w.WriteHeader(status)
+					}
+					_, _ = w.Write([]byte(responses[path]))
+				}))
+				defer server.Close()
+
+				responses["kv/invalid"] = "not json"
+				statuses["kv/unavailable"] = http.StatusServiceUnavailable
+				responses["auth/approle/login"] = `{"auth": null}`
+
+				client, err := vault.NewClient(server.URL, vault.Auth{Method: vault.AuthMethodToken, Token: "root"})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				_, err = client.Read(ctx, "vault://kv/invalid#cf_token")
+				g.Expect(err).To(MatchError(ContainSubstring("invalid Vault response for kv/invalid: ")))
+
+				_, err = client.Read(ctx, "vault://kv/unavailable#cf_token")
+				g.Expect(err).To(MatchError("vault returned 503 for kv/unavailable"))
+
+				client, err = vault.NewClient(server.URL, vault.Auth{Method: vault.AuthMethodAppRole, RoleID: "foo", SecretID: "bar"})
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).To(MatchError("logging in to Vault with approle auth: no token returned"))
+
+				client, err = vault.NewClient("http://\x7f", vault.Auth{Method: vault.AuthMethodToken, Token: "root"})
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = client.Read(ctx, "vault://kv/dns#cf_token")
+				g.Expect(err).To(MatchError(ContainSubstring("invalid control character in URL")))
+			},
+		},
+		{
+			testCase: "parses references",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				path, key, err := vault.ParseReference("vault://secret/data/dns#cf_token")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(path).To(Equal("secret/data/dns"))
+				g.Expect(key).To(Equal("cf_token"))
+
+				for _, reference := range []string{"secret/data/dns#cf_token", "https://secret/data/dns#cf_token", "vault:///dns#cf_token", "vault://%zz"} {
+					_, _, err = vault.ParseReference(reference)
+					g.Expect(err).To(MatchError(ContainSubstring("invalid Vault reference")))
+				}
+			},
+		},
+		{
+			testCase: "validates the auth method",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, err := vault.NewClient("", vault.Auth{Method: vault.AuthMethodToken, Token: "root"})
+				g.Expect(err).To(MatchError("a Vault address is required"))
+
+				tests := map[string]vault.Auth{
+					"token auth requires a Vault token":               {Method: vault.AuthMethodToken},
+					"approle auth requires a role ID and a secret ID": {Method: vault.AuthMethodAppRole, RoleID: "foo"},
+					"kubernetes auth requires a role":                 {Method: vault.AuthMethodKubernetes},
+					"unsupported Vault auth method: ldap":             {Method: "ldap"},
+				}
+				for message, auth := range tests {
+					_, err = vault.NewClient("http://localhost:8200", auth)
+					g.Expect(err).To(MatchError(message))
+				}
+
+				client, err := vault.NewClient("http://localhost:8200", vault.Auth{Method: vault.AuthMethodKubernetes, Role: "qrkdns"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Auth.Mount).To(Equal("kubernetes"))
+				g.Expect(client.Auth.JWTPath).To(Equal(vault.DefaultJWTPath))
+				g.Expect(client.Client).To(Equal(&http.Client{Timeout: vault.DefaultTimeout}))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, err := vault.NewClient("http://localhost:8200", vault.Auth{Method: vault.AuthMethodToken, Token: "root"}, func(client *vault.DefaultClient) error {
+					return errors.New("foo")
+				})
+				g.Expect(err).To(MatchError("foo"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
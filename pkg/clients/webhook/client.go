@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+)
+
+var (
+	_ notify.Notifier = &DefaultClient{}
+)
+
+const (
+	// DefaultTemplate posts the full event as JSON
+	DefaultTemplate string = "{{ json . }}"
+
+	// DefaultTimeout bounds a request to the webhook, so that an
+	// unresponsive receiver can't hold a sync up
+	DefaultTimeout time.Duration = 10 * time.Second
+)
+
+// DefaultClient implements a generic JSON webhook notifier
+type DefaultClient struct {
+	URL      string
+	Template *template.Template
+	// Client       *http.Client
+	Client HTTPClient
+}
+
+// LoadOption allows for modifying the client after it's created
+type LoadOption func(client *DefaultClient) error
+
+// NewClient returns a new webhook client. The body template is rendered with
+// the event as its data, and may use the `json` function to encode values.
+func NewClient(url, bodyTemplate string, opts ...LoadOption) (*DefaultClient, error) {
+	if bodyTemplate == "" {
+		bodyTemplate = DefaultTemplate
+	}
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(bodyTemplate)
+	if err != nil {
+		return &DefaultClient{}, err
+	}
+
+	client := DefaultClient{
+		URL:      url,
+		Template: tmpl,
+		Client:   &http.Client{Timeout: DefaultTimeout},
+	}
+	for _, opt := range opts {
+		if err := opt(&client); err != nil {
+			return &DefaultClient{}, err
+		}
+	}
+	return &client, nil
+}
+
+// Notify renders the event and POSTs it to the webhook
+func (c *DefaultClient) Notify(ctx context.Context, event notify.Event) error {
+	body := bytes.Buffer{}
+	if err := c.Template.Execute(&body, &event); err != nil {
+		return err
+	}
+	return Post(ctx, c.Client, c.URL, body.Bytes())
+}
+
+// Post sends a JSON payload to the given URL and checks the response status
+func Post(ctx context.Context, client HTTPClient, url string, payload []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("received status code %v: %v", response.StatusCode, string(bytes.TrimSpace(body)))
+	}
+	return nil
+}
+
+// toJSON encodes a value for use inside a template
+func toJSON(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,184 @@
+package webhook_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+	"github.com/markliederbach/qrkdns/pkg/clients/webhook"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+)
+
+type testRunner struct {
+	testCase string
+	runner   func(tt *testing.T)
+}
+
+func withMockHTTPClient(client *webhook.DefaultClient) error {
+	client.Client = &mocks.MockHTTPClient{}
+	return nil
+}
+
+// recordingHTTPClient captures the last request body
+type recordingHTTPClient struct {
+	body string
+}
+
+func (r *recordingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.body = string(body)
+	return &http.Response{StatusCode: 204, Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func TestFile(t *testing.T) {
+	event := notify.Event{Type: notify.EventTypeIPChanged, Name: "bar.foo.net", OldIP: "1.1.1.1", NewIP: "2.2.2.2"}
+
+	tests := []testRunner{
+		{
+			testCase: "posts the event as json by default",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				recorder := &recordingHTTPClient{}
+
+				client, err := webhook.NewClient("http://hook", "", func(client *webhook.DefaultClient) error {
+					client.Client = recorder
+					return nil
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Notify(context.Background(), event)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(recorder.body).To(ContainSubstring(`"type":"ip_changed"`))
+				g.Expect(recorder.body).To(ContainSubstring(`"new_ip":"2.2.2.2"`))
+			},
+		},
+		{
+			testCase: "renders a custom template",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				recorder := &recordingHTTPClient{}
+
+				client, err := webhook.NewClient("http://hook", `{"msg": {{ json .Summary }}}`, func(client *webhook.DefaultClient) error {
+					client.Client = recorder
+					return nil
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Notify(context.Background(), event)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(recorder.body).To(Equal(`{"msg": "qrkdns: bar.foo.net changed from 1.1.1.1 to 2.2.2.2"}`))
+			},
+		},
+		{
+			testCase: "returns error for invalid template",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := webhook.NewClient("http://hook", "{{ .Nope ")
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "returns error from template execution",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := webhook.NewClient("http://hook", "{{ .Missing }}", withMockHTTPClient)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Notify(context.Background(), event)
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "returns error encoding values",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := webhook.NewClient("http://hook", "{{ json .Time }}", withMockHTTPClient)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				// Times past year 9999 have no JSON encoding
+				late := event
+				late.Time = time.Date(10000, time.January, 1, 0, 0, 0, 0, time.UTC)
+				err = client.Notify(context.Background(), late)
+				g.Expect(err).To(MatchError(ContainSubstring("year outside of range [0,9999]")))
+			},
+		},
+		{
+			testCase: "bounds requests with a timeout by default",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := webhook.NewClient("http://hook", "")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Client).To(Equal(&http.Client{Timeout: webhook.DefaultTimeout}))
+			},
+		},
+		{
+			testCase: "returns error from load option",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				_, err := webhook.NewClient("http://hook", "", func(client *webhook.DefaultClient) error {
+					return fmt.Errorf("oh no")
+				})
+				g.Expect(err).To(MatchError("oh no"))
+			},
+		},
+		{
+			testCase: "returns error from request builder",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := webhook.NewClient("://bad", "", withMockHTTPClient)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Notify(context.Background(), event)
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "returns error from http client",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := webhook.NewClient("http://hook", "", withMockHTTPClient)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = envy.AddErrorReturns("Do", fmt.Errorf("unreachable"))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Notify(context.Background(), event)
+				g.Expect(err).To(MatchError("unreachable"))
+			},
+		},
+		{
+			testCase: "returns error for unsuccessful status code",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				client, err := webhook.NewClient("http://hook", "", withMockHTTPClient)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = envy.AddObjectReturns(
+					"Do",
+					&http.Response{
+						StatusCode: 500,
+						Body:       io.NopCloser(strings.NewReader("broken\n")),
+					},
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				err = client.Notify(context.Background(), event)
+				g.Expect(err).To(MatchError("received status code 500: broken"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -1,8 +1,11 @@
 package controllers
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/markliederbach/qrkdns/pkg/clients/envfile"
+	"github.com/markliederbach/qrkdns/pkg/clients/secrets"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
@@ -10,6 +13,14 @@ import (
 const (
 	// LogLevelFlag wraps the name of the command flag
 	LogLevelFlag string = "log-level"
+
+	// ConfigFileFlag wraps the name of the command flag
+	ConfigFileFlag string = "config-file"
+)
+
+var (
+	// configEnvironment holds the variables applied from the config file
+	configEnvironment = envfile.NewEnvironment()
 )
 
 // NewQrkDNSApp creates a new CLI app
@@ -27,17 +38,46 @@ func NewQrkDNSApp(version string, commands []*cli.Command) *cli.App {
 				Usage:   "Set the log output",
 				EnvVars: []string{"LOG_LEVEL"},
 			},
+			&cli.StringFlag{
+				Name:    ConfigFileFlag,
+				Usage:   "File of KEY=VALUE environment variables configuring the commands, reloaded by `sync cron` on SIGHUP. Variables already set in the environment take precedence",
+				EnvVars: []string{"CONFIG_FILE"},
+			},
 		},
 		Before: func(c *cli.Context) error {
-			log.SetFormatter(&log.JSONFormatter{})
+			// Secrets never reach the logs, whichever line they end up in
+			log.SetFormatter(&secrets.Formatter{
+				Formatter: &log.JSONFormatter{},
+				Redactor:  secrets.DefaultRedactor,
+			})
 			logrusLevel, err := log.ParseLevel(c.String(LogLevelFlag))
 			if err != nil {
 				return err
 			}
 			log.SetLevel(logrusLevel)
+			if err := loadConfigFile(c.String(ConfigFileFlag)); err != nil {
+				log.WithError(err).Error("Failed to load config file")
+				return err
+			}
 			log.WithField("version", version).Debug("Running qrkdns")
 			return nil
 		},
 		Commands: commands,
+		// Leave exit codes to the caller rather than exiting inside Run
+		ExitErrHandler: func(c *cli.Context, err error) {},
+	}
+}
+
+// loadConfigFile applies the config file, if any, to the environment the
+// command flags are read from
+func loadConfigFile(path string) error {
+	values := map[string]string{}
+	if path != "" {
+		var err error
+		values, err = envfile.Load(path)
+		if err != nil {
+			return fmt.Errorf("config file %v: %w", path, err)
+		}
 	}
+	return configEnvironment.Apply(values)
 }
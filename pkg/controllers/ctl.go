@@ -0,0 +1,460 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/control"
+	"github.com/markliederbach/qrkdns/pkg/clients/dampening"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/election"
+	"github.com/markliederbach/qrkdns/pkg/clients/health"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	"github.com/markliederbach/qrkdns/pkg/clients/scheduler"
+	"github.com/markliederbach/qrkdns/pkg/clients/secrets"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// ControlClientOptions is used by testing to inject a mock client option
+	ControlClientOptions = []control.LoadOption{}
+	// ControlServerOptions is used by testing to inject a mock server option
+	ControlServerOptions = []control.ServerLoadOption{}
+)
+
+const (
+	// ControlAddressFlag wraps the name of the command flag
+	ControlAddressFlag string = "control-address"
+
+	// ControlTokenFlag wraps the name of the command flag
+	ControlTokenFlag string = "control-token"
+
+	// LimitFlag wraps the name of the command flag
+	LimitFlag string = "limit"
+)
+
+// controlFlags returns the flags locating and authenticating the control API
+func controlFlags() []cli.Flag {
+	return flagsOf(
+		[]cli.Flag{
+			&cli.StringFlag{
+				Name:    ControlAddressFlag,
+				Usage:   "Unix socket (unix:/path) or loopback address of the control API of `sync cron`. Empty disables the API",
+				EnvVars: []string{"CONTROL_ADDRESS"},
+			},
+		},
+		secretFlags(
+			&cli.StringFlag{
+				Name:    ControlTokenFlag,
+				Usage:   "Token authenticating requests to the control API",
+				EnvVars: []string{"CONTROL_TOKEN"},
+			},
+			"control API token",
+		),
+	)
+}
+
+// CtlCommand returns the command controlling a running `sync cron`
+func CtlCommand() *cli.Command {
+	ctlFlags := func(extra ...cli.Flag) []cli.Flag {
+		return flagsOf(controlFlags(), vaultFlags(), []cli.Flag{outputFlag()}, extra)
+	}
+	return &cli.Command{
+		Name:  "ctl",
+		Usage: "Control a running `sync cron` through its control API",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "sync",
+				Usage:  "Sync right away, waiting for any sync in progress",
+				Flags:  ctlFlags(),
+				Action: ctlSync,
+			},
+			{
+				Name:  "pause",
+				Usage: "Skip scheduled syncs until resumed",
+				Flags: ctlFlags(),
+				Action: func(c *cli.Context) error {
+					return ctlStatus(c, control.Controller.Pause)
+				},
+			},
+			{
+				Name:  "resume",
+				Usage: "Run scheduled syncs again",
+				Flags: ctlFlags(),
+				Action: func(c *cli.Context) error {
+					return ctlStatus(c, control.Controller.Resume)
+				},
+			},
+			{
+				Name:  "status",
+				Usage: "Show the state of the agent and its last sync",
+				Flags: ctlFlags(),
+				Action: func(c *cli.Context) error {
+					return ctlStatus(c, control.Controller.Status)
+				},
+			},
+			{
+				Name:  "history",
+				Usage: "Show the agent's most recent history entries",
+				Flags: ctlFlags(&cli.IntFlag{
+					Name:  LimitFlag,
+					Usage: "Number of entries to show",
+					Value: control.DefaultHistoryLimit,
+				}),
+				Action: ctlHistory,
+			},
+		},
+	}
+}
+
+// buildController creates the client of the control API
+func buildController(c *cli.Context) (control.Controller, error) {
+	options, err := stringsOrError(c, "using the control API", ControlAddressFlag, ControlTokenFlag)
+	if err != nil {
+		return nil, err
+	}
+	return control.NewClient(options[ControlAddressFlag], options[ControlTokenFlag], ControlClientOptions...)
+}
+
+// ctlSync asks the agent to sync, failing if the sync did
+func ctlSync(c *cli.Context) error {
+	controller, err := buildController(c)
+	if err != nil {
+		return err
+	}
+	run, err := controller.Sync(c.Context)
+	if err != nil {
+		return err
+	}
+	if err := writeRun(c.App.Writer, c.String(OutputFlag), run); err != nil {
+		return err
+	}
+	if run.Error != "" {
+		return fmt.Errorf("sync failed: %v", run.Error)
+	}
+	return nil
+}
+
+// ctlStatus performs an operation returning the state of the agent
+func ctlStatus(c *cli.Context, operation func(control.Controller, context.Context) (control.Status, error)) error {
+	controller, err := buildController(c)
+	if err != nil {
+		return err
+	}
+	status, err := operation(controller, c.Context)
+	if err != nil {
+		return err
+	}
+	return writeAgentStatus(c.App.Writer, c.String(OutputFlag), status)
+}
+
+// ctlHistory prints the agent's most recent history entries
+func ctlHistory(c *cli.Context) error {
+	controller, err := buildController(c)
+	if err != nil {
+		return err
+	}
+	entries, err := controller.History(c.Context, c.Int(LimitFlag))
+	if err != nil {
+		return err
+	}
+	return writeHistory(c.App.Writer, c.String(OutputFlag), historyOutput{
+		Entries: entries,
+		Stats:   history.Summarize(entries, time.Now()),
+	})
+}
+
+// writeRun prints the outcome of a sync in the requested format
+func writeRun(w io.Writer, format string, run control.Run) error {
+	switch format {
+	case OutputFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(run)
+	case OutputFormatTable:
+		fmt.Fprintln(w, describeRun(run))
+		writeResults(w, run.Results)
+		if run.Internal != nil {
+			fmt.Fprintf(w, "Internal view: published %v\n", run.Internal.IP)
+			writeResults(w, run.Internal.Results)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format: %v", format)
+	}
+}
+
+// writeAgentStatus prints the state of the agent in the requested format
+func writeAgentStatus(w io.Writer, format string, status control.Status) error {
+	switch format {
+	case OutputFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(status)
+	case OutputFormatTable:
+		fmt.Fprintf(w, "Schedule: %v\n", status.Schedule)
+		fmt.Fprintf(w, "Paused: %v\n", yesNo(status.Paused))
+		fmt.Fprintf(w, "Running: %v\n", yesNo(status.Running))
+		fmt.Fprintf(w, "Started: %v\n", status.Started.Format(time.RFC3339))
+		fmt.Fprintf(w, "Syncs: %v\n", status.Runs)
+		if status.LastRun != nil {
+			fmt.Fprintf(w, "Last sync: %v\n", describeRun(*status.LastRun))
+		}
+		if status.LastReload != nil {
+			fmt.Fprintf(w, "Last reload: %v\n", describeReload(*status.LastReload))
+		}
+		if status.Election != nil {
+			fmt.Fprintf(w, "Role: %v\n", describeElection(*status.Election))
+		}
+		if len(status.Health) > 0 {
+			fmt.Fprintln(w, "Failover:")
+			for _, target := range status.Health {
+				fmt.Fprintf(w, "  %v: %v\n", target.Address, describeTarget(target))
+			}
+		}
+		if status.Rejections > 0 {
+			fmt.Fprintf(w, "Rejected addresses: %v\n", status.Rejections)
+		}
+		if status.Dampening != nil {
+			fmt.Fprintf(w, "Dampening: %v\n", describeDampening(*status.Dampening))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format: %v", format)
+	}
+}
+
+// describeDampening summarizes the dampening state on a single line
+func describeDampening(state dampening.State) string {
+	description := fmt.Sprintf("%v change(s) suppressed", state.Suppressed)
+	if state.Candidate != "" {
+		description += fmt.Sprintf(", %v pending after %v observation(s)", state.Candidate, state.Observations)
+	}
+	return description
+}
+
+// describeRun summarizes a sync on a single line
+func describeRun(run control.Run) string {
+	outcome := "published " + orDash(run.IP)
+	if run.Error != "" {
+		outcome = "failed: " + run.Error
+	}
+	return fmt.Sprintf(
+		"%v (%v, took %v) %v",
+		run.Started.Format(time.RFC3339),
+		run.Trigger,
+		run.Finished.Sub(run.Started).Round(time.Millisecond),
+		outcome,
+	)
+}
+
+// writeResults prints the outcome of applying each A record
+func writeResults(w io.Writer, results []dns.ApplyResult) {
+	for _, result := range results {
+		fmt.Fprintf(w, "  %v: %v (%v)\n", result.Record.Name, result.Record.Content, describeResult(result.Changed()))
+	}
+}
+
+// describeReload summarizes a reload on a single line
+func describeReload(reload control.Reload) string {
+	if reload.Error != "" {
+		return fmt.Sprintf("%v rejected: %v", reload.Time.Format(time.RFC3339), reload.Error)
+	}
+	return fmt.Sprintf("%v applied", reload.Time.Format(time.RFC3339))
+}
+
+// describeElection summarizes the outcome of the last election on a single line
+func describeElection(state election.State) string {
+	description := string(state.Role)
+	if state.Leader != nil && state.Role == election.RoleFollower {
+		description += " of " + state.Leader.Holder
+	}
+	if state.Error != "" {
+		description += ", failed: " + state.Error
+	}
+	return description
+}
+
+// describeTarget summarizes the health of a failover address on a single line
+func describeTarget(target health.Target) string {
+	description := string(target.Status)
+	if target.Error != "" {
+		description += ", last check failed: " + target.Error
+	}
+	return description
+}
+
+// describeResult tells whether applying a record changed anything
+func describeResult(changed bool) string {
+	if changed {
+		return "changed"
+	}
+	return "unchanged"
+}
+
+// agent is the `sync cron` process, as seen through the control API
+type agent struct {
+	// c and syncer are swapped by reloads, holding both locks
+	c         *cli.Context
+	syncer    *syncer
+	scheduler *scheduler.DefaultClient
+	// syncing serializes scheduled and requested syncs
+	syncing sync.Mutex
+	// mu guards the status
+	mu     sync.Mutex
+	status control.Status
+}
+
+var (
+	// Assert the agent matches the correct interface
+	_ control.Agent = &agent{}
+)
+
+// newAgent wraps the syncer shared by every sync of the schedule
+func newAgent(c *cli.Context, s *syncer, client *scheduler.DefaultClient) *agent {
+	return &agent{
+		c:         c,
+		syncer:    s,
+		scheduler: client,
+		status: control.Status{
+			Schedule: c.String(ScheduleFlag),
+			Started:  time.Now(),
+			Election: s.electionState(),
+		},
+	}
+}
+
+// scheduled is the job run by the scheduler, skipped while paused
+func (a *agent) scheduled(c *cli.Context) error {
+	if a.Status().Paused {
+		log.Info("Syncs are paused, skipping scheduled sync")
+		return nil
+	}
+	_, err := a.sync(control.TriggerSchedule)
+	return err
+}
+
+// sync performs a sync once no other sync is in progress
+func (a *agent) sync(trigger control.Trigger) (control.Run, error) {
+	a.syncing.Lock()
+	defer a.syncing.Unlock()
+
+	a.mu.Lock()
+	a.status.Running = true
+	a.mu.Unlock()
+
+	run := control.Run{Trigger: trigger, Started: time.Now()}
+	public, internal, err := a.syncer.apply(a.c)
+	run.Finished = time.Now()
+	run.IP = public.IP
+	run.Results = public.Results
+	run.Internal = internal
+	if err != nil {
+		run.Error = secrets.DefaultRedactor.Redact(err.Error())
+	}
+
+	a.mu.Lock()
+	a.status.Running = false
+	a.status.Runs++
+	a.status.LastRun = &run
+	a.status.Election = a.syncer.electionState()
+	a.status.Rejections = a.syncer.rejections
+	a.mu.Unlock()
+	return run, err
+}
+
+// Sync implements control.Agent. The sync runs for the agent rather than
+// the request, so it completes even if the caller goes away.
+func (a *agent) Sync(ctx context.Context) control.Run {
+	log.Info("Sync requested through the control API")
+	run, _ := a.sync(control.TriggerControl)
+	return run
+}
+
+// Pause implements control.Agent
+func (a *agent) Pause() control.Status {
+	log.Info("Scheduled syncs paused through the control API")
+	return a.setPaused(true)
+}
+
+// Resume implements control.Agent
+func (a *agent) Resume() control.Status {
+	log.Info("Scheduled syncs resumed through the control API")
+	return a.setPaused(false)
+}
+
+// setPaused pauses or resumes scheduled syncs
+func (a *agent) setPaused(paused bool) control.Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.status.Paused = paused
+	return a.status
+}
+
+// Status implements control.Agent. The health of the failover addresses is
+// checked in the background, so it is read as the status is.
+func (a *agent) Status() control.Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	status := a.status
+	status.Health = a.syncer.healthState()
+	status.Dampening = a.syncer.dampeningState()
+	return status
+}
+
+// History implements control.Agent
+func (a *agent) History(limit int) ([]history.Entry, error) {
+	a.mu.Lock()
+	historyClient := a.syncer.history
+	a.mu.Unlock()
+	if historyClient == nil {
+		return nil, errors.New("history is disabled, set --state-dir to enable it")
+	}
+	entries, err := historyClient.Query(history.Filter{})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
+
+// serveControl serves the control API of the agent, if enabled, until
+// the returned function is called
+func serveControl(c *cli.Context, a *agent) (func(), error) {
+	address := c.String(ControlAddressFlag)
+	if address == "" {
+		return func() {}, nil
+	}
+	options, err := stringsOrError(c, "serving the control API", ControlTokenFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := control.NewServer(a, options[ControlTokenFlag], ControlServerOptions...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build control server")
+		return nil, err
+	}
+	listener, err := control.Listen(address)
+	if err != nil {
+		log.WithError(err).Error("Failed to listen for the control API")
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(c.Context)
+	go func() {
+		if err := server.Serve(ctx, listener); err != nil {
+			log.WithError(err).Error("Control API stopped")
+		}
+	}()
+	log.WithField("address", address).Info("Serving control API")
+	return cancel, nil
+}
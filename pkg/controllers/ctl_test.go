@@ -0,0 +1,372 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/go-co-op/gocron"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/control"
+	"github.com/markliederbach/qrkdns/pkg/clients/scheduler"
+	"github.com/markliederbach/qrkdns/pkg/clients/secrets"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+// blockingScheduler runs the scheduled job whenever the test asks, and
+// blocks until the test is done
+type blockingScheduler struct {
+	mu        sync.Mutex
+	job       func(*cli.Context) error
+	ctx       *cli.Context
+	schedules []string
+	trigger   chan struct{}
+	errors    chan error
+}
+
+func (s *blockingScheduler) Do(jobFun interface{}, params ...interface{}) (*gocron.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.job = jobFun.(func(*cli.Context) error)
+	s.ctx = params[0].(*cli.Context)
+	return &gocron.Job{}, nil
+}
+
+func (s *blockingScheduler) Cron(cronExpression string) *gocron.Scheduler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules = append(s.schedules, cronExpression)
+	return nil
+}
+
+func (s *blockingScheduler) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.job = nil
+}
+
+func (s *blockingScheduler) StartBlocking() {
+	for range s.trigger {
+		s.mu.Lock()
+		job, ctx := s.job, s.ctx
+		s.mu.Unlock()
+		s.errors <- job(ctx)
+	}
+}
+
+// replaced returns the schedules the job was moved to
+func (s *blockingScheduler) replaced() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.schedules
+}
+
+// run runs the scheduled job once
+func (s *blockingScheduler) run() error {
+	s.trigger <- struct{}{}
+	return <-s.errors
+}
+
+// queueSync queues the answers of a successful sync of the network to the
+// mocks
+func queueSync(g *WithT, networkID, ipAddress string) {
+	g.Expect(envy.AddObjectReturns("Do", ipResponse(ipAddress))).To(Succeed())
+	g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+	applied := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord(networkID, "foo.net", ipAddress))
+	g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: applied})).To(Succeed())
+}
+
+// startAgent runs `sync cron` with the control API on a unix socket,
+// returning its scheduler, a function running `ctl` against it and a
+// function stopping it
+func startAgent(tt *testing.T, g *WithT, extra map[string]string) (*blockingScheduler, func(args ...string) (string, error), func()) {
+	blocking := &blockingScheduler{trigger: make(chan struct{}), errors: make(chan error)}
+	schedulerOptions := controllers.SchedulerClientOptions
+	controllers.SchedulerClientOptions = []scheduler.LoadOption{
+		func(client *scheduler.DefaultClient) error {
+			client.Client = blocking
+			return nil
+		},
+	}
+
+	values := map[string]string{
+		"NETWORK_ID":            "home",
+		"DOMAIN_NAME":           "foo.net",
+		"CLOUDFLARE_ACCOUNT_ID": "foo",
+		"CLOUDFLARE_API_TOKEN":  "bar",
+		"SCHEDULE":              "*/5 * * * *",
+		"CONTROL_ADDRESS":       control.UnixPrefix + filepath.Join(tt.TempDir(), "control.sock"),
+		"CONTROL_TOKEN":         "s3cret",
+	}
+	for key, value := range extra {
+		// Empty values leave the variable to the config file
+		if value == "" {
+			delete(values, key)
+			continue
+		}
+		values[key] = value
+	}
+	env := envy.MockEnv{}
+	g.Expect(env.Load(values)).To(Succeed())
+
+	// The agent builds its control server once the cli package is done
+	// setting it up
+	ready := make(chan struct{})
+	var once sync.Once
+	serverOptions := controllers.ControlServerOptions
+	controllers.ControlServerOptions = append(
+		slices.Clone(serverOptions),
+		func(server *control.DefaultServer) error {
+			once.Do(func() { close(ready) })
+			return nil
+		},
+	)
+
+	done := make(chan error, 1)
+	go func() {
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+		done <- app.Run([]string{"qrkdns", "sync", "cron"})
+	}()
+
+	// The cli package shares state between apps, so no other app may run
+	// until the agent is set up
+	g.Eventually(ready, 5*time.Second).Should(BeClosed())
+
+	ctl := func(args ...string) (string, error) {
+		output := &bytes.Buffer{}
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.CtlCommand()})
+		app.Writer = output
+		err := app.Run(append([]string{"qrkdns", "ctl"}, args...))
+		return output.String(), err
+	}
+
+	// Wait for the API to come up
+	g.Eventually(func() error {
+		_, err := ctl("status")
+		return err
+	}, 5*time.Second, 10*time.Millisecond).Should(Succeed())
+
+	stop := func() {
+		close(blocking.trigger)
+		g.Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+		env.Restore()
+		controllers.SchedulerClientOptions = schedulerOptions
+		controllers.ControlServerOptions = serverOptions
+	}
+	return blocking, ctl, stop
+}
+
+func TestCtl(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	tests := []testRunner{
+		{
+			testCase: "controls a running agent",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				blocking, ctl, stop := startAgent(tt, g, map[string]string{"STATE_DIR": stateDir})
+				defer stop()
+
+				output, err := ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(ContainSubstring("Schedule: */5 * * * *\nPaused: no\nRunning: no\n"))
+				g.Expect(output).To(ContainSubstring("Syncs: 0\n"))
+
+				queueSync(g, "home", "1.2.3.4")
+				g.Expect(blocking.run()).To(Succeed())
+
+				output, err = ctl("pause")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(ContainSubstring("Paused: yes\n"))
+				g.Expect(output).To(MatchRegexp(`Last sync: \S+ \(schedule, took \S+\) published 1.2.3.4\n`))
+
+				// Paused agents skip the schedule, but still sync on request
+				g.Expect(blocking.run()).To(Succeed())
+				queueSync(g, "home", "1.2.3.5")
+				output, err = ctl("sync")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(MatchRegexp(`\(control, took \S+\) published 1.2.3.5\n  home.foo.net: 1.2.3.5 \(changed\)\n`))
+
+				output, err = ctl("resume", "--output", "json")
+				g.Expect(err).NotTo(HaveOccurred())
+				status := control.Status{}
+				g.Expect(json.Unmarshal([]byte(output), &status)).To(Succeed())
+				g.Expect(status.Paused).To(BeFalse())
+				g.Expect(status.Runs).To(Equal(2))
+				g.Expect(status.LastRun.Trigger).To(Equal(control.TriggerControl))
+
+				// Failures don't leak secrets through the control API
+				secrets.DefaultRedactor.Add("c7l-secret")
+				g.Expect(envy.AddErrorReturns("Do", errors.New("boom c7l-secret"))).To(Succeed())
+				output, err = ctl("sync", "--output", "json")
+				g.Expect(err).To(MatchError("sync failed: boom [REDACTED]"))
+				run := control.Run{}
+				g.Expect(json.Unmarshal([]byte(output), &run)).To(Succeed())
+				g.Expect(run.Error).To(Equal("boom [REDACTED]"))
+
+				output, err = ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(MatchRegexp(`Last sync: \S+ \(control, took \S+\) failed: boom \[REDACTED\]\n`))
+
+				// Records already pointing to the address are left alone
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.5"))).To(Succeed())
+				current := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("home", "foo.net", "1.2.3.5"))
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{current})).To(Succeed())
+				output, err = ctl("sync")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(ContainSubstring("  home.foo.net: 1.2.3.5 (unchanged)\n"))
+
+				output, err = ctl("history", "--limit", "2")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(MatchRegexp(`ip_changed\s+home.foo.net\s+1.2.3.4\s+1.2.3.5`))
+				g.Expect(output).To(MatchRegexp(`record_created\s+home.foo.net\s+-\s+1.2.3.5`))
+				g.Expect(output).NotTo(ContainSubstring("1.2.3.4  -"))
+
+				_, err = ctl("status", "--output", "yaml")
+				g.Expect(err).To(MatchError("unsupported output format: yaml"))
+				queueSync(g, "home", "1.2.3.5")
+				_, err = ctl("sync", "--output", "yaml")
+				g.Expect(err).To(MatchError("unsupported output format: yaml"))
+				_, err = ctl("status", "--control-token", "wrong")
+				g.Expect(err).To(MatchError("control API answered 401 Unauthorized: invalid token"))
+
+				history, err := os.OpenFile(filepath.Join(stateDir, "history.jsonl"), os.O_APPEND|os.O_WRONLY, 0o600)
+				g.Expect(err).NotTo(HaveOccurred())
+				_, err = history.WriteString("nope\n")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(history.Close()).To(Succeed())
+				_, err = ctl("history")
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "reports a disabled history",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, ctl, stop := startAgent(tt, g, nil)
+				defer stop()
+
+				_, err := ctl("history")
+				g.Expect(err).To(MatchError("control API answered 500 Internal Server Error: history is disabled, set --state-dir to enable it"))
+			},
+		},
+		{
+			testCase: "logs failures to stop serving",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				options := controllers.ControlServerOptions
+				controllers.ControlServerOptions = []control.ServerLoadOption{func(server *control.DefaultServer) error {
+					server.ShutdownTimeout = 0
+					return nil
+				}}
+				defer func() { controllers.ControlServerOptions = options }()
+
+				_, ctl, stop := startAgent(tt, g, nil)
+				_, err := ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+
+				// A client that never finishes its request outlives the timeout
+				conn, err := net.Dial("unix", strings.TrimPrefix(os.Getenv("CONTROL_ADDRESS"), control.UnixPrefix))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer conn.Close()
+				_, err = conn.Write([]byte("GET "))
+				g.Expect(err).NotTo(HaveOccurred())
+				time.Sleep(100 * time.Millisecond)
+				stop()
+			},
+		},
+		{
+			testCase: "returns error for invalid configuration",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				repeating, restore := withRepeatingScheduler(0)
+				defer restore()
+
+				cron := func(extra map[string]string) error {
+					values := map[string]string{
+						"NETWORK_ID":            "home",
+						"DOMAIN_NAME":           "foo.net",
+						"CLOUDFLARE_ACCOUNT_ID": "foo",
+						"CLOUDFLARE_API_TOKEN":  "bar",
+						"SCHEDULE":              "*/5 * * * *",
+					}
+					for key, value := range extra {
+						values[key] = value
+					}
+					env := envy.MockEnv{}
+					g.Expect(env.Load(values)).To(Succeed())
+					defer env.Restore()
+
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+					return app.Run([]string{"qrkdns", "sync", "cron"})
+				}
+
+				g.Expect(cron(nil)).To(Succeed())
+				g.Expect(repeating.job).NotTo(BeNil())
+
+				err := cron(map[string]string{"CONTROL_ADDRESS": "127.0.0.1:0"})
+				g.Expect(err).To(MatchError("options [--control-token] are required when serving the control API"))
+
+				err = cron(map[string]string{"CONTROL_ADDRESS": "0.0.0.0:0", "CONTROL_TOKEN": "s3cret"})
+				g.Expect(err).To(MatchError("control address 0.0.0.0:0 is neither a unix socket nor a loopback address"))
+
+				options := controllers.ControlServerOptions
+				controllers.ControlServerOptions = []control.ServerLoadOption{func(server *control.DefaultServer) error {
+					return errors.New("foo")
+				}}
+				err = cron(map[string]string{"CONTROL_ADDRESS": "127.0.0.1:0", "CONTROL_TOKEN": "s3cret"})
+				controllers.ControlServerOptions = options
+				g.Expect(err).To(MatchError("foo"))
+
+				// Serving stops along with the schedule
+				g.Expect(cron(map[string]string{"CONTROL_ADDRESS": "127.0.0.1:0", "CONTROL_TOKEN": "s3cret"})).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.CtlCommand()})
+				for _, command := range []string{"sync", "pause", "resume", "status", "history"} {
+					err = app.Run([]string{"qrkdns", "ctl", command})
+					g.Expect(err).To(MatchError("options [--control-address, --control-token] are required when using the control API"), command)
+				}
+
+				// No agent is listening
+				for _, command := range []string{"sync", "status", "history"} {
+					err = app.Run([]string{"qrkdns", "ctl", command, "--control-address", "127.0.0.1:1", "--control-token", "s3cret"})
+					g.Expect(err).To(HaveOccurred(), command)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dampening"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// DampeningClientOptions is used by testing to inject a mock client option
+	DampeningClientOptions = []dampening.LoadOption{}
+)
+
+const (
+	// DampenObservationsFlag wraps the name of the command flag
+	DampenObservationsFlag string = "dampen-observations"
+
+	// DampenHoldFlag wraps the name of the command flag
+	DampenHoldFlag string = "dampen-hold"
+
+	// DampenMaxChangesFlag wraps the name of the command flag
+	DampenMaxChangesFlag string = "dampen-max-changes-per-hour"
+)
+
+// dampeningFlags returns the flags used to configure flap dampening
+func dampeningFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:    DampenObservationsFlag,
+			Usage:   "Only publish a new address once it was discovered this many times in a row",
+			EnvVars: []string{"DAMPEN_OBSERVATIONS"},
+		},
+		&cli.DurationFlag{
+			Name:    DampenHoldFlag,
+			Usage:   "Only publish a new address once it was held this long. Either this or --dampen-observations being met is enough",
+			EnvVars: []string{"DAMPEN_HOLD"},
+		},
+		&cli.IntFlag{
+			Name:    DampenMaxChangesFlag,
+			Usage:   "Maximum number of address changes published per hour",
+			EnvVars: []string{"DAMPEN_MAX_CHANGES_PER_HOUR"},
+		},
+	}
+}
+
+// buildDampener creates the dampening client from the command flags, or
+// returns nil if dampening is disabled. Without a state directory, the
+// dampening state only lasts as long as the process.
+func buildDampener(c *cli.Context) (*dampening.DefaultClient, error) {
+	policy := dampening.Policy{
+		Observations:      c.Int(DampenObservationsFlag),
+		Hold:              c.Duration(DampenHoldFlag),
+		MaxChangesPerHour: c.Int(DampenMaxChangesFlag),
+	}
+	if !policy.Enabled() {
+		return nil, nil
+	}
+	return dampening.NewClient(c.String(StateDirFlag), policy, DampeningClientOptions...)
+}
+
+// dampen decides whether the discovered address is stable enough to
+// replace the published records, logging changes that are held back
+func (s *syncer) dampen(ctx context.Context, c *cli.Context, dnsClient dns.Provider, externalIP string) (bool, error) {
+	records, err := dnsClient.GetDNSARecords(ctx, asciiName(c.String(NetworkIDFlag)))
+	if err != nil {
+		log.WithError(err).Error("Failed to list DNS A records")
+		return false, err
+	}
+	published := []string{}
+	for _, record := range records {
+		published = append(published, record.Content)
+	}
+
+	decision, err := s.dampener.Observe(published, externalIP)
+	if err != nil {
+		log.WithError(err).Error("Failed to update dampening state")
+		return false, err
+	}
+	if !decision.Publish {
+		s.suppressions++
+		log.WithFields(log.Fields{
+			"ip":           externalIP,
+			"published":    published,
+			"reason":       decision.Reason,
+			"suppressions": s.suppressions,
+		}).Warn("Suppressing address change")
+	}
+	return decision.Publish, nil
+}
+
+// dampeningState returns the persisted dampening state, if enabled
+func (s *syncer) dampeningState() *dampening.State {
+	if s.dampener == nil {
+		return nil
+	}
+	state, err := s.dampener.State()
+	if err != nil {
+		log.WithError(err).Warn("Failed to read dampening state")
+		return nil
+	}
+	return &state
+}
@@ -0,0 +1,321 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/dampening"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+func TestDampening(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+	controllers.ResolverClientOptions = append(
+		controllers.ResolverClientOptions,
+		withMockResolver,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	dampenEnv := func(extra map[string]string) map[string]string {
+		env := map[string]string{
+			"NETWORK_ID":            "bar",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"SCHEDULE":              "* * * * *",
+			"DAMPEN_OBSERVATIONS":   "2",
+		}
+		for key, value := range extra {
+			env[key] = value
+		}
+		return env
+	}
+
+	recordFor := func(content string) sdk.DNSRecord {
+		return cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("bar", "foo.net", content))
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "holds back flapping addresses in cron mode",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				env := envy.MockEnv{}
+				err := env.Load(dampenEnv(map[string]string{"STATE_DIR": stateDir}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				repeating, restore := withRepeatingScheduler(3)
+				defer restore()
+
+				// The second observation of 5.6.7.8 is published, the bounce back isn't
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"), ipResponse("5.6.7.8"), ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns(
+					"DNSRecords",
+					[]sdk.DNSRecord{recordFor("1.2.3.4")},
+					[]sdk.DNSRecord{recordFor("1.2.3.4")},
+					[]sdk.DNSRecord{recordFor("1.2.3.4")},
+					[]sdk.DNSRecord{},
+					[]sdk.DNSRecord{recordFor("5.6.7.8")},
+				)).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: recordFor("5.6.7.8")})).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync", "cron"})).To(Succeed())
+				g.Expect(repeating.errors).To(Equal([]error{nil, nil, nil}))
+
+				client, err := dampening.NewClient(stateDir, dampening.Policy{})
+				g.Expect(err).NotTo(HaveOccurred())
+				state, err := client.State()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state.Candidate).To(Equal("1.2.3.4"))
+				g.Expect(state.Observations).To(Equal(1))
+				g.Expect(state.Changes).To(HaveLen(1))
+				g.Expect(state.Suppressed).To(Equal(2))
+				g.Expect(state.LastReason).To(Equal("observed 1 of 2 times"))
+
+				// The pending change shows up in the status
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{recordFor("5.6.7.8")})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("LookupIP", []net.IP{net.ParseIP("5.6.7.8")})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				app = controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.StatusCommand()})
+				app.Writer = output
+				g.Expect(app.Run([]string{"qrkdns", "status"})).To(HaveOccurred())
+				g.Expect(output.String()).To(MatchRegexp(`Pending change for bar\.foo\.net: 1\.2\.3\.4 observed 1 times since \S+`))
+				g.Expect(output.String()).To(ContainSubstring("Suppressed changes: 2\n"))
+				g.Expect(output.String()).To(ContainSubstring("(observed 1 of 2 times)"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{recordFor("5.6.7.8")})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("LookupIP", []net.IP{net.ParseIP("5.6.7.8")})).To(Succeed())
+
+				output.Reset()
+				g.Expect(app.Run([]string{"qrkdns", "status", "--output", "json"})).To(HaveOccurred())
+				statuses := []map[string]interface{}{}
+				g.Expect(json.Unmarshal(output.Bytes(), &statuses)).To(Succeed())
+				g.Expect(statuses[0]["dampening"]).To(HaveKeyWithValue("candidate", "1.2.3.4"))
+				g.Expect(statuses[0]["dampening"]).To(HaveKeyWithValue("suppressed", 2.0))
+			},
+		},
+		{
+			testCase: "persists observations across single syncs",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				env := envy.MockEnv{}
+				err := env.Load(dampenEnv(map[string]string{"STATE_DIR": stateDir}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"), ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns(
+					"DNSRecords",
+					[]sdk.DNSRecord{recordFor("1.2.3.4")},
+					[]sdk.DNSRecord{recordFor("1.2.3.4")},
+					[]sdk.DNSRecord{recordFor("1.2.3.4")},
+				)).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: recordFor("5.6.7.8")})).To(Succeed())
+
+				for _, suppressed := range []int{1, 1} {
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+					g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+
+					client, err := dampening.NewClient(stateDir, dampening.Policy{})
+					g.Expect(err).NotTo(HaveOccurred())
+					state, err := client.State()
+					g.Expect(err).NotTo(HaveOccurred())
+					g.Expect(state.Suppressed).To(Equal(suppressed))
+				}
+
+				client, err := dampening.NewClient(stateDir, dampening.Policy{})
+				g.Expect(err).NotTo(HaveOccurred())
+				state, err := client.State()
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(state.Candidate).To(BeEmpty())
+				g.Expect(state.Changes).To(HaveLen(1))
+			},
+		},
+		{
+			testCase: "holds back changes to internationalized names",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &memorySDKClient{records: []sdk.DNSRecord{
+					cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("xn--br-via", "foo.net", "1.2.3.4")),
+				}}
+				defer withSDKClient(sdkClient)()
+
+				env := envy.MockEnv{}
+				err := env.Load(dampenEnv(map[string]string{"NETWORK_ID": "bär"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{"xn--br-via.foo.net 1.2.3.4"}))
+			},
+		},
+		{
+			testCase: "returns errors reading the published records and state",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				env := envy.MockEnv{}
+				err := env.Load(dampenEnv(map[string]string{"STATE_DIR": stateDir}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(envy.AddErrorReturns("DNSRecords", errors.New("foo"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError("foo"))
+
+				g.Expect(os.WriteFile(filepath.Join(stateDir, dampening.FileName), []byte("{"), 0o600)).To(Succeed())
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{recordFor("1.2.3.4")})).To(Succeed())
+
+				app = controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError(ContainSubstring(dampening.FileName)))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{recordFor("1.2.3.4")})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("LookupIP", []net.IP{net.ParseIP("1.2.3.4")})).To(Succeed())
+
+				app = controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.StatusCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "status"})).To(MatchError(ContainSubstring(dampening.FileName)))
+			},
+		},
+		{
+			testCase: "only warns when the published change can't be recorded",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(dampenEnv(map[string]string{"STATE_DIR": tt.TempDir(), "DAMPEN_OBSERVATIONS": "1", "DAMPEN_MAX_CHANGES_PER_HOUR": "5"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				// Block the state file from being replaced once the change is applied
+				options := controllers.DampeningClientOptions
+				controllers.DampeningClientOptions = []dampening.LoadOption{
+					func(client *dampening.DefaultClient) error {
+						calls := 0
+						client.Now = func() time.Time {
+							calls++
+							if calls == 2 {
+								g.Expect(os.Mkdir(client.Path+".tmp", 0o700)).To(Succeed())
+							}
+							return time.Now()
+						}
+						return nil
+					},
+				}
+				defer func() { controllers.DampeningClientOptions = options }()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns(
+					"DNSRecords",
+					[]sdk.DNSRecord{recordFor("1.2.3.4")},
+					[]sdk.DNSRecord{recordFor("1.2.3.4")},
+				)).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: recordFor("5.6.7.8")})).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+			},
+		},
+		{
+			testCase: "reports suppressed changes to the control API",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				sdkClient := &memorySDKClient{records: []sdk.DNSRecord{
+					{ID: "a", Type: "A", Name: "home.foo.net", Content: "1.2.3.4"},
+				}}
+				defer withSDKClient(sdkClient)()
+
+				blocking, ctl, stop := startAgent(tt, g, map[string]string{
+					"DAMPEN_OBSERVATIONS": "2",
+					"STATE_DIR":           stateDir,
+				})
+				defer stop()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(blocking.run()).To(Succeed())
+				output, err := ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(ContainSubstring("Dampening: 1 change(s) suppressed, 5.6.7.8 pending after 1 observation(s)\n"))
+				g.Expect(sdkClient.published("A")).To(Equal([]string{"home.foo.net 1.2.3.4"}))
+
+				// An unreadable state is left out
+				g.Expect(os.WriteFile(filepath.Join(stateDir, dampening.FileName), []byte("{"), 0o600)).To(Succeed())
+				output, err = ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).NotTo(ContainSubstring("Dampening:"))
+			},
+		},
+		{
+			testCase: "returns errors building the dampener",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(dampenEnv(map[string]string{"STATE_DIR": tt.TempDir()}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				options := controllers.DampeningClientOptions
+				controllers.DampeningClientOptions = []dampening.LoadOption{
+					func(client *dampening.DefaultClient) error {
+						return errors.New("foo")
+					},
+				}
+				defer func() { controllers.DampeningClientOptions = options }()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError("foo"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{recordFor("1.2.3.4")})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("LookupIP", []net.IP{net.ParseIP("1.2.3.4")})).To(Succeed())
+
+				app = controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.StatusCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "status"})).To(MatchError("foo"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/doctor"
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// DoctorClientOptions is used by testing to inject a mock client option
+	DoctorClientOptions = []doctor.LoadOption{}
+)
+
+const (
+	// ClockURLFlag wraps the name of the command flag
+	ClockURLFlag string = "clock-url"
+
+	// DoctorExitCodeFailed is returned by the doctor command when a check
+	// fails. Errors running the checks exit with 1.
+	DoctorExitCodeFailed int = 2
+)
+
+// DoctorCommand returns the command checking the configuration before
+// anything is synced
+func DoctorCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "doctor",
+		Usage: "Check the provider credentials and permissions, the IP source and the clock",
+		Flags: flagsOf(
+			providerFlags(),
+			discoveryFlags(),
+			internalFlags(),
+			[]cli.Flag{
+				&cli.StringFlag{
+					Name:    ClockURLFlag,
+					Usage:   "Server whose Date header the local clock is compared with",
+					EnvVars: []string{"CLOCK_URL"},
+					Value:   "https://api.cloudflare.com",
+				},
+				outputFlag(),
+			},
+		),
+		Action: runDoctor,
+	}
+}
+
+// runDoctor runs every check and prints the report, failing if any check failed
+func runDoctor(c *cli.Context) error {
+	ctx, cancel, err := withTimeout(c)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	doctorClient, err := doctor.NewClient(DoctorClientOptions...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build doctor client")
+		return err
+	}
+
+	checks := []doctor.Check{}
+	diagnoser, check := buildDiagnoser(c)
+	checks = append(checks, check)
+	if diagnoser != nil {
+		checks = append(checks, diagnoser.Diagnose(ctx)...)
+	}
+
+	sources := []string{c.String(IPSourceFlag)}
+	if len(c.StringSlice(InternalNamesFlag)) > 0 && c.String(InternalIPSourceFlag) != sources[0] {
+		sources = append(sources, c.String(InternalIPSourceFlag))
+	}
+	for _, source := range sources {
+		check, err := checkIPSource(ctx, c, doctorClient, source)
+		if err != nil {
+			return err
+		}
+		checks = append(checks, check)
+	}
+	checks = append(checks, doctorClient.CheckClock(ctx, c.String(ClockURLFlag)))
+
+	if err = writeDoctorReport(c.App.Writer, c.String(OutputFlag), checks); err != nil {
+		return err
+	}
+	if failed := doctor.Failed(checks); failed > 0 {
+		return cli.Exit(fmt.Sprintf("%v of %v checks failed", failed, len(checks)), DoctorExitCodeFailed)
+	}
+	return nil
+}
+
+// localIPSource discovers the address of an interface for the doctor checks
+type localIPSource struct {
+	client *ip.LocalClient
+}
+
+// GetExternalIPAddress implements doctor.IPSource
+func (s localIPSource) GetExternalIPAddress(ctx context.Context) (string, error) {
+	return s.client.GetLocalIPAddress(ctx)
+}
+
+// checkIPSource checks that the source answers with an IPv4 address
+func checkIPSource(ctx context.Context, c *cli.Context, doctorClient *doctor.DefaultClient, source string) (doctor.Check, error) {
+	kind, name, err := ip.ParseSource(source)
+	if err != nil {
+		return doctor.Check{}, err
+	}
+	if kind == ip.SourceHTTP {
+		opts, err := ipClientOptions(c)
+		if err != nil {
+			return doctor.Check{}, err
+		}
+		ipClient, err := ip.NewClient(c.String(IPServiceURLFlag), opts...)
+		if err != nil {
+			log.WithError(err).Error("Failed to build IP client")
+			return doctor.Check{}, err
+		}
+		return doctorClient.CheckIPSource(ctx, c.String(IPServiceURLFlag), &ipClient), nil
+	}
+
+	client, err := ip.NewLocalClient(name, LocalIPClientOptions...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build local IP client")
+		return doctor.Check{}, err
+	}
+	return doctorClient.CheckIPSource(ctx, source, localIPSource{client: &client}), nil
+}
+
+// buildDiagnoser builds the DNS provider without contacting it, reporting
+// missing options as a failed check
+func buildDiagnoser(c *cli.Context) (doctor.Diagnoser, doctor.Check) {
+	check := doctor.Check{Name: "Provider configuration", Status: doctor.StatusFail}
+	providerType := c.String(ProviderTypeFlag)
+
+	switch dns.ProviderType(providerType) {
+	case dns.ProviderTypeCloudflare:
+		accountID, options, err := cloudflareClientOptions(c, c.String(CloudflareZoneIDFlag))
+		if err != nil {
+			check.Message = err.Error()
+			check.Remedy = "Set the missing options, or their environment variables"
+			return nil, check
+		}
+		client, err := cloudflare.NewLazyClient(accountID, c.String(DomainFlag), options...)
+		if err != nil {
+			check.Message = err.Error()
+			return nil, check
+		}
+		check.Status = doctor.StatusPass
+		check.Message = fmt.Sprintf("using the %v provider for %v", providerType, dns.ToUnicode(domainName(c)))
+		return client, check
+	default:
+		check.Message = fmt.Sprintf("unsupported DNS client: %v", providerType)
+		check.Remedy = fmt.Sprintf("Set --%v to one of: %v", ProviderTypeFlag, getSupportedProvidersString())
+		return nil, check
+	}
+}
+
+// writeDoctorReport prints the checks in the requested format
+func writeDoctorReport(w io.Writer, format string, checks []doctor.Check) error {
+	switch format {
+	case OutputFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(checks)
+	case OutputFormatTable:
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "STATUS\tCHECK\tDETAILS")
+		for _, check := range checks {
+			fmt.Fprintf(table, "%v\t%v\t%v\n", strings.ToUpper(string(check.Status)), check.Name, check.Message)
+			if check.Remedy != "" {
+				fmt.Fprintf(table, "\t\t-> %v\n", check.Remedy)
+			}
+		}
+		return table.Flush()
+	default:
+		return fmt.Errorf("unsupported output format: %v", format)
+	}
+}
@@ -0,0 +1,206 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/doctor"
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+func TestDoctor(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	// The Date header is set to the current time
+	clock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer clock.Close()
+
+	doctorEnv := func(extra map[string]string) map[string]string {
+		env := map[string]string{
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"CLOCK_URL":             clock.URL,
+		}
+		for key, value := range extra {
+			env[key] = value
+		}
+		return env
+	}
+
+	runDoctor := func(g *WithT, extra map[string]string) (string, error) {
+		env := envy.MockEnv{}
+		g.Expect(env.Load(doctorEnv(extra))).To(Succeed())
+		defer env.Restore()
+
+		output := &bytes.Buffer{}
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.DoctorCommand()})
+		app.Writer = output
+		err := app.Run([]string{"qrkdns", "doctor"})
+		return output.String(), err
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "passes every check",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+
+				output, err := runDoctor(g, map[string]string{})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(MatchRegexp(`STATUS\s+CHECK\s+DETAILS`))
+				g.Expect(output).To(MatchRegexp(`PASS\s+Provider configuration\s+using the cloudflare provider for foo.net`))
+				g.Expect(output).To(MatchRegexp(`PASS\s+Account ownership\s+the foo.net zone belongs to account foo`))
+				g.Expect(output).To(MatchRegexp(`PASS\s+IP source http://checkip.amazonaws.com\s+answered 1.2.3.4`))
+				g.Expect(output).To(MatchRegexp(`PASS\s+Clock skew`))
+				g.Expect(output).NotTo(ContainSubstring("FAIL"))
+			},
+		},
+		{
+			testCase: "checks every configured IP source",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				defer withInterfacePrefixes("192.168.1.10/24")()
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				output, err := runDoctor(g, map[string]string{
+					"INTERNAL_NAMES":     "nas",
+					"INTERNAL_IP_SOURCE": "interface:eth0",
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(MatchRegexp(`PASS\s+IP source http://checkip.amazonaws.com\s+answered 1.2.3.4`))
+				g.Expect(output).To(MatchRegexp(`PASS\s+IP source interface:eth0\s+answered 192.168.1.10`))
+
+				// The service isn't checked when the address is read from an interface
+				output, err = runDoctor(g, map[string]string{"IP_SOURCE": "interface:eth0"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).NotTo(ContainSubstring("checkip.amazonaws.com"))
+
+				_, err = runDoctor(g, map[string]string{"IP_SOURCE": "nope"})
+				g.Expect(err).To(MatchError(`invalid IP source "nope", expected http, interface or interface:<name>`))
+
+				options := controllers.LocalIPClientOptions
+				controllers.LocalIPClientOptions = []ip.LocalLoadOption{func(client *ip.LocalClient) error {
+					return errors.New("boom")
+				}}
+				defer func() { controllers.LocalIPClientOptions = options }()
+				_, err = runDoctor(g, map[string]string{"IP_SOURCE": "interface:eth0"})
+				g.Expect(err).To(MatchError("boom"))
+			},
+		},
+		{
+			testCase: "reports failed checks with remedies and a distinct exit code",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				g.Expect(envy.AddObjectReturns("ZoneDetails", sdk.Zone{
+					ID:          "zone1234",
+					Name:        "foo.net",
+					Permissions: []string{"#dns_records:read"},
+					Account:     sdk.Account{ID: "other"},
+				})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("not an address"))).To(Succeed())
+
+				output, err := runDoctor(g, map[string]string{"OUTPUT": "json"})
+				g.Expect(err).To(MatchError("3 of 8 checks failed"))
+				exitCoder, ok := err.(cli.ExitCoder)
+				g.Expect(ok).To(BeTrue())
+				g.Expect(exitCoder.ExitCode()).To(Equal(controllers.DoctorExitCodeFailed))
+
+				checks := []doctor.Check{}
+				g.Expect(json.Unmarshal([]byte(output), &checks)).To(Succeed())
+				failed := map[string]string{}
+				for _, check := range checks {
+					if check.Status == doctor.StatusFail {
+						failed[check.Name] = check.Remedy
+					}
+				}
+				g.Expect(failed).To(HaveKey("DNS edit permission"))
+				g.Expect(failed).To(HaveKeyWithValue("Account ownership", "Set --cf-account-id to other"))
+				g.Expect(failed).To(HaveKey("IP source http://checkip.amazonaws.com"))
+			},
+		},
+		{
+			testCase: "reports provider configuration errors",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				output, err := runDoctor(g, map[string]string{"CLOUDFLARE_API_TOKEN": ""})
+				g.Expect(err).To(MatchError("1 of 3 checks failed"))
+				g.Expect(output).To(MatchRegexp(`FAIL\s+Provider configuration\s+options \[--cf-account-id, --cf-api-token\] are required`))
+				g.Expect(output).To(ContainSubstring("-> Set the missing options"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				output, err = runDoctor(g, map[string]string{"PROVIDER": "foo"})
+				g.Expect(err).To(MatchError("1 of 3 checks failed"))
+				g.Expect(output).To(ContainSubstring("unsupported DNS client: foo"))
+
+				options := controllers.CloudflareClientOptions
+				controllers.CloudflareClientOptions = []cloudflare.LoadOption{func(client *cloudflare.DefaultClient) error {
+					return errors.New("foo")
+				}}
+				defer func() { controllers.CloudflareClientOptions = options }()
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				output, err = runDoctor(g, map[string]string{})
+				g.Expect(err).To(MatchError("1 of 3 checks failed"))
+				g.Expect(output).To(MatchRegexp(`FAIL\s+Provider configuration\s+foo`))
+			},
+		},
+		{
+			testCase: "returns errors running the checks",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				_, err := runDoctor(g, map[string]string{"TIMEOUT": "foo"})
+				g.Expect(err).To(HaveOccurred())
+
+				options := controllers.DoctorClientOptions
+				controllers.DoctorClientOptions = []doctor.LoadOption{func(client *doctor.DefaultClient) error {
+					return errors.New("foo")
+				}}
+				_, err = runDoctor(g, map[string]string{})
+				controllers.DoctorClientOptions = options
+				g.Expect(err).To(MatchError("foo"))
+
+				ipOptions := controllers.IPClientOptions
+				controllers.IPClientOptions = []ip.LoadOption{func(client *ip.DefaultClient) error {
+					return errors.New("foo")
+				}}
+				_, err = runDoctor(g, map[string]string{})
+				controllers.IPClientOptions = ipOptions
+				g.Expect(err).To(MatchError("foo"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				_, err = runDoctor(g, map[string]string{"OUTPUT": "yaml"})
+				g.Expect(err).To(MatchError("unsupported output format: yaml"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/election"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// ElectionClientOptions is used by testing to inject a mock client option
+	ElectionClientOptions = []election.LoadOption{}
+)
+
+const (
+	// ElectionFlag wraps the name of the command flag
+	ElectionFlag string = "election"
+
+	// LeaderLeaseFlag wraps the name of the command flag
+	LeaderLeaseFlag string = "leader-lease"
+
+	// LeaderRenewDeadlineFlag wraps the name of the command flag
+	LeaderRenewDeadlineFlag string = "leader-renew-deadline"
+
+	// electionDNS selects the lock record in the DNS provider
+	electionDNS string = "dns"
+
+	// electionFilePrefix selects a local file lock
+	electionFilePrefix string = "file:"
+)
+
+// electionFlags returns the flags used to elect the agent performing the mutations
+func electionFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    ElectionFlag,
+			Usage:   "Lock electing the only agent that updates the records: dns, a record next to the network ID's name, or file:<path> for agents on the same host. Empty disables the election",
+			EnvVars: []string{"ELECTION"},
+		},
+		&cli.DurationFlag{
+			Name:    LeaderLeaseFlag,
+			Usage:   "How long the lease of the leader lasts without being renewed before another agent takes over",
+			EnvVars: []string{"LEADER_LEASE"},
+			Value:   15 * time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:    LeaderRenewDeadlineFlag,
+			Usage:   "How long the leader keeps updating the records while failing to renew its lease. Shorter than --leader-lease",
+			EnvVars: []string{"LEADER_RENEW_DEADLINE"},
+			Value:   10 * time.Minute,
+		},
+	}
+}
+
+// buildElector creates the election client from the command flags, or
+// returns nil if no election is configured
+func buildElector(c *cli.Context) (*election.DefaultClient, error) {
+	value := c.String(ElectionFlag)
+	if value == "" {
+		return nil, nil
+	}
+
+	var lock election.Lock
+	path, isFile := strings.CutPrefix(value, electionFilePrefix)
+	switch {
+	case value == electionDNS:
+		provider, err := buildDNSProvider(c)
+		if err != nil {
+			return nil, err
+		}
+		lock = election.NewDNSLock(provider, recordName(c))
+	case isFile && path != "":
+		lock = election.NewFileLock(path)
+	default:
+		return nil, fmt.Errorf("invalid election %q, expected %v or %v<path>", value, electionDNS, electionFilePrefix)
+	}
+
+	holder, err := agentID(c)
+	if err != nil {
+		return nil, err
+	}
+	return election.NewClient(lock, holder, c.Duration(LeaderLeaseFlag), c.Duration(LeaderRenewDeadlineFlag), ElectionClientOptions...)
+}
+
+// elect takes part in the leader election, returning whether this agent
+// may update the records. Followers skip the sync.
+func (s *syncer) elect(ctx context.Context) (bool, error) {
+	previous := s.elector.State().Role
+	state, err := s.elector.Elect(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to take part in the leader election")
+		return false, err
+	}
+
+	leader := ""
+	if state.Leader != nil {
+		leader = state.Leader.Holder
+	}
+	contextLog := log.WithFields(log.Fields{
+		"role":   state.Role,
+		"leader": leader,
+		"lock":   s.elector.Lock.String(),
+	})
+	if state.Role != previous {
+		contextLog.Info("Election role changed")
+	}
+	if state.Error != "" {
+		contextLog.WithField("error", state.Error).Warn("Failed to renew the leader lease, leading until the renew deadline")
+	}
+	if state.Role == election.RoleFollower {
+		contextLog.Info("Following the leader, skipping sync")
+		return false, nil
+	}
+	return true, nil
+}
+
+// electionState returns the outcome of the last election, if enabled
+func (s *syncer) electionState() *election.State {
+	if s.elector == nil {
+		return nil
+	}
+	state := s.elector.State()
+	return &state
+}
+
+// inheritElector keeps the leadership across reloads using the same lock,
+// and otherwise releases the lock of the previous syncer
+func (s *syncer) inheritElector(previous *syncer) {
+	if previous.elector == nil || (s.elector != nil && s.elector.Adopt(previous.elector)) {
+		return
+	}
+	if err := previous.elector.Release(context.Background()); err != nil {
+		log.WithError(err).Warn("Failed to release the previous leader lease")
+	}
+}
@@ -0,0 +1,212 @@
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/election"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+// lockSDKClient keeps records in memory, failing to list the lock records
+// while failLocks is set
+type lockSDKClient struct {
+	memorySDKClient
+	failLocks atomic.Bool
+}
+
+func (c *lockSDKClient) DNSRecords(ctx context.Context, zoneID string, rr sdk.DNSRecord) ([]sdk.DNSRecord, error) {
+	if c.failLocks.Load() && strings.HasPrefix(rr.Name, election.LockPrefix) {
+		return nil, errors.New("boom")
+	}
+	return c.memorySDKClient.DNSRecords(ctx, zoneID, rr)
+}
+
+// withSDKClient makes every Cloudflare client use sdkClient until the
+// returned function is called
+func withSDKClient(sdkClient cloudflare.SDKClient) func() {
+	options := controllers.CloudflareClientOptions
+	controllers.CloudflareClientOptions = append(options, func(client *cloudflare.DefaultClient) error {
+		client.Client = sdkClient
+		return nil
+	})
+	return func() { controllers.CloudflareClientOptions = options }
+}
+
+func TestElection(t *testing.T) {
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	// sync runs a single sync of home.foo.net by an agent
+	sync := func(g *WithT, sdkClient cloudflare.SDKClient, extra map[string]string) error {
+		values := map[string]string{
+			"NETWORK_ID":            "home",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"CLOUDFLARE_ZONE_ID":    "zone",
+		}
+		for key, value := range extra {
+			values[key] = value
+		}
+		env := envy.MockEnv{}
+		g.Expect(env.Load(values)).To(Succeed())
+		defer env.Restore()
+		defer withSDKClient(sdkClient)()
+
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+		return app.Run([]string{"qrkdns", "sync"})
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "only the leader updates the records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &memorySDKClient{}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, map[string]string{"ELECTION": "dns", "AGENT_ID": "paris"})).To(Succeed())
+
+				// Followers don't even discover their address
+				g.Expect(sync(g, sdkClient, map[string]string{"ELECTION": "dns", "AGENT_ID": "berlin"})).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{"home.foo.net 1.2.3.4"}))
+
+				locks := []string{}
+				for _, record := range sdkClient.published(dns.RecordTypeTXT) {
+					if strings.HasPrefix(record, election.LockPrefix) {
+						locks = append(locks, record)
+					}
+				}
+				g.Expect(locks).To(HaveLen(1))
+				g.Expect(locks[0]).To(HavePrefix("_qrkdns-leader.home.foo.net heritage=qrkdns,holder=paris,"))
+			},
+		},
+		{
+			testCase: "agents on the same host elect through a file lock",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				extra := map[string]string{"ELECTION": "file:" + filepath.Join(tt.TempDir(), "qrkdns.lock")}
+				sdkClient := &memorySDKClient{}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, extra)).To(Succeed())
+				g.Expect(sync(g, sdkClient, extra)).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{"home.foo.net 1.2.3.4"}))
+			},
+		},
+		{
+			testCase: "reports the role of a running agent",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				now := time.Now()
+				sdkClient := &lockSDKClient{memorySDKClient: memorySDKClient{records: []sdk.DNSRecord{{
+					ID:      "a",
+					Type:    "TXT",
+					Name:    "_qrkdns-leader.home.foo.net",
+					Content: election.LockContent(election.Lease{Holder: "paris", Acquired: now, Renewed: now}),
+				}}}}
+				defer withSDKClient(sdkClient)()
+
+				blocking, ctl, stop := startAgent(tt, g, map[string]string{
+					"ELECTION":           "dns",
+					"AGENT_ID":           "berlin",
+					"CLOUDFLARE_ZONE_ID": "zone",
+				})
+				defer stop()
+
+				output, err := ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(ContainSubstring("Role: follower\n"))
+
+				g.Expect(blocking.run()).To(Succeed())
+				output, err = ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(ContainSubstring("Role: follower of paris\n"))
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "keeps leading through failed renewals",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &lockSDKClient{}
+				defer withSDKClient(sdkClient)()
+
+				blocking, ctl, stop := startAgent(tt, g, map[string]string{
+					"ELECTION":           "dns",
+					"AGENT_ID":           "paris",
+					"CLOUDFLARE_ZONE_ID": "zone",
+				})
+				defer stop()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(blocking.run()).To(Succeed())
+				output, err := ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(ContainSubstring("Role: leader\n"))
+
+				sdkClient.failLocks.Store(true)
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.5"))).To(Succeed())
+				g.Expect(blocking.run()).To(Succeed())
+				output, err = ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(ContainSubstring("Role: leader, failed: boom\n"))
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{"home.foo.net 1.2.3.5"}))
+			},
+		},
+		{
+			testCase: "returns error taking part in the election",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &lockSDKClient{}
+				sdkClient.failLocks.Store(true)
+				err := sync(g, sdkClient, map[string]string{"ELECTION": "dns"})
+				g.Expect(err).To(MatchError("boom"))
+			},
+		},
+		{
+			testCase: "returns error for invalid election settings",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for message, extra := range map[string]map[string]string{
+					`invalid election "nope", expected dns or file:<path>`:                    {"ELECTION": "nope"},
+					`invalid election "file:", expected dns or file:<path>`:                   {"ELECTION": "file:"},
+					"the renew deadline must be positive and shorter than the lease duration": {"ELECTION": "dns", "LEADER_RENEW_DEADLINE": "1h"},
+					`invalid agent ID "paris berlin"`:                                         {"ELECTION": "dns", "AGENT_ID": "paris berlin"},
+					"unsupported DNS client: nope":                                            {"ELECTION": "dns", "PROVIDER": "nope"},
+				} {
+					err := sync(g, &memorySDKClient{}, extra)
+					g.Expect(err).To(MatchError(message), message)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/control"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/health"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// HealthClientOptions is used by testing to inject a mock client option
+	HealthClientOptions = []health.LoadOption{}
+)
+
+const (
+	// FailoverNameFlag wraps the name of the command flag
+	FailoverNameFlag string = "failover-name"
+
+	// FailoverAddressFlag wraps the name of the command flag
+	FailoverAddressFlag string = "failover-address"
+
+	// FailoverBackupFlag wraps the name of the command flag
+	FailoverBackupFlag string = "failover-backup"
+
+	// HealthCheckFlag wraps the name of the command flag
+	HealthCheckFlag string = "health-check"
+
+	// HealthExpectedStatusFlag wraps the name of the command flag
+	HealthExpectedStatusFlag string = "health-expected-status"
+
+	// HealthIntervalFlag wraps the name of the command flag
+	HealthIntervalFlag string = "health-interval"
+
+	// HealthTimeoutFlag wraps the name of the command flag
+	HealthTimeoutFlag string = "health-timeout"
+
+	// HealthyThresholdFlag wraps the name of the command flag
+	HealthyThresholdFlag string = "healthy-threshold"
+
+	// UnhealthyThresholdFlag wraps the name of the command flag
+	UnhealthyThresholdFlag string = "unhealthy-threshold"
+)
+
+// failoverFlags returns the flags used to publish the healthy addresses
+// among a set of candidates
+func failoverFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    FailoverNameFlag,
+			Usage:   "Name, relative to the domain, publishing the healthy addresses among --failover-address",
+			EnvVars: []string{"FAILOVER_NAME"},
+		},
+		&cli.StringSliceFlag{
+			Name:    FailoverAddressFlag,
+			Usage:   "Candidate address of the failover name, published while healthy (repeatable)",
+			EnvVars: []string{"FAILOVER_ADDRESSES"},
+		},
+		&cli.StringFlag{
+			Name:    FailoverBackupFlag,
+			Usage:   "Address published when no candidate is healthy. Without it, the published records are kept",
+			EnvVars: []string{"FAILOVER_BACKUP"},
+		},
+		&cli.StringFlag{
+			Name:    HealthCheckFlag,
+			Usage:   "Health check of the candidates: tcp:<port>, or an http:// or https:// URL requested from each address with the URL's host name",
+			EnvVars: []string{"HEALTH_CHECK"},
+		},
+		&cli.StringFlag{
+			Name:    HealthExpectedStatusFlag,
+			Usage:   "Status codes passing HTTP health checks, e.g., 200,204 or 200-399",
+			EnvVars: []string{"HEALTH_EXPECTED_STATUS"},
+			Value:   "200-399",
+		},
+		&cli.DurationFlag{
+			Name:    HealthIntervalFlag,
+			Usage:   "Time between two health checks of the candidates by `sync cron`",
+			EnvVars: []string{"HEALTH_INTERVAL"},
+			Value:   30 * time.Second,
+		},
+		&cli.DurationFlag{
+			Name:    HealthTimeoutFlag,
+			Usage:   "Time a health check may take before failing",
+			EnvVars: []string{"HEALTH_TIMEOUT"},
+			Value:   5 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:    HealthyThresholdFlag,
+			Usage:   "Consecutive passing checks making an unhealthy candidate healthy again",
+			EnvVars: []string{"HEALTHY_THRESHOLD"},
+			Value:   2,
+		},
+		&cli.IntFlag{
+			Name:    UnhealthyThresholdFlag,
+			Usage:   "Consecutive failing checks making a healthy candidate unhealthy",
+			EnvVars: []string{"UNHEALTHY_THRESHOLD"},
+			Value:   3,
+		},
+	}
+}
+
+// failover publishes the healthy addresses among a set of candidates
+type failover struct {
+	// name is relative to the domain, in its A-label form
+	name   string
+	backup string
+	health *health.DefaultClient
+}
+
+// buildFailover creates the failover from the command flags, or returns
+// nil if no failover name is configured
+func buildFailover(c *cli.Context) (*failover, error) {
+	// `sync cron` checks on the interval even before a failover is configured
+	if c.Duration(HealthIntervalFlag) <= 0 || c.Duration(HealthTimeoutFlag) <= 0 {
+		return nil, fmt.Errorf("--%v and --%v must be positive", HealthIntervalFlag, HealthTimeoutFlag)
+	}
+	if c.String(FailoverNameFlag) == "" {
+		return nil, nil
+	}
+	names, err := asciiNames(c.String(DomainFlag), []string{c.String(FailoverNameFlag)})
+	if err != nil {
+		return nil, err
+	}
+	managed, aliases, err := managedNames(c)
+	if err != nil {
+		return nil, err
+	}
+	name := dns.FQDN(names[0], domainName(c))
+	for _, other := range append(managed, aliases...) {
+		if dns.FQDN(other, domainName(c)) == name {
+			return nil, fmt.Errorf("name %v is configured more than once", dns.ToUnicode(name))
+		}
+	}
+
+	addresses := c.StringSlice(FailoverAddressFlag)
+	for _, address := range addresses {
+		if !isIPv4(address) {
+			return nil, fmt.Errorf("invalid failover address %q", address)
+		}
+	}
+	backup := c.String(FailoverBackupFlag)
+	if backup != "" && !isIPv4(backup) {
+		return nil, fmt.Errorf("invalid failover backup %q", backup)
+	}
+	if c.String(HealthCheckFlag) == "" {
+		return nil, fmt.Errorf("--%v is required with --%v", HealthCheckFlag, FailoverNameFlag)
+	}
+
+	checker, err := health.ParseCheck(c.String(HealthCheckFlag), c.String(HealthExpectedStatusFlag), c.Duration(HealthTimeoutFlag))
+	if err != nil {
+		return nil, err
+	}
+	client, err := health.NewClient(checker, addresses, c.Int(HealthyThresholdFlag), c.Int(UnhealthyThresholdFlag), HealthClientOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return &failover{name: names[0], backup: backup, health: client}, nil
+}
+
+// isIPv4 reports whether address is an IPv4 address, as published by A records
+func isIPv4(address string) bool {
+	parsed := net.ParseIP(address)
+	return parsed != nil && parsed.To4() != nil
+}
+
+// syncFailover publishes the healthy failover addresses, checking them
+// first if they never were. Without any healthy address, the backup is
+// published, if any, and the published records are kept otherwise.
+func (s *syncer) syncFailover(ctx context.Context, c *cli.Context) error {
+	if !s.failover.health.Checked() {
+		s.checkHealth(ctx)
+	}
+
+	name := dns.FQDN(s.failover.name, domainName(c))
+	contextLog := log.WithField("name", dns.ToUnicode(name))
+	addresses := s.failover.health.Healthy()
+	if len(addresses) == 0 {
+		if s.failover.backup == "" {
+			contextLog.Warn("No failover address is healthy, keeping the published records")
+			return nil
+		}
+		contextLog.WithField("backup", s.failover.backup).Warn("No failover address is healthy, publishing the backup")
+		addresses = []string{s.failover.backup}
+	}
+
+	dnsClient, err := buildDNSProvider(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build DNS client")
+		return err
+	}
+	result, err := dns.ApplyARecordSet(ctx, dnsClient, name, addresses)
+	if err != nil {
+		contextLog.WithError(err).Error("Failed to apply failover records")
+		return err
+	}
+	s.record(historyFromSet(c.String(ProviderTypeFlag), result)...)
+	if result.Changed() {
+		contextLog.WithField("addresses", addresses).Info("Failover records applied")
+	}
+
+	err = dns.EnsureOwnership(ctx, dnsClient, name, c.String(OwnerIDFlag))
+	if err != nil {
+		log.WithError(err).Error("Failed to record ownership")
+		return err
+	}
+	return nil
+}
+
+// checkHealth checks every failover address once, logging the changes of
+// health
+func (s *syncer) checkHealth(ctx context.Context) []health.Transition {
+	transitions := s.failover.health.CheckAll(ctx)
+	for _, transition := range transitions {
+		contextLog := log.WithFields(log.Fields{
+			"address": transition.Address,
+			"from":    transition.From,
+			"to":      transition.To,
+			"check":   s.failover.health.Checker.String(),
+		})
+		if transition.To == health.StatusUnhealthy {
+			contextLog.WithField("error", transition.Error).Warn("Failover address became unhealthy")
+			continue
+		}
+		contextLog.Info("Failover address became healthy")
+	}
+	return transitions
+}
+
+// healthState returns the health of the failover addresses, if configured
+func (s *syncer) healthState() []health.Target {
+	if s.failover == nil {
+		return nil
+	}
+	return s.failover.health.Targets()
+}
+
+// inheritHealth keeps the health of the failover addresses across reloads
+func (s *syncer) inheritHealth(previous *syncer) {
+	if s.failover == nil || previous.failover == nil {
+		return
+	}
+	s.failover.health.Adopt(previous.failover.health)
+}
+
+// historyFromSet converts the changes made while applying a record set into
+// history entries, each carrying the records as they were before the apply
+func historyFromSet(providerType string, result dns.SetResult) []history.Entry {
+	entries := []history.Entry{}
+	for _, created := range result.Created {
+		created := created
+		entries = append(entries, history.Entry{
+			Type:     history.EntryTypeRecordCreated,
+			Name:     created.Name,
+			Provider: providerType,
+			NewIP:    created.Content,
+			Record:   &created,
+			Snapshot: result.Previous,
+		})
+	}
+	for _, deleted := range result.Deleted {
+		deleted := deleted
+		entries = append(entries, history.Entry{
+			Type:     history.EntryTypeRecordDeleted,
+			Name:     deleted.Name,
+			Provider: providerType,
+			OldIP:    deleted.Content,
+			Record:   &deleted,
+			Snapshot: result.Previous,
+		})
+	}
+	return entries
+}
+
+// watchHealth checks the failover addresses in the background, syncing
+// right away when one of them changes health, until the returned function
+// is called. Reloads apply from the next check on.
+func watchHealth(a *agent) func() {
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			timer := time.NewTimer(a.healthInterval())
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-timer.C:
+				a.checkHealth()
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// healthInterval returns the time between two health checks of the
+// current configuration
+func (a *agent) healthInterval() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.c.Duration(HealthIntervalFlag)
+}
+
+// checkHealth checks the failover addresses of the current configuration,
+// if any, syncing when one of them changes health unless syncs are paused
+func (a *agent) checkHealth() {
+	a.mu.Lock()
+	c, s := a.c, a.syncer
+	a.mu.Unlock()
+	if s.failover == nil || len(s.checkHealth(c.Context)) == 0 {
+		return
+	}
+	if a.Status().Paused {
+		log.Info("Syncs are paused, skipping failover sync")
+		return
+	}
+	_, _ = a.sync(control.TriggerHealth)
+}
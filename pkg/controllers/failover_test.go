@@ -0,0 +1,307 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/control"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/health"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+// fakeChecker fails the addresses set as failing
+type fakeChecker struct {
+	mu      sync.Mutex
+	failing map[string]bool
+}
+
+func (f *fakeChecker) Check(ctx context.Context, address string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing[address] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (f *fakeChecker) String() string {
+	return "fake"
+}
+
+// fail sets the failing addresses
+func (f *fakeChecker) fail(addresses ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failing = map[string]bool{}
+	for _, address := range addresses {
+		f.failing[address] = true
+	}
+}
+
+// ownerlessSDKClient keeps records in memory, failing to create ownership
+// records
+type ownerlessSDKClient struct {
+	memorySDKClient
+}
+
+func (c *ownerlessSDKClient) CreateDNSRecord(ctx context.Context, zoneID string, rr sdk.DNSRecord) (*sdk.DNSRecordResponse, error) {
+	if rr.Name == dns.OwnershipRecordName("www.foo.net") {
+		return nil, errors.New("boom")
+	}
+	return c.memorySDKClient.CreateDNSRecord(ctx, zoneID, rr)
+}
+
+// withChecker makes every health client use checker until the returned
+// function is called
+func withChecker(checker health.Checker) func() {
+	options := controllers.HealthClientOptions
+	controllers.HealthClientOptions = append(options, func(client *health.DefaultClient) error {
+		client.Checker = checker
+		return nil
+	})
+	return func() { controllers.HealthClientOptions = options }
+}
+
+func TestFailover(t *testing.T) {
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	failover := map[string]string{
+		"FAILOVER_NAME":      "www",
+		"FAILOVER_ADDRESSES": "1.2.3.4,1.2.3.5",
+		"HEALTH_CHECK":       "tcp:443",
+	}
+
+	// sync runs a single sync of home.foo.net and its failover name
+	sync := func(g *WithT, sdkClient cloudflare.SDKClient, extra map[string]string) error {
+		values := map[string]string{
+			"NETWORK_ID":            "home",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"CLOUDFLARE_ZONE_ID":    "zone",
+		}
+		for _, settings := range []map[string]string{failover, extra} {
+			for key, value := range settings {
+				values[key] = value
+			}
+		}
+		env := envy.MockEnv{}
+		g.Expect(env.Load(values)).To(Succeed())
+		defer env.Restore()
+		defer withSDKClient(sdkClient)()
+
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+		return app.Run([]string{"qrkdns", "sync"})
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "publishes the healthy addresses",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				checker := &fakeChecker{}
+				checker.fail("1.2.3.5")
+				defer withChecker(checker)()
+
+				sdkClient := &memorySDKClient{records: []sdk.DNSRecord{
+					{ID: "a", Type: "A", Name: "www.foo.net", Content: "1.2.3.5"},
+				}}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, nil)).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{
+					"home.foo.net 5.6.7.8",
+					"www.foo.net 1.2.3.4",
+				}))
+				g.Expect(sdkClient.published(dns.RecordTypeTXT)).To(ContainElement(ContainSubstring("_qrkdns.www.foo.net")))
+			},
+		},
+		{
+			testCase: "never publishes an empty record set",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				checker := &fakeChecker{}
+				checker.fail("1.2.3.4", "1.2.3.5")
+				defer withChecker(checker)()
+
+				// The published records stay without a backup
+				sdkClient := &memorySDKClient{records: []sdk.DNSRecord{
+					{ID: "a", Type: "A", Name: "www.foo.net", Content: "1.2.3.5"},
+				}}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, nil)).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{
+					"home.foo.net 5.6.7.8",
+					"www.foo.net 1.2.3.5",
+				}))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, map[string]string{"FAILOVER_BACKUP": "9.9.9.9"})).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{
+					"home.foo.net 5.6.7.8",
+					"www.foo.net 9.9.9.9",
+				}))
+			},
+		},
+		{
+			testCase: "syncs when an address changes health",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				checker := &fakeChecker{}
+				defer withChecker(checker)()
+				sdkClient := &memorySDKClient{}
+				defer withSDKClient(sdkClient)()
+
+				// The first checks sync right away
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				extra := map[string]string{
+					"CLOUDFLARE_ZONE_ID":  "zone",
+					"HEALTH_INTERVAL":     "10ms",
+					"HEALTHY_THRESHOLD":   "1",
+					"UNHEALTHY_THRESHOLD": "1",
+				}
+				for key, value := range failover {
+					extra[key] = value
+				}
+				_, ctl, stop := startAgent(tt, g, extra)
+				defer stop()
+
+				status := func() control.Status {
+					output, err := ctl("status", "--output", "json")
+					g.Expect(err).NotTo(HaveOccurred())
+					status := control.Status{}
+					g.Expect(json.Unmarshal([]byte(output), &status)).To(Succeed())
+					return status
+				}
+				g.Eventually(func() int { return status().Runs }, 5*time.Second, 10*time.Millisecond).Should(Equal(1))
+				g.Expect(status().LastRun.Trigger).To(Equal(control.TriggerHealth))
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{
+					"home.foo.net 5.6.7.8",
+					"www.foo.net 1.2.3.4",
+					"www.foo.net 1.2.3.5",
+				}))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				checker.fail("1.2.3.5")
+				g.Eventually(func() int { return status().Runs }, 5*time.Second, 10*time.Millisecond).Should(Equal(2))
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{
+					"home.foo.net 5.6.7.8",
+					"www.foo.net 1.2.3.4",
+				}))
+				targets := status().Health
+				for i := range targets {
+					targets[i].Checked = time.Time{}
+				}
+				g.Expect(targets).To(Equal([]health.Target{
+					{Address: "1.2.3.4", Status: health.StatusHealthy},
+					{Address: "1.2.3.5", Status: health.StatusUnhealthy, Transitions: 1, Error: "connection refused"},
+				}))
+				output, err := ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(ContainSubstring("Failover:\n  1.2.3.4: healthy\n  1.2.3.5: unhealthy, last check failed: connection refused\n"))
+
+				// Paused agents keep checking without syncing
+				_, err = ctl("pause")
+				g.Expect(err).NotTo(HaveOccurred())
+				checker.fail()
+				g.Eventually(func() health.Status { return status().Health[1].Status }, 5*time.Second, 10*time.Millisecond).Should(Equal(health.StatusHealthy))
+				g.Expect(status().Runs).To(Equal(2))
+			},
+		},
+		{
+			testCase: "returns error applying the failover records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				defer withChecker(&fakeChecker{})()
+
+				sdkClient := &memorySDKClient{
+					records:   []sdk.DNSRecord{{ID: "a", Type: "A", Name: "www.foo.net", Content: "1.2.3.6"}},
+					deleteErr: errors.New("boom"),
+				}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, nil)).To(MatchError("boom"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(sync(g, &ownerlessSDKClient{}, nil)).To(MatchError("boom"))
+
+				// Both views fail to build their DNS client
+				err := sync(g, &memorySDKClient{}, map[string]string{"PROVIDER": "foo"})
+				g.Expect(err).To(MatchError("unsupported DNS client: foo\nunsupported DNS client: foo"))
+			},
+		},
+		{
+			testCase: "publishes the failover records when the public sync fails",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				defer withChecker(&fakeChecker{})()
+
+				sdkClient := &memorySDKClient{}
+				g.Expect(envy.AddErrorReturns("Do", errors.New("offline"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, nil)).To(MatchError("offline"))
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{
+					"www.foo.net 1.2.3.4",
+					"www.foo.net 1.2.3.5",
+				}))
+
+				// Both errors are returned
+				sdkClient = &memorySDKClient{
+					records:   []sdk.DNSRecord{{ID: "a", Type: "A", Name: "www.foo.net", Content: "1.2.3.6"}},
+					deleteErr: errors.New("boom"),
+				}
+				g.Expect(envy.AddErrorReturns("Do", errors.New("offline"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, nil)).To(MatchError("offline\nboom"))
+			},
+		},
+		{
+			testCase: "returns error for invalid failover settings",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for message, extra := range map[string]map[string]string{
+					"name home.foo.net is configured more than once":                        {"FAILOVER_NAME": "home"},
+					`invalid failover address "nope"`:                                       {"FAILOVER_ADDRESSES": "1.2.3.4,nope"},
+					`invalid failover address "::1"`:                                        {"FAILOVER_ADDRESSES": "::1"},
+					`invalid failover backup "nope"`:                                        {"FAILOVER_BACKUP": "nope"},
+					"--health-check is required with --failover-name":                       {"HEALTH_CHECK": ""},
+					"--health-interval and --health-timeout must be positive":               {"HEALTH_TIMEOUT": "0s"},
+					`invalid health check "icmp", expected tcp:<port> or an http(s):// URL`: {"HEALTH_CHECK": "icmp"},
+					"the health thresholds must be at least 1":                              {"UNHEALTHY_THRESHOLD": "0"},
+					"at least one address to check is required":                             {"FAILOVER_ADDRESSES": ""},
+					"invalid name -www.foo.net: label -www starts or ends with a hyphen":    {"FAILOVER_NAME": "-www"},
+					"invalid name -home.foo.net: label -home starts or ends with a hyphen":  {"NETWORK_ID": "-home"},
+					`invalid expected status "nope"`:                                        {"HEALTH_CHECK": "http://www.foo.net", "HEALTH_EXPECTED_STATUS": "nope"},
+				} {
+					err := sync(g, &memorySDKClient{}, extra)
+					g.Expect(err).To(MatchError(message), message)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// FollowIPFlag wraps the name of the command flag
+	FollowIPFlag string = "follow-ip"
+
+	// FollowIPNameFlag wraps the name of the command flag
+	FollowIPNameFlag string = "follow-ip-name"
+)
+
+// followFlags returns the flags used to update the other records of the
+// zone pointing at the previous address when it changes
+func followFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:    FollowIPFlag,
+			Usage:   "When the IP changes, also update every A/AAAA record of the zone still pointing at the previous address, except the names managed by qrkdns",
+			EnvVars: []string{"FOLLOW_IP"},
+		},
+		followNameFlag(),
+	}
+}
+
+// followNameFlag returns the flag narrowing down the records following
+// the address changes. Records can't be selected by tag, as the records
+// of the Cloudflare API version qrkdns uses carry no tags.
+func followNameFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:    FollowIPNameFlag,
+		Usage:   "Only follow the records whose name, relative to the domain, matches one of these shell patterns (e.g., vpn or *.office; repeatable)",
+		EnvVars: []string{"FOLLOW_IP_NAMES"},
+	}
+}
+
+// followPatterns returns the validated name patterns of the records
+// following the address changes. Without patterns, every name follows.
+func followPatterns(c *cli.Context) ([]string, error) {
+	patterns := []string{}
+	for _, pattern := range c.StringSlice(FollowIPNameFlag) {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q", pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// previousIP returns the address the network ID's record served before
+// its last update, from the history, so that a sync failing to follow it
+// is made up for by the next one, even in another process
+func previousIP(c *cli.Context, historyClient *history.DefaultClient) (string, error) {
+	entries, err := historyClient.Query(history.Filter{
+		Name:  recordName(c),
+		Types: []history.EntryType{history.EntryTypeRecordCreated, history.EntryTypeRecordUpdated},
+	})
+	if err != nil || len(entries) == 0 {
+		return "", err
+	}
+	return entries[len(entries)-1].OldIP, nil
+}
+
+// addUnfollowed marks previous addresses as served by records that haven't
+// followed the address change yet
+func (s *syncer) addUnfollowed(addresses ...string) {
+	for _, address := range addresses {
+		if address != "" && !slices.Contains(s.unfollowed, address) {
+			s.unfollowed = append(s.unfollowed, address)
+		}
+	}
+}
+
+// followIP points the records of the zone still serving the previous
+// addresses to the new address. The addresses stay unfollowed until every
+// record serving them is updated, so a failing sync is retried by the next
+// one.
+func (s *syncer) followIP(ctx context.Context, c *cli.Context, dnsClient dns.Provider, address string) error {
+	for len(s.unfollowed) > 0 {
+		previous := s.unfollowed[0]
+		if net.ParseIP(previous).Equal(net.ParseIP(address)) {
+			s.unfollowed = s.unfollowed[1:]
+			continue
+		}
+
+		plan, records, err := followPlan(ctx, dnsClient, domainName(c), previous, s.followNames)
+		if err != nil {
+			log.WithError(err).Error("Failed to list the records following the address")
+			return err
+		}
+		for _, record := range plan {
+			contextLog := log.WithFields(log.Fields{"name": dns.ToUnicode(record.Name), "oldIP": previous, "newIP": address})
+			updated, err := pointRecord(ctx, dnsClient, record, address)
+			if err != nil {
+				contextLog.WithError(err).Error("Failed to update the record following the address")
+				return err
+			}
+			s.record(history.Entry{
+				Type:     history.EntryTypeRecordUpdated,
+				Name:     record.Name,
+				Provider: c.String(ProviderTypeFlag),
+				OldIP:    previous,
+				NewIP:    address,
+				Record:   &updated,
+				Snapshot: recordsOf(records, record.Name, record.Type),
+			})
+			contextLog.Info("Record followed the address change")
+		}
+		s.unfollowed = s.unfollowed[1:]
+	}
+	return nil
+}
+
+// followPlan returns the A and AAAA records of the zone pointing at address
+// whose names match the patterns, leaving out the names managed by qrkdns,
+// along with every record of the zone
+func followPlan(ctx context.Context, dnsClient dns.Provider, domain, address string, patterns []string) ([]dns.Record, []dns.Record, error) {
+	records, err := dnsClient.ListRecords(ctx, dns.RecordFilter{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owners := dns.Owners(records)
+	target := net.ParseIP(address)
+	plan := []dns.Record{}
+	for _, record := range records {
+		if record.Type != dns.RecordTypeA && record.Type != dns.RecordTypeAAAA {
+			continue
+		}
+		if !target.Equal(net.ParseIP(record.Content)) || owners[record.Name] != "" {
+			continue
+		}
+		if matchesName(patterns, record.Name, domain) {
+			plan = append(plan, record)
+		}
+	}
+	return plan, records, nil
+}
+
+// matchesName reports whether a name, relative to the domain, matches one
+// of the patterns. Without patterns, every name matches.
+func matchesName(patterns []string, fqdn, domain string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	name, ok := dns.RelativeName(fqdn, domain)
+	if !ok {
+		return false
+	}
+	for _, pattern := range patterns {
+		for _, candidate := range []string{name, dns.ToUnicode(name)} {
+			if matched, _ := path.Match(pattern, candidate); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pointRecord updates the content of a record to address in place
+func pointRecord(ctx context.Context, dnsClient dns.Provider, record dns.Record, address string) (dns.Record, error) {
+	record.Content = address
+	return dnsClient.UpdateRecord(ctx, record)
+}
+
+// recordsOf returns the records with the given name and type
+func recordsOf(records []dns.Record, name string, recordType dns.RecordType) []dns.Record {
+	results := []dns.Record{}
+	for _, record := range records {
+		if record.Name == name && record.Type == recordType {
+			results = append(results, record)
+		}
+	}
+	return results
+}
+
+// followRecords points the records still serving an old address to the
+// new one after confirmation, or only prints them with --dry-run
+func followRecords(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected exactly one address")
+	}
+	previous := c.Args().First()
+	if net.ParseIP(previous) == nil {
+		return fmt.Errorf("invalid address %q", previous)
+	}
+	patterns, err := followPatterns(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(c)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	dnsClient, err := buildDNSProvider(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build DNS client")
+		return err
+	}
+
+	address := c.String(ToFlag)
+	if address == "" {
+		published, err := dnsClient.GetDNSARecords(ctx, asciiName(c.String(NetworkIDFlag)))
+		if err != nil {
+			return err
+		}
+		if len(published) == 0 {
+			return fmt.Errorf("no A record is published for %v, set --%v", dns.ToUnicode(recordName(c)), ToFlag)
+		}
+		address = published[0].Content
+	}
+	if net.ParseIP(address) == nil {
+		return fmt.Errorf("invalid address %q", address)
+	}
+	if isIPv4(previous) != isIPv4(address) {
+		return fmt.Errorf("%v and %v aren't of the same address family", previous, address)
+	}
+
+	plan, _, err := followPlan(ctx, dnsClient, domainName(c), previous, patterns)
+	if err != nil {
+		return err
+	}
+	if c.Bool(DryRunFlag) {
+		return writeRecords(c.App.Writer, OutputFormatTable, toOwnedRecords(plan, ""))
+	}
+	if len(plan) == 0 {
+		fmt.Fprintln(c.App.Writer, "No records to update")
+		return nil
+	}
+	if net.ParseIP(previous).Equal(net.ParseIP(address)) {
+		return fmt.Errorf("the records already point at %v", address)
+	}
+
+	if err := writeRecords(c.App.Writer, OutputFormatTable, toOwnedRecords(plan, "")); err != nil {
+		return err
+	}
+	if err := confirmOrAbort(c, fmt.Sprintf("Point %v record(s) to %v?", len(plan), address)); err != nil {
+		return err
+	}
+
+	for _, record := range plan {
+		log.WithFields(log.Fields{"record": record, "newIP": address}).Info("Updating record")
+		if _, err := pointRecord(ctx, dnsClient, record, address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
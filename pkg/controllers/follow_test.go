@@ -0,0 +1,315 @@
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+// unlistableSDKClient keeps records in memory, failing to list the whole
+// zone
+type unlistableSDKClient struct {
+	memorySDKClient
+}
+
+func (c *unlistableSDKClient) DNSRecords(ctx context.Context, zoneID string, rr sdk.DNSRecord) ([]sdk.DNSRecord, error) {
+	if rr.Name == "" && rr.Type == "" {
+		return nil, errors.New("boom")
+	}
+	return c.memorySDKClient.DNSRecords(ctx, zoneID, rr)
+}
+
+func TestFollow(t *testing.T) {
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	aRecord := func(id, name, content string) sdk.DNSRecord {
+		return sdk.DNSRecord{ID: id, Type: "A", Name: name, Content: content, TTL: 1}
+	}
+
+	// zone holds the published address of home.foo.net, hand-made records
+	// sharing it and records of other owners
+	zone := func() []sdk.DNSRecord {
+		return []sdk.DNSRecord{
+			aRecord("home", "home.foo.net", "1.1.1.1"),
+			{ID: "home-owner", Type: "TXT", Name: dns.OwnershipRecordName("home.foo.net"), Content: dns.OwnershipContent("qrkdns")},
+			aRecord("vpn", "vpn.foo.net", "1.1.1.1"),
+			aRecord("mail", "mail.foo.net", "1.1.1.1"),
+			aRecord("ftp", "ftp.foo.net", "1.1.1.1"),
+			aRecord("git", "git.foo.net", "2.2.2.2"),
+			aRecord("shared", "shared.foo.net", "1.1.1.1"),
+			{ID: "shared-owner", Type: "TXT", Name: dns.OwnershipRecordName("shared.foo.net"), Content: dns.OwnershipContent("someone-else")},
+			aRecord("other", "vpn.other.net", "1.1.1.1"),
+			{ID: "vpn6", Type: "AAAA", Name: "vpn.foo.net", Content: "2001:db8::1", TTL: 1},
+		}
+	}
+
+	settings := func(extra map[string]string) map[string]string {
+		values := map[string]string{
+			"NETWORK_ID":            "home",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"CLOUDFLARE_ZONE_ID":    "zone",
+		}
+		for key, value := range extra {
+			values[key] = value
+		}
+		return values
+	}
+
+	// run runs a qrkdns command against the records of sdkClient
+	run := func(g *WithT, sdkClient cloudflare.SDKClient, extra map[string]string, input string, args ...string) (string, error) {
+		env := envy.MockEnv{}
+		g.Expect(env.Load(settings(extra))).To(Succeed())
+		defer env.Restore()
+		defer withSDKClient(sdkClient)()
+
+		output := &bytes.Buffer{}
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand(), controllers.RecordsCommand()})
+		app.Reader = strings.NewReader(input)
+		app.Writer = output
+		err := app.Run(append([]string{"qrkdns"}, args...))
+		return output.String(), err
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "updates the records still pointing at the previous address",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				sdkClient := &memorySDKClient{records: zone()}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				_, err := run(g, sdkClient, map[string]string{
+					"FOLLOW_IP":       "true",
+					"FOLLOW_IP_NAMES": "vpn,MAIL*",
+					"STATE_DIR":       stateDir,
+				}, "", "sync")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{
+					"ftp.foo.net 1.1.1.1",
+					"git.foo.net 2.2.2.2",
+					"home.foo.net 5.6.7.8",
+					"mail.foo.net 5.6.7.8",
+					"shared.foo.net 1.1.1.1",
+					"vpn.foo.net 5.6.7.8",
+					"vpn.other.net 1.1.1.1",
+				}))
+				// Followed records are updated in place
+				g.Expect(sdkClient.records[1].ID).To(Equal("vpn"))
+				g.Expect(sdkClient.published(dns.RecordTypeAAAA)).To(Equal([]string{"vpn.foo.net 2001:db8::1"}))
+
+				historyClient, err := history.NewClient(stateDir)
+				g.Expect(err).NotTo(HaveOccurred())
+				entries, err := historyClient.Query(history.Filter{Name: "vpn.foo.net"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(HaveLen(1))
+				g.Expect(entries[0].Type).To(Equal(history.EntryTypeRecordUpdated))
+				g.Expect(entries[0].OldIP).To(Equal("1.1.1.1"))
+				g.Expect(entries[0].NewIP).To(Equal("5.6.7.8"))
+				g.Expect(entries[0].Record.ID).To(Equal("vpn"))
+				g.Expect(entries[0].Snapshot).To(Equal([]dns.Record{
+					{ID: "vpn", Type: dns.RecordTypeA, Name: "vpn.foo.net", Content: "1.1.1.1", TTL: 1},
+				}))
+			},
+		},
+		{
+			testCase: "follows every unmanaged name without patterns",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &memorySDKClient{records: zone()}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				_, err := run(g, sdkClient, map[string]string{"FOLLOW_IP": "true"}, "", "sync")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{
+					"ftp.foo.net 5.6.7.8",
+					"git.foo.net 2.2.2.2",
+					"home.foo.net 5.6.7.8",
+					"mail.foo.net 5.6.7.8",
+					"shared.foo.net 1.1.1.1",
+					"vpn.foo.net 5.6.7.8",
+					"vpn.other.net 5.6.7.8",
+				}))
+
+				// Only address changes are followed
+				sdkClient = &memorySDKClient{records: zone()}
+				sdkClient.records[0].Content = "5.6.7.8"
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				_, err = run(g, sdkClient, map[string]string{"FOLLOW_IP": "true"}, "", "sync")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(ContainElement("vpn.foo.net 1.1.1.1"))
+			},
+		},
+		{
+			testCase: "leaves the other records alone by default",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &memorySDKClient{records: zone()}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				_, err := run(g, sdkClient, nil, "", "sync")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(ContainElement("vpn.foo.net 1.1.1.1"))
+			},
+		},
+		{
+			testCase: "returns error following the address",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(envy.AddErrorReturns("UpdateDNSRecord", errors.New("boom"))).To(Succeed())
+				_, err := run(g, &memorySDKClient{records: zone()}, map[string]string{"FOLLOW_IP": "true"}, "", "sync")
+				g.Expect(err).To(MatchError("boom"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				_, err = run(g, &unlistableSDKClient{memorySDKClient{records: zone()}}, map[string]string{"FOLLOW_IP": "true"}, "", "sync")
+				g.Expect(err).To(MatchError("boom"))
+
+				_, err = run(g, &memorySDKClient{}, map[string]string{"FOLLOW_IP_NAMES": "vpn,["}, "", "sync")
+				g.Expect(err).To(MatchError(`invalid name pattern "["`))
+			},
+		},
+		{
+			testCase: "follows the previous address again after a failed sync",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				extra := map[string]string{"FOLLOW_IP": "true", "STATE_DIR": tt.TempDir()}
+				sdkClient := &unlistableSDKClient{memorySDKClient{records: zone()}}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				_, err := run(g, sdkClient, extra, "", "sync")
+				g.Expect(err).To(MatchError("boom"))
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(ContainElements("home.foo.net 5.6.7.8", "vpn.foo.net 1.1.1.1"))
+
+				// The next sync reads the previous address from the history
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				_, err = run(g, &sdkClient.memorySDKClient, extra, "", "sync")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(ContainElements("home.foo.net 5.6.7.8", "vpn.foo.net 5.6.7.8"))
+
+				// An address coming back is followed from the one it replaced
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.1.1.1"))).To(Succeed())
+				_, err = run(g, &sdkClient.memorySDKClient, extra, "", "sync")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(ContainElements("home.foo.net 1.1.1.1", "vpn.foo.net 1.1.1.1"))
+			},
+		},
+		{
+			testCase: "lists the records following an address",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &memorySDKClient{records: zone()}
+				output, err := run(g, sdkClient, nil, "", "records", "follow", "--dry-run", "1.1.1.1")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(MatchRegexp(`vpn\.foo\.net\s+A\s+1\.1\.1\.1\s+1\s+-`))
+				g.Expect(output).To(ContainSubstring("mail.foo.net"))
+				g.Expect(output).To(ContainSubstring("ftp.foo.net"))
+				g.Expect(output).NotTo(ContainSubstring("home.foo.net"))
+				g.Expect(output).NotTo(ContainSubstring("shared.foo.net"))
+				g.Expect(output).To(ContainSubstring("vpn.other.net"))
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(ContainElement("vpn.foo.net 1.1.1.1"))
+			},
+		},
+		{
+			testCase: "points the records to the new address after confirmation",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &memorySDKClient{records: zone()}
+				sdkClient.records[0].Content = "5.6.7.8"
+				_, err := run(g, sdkClient, nil, "n\n", "records", "follow", "1.1.1.1")
+				g.Expect(err).To(MatchError("aborted"))
+
+				output, err := run(g, sdkClient, map[string]string{"FOLLOW_IP_NAMES": "ftp"}, "y\n", "records", "follow", "1.1.1.1")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(ContainSubstring("Point 1 record(s) to 5.6.7.8?"))
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(ContainElements("ftp.foo.net 5.6.7.8", "vpn.foo.net 1.1.1.1"))
+
+				_, err = run(g, sdkClient, nil, "", "records", "follow", "--yes", "--to", "2001:db8::2", "2001:db8::1")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(sdkClient.published(dns.RecordTypeAAAA)).To(Equal([]string{"vpn.foo.net 2001:db8::2"}))
+
+				output, err = run(g, sdkClient, nil, "", "records", "follow", "--to", "2001:db8::2", "2001:db8::1")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(Equal("No records to update\n"))
+			},
+		},
+		{
+			testCase: "returns error following an address",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for message, args := range map[string][]string{
+					"expected exactly one address":                              {},
+					`invalid address "nope"`:                                    {"nope"},
+					`invalid address "2.2.2"`:                                   {"--to", "2.2.2", "1.1.1.1"},
+					"1.1.1.1 and 2001:db8::2 aren't of the same address family": {"--to", "2001:db8::2", "1.1.1.1"},
+					"the records already point at 1.1.1.1":                      {"--to", "1.1.1.1", "1.1.1.1"},
+					`invalid name pattern "["`:                                  {"--follow-ip-name", "[", "1.1.1.1"},
+				} {
+					_, err := run(g, &memorySDKClient{records: zone()}, nil, "", append([]string{"records", "follow"}, args...)...)
+					g.Expect(err).To(MatchError(message), message)
+				}
+
+				g.Expect(envy.AddErrorReturns("UpdateDNSRecord", errors.New("boom"))).To(Succeed())
+				_, err := run(g, &memorySDKClient{records: zone()}, nil, "", "records", "follow", "--yes", "--to", "5.6.7.8", "1.1.1.1")
+				g.Expect(err).To(MatchError("boom"))
+
+				g.Expect(envy.AddErrorReturns("DNSRecords", errors.New("boom"))).To(Succeed())
+				_, err = run(g, &mocks.MockCloudflareSDKClient{}, nil, "", "records", "follow", "1.1.1.1")
+				g.Expect(err).To(MatchError("boom"))
+
+				_, err = run(g, &memorySDKClient{}, nil, "", "records", "follow", "1.1.1.1")
+				g.Expect(err).To(MatchError("no A record is published for home.foo.net, set --to"))
+
+				_, err = run(g, &unlistableSDKClient{memorySDKClient{records: zone()}}, nil, "", "records", "follow", "1.1.1.1")
+				g.Expect(err).To(MatchError("boom"))
+
+				env := envy.MockEnv{}
+				g.Expect(env.Load(settings(nil))).To(Succeed())
+				restore := withSDKClient(&memorySDKClient{records: zone()})
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.RecordsCommand()})
+				app.Writer = failingReadWriter{}
+				err = app.Run([]string{"qrkdns", "records", "follow", "--to", "5.6.7.8", "1.1.1.1"})
+				restore()
+				env.Restore()
+				g.Expect(err).To(MatchError("closed"))
+
+				_, err = run(g, &memorySDKClient{}, map[string]string{"TIMEOUT": "nope"}, "", "records", "follow", "1.1.1.1")
+				g.Expect(err).To(HaveOccurred())
+
+				_, err = run(g, &memorySDKClient{}, map[string]string{"PROVIDER": "nope"}, "", "records", "follow", "1.1.1.1")
+				g.Expect(err).To(MatchError("unsupported DNS client: nope"))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
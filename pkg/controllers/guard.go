@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"github.com/markliederbach/qrkdns/pkg/clients/guard"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// GuardAllowCIDRsFlag wraps the name of the command flag
+	GuardAllowCIDRsFlag string = "guard-allow-cidrs"
+
+	// GuardDenyCIDRsFlag wraps the name of the command flag
+	GuardDenyCIDRsFlag string = "guard-deny-cidrs"
+
+	// GuardAllowReservedFlag wraps the name of the command flag
+	GuardAllowReservedFlag string = "guard-allow-reserved"
+
+	// GuardMMDBFlag wraps the name of the command flag
+	GuardMMDBFlag string = "guard-mmdb"
+
+	// GuardAllowASNsFlag wraps the name of the command flag
+	GuardAllowASNsFlag string = "guard-allow-asns"
+
+	// GuardAllowCountriesFlag wraps the name of the command flag
+	GuardAllowCountriesFlag string = "guard-allow-countries"
+
+	// GuardNotifyFlag wraps the name of the command flag
+	GuardNotifyFlag string = "guard-notify"
+)
+
+// guardFlags returns the flags used to configure the publication guard
+func guardFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    GuardAllowCIDRsFlag,
+			Usage:   "Only publish addresses in these CIDRs. Addresses in them may be private or reserved",
+			EnvVars: []string{"GUARD_ALLOW_CIDRS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    GuardDenyCIDRsFlag,
+			Usage:   "Never publish addresses in these CIDRs (e.g., a VPN provider's ranges)",
+			EnvVars: []string{"GUARD_DENY_CIDRS"},
+		},
+		&cli.BoolFlag{
+			Name:    GuardAllowReservedFlag,
+			Usage:   "Publish private, CGNAT and other reserved addresses",
+			EnvVars: []string{"GUARD_ALLOW_RESERVED"},
+		},
+		&cli.StringSliceFlag{
+			Name:    GuardMMDBFlag,
+			Usage:   "MaxMind or IPinfo mmdb files used to look up the ASN and country of addresses",
+			EnvVars: []string{"GUARD_MMDB"},
+		},
+		&cli.StringSliceFlag{
+			Name:    GuardAllowASNsFlag,
+			Usage:   "Only publish addresses announced by these ASNs (e.g., AS3320). Requires --guard-mmdb",
+			EnvVars: []string{"GUARD_ALLOW_ASNS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    GuardAllowCountriesFlag,
+			Usage:   "Only publish addresses located in these countries (ISO codes, e.g., DE). Requires --guard-mmdb",
+			EnvVars: []string{"GUARD_ALLOW_COUNTRIES"},
+		},
+		&cli.BoolFlag{
+			Name:    GuardNotifyFlag,
+			Usage:   "Send a notification when an address is rejected",
+			EnvVars: []string{"GUARD_NOTIFY"},
+			Value:   true,
+		},
+	}
+}
+
+// buildGuard creates the publication guard from the command flags
+func buildGuard(c *cli.Context) (*guard.DefaultClient, error) {
+	return guard.NewClient(
+		guard.WithAllowedCIDRs(c.StringSlice(GuardAllowCIDRsFlag)),
+		guard.WithDeniedCIDRs(c.StringSlice(GuardDenyCIDRsFlag)),
+		guard.WithReservedRanges(c.Bool(GuardAllowReservedFlag)),
+		guard.WithDatabases(c.StringSlice(GuardMMDBFlag)...),
+		guard.WithAllowedASNs(c.StringSlice(GuardAllowASNsFlag)),
+		guard.WithAllowedCountries(c.StringSlice(GuardAllowCountriesFlag)),
+	)
+}
+
+// reject counts and logs an address refused by the guard. The first
+// rejection of an address in a row is also recorded and notified.
+func (s *syncer) reject(c *cli.Context, rejection *guard.Rejection) {
+	s.rejections++
+	log.WithFields(log.Fields{
+		"ip":         rejection.IP,
+		"reason":     rejection.Reason,
+		"rejections": s.rejections,
+	}).Warn("Refusing to publish address")
+
+	if rejection.IP == s.rejectedIP {
+		return
+	}
+	s.rejectedIP = rejection.IP
+
+	s.record(history.Entry{
+		Type:     history.EntryTypeIPRejected,
+		Name:     recordName(c),
+		Provider: c.String(ProviderTypeFlag),
+		Source:   sourceName(c, c.String(IPSourceFlag)),
+		OldIP:    s.observedIP,
+		NewIP:    rejection.IP,
+		Reason:   rejection.Reason,
+	})
+	if c.Bool(GuardNotifyFlag) {
+		s.notify(c.Context, notify.Event{
+			Type:     notify.EventTypeIPRejected,
+			Name:     recordName(c),
+			Provider: c.String(ProviderTypeFlag),
+			OldIP:    s.observedIP,
+			NewIP:    rejection.IP,
+			Error:    rejection.Reason,
+		})
+	}
+}
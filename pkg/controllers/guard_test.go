@@ -0,0 +1,180 @@
+package controllers_test
+
+import (
+	"testing"
+	"time"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	"github.com/markliederbach/qrkdns/pkg/clients/webhook"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+func TestGuard(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	recorder := &recordingHTTPClient{}
+	webhookOptions := controllers.WebhookClientOptions
+	controllers.WebhookClientOptions = []webhook.LoadOption{
+		func(client *webhook.DefaultClient) error {
+			client.Client = recorder
+			return nil
+		},
+	}
+	defer func() { controllers.WebhookClientOptions = webhookOptions }()
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	guardEnv := func(extra map[string]string) map[string]string {
+		env := map[string]string{
+			"NETWORK_ID":            "bar",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"NOTIFY_WEBHOOK_URL":    "http://hook",
+			"SCHEDULE":              "* * * * *",
+		}
+		for key, value := range extra {
+			env[key] = value
+		}
+		return env
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "rejects reserved addresses without touching dns",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				recorder.bodies = []string{}
+
+				stateDir := tt.TempDir()
+				env := envy.MockEnv{}
+				err := env.Load(guardEnv(map[string]string{"STATE_DIR": stateDir}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				repeating, restore := withRepeatingScheduler(4)
+				defer restore()
+
+				// Only the third address reaches the provider
+				applied := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4"))
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("100.64.0.1"), ipResponse("100.64.0.1"), ipResponse("1.2.3.4"), ipResponse("100.64.0.1"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: applied})).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync", "cron"})).To(Succeed())
+
+				g.Expect(repeating.errors).To(HaveLen(4))
+				g.Expect(repeating.errors[0]).To(MatchError("refusing to publish 100.64.0.1: inside private or reserved range 100.64.0.0/10"))
+				g.Expect(repeating.errors[1]).To(HaveOccurred())
+				g.Expect(repeating.errors[2]).NotTo(HaveOccurred())
+				g.Expect(repeating.errors[3]).To(HaveOccurred())
+
+				// Repeated rejections of the same address are only reported once
+				g.Expect(recorder.bodies).To(HaveLen(3))
+				g.Expect(recorder.bodies[0]).To(ContainSubstring(`"type":"ip_rejected"`))
+				g.Expect(recorder.bodies[0]).To(ContainSubstring(`"new_ip":"100.64.0.1"`))
+				g.Expect(recorder.bodies[1]).To(ContainSubstring(`"type":"record_created"`))
+				g.Expect(recorder.bodies[2]).To(ContainSubstring(`"old_ip":"1.2.3.4"`))
+
+				client, err := history.NewClient(stateDir)
+				g.Expect(err).NotTo(HaveOccurred())
+				entries, err := client.Query(history.Filter{Types: []history.EntryType{history.EntryTypeIPRejected}})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(HaveLen(2))
+				g.Expect(entries[0].Reason).To(Equal("inside private or reserved range 100.64.0.0/10"))
+				g.Expect(entries[0].Source).To(Equal("http://checkip.amazonaws.com"))
+				g.Expect(history.Summarize(entries, time.Now()).Rejections).To(Equal(2))
+
+				// The rejected address was never observed as the current one
+				lastIP, err := client.LastIP("bar.foo.net")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lastIP).To(Equal("1.2.3.4"))
+			},
+		},
+		{
+			testCase: "rejects addresses from other networks",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				recorder.bodies = []string{}
+
+				env := envy.MockEnv{}
+				err := env.Load(guardEnv(map[string]string{
+					"GUARD_MMDB":       mocks.MMDBFixture(mocks.MMDBASN),
+					"GUARD_ALLOW_ASNS": "AS3320",
+					"GUARD_NOTIFY":     "false",
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("refusing to publish 5.6.7.8: AS9009 (M247 Europe SRL) is not an allowed ASN"))
+				g.Expect(recorder.bodies).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "reports rejections to the control API",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &memorySDKClient{}
+				defer withSDKClient(sdkClient)()
+
+				blocking, ctl, stop := startAgent(tt, g, map[string]string{
+					"GUARD_MMDB":       mocks.MMDBFixture(mocks.MMDBASN),
+					"GUARD_ALLOW_ASNS": "AS3320",
+					"GUARD_NOTIFY":     "false",
+				})
+				defer stop()
+
+				output, err := ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).NotTo(ContainSubstring("Rejected addresses:"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"), ipResponse("5.6.7.9"))).To(Succeed())
+				g.Expect(blocking.run()).To(MatchError(ContainSubstring("refusing to publish 5.6.7.8")))
+				g.Expect(blocking.run()).To(MatchError(ContainSubstring("refusing to publish 5.6.7.9")))
+				output, err = ctl("status")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(ContainSubstring("Rejected addresses: 2\n"))
+				g.Expect(sdkClient.published("A")).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "returns errors building the guard",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(guardEnv(map[string]string{"GUARD_DENY_CIDRS": "nope"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError(`invalid CIDR "nope"`))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
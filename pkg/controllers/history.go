@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// HistoryClientOptions is used by testing to inject a mock client option
+	HistoryClientOptions = []history.LoadOption{}
+)
+
+const (
+	// StateDirFlag wraps the name of the command flag
+	StateDirFlag string = "state-dir"
+
+	// SinceFlag wraps the name of the command flag
+	SinceFlag string = "since"
+
+	// UntilFlag wraps the name of the command flag
+	UntilFlag string = "until"
+)
+
+// historyOutput is the JSON document printed by the history command
+type historyOutput struct {
+	Entries []history.Entry `json:"entries"`
+	Stats   history.Stats   `json:"stats"`
+}
+
+// stateFlags returns the flags locating persistent state
+func stateFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    StateDirFlag,
+			Usage:   "Directory where history and other state is persisted. Empty disables persistence",
+			EnvVars: []string{"STATE_DIR"},
+		},
+	}
+}
+
+// HistoryCommand returns the command querying the IP change history
+func HistoryCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "history",
+		Usage: "Show recorded IP changes and record mutations",
+		Flags: flagsOf(
+			stateFlags(),
+			[]cli.Flag{
+				&cli.StringFlag{
+					Name:  SinceFlag,
+					Usage: "Only show entries after this time (RFC 3339, a date, or a duration ago such as 24h)",
+				},
+				&cli.StringFlag{
+					Name:  UntilFlag,
+					Usage: "Only show entries before this time (RFC 3339, a date, or a duration ago such as 24h)",
+				},
+				&cli.StringFlag{
+					Name:  RecordNameFlag,
+					Usage: "Only show entries for this fully qualified name",
+				},
+				&cli.StringFlag{
+					Name:  RecordTypeFlag,
+					Usage: fmt.Sprintf("Only show entries of this type (one of: %v)", getSupportedEntryTypesString()),
+				},
+				outputFlag(),
+			},
+		),
+		Action: showHistory,
+	}
+}
+
+// buildHistory creates the history store, or returns nil if persistence is disabled
+func buildHistory(c *cli.Context) (*history.DefaultClient, error) {
+	stateDir := c.String(StateDirFlag)
+	if stateDir == "" {
+		return nil, nil
+	}
+	return history.NewClient(stateDir, HistoryClientOptions...)
+}
+
+// showHistory prints the entries matching the filters along with summary stats
+func showHistory(c *cli.Context) error {
+	options, err := stringsOrError(c, "reading history", StateDirFlag)
+	if err != nil {
+		return err
+	}
+
+	historyClient, err := history.NewClient(options[StateDirFlag], HistoryClientOptions...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build history client")
+		return err
+	}
+
+	now := historyClient.Now()
+	filter := history.Filter{Name: c.String(RecordNameFlag)}
+	if filter.Since, err = parseTime(c.String(SinceFlag), now); err != nil {
+		return err
+	}
+	if filter.Until, err = parseTime(c.String(UntilFlag), now); err != nil {
+		return err
+	}
+	if entryType := c.String(RecordTypeFlag); entryType != "" {
+		filter.Types = []history.EntryType{history.EntryType(entryType)}
+	}
+
+	entries, err := historyClient.Query(filter)
+	if err != nil {
+		return err
+	}
+
+	until := now
+	if !filter.Until.IsZero() && filter.Until.Before(now) {
+		until = filter.Until
+	}
+	return writeHistory(c.App.Writer, c.String(OutputFlag), historyOutput{
+		Entries: entries,
+		Stats:   history.Summarize(entries, until),
+	})
+}
+
+// writeHistory prints the history in the requested format
+func writeHistory(w io.Writer, format string, output historyOutput) error {
+	switch format {
+	case OutputFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(output)
+	case OutputFormatTable:
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "TIME\tTYPE\tNAME\tOLD IP\tNEW IP\tSOURCE")
+		for _, entry := range output.Entries {
+			source := entry.Source
+			if source == "" {
+				source = entry.Provider
+			}
+			fmt.Fprintf(
+				table,
+				"%v\t%v\t%v\t%v\t%v\t%v\n",
+				entry.Time.Format(time.RFC3339),
+				entry.Type,
+				entry.Name,
+				orDash(entry.OldIP),
+				orDash(entry.NewIP),
+				orDash(source),
+			)
+		}
+		if err := table.Flush(); err != nil {
+			return err
+		}
+
+		stats := output.Stats
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "IP changes: %v (%.2f per day)\n", stats.IPChanges, stats.ChangesPerDay)
+		fmt.Fprintf(w, "Record changes: %v\n", stats.RecordChanges)
+		if stats.Rejections > 0 {
+			fmt.Fprintf(w, "Rejected addresses: %v\n", stats.Rejections)
+		}
+		if stats.LastChange != nil {
+			fmt.Fprintf(w, "Last IP change: %v\n", stats.LastChange.Format(time.RFC3339))
+		}
+		if period := stats.LongestStable; period != nil {
+			fmt.Fprintf(
+				w,
+				"Longest stable period: %v (%v held %v from %v to %v)\n",
+				period.Duration().Round(time.Second),
+				period.Name,
+				period.IP,
+				period.From.Format(time.RFC3339),
+				period.To.Format(time.RFC3339),
+			)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported output format: %v", format)
+	}
+}
+
+// historyFromResult converts the changes made while applying a record into
+// history entries, each carrying the records as they were before the apply
+func historyFromResult(providerType string, result dns.ApplyResult) []history.Entry {
+	entries := []history.Entry{}
+	if result.Created || result.Updated {
+		record := result.Record
+		entry := history.Entry{
+			Type:     history.EntryTypeRecordCreated,
+			Name:     record.Name,
+			Provider: providerType,
+			OldIP:    result.OldContent(),
+			NewIP:    record.Content,
+			Record:   &record,
+			Snapshot: result.Previous,
+		}
+		if result.Updated {
+			entry.Type = history.EntryTypeRecordUpdated
+		}
+		entries = append(entries, entry)
+	}
+	for _, deleted := range result.Deleted {
+		deleted := deleted
+		entries = append(entries, history.Entry{
+			Type:     history.EntryTypeRecordDeleted,
+			Name:     deleted.Name,
+			Provider: providerType,
+			OldIP:    deleted.Content,
+			Record:   &deleted,
+			Snapshot: result.Previous,
+		})
+	}
+	return entries
+}
+
+// parseTime parses an absolute time, a date, or a duration before now.
+// An empty value returns the zero time.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	if parsed, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return parsed, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected RFC 3339, a date (YYYY-MM-DD) or a duration", value)
+}
+
+// getSupportedEntryTypesString returns the history entry types as a
+// comma-separated string
+func getSupportedEntryTypesString() string {
+	entryTypes := []string{}
+	for _, entryType := range history.SupportedEntryTypes {
+		entryTypes = append(entryTypes, string(entryType))
+	}
+	return strings.Join(entryTypes, ", ")
+}
@@ -0,0 +1,312 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+func TestHistory(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	now := time.Date(2021, 9, 3, 0, 0, 0, 0, time.UTC)
+	historyOptions := controllers.HistoryClientOptions
+	controllers.HistoryClientOptions = []history.LoadOption{
+		func(client *history.DefaultClient) error {
+			client.Now = func() time.Time { return now }
+			return nil
+		},
+	}
+	defer func() { controllers.HistoryClientOptions = historyOptions }()
+
+	syncEnv := func(stateDir string) map[string]string {
+		return map[string]string{
+			"NETWORK_ID":            "bar",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"STATE_DIR":             stateDir,
+		}
+	}
+
+	writeHistory := func(g *WithT, stateDir string) {
+		client, err := history.NewClient(stateDir)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(client.Append(
+			history.Entry{Time: now.Add(-48 * time.Hour), Type: history.EntryTypeIPChanged, Name: "bar.foo.net", NewIP: "1.1.1.1", Source: "http://checkip"},
+			history.Entry{Time: now.Add(-12 * time.Hour), Type: history.EntryTypeIPChanged, Name: "bar.foo.net", OldIP: "1.1.1.1", NewIP: "2.2.2.2", Source: "http://checkip"},
+			history.Entry{Time: now.Add(-12 * time.Hour), Type: history.EntryTypeRecordUpdated, Name: "bar.foo.net", OldIP: "1.1.1.1", NewIP: "2.2.2.2", Provider: "cloudflare"},
+			history.Entry{Time: now.Add(-6 * time.Hour), Type: history.EntryTypeIPRejected, Name: "bar.foo.net", OldIP: "2.2.2.2", NewIP: "100.64.0.1", Reason: "inside private or reserved range 100.64.0.0/10"},
+		)).To(Succeed())
+	}
+
+	newHistoryApp := func(output *bytes.Buffer) *cli.App {
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.HistoryCommand()})
+		app.Writer = output
+		return app
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "sync records ip changes and record mutations",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				env := envy.MockEnv{}
+				err := env.Load(syncEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				existing := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("bar", "foo.net", "5.6.7.8"))
+				existing.ID = "old"
+				applied := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4"))
+				applied.ID = "new"
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"), ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{existing})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: applied})).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+
+				// The same address is not recorded twice, even by a new process
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{applied})).To(Succeed())
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+
+				client, err := history.NewClient(stateDir)
+				g.Expect(err).NotTo(HaveOccurred())
+				entries, err := client.Query(history.Filter{})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(HaveLen(3))
+
+				g.Expect(entries[0].Type).To(Equal(history.EntryTypeIPChanged))
+				g.Expect(entries[0].NewIP).To(Equal("1.2.3.4"))
+				g.Expect(entries[0].Source).To(Equal("http://checkip.amazonaws.com"))
+
+				snapshot := cloudflare.ConvertDNSRecordList([]sdk.DNSRecord{existing})
+				g.Expect(entries[1].Type).To(Equal(history.EntryTypeRecordCreated))
+				g.Expect(entries[1].OldIP).To(Equal("5.6.7.8"))
+				g.Expect(entries[1].NewIP).To(Equal("1.2.3.4"))
+				g.Expect(entries[1].Record.ID).To(Equal("new"))
+				g.Expect(entries[1].Snapshot).To(Equal(snapshot))
+
+				g.Expect(entries[2].Type).To(Equal(history.EntryTypeRecordDeleted))
+				g.Expect(entries[2].OldIP).To(Equal("5.6.7.8"))
+				g.Expect(entries[2].Record.ID).To(Equal("old"))
+				g.Expect(entries[2].Snapshot).To(Equal(snapshot))
+			},
+		},
+		{
+			testCase: "sync records records updated in place",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				env := envy.MockEnv{}
+				err := env.Load(syncEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				// Same address, but the proxied setting drifted
+				existing := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4"))
+				existing.ID = "existing"
+				proxied := true
+				existing.Proxied = &proxied
+				updated := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4"))
+				updated.ID = "existing"
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{existing})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecord", updated)).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+
+				client, err := history.NewClient(stateDir)
+				g.Expect(err).NotTo(HaveOccurred())
+				entries, err := client.Query(history.Filter{Types: []history.EntryType{history.EntryTypeRecordUpdated}})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(HaveLen(1))
+				g.Expect(entries[0].Record.Proxied).To(BeFalse())
+				g.Expect(entries[0].Snapshot[0].Proxied).To(BeTrue())
+			},
+		},
+		{
+			testCase: "sync keeps going when the history can't be written",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				// The history file is a link into a directory that doesn't exist,
+				// so it reads as empty but can't be created
+				stateDir := tt.TempDir()
+				g.Expect(os.Symlink(filepath.Join(stateDir, "missing", "history"), filepath.Join(stateDir, history.FileName))).To(Succeed())
+
+				env := envy.MockEnv{}
+				err := env.Load(syncEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+			},
+		},
+		{
+			testCase: "sync returns error reading the history",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				// The state directory is a regular file
+				stateDir := filepath.Join(tt.TempDir(), "file")
+				g.Expect(os.WriteFile(stateDir, []byte{}, 0o600)).To(Succeed())
+
+				env := envy.MockEnv{}
+				err := env.Load(syncEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError(ContainSubstring("not a directory")))
+			},
+		},
+		{
+			testCase: "sync returns errors building the history",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(syncEnv(tt.TempDir()))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				controllers.HistoryClientOptions = append(controllers.HistoryClientOptions, func(client *history.DefaultClient) error {
+					return fmt.Errorf("boo")
+				})
+				defer func() { controllers.HistoryClientOptions = controllers.HistoryClientOptions[:1] }()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError("boo"))
+				g.Expect(newHistoryApp(&bytes.Buffer{}).Run([]string{"qrkdns", "history"})).To(MatchError("boo"))
+			},
+		},
+		{
+			testCase: "shows the history with summary stats",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				writeHistory(g, stateDir)
+
+				env := envy.MockEnv{}
+				err := env.Load(map[string]string{"STATE_DIR": stateDir})
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				output := &bytes.Buffer{}
+				err = newHistoryApp(output).Run([]string{"qrkdns", "history"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).To(MatchRegexp(`2021-09-02T12:00:00Z\s+ip_changed\s+bar\.foo\.net\s+1\.1\.1\.1\s+2\.2\.2\.2\s+http://checkip`))
+				g.Expect(output.String()).To(MatchRegexp(`record_updated\s+bar\.foo\.net\s+1\.1\.1\.1\s+2\.2\.2\.2\s+cloudflare`))
+				g.Expect(output.String()).To(ContainSubstring("IP changes: 1 (0.50 per day)"))
+				g.Expect(output.String()).To(ContainSubstring("Rejected addresses: 1"))
+				g.Expect(output.String()).To(ContainSubstring("Last IP change: 2021-09-02T12:00:00Z"))
+				g.Expect(output.String()).To(ContainSubstring("Longest stable period: 36h0m0s (bar.foo.net held 1.1.1.1 from 2021-09-01T00:00:00Z to 2021-09-02T12:00:00Z)"))
+			},
+		},
+		{
+			testCase: "filters the history as json",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				writeHistory(g, stateDir)
+
+				env := envy.MockEnv{}
+				err := env.Load(map[string]string{"STATE_DIR": stateDir})
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				output := &bytes.Buffer{}
+				err = newHistoryApp(output).Run([]string{
+					"qrkdns", "history",
+					"--since", "24h",
+					"--until", "2021-09-03T00:00:00Z",
+					"--name", "bar.foo.net",
+					"--type", "ip_changed",
+					"--output", "json",
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				result := struct {
+					Entries []history.Entry `json:"entries"`
+					Stats   history.Stats   `json:"stats"`
+				}{}
+				g.Expect(json.Unmarshal(output.Bytes(), &result)).To(Succeed())
+				g.Expect(result.Entries).To(HaveLen(1))
+				g.Expect(result.Entries[0].NewIP).To(Equal("2.2.2.2"))
+				g.Expect(result.Stats.IPChanges).To(Equal(1))
+
+				output.Reset()
+				err = newHistoryApp(output).Run([]string{"qrkdns", "history", "--until", "2021-09-02"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).NotTo(ContainSubstring("2.2.2.2"))
+				g.Expect(output.String()).To(ContainSubstring("IP changes: 0"))
+			},
+		},
+		{
+			testCase: "returns errors for invalid arguments",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				err := newHistoryApp(&bytes.Buffer{}).Run([]string{"qrkdns", "history"})
+				g.Expect(err).To(MatchError("options [--state-dir] are required when reading history"))
+
+				stateDir := tt.TempDir()
+				err = newHistoryApp(&bytes.Buffer{}).Run([]string{"qrkdns", "history", "--state-dir", stateDir, "--since", "yesterday"})
+				g.Expect(err).To(MatchError(`invalid time "yesterday": expected RFC 3339, a date (YYYY-MM-DD) or a duration`))
+
+				err = newHistoryApp(&bytes.Buffer{}).Run([]string{"qrkdns", "history", "--state-dir", stateDir, "--until", "tomorrow"})
+				g.Expect(err).To(HaveOccurred())
+
+				err = newHistoryApp(&bytes.Buffer{}).Run([]string{"qrkdns", "history", "--state-dir", stateDir, "--output", "yaml"})
+				g.Expect(err).To(MatchError("unsupported output format: yaml"))
+
+				app := newHistoryApp(&bytes.Buffer{})
+				app.Writer = failingReadWriter{}
+				err = app.Run([]string{"qrkdns", "history", "--state-dir", stateDir})
+				g.Expect(err).To(MatchError("closed"))
+
+				g.Expect(os.WriteFile(filepath.Join(stateDir, history.FileName), []byte("nope\n"), 0o600)).To(Succeed())
+				err = newHistoryApp(&bytes.Buffer{}).Run([]string{"qrkdns", "history", "--state-dir", stateDir})
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
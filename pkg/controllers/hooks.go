@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/hooks"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// HookClientOptions is used by testing to inject a mock client option
+	HookClientOptions = []hooks.LoadOption{}
+)
+
+const (
+	// PreSyncHookFlag wraps the name of the command flag
+	PreSyncHookFlag string = "pre-sync-hook"
+
+	// PostChangeHookFlag wraps the name of the command flag
+	PostChangeHookFlag string = "post-change-hook"
+
+	// HookTimeoutFlag wraps the name of the command flag
+	HookTimeoutFlag string = "hook-timeout"
+
+	// HookFailurePolicyFlag wraps the name of the command flag
+	HookFailurePolicyFlag string = "hook-failure-policy"
+)
+
+// hookFlags returns the flags used to configure user hooks
+func hookFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    PreSyncHookFlag,
+			Usage:   "Shell command run after IP discovery and before the provider is updated",
+			EnvVars: []string{"PRE_SYNC_HOOK"},
+		},
+		&cli.StringFlag{
+			Name:    PostChangeHookFlag,
+			Usage:   "Shell command run after the provider changed a record",
+			EnvVars: []string{"POST_CHANGE_HOOK"},
+		},
+		&cli.DurationFlag{
+			Name:    HookTimeoutFlag,
+			Usage:   "Maximum time a hook may run before it is killed",
+			EnvVars: []string{"HOOK_TIMEOUT"},
+			Value:   30 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:    HookFailurePolicyFlag,
+			Usage:   fmt.Sprintf("What a failing hook does to the sync (one of: %v)", getSupportedHookPoliciesString()),
+			EnvVars: []string{"HOOK_FAILURE_POLICY"},
+			Value:   string(hooks.FailurePolicyWarn),
+		},
+	}
+}
+
+// buildHooks creates the hook runner
+func buildHooks(c *cli.Context) (hooks.DefaultClient, error) {
+	return hooks.NewClient(
+		c.Duration(HookTimeoutFlag),
+		c.String(HookFailurePolicyFlag),
+		HookClientOptions...,
+	)
+}
+
+// publishedIP returns the address of the A record published at name, or
+// fallback when there's none or it can't be read
+func publishedIP(ctx context.Context, dnsClient dns.Provider, name, fallback string) string {
+	records, err := dnsClient.ListRecords(ctx, dns.RecordFilter{Name: name, Type: dns.RecordTypeA})
+	if err != nil {
+		log.WithError(err).Warn("Failed to read the published address")
+		return fallback
+	}
+	if len(records) == 0 {
+		return fallback
+	}
+	return records[0].Content
+}
+
+// getSupportedHookPoliciesString returns the supported hook failure
+// policies as a comma-separated string
+func getSupportedHookPoliciesString() string {
+	policies := []string{}
+	for _, policy := range hooks.SupportedFailurePolicies {
+		policies = append(policies, string(policy))
+	}
+	return strings.Join(policies, ", ")
+}
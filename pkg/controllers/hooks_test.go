@@ -0,0 +1,199 @@
+package controllers_test
+
+import (
+	"fmt"
+	"testing"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/hooks"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+func TestHooks(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	runner := &mocks.MockCommandRunner{}
+	controllers.HookClientOptions = append(
+		controllers.HookClientOptions,
+		func(client *hooks.DefaultClient) error {
+			client.Client = runner
+			return nil
+		},
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	hookEnv := func(extra map[string]string) map[string]string {
+		env := map[string]string{
+			"NETWORK_ID":            "bar",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"PRE_SYNC_HOOK":         "pre",
+			"POST_CHANGE_HOOK":      "post",
+		}
+		for key, value := range extra {
+			env[key] = value
+		}
+		return env
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "runs pre-sync and post-change hooks",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				runner.Commands, runner.Envs = []string{}, [][]string{}
+
+				env := envy.MockEnv{}
+				err := env.Load(hookEnv(map[string]string{}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				oldRecord := cloudflare.BuildDNSARecord("bar", "foo.net", "9.9.9.9")
+				oldRecord.ID = "old"
+				newRecord := cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4")
+				newRecord.ID = "new"
+
+				// The pre-sync hook reads the published address first
+				published := []sdk.DNSRecord{cloudflare.ToCloudFlareDNSRecord(oldRecord)}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", published, published)).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: cloudflare.ToCloudFlareDNSRecord(newRecord)})).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(runner.Commands).To(Equal([]string{"pre", "post"}))
+				g.Expect(runner.Envs[0]).To(ContainElement("QRKDNS_OLD_IP=9.9.9.9"))
+				g.Expect(runner.Envs[1]).To(ContainElement("QRKDNS_OLD_IP=9.9.9.9"))
+				g.Expect(runner.Envs[1]).To(ContainElement("QRKDNS_NEW_IP=1.2.3.4"))
+			},
+		},
+		{
+			testCase: "falls back to the history for the previous address",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				runner.Commands, runner.Envs = []string{}, [][]string{}
+
+				env := envy.MockEnv{}
+				err := env.Load(hookEnv(map[string]string{"STATE_DIR": tt.TempDir()}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				sync := func(ip string, lookupErr error) {
+					record := cloudflare.BuildDNSARecord("bar", "foo.net", ip)
+					g.Expect(envy.AddObjectReturns("Do", ipResponse(ip))).To(Succeed())
+					g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{}, []sdk.DNSRecord{})).To(Succeed())
+					g.Expect(envy.AddErrorReturns("DNSRecords", lookupErr, nil)).To(Succeed())
+					g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: cloudflare.ToCloudFlareDNSRecord(record)})).To(Succeed())
+
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+					g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+				}
+
+				// Nothing is published nor recorded yet
+				sync("5.6.7.8", nil)
+				g.Expect(runner.Envs[0]).To(ContainElement("QRKDNS_OLD_IP="))
+
+				// The published address can't be read
+				sync("1.2.3.4", fmt.Errorf("boom"))
+				g.Expect(runner.Commands).To(Equal([]string{"pre", "post", "pre", "post"}))
+				g.Expect(runner.Envs[2]).To(ContainElement("QRKDNS_OLD_IP=5.6.7.8"))
+			},
+		},
+		{
+			testCase: "skips post-change hook when nothing changed",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				runner.Commands, runner.Envs = []string{}, [][]string{}
+
+				env := envy.MockEnv{}
+				err := env.Load(hookEnv(map[string]string{}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				record := cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4")
+				published := []sdk.DNSRecord{cloudflare.ToCloudFlareDNSRecord(record)}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", published, published)).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(runner.Commands).To(Equal([]string{"pre"}))
+			},
+		},
+		{
+			testCase: "aborts the sync when a pre-sync hook fails",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(hookEnv(map[string]string{"HOOK_FAILURE_POLICY": "fail"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddErrorReturns("RunCommand", fmt.Errorf("exit status 2"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("pre-sync hook failed: exit status 2"))
+			},
+		},
+		{
+			testCase: "fails the sync when a post-change hook fails",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(hookEnv(map[string]string{"HOOK_FAILURE_POLICY": "fail"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				newRecord := cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4")
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: cloudflare.ToCloudFlareDNSRecord(newRecord)})).To(Succeed())
+				g.Expect(envy.AddErrorReturns("RunCommand", nil, fmt.Errorf("exit status 3"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("post-change hook failed: exit status 3"))
+			},
+		},
+		{
+			testCase: "returns error for unsupported failure policy",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(hookEnv(map[string]string{"HOOK_FAILURE_POLICY": "explode"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("unsupported hook failure policy: explode"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/control"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// LocalIPClientOptions is used by testing to inject a mock client option
+	LocalIPClientOptions = []ip.LocalLoadOption{}
+)
+
+const (
+	// IPSourceFlag wraps the name of the command flag
+	IPSourceFlag string = "ip-source"
+
+	// InternalNamesFlag wraps the name of the command flag
+	InternalNamesFlag string = "internal-name"
+
+	// InternalDomainFlag wraps the name of the command flag
+	InternalDomainFlag string = "internal-domain"
+
+	// InternalZoneIDFlag wraps the name of the command flag
+	InternalZoneIDFlag string = "internal-cf-zone-id"
+
+	// InternalIPSourceFlag wraps the name of the command flag
+	InternalIPSourceFlag string = "internal-ip-source"
+)
+
+// internalFlags returns the flags of the internal view of a split-horizon
+// setup, publishing another address of the host to another zone
+func internalFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    InternalNamesFlag,
+			Usage:   "Names, relative to the internal domain, receiving the internal address (repeatable). Enables the internal view",
+			EnvVars: []string{"INTERNAL_NAMES"},
+		},
+		&cli.StringFlag{
+			Name:    InternalDomainFlag,
+			Usage:   "Domain of the internal names. Defaults to --domain",
+			EnvVars: []string{"INTERNAL_DOMAIN_NAME"},
+		},
+		&cli.StringFlag{
+			Name:    InternalZoneIDFlag,
+			Usage:   "Cloudflare zone ID of the internal names, e.g., an internal zone sharing the public zone's name",
+			EnvVars: []string{"INTERNAL_CLOUDFLARE_ZONE_ID"},
+		},
+		&cli.StringFlag{
+			Name:    InternalIPSourceFlag,
+			Usage:   "Source of the internal address (http, interface or interface:<name>)",
+			EnvVars: []string{"INTERNAL_IP_SOURCE"},
+			Value:   ip.SourceInterface,
+		},
+	}
+}
+
+// internalDomain returns the domain of the internal names
+func internalDomain(c *cli.Context) string {
+	if domain := c.String(InternalDomainFlag); domain != "" {
+		return domain
+	}
+	return c.String(DomainFlag)
+}
+
+// internalNames returns the validated names of the internal view, relative
+// to the internal domain, in their A-label form. Names can only appear in
+// both views, given as the public names, when the views are different
+// zones.
+func internalNames(c *cli.Context, public []string) ([]string, error) {
+	names, err := asciiNames(internalDomain(c), c.StringSlice(InternalNamesFlag))
+	if err != nil || len(names) == 0 {
+		return nil, err
+	}
+	if _, _, err := ip.ParseSource(c.String(InternalIPSourceFlag)); err != nil {
+		return nil, err
+	}
+
+	sameZone := asciiName(internalDomain(c)) == domainName(c) &&
+		c.String(InternalZoneIDFlag) == c.String(CloudflareZoneIDFlag)
+	if !sameZone {
+		return names, nil
+	}
+	seen := make(map[string]bool)
+	for _, name := range public {
+		seen[name] = true
+	}
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf(
+				"name %v is in both views of the same zone, set --%v or --%v",
+				dns.ToUnicode(dns.FQDN(name, domainName(c))), InternalDomainFlag, InternalZoneIDFlag,
+			)
+		}
+	}
+	return names, nil
+}
+
+// discoverAddress returns the address of the host according to the source
+func discoverAddress(ctx context.Context, c *cli.Context, source string) (string, error) {
+	kind, name, err := ip.ParseSource(source)
+	if err != nil {
+		return "", err
+	}
+	if kind == ip.SourceHTTP {
+		return discoverIP(ctx, c, c.String(IPServiceURLFlag))
+	}
+
+	client, err := ip.NewLocalClient(name, LocalIPClientOptions...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build local IP client")
+		return "", err
+	}
+	address, err := client.GetLocalIPAddress(ctx)
+	if err != nil {
+		log.WithError(err).WithField("source", source).Error("Failed to get local IP address")
+		return "", err
+	}
+	return address, nil
+}
+
+// sourceName describes where an address was discovered, for the history
+func sourceName(c *cli.Context, source string) string {
+	if kind, _, _ := ip.ParseSource(source); kind == ip.SourceHTTP {
+		return c.String(IPServiceURLFlag)
+	}
+	return source
+}
+
+// syncInternal publishes the internal address to the internal names, if
+// any. The guard, dampening, hooks and propagation checks only concern the
+// public view, as the internal address is private by design.
+func (s *syncer) syncInternal(ctx context.Context, c *cli.Context, names []string) (*control.View, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	dnsClient, err := buildZoneProvider(c, internalDomain(c), c.String(InternalZoneIDFlag))
+	if err != nil {
+		log.WithError(err).Error("Failed to build internal DNS client")
+		return nil, err
+	}
+	address, err := discoverAddress(ctx, c, c.String(InternalIPSourceFlag))
+	if err != nil {
+		return nil, err
+	}
+
+	view := &control.View{IP: address, Results: []dns.ApplyResult{}}
+	for _, name := range names {
+		result, err := dnsClient.ApplyDNSARecord(ctx, name, address)
+		if err != nil {
+			log.WithError(err).Error("Failed to apply internal DNS A record")
+			return nil, err
+		}
+		s.record(historyFromResult(c.String(ProviderTypeFlag), result)...)
+
+		err = dns.EnsureOwnership(ctx, dnsClient, result.Record.Name, c.String(OwnerIDFlag))
+		if err != nil {
+			log.WithError(err).Error("Failed to record ownership")
+			return nil, err
+		}
+		view.Results = append(view.Results, result)
+	}
+	log.WithField("internalIP", address).Info("Internal view synced")
+	return view, nil
+}
@@ -0,0 +1,274 @@
+package controllers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/control"
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+// zoneSDKClient serves empty zones, recording the records created in each
+type zoneSDKClient struct {
+	mocks.MockCloudflareSDKClient
+	created []string
+	// listings counts the record listings of each zone
+	listings map[string]int
+	// failZone fails listing the records of the zone once it was listed
+	// failAfter times
+	failZone  string
+	failAfter int
+}
+
+func (c *zoneSDKClient) DNSRecords(ctx context.Context, zoneID string, rr sdk.DNSRecord) ([]sdk.DNSRecord, error) {
+	if c.listings == nil {
+		c.listings = map[string]int{}
+	}
+	c.listings[zoneID]++
+	if zoneID == c.failZone && c.listings[zoneID] > c.failAfter {
+		return nil, errors.New("boom")
+	}
+	return []sdk.DNSRecord{}, nil
+}
+
+func (c *zoneSDKClient) CreateDNSRecord(ctx context.Context, zoneID string, rr sdk.DNSRecord) (*sdk.DNSRecordResponse, error) {
+	c.created = append(c.created, fmt.Sprintf("%v: %v %v %v", zoneID, rr.Type, rr.Name, rr.Content))
+	return &sdk.DNSRecordResponse{Result: rr}, nil
+}
+
+// withInterfaceAddress gives every interface the address
+func withInterfaceAddress(address string) ip.LocalLoadOption {
+	return func(client *ip.LocalClient) error {
+		client.InterfaceAddrs = func(name string) ([]net.Addr, error) {
+			return []net.Addr{&net.IPNet{IP: net.ParseIP(address), Mask: net.CIDRMask(24, 32)}}, nil
+		}
+		client.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, errors.New("network is unreachable")
+		}
+		return nil
+	}
+}
+
+func TestHorizon(t *testing.T) {
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	// sync runs a single sync of the split-horizon setup against the
+	// client, with the extra environment
+	sync := func(g *WithT, sdkClient *zoneSDKClient, extra map[string]string) error {
+		values := map[string]string{
+			"NETWORK_ID":                  "home",
+			"DOMAIN_NAME":                 "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID":       "foo",
+			"CLOUDFLARE_API_TOKEN":        "bar",
+			"CLOUDFLARE_ZONE_ID":          "public",
+			"INTERNAL_NAMES":              "home,nas",
+			"INTERNAL_CLOUDFLARE_ZONE_ID": "internal",
+			"INTERNAL_IP_SOURCE":          "interface:eth0",
+		}
+		for key, value := range extra {
+			values[key] = value
+		}
+		env := envy.MockEnv{}
+		g.Expect(env.Load(values)).To(Succeed())
+		defer env.Restore()
+
+		options := controllers.CloudflareClientOptions
+		controllers.CloudflareClientOptions = append(options, func(client *cloudflare.DefaultClient) error {
+			client.Client = sdkClient
+			return nil
+		})
+		defer func() { controllers.CloudflareClientOptions = options }()
+
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+		return app.Run([]string{"qrkdns", "sync"})
+	}
+
+	localOptions := controllers.LocalIPClientOptions
+	controllers.LocalIPClientOptions = []ip.LocalLoadOption{withInterfaceAddress("192.168.1.20")}
+	defer func() { controllers.LocalIPClientOptions = localOptions }()
+
+	tests := []testRunner{
+		{
+			testCase: "publishes the LAN address to the internal zone",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				sdkClient := &zoneSDKClient{}
+				g.Expect(sync(g, sdkClient, nil)).To(Succeed())
+				g.Expect(sdkClient.created).To(Equal([]string{
+					"public: A home.foo.net 1.2.3.4",
+					"public: TXT _qrkdns.home.foo.net heritage=qrkdns,owner=qrkdns",
+					"internal: A home.foo.net 192.168.1.20",
+					"internal: TXT _qrkdns.home.foo.net heritage=qrkdns,owner=qrkdns",
+					"internal: A nas.foo.net 192.168.1.20",
+					"internal: TXT _qrkdns.nas.foo.net heritage=qrkdns,owner=qrkdns",
+				}))
+			},
+		},
+		{
+			testCase: "publishes interface addresses to the public zone",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				sdkClient := &zoneSDKClient{}
+				g.Expect(sync(g, sdkClient, map[string]string{
+					"IP_SOURCE":            "interface:ppp0",
+					"INTERNAL_NAMES":       "",
+					"GUARD_ALLOW_RESERVED": "true",
+					"STATE_DIR":            stateDir,
+				})).To(Succeed())
+				g.Expect(sdkClient.created).To(ContainElement("public: A home.foo.net 192.168.1.20"))
+
+				history, err := os.ReadFile(filepath.Join(stateDir, "history.jsonl"))
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(string(history)).To(ContainSubstring(`"source":"interface:ppp0"`))
+			},
+		},
+		{
+			testCase: "returns error for invalid internal configuration",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				// Configuration errors are found before discovering any address
+				for _, invalid := range []struct {
+					extra   map[string]string
+					message string
+				}{
+					{
+						extra:   map[string]string{"INTERNAL_IP_SOURCE": "nope"},
+						message: `invalid IP source "nope", expected http, interface or interface:<name>`,
+					},
+					{
+						extra:   map[string]string{"IP_SOURCE": "nope", "INTERNAL_NAMES": ""},
+						message: `invalid IP source "nope", expected http, interface or interface:<name>`,
+					},
+					{
+						extra:   map[string]string{"INTERNAL_CLOUDFLARE_ZONE_ID": "public"},
+						message: "name home.foo.net is in both views of the same zone, set --internal-domain or --internal-cf-zone-id",
+					},
+					{
+						extra:   map[string]string{"INTERNAL_NAMES": "a.*"},
+						message: "invalid name a.*.foo.net: label * contains '*'",
+					},
+				} {
+					g.Expect(sync(g, &zoneSDKClient{}, invalid.extra)).To(MatchError(invalid.message), invalid.message)
+				}
+
+				// Names may repeat in another zone of the same name
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				sdkClient := &zoneSDKClient{}
+				g.Expect(sync(g, sdkClient, map[string]string{
+					"INTERNAL_DOMAIN_NAME":        "lan.foo.net",
+					"INTERNAL_CLOUDFLARE_ZONE_ID": "public",
+				})).To(Succeed())
+				g.Expect(sdkClient.created).To(ContainElement("public: A home.lan.foo.net 192.168.1.20"))
+
+				// Or differ in the same zone
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				sdkClient = &zoneSDKClient{}
+				g.Expect(sync(g, sdkClient, map[string]string{
+					"INTERNAL_NAMES":              "home-lan",
+					"INTERNAL_CLOUDFLARE_ZONE_ID": "public",
+				})).To(Succeed())
+				g.Expect(sdkClient.created).To(ContainElement("public: A home-lan.foo.net 192.168.1.20"))
+			},
+		},
+		{
+			testCase: "reports the internal view separately",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				options := controllers.CloudflareClientOptions
+				controllers.CloudflareClientOptions = append(options, func(client *cloudflare.DefaultClient) error {
+					client.Client = &zoneSDKClient{}
+					return nil
+				})
+				defer func() { controllers.CloudflareClientOptions = options }()
+
+				_, ctl, stop := startAgent(tt, g, map[string]string{
+					"CLOUDFLARE_ZONE_ID":          "public",
+					"INTERNAL_NAMES":              "nas",
+					"INTERNAL_CLOUDFLARE_ZONE_ID": "internal",
+					"INTERNAL_IP_SOURCE":          "interface:eth0",
+				})
+				defer stop()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				output, err := ctl("sync")
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output).To(MatchRegexp(
+					`published 1.2.3.4\n  home.foo.net: 1.2.3.4 \(changed\)\nInternal view: published 192.168.1.20\n  nas.foo.net: 192.168.1.20 \(changed\)\n$`,
+				))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				output, err = ctl("sync", "--output", "json")
+				g.Expect(err).NotTo(HaveOccurred())
+				run := control.Run{}
+				g.Expect(json.Unmarshal([]byte(output), &run)).To(Succeed())
+				g.Expect(run.IP).To(Equal("1.2.3.4"))
+				g.Expect(run.Internal.IP).To(Equal("192.168.1.20"))
+				g.Expect(run.Internal.Results[0].Record.Name).To(Equal("nas.foo.net"))
+			},
+		},
+		{
+			testCase: "returns error for failing internal syncs",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				// Applying the record, then its ownership
+				for _, failAfter := range []int{0, 1} {
+					g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+					sdkClient := &zoneSDKClient{failZone: "internal", failAfter: failAfter}
+					g.Expect(sync(g, sdkClient, nil)).To(MatchError(ContainSubstring("boom")))
+				}
+
+				// The zone of the internal domain can't be found
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddErrorReturns("ListZonesContext", errors.New("no zone"))).To(Succeed())
+				err := sync(g, &zoneSDKClient{}, map[string]string{"INTERNAL_CLOUDFLARE_ZONE_ID": "", "INTERNAL_DOMAIN_NAME": "lan.foo.net"})
+				g.Expect(err).To(MatchError(ContainSubstring("no zone")))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				controllers.LocalIPClientOptions = []ip.LocalLoadOption{withInterfaceAddress("2001:db8::1")}
+				err = sync(g, &zoneSDKClient{}, nil)
+				g.Expect(err).To(MatchError("interface eth0 has no IPv4 address"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				controllers.LocalIPClientOptions = []ip.LocalLoadOption{func(client *ip.LocalClient) error {
+					return errors.New("foo")
+				}}
+				err = sync(g, &zoneSDKClient{}, nil)
+				g.Expect(err).To(MatchError("foo"))
+				controllers.LocalIPClientOptions = []ip.LocalLoadOption{withInterfaceAddress("192.168.1.20")}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/email"
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+	"github.com/markliederbach/qrkdns/pkg/clients/secrets"
+	"github.com/markliederbach/qrkdns/pkg/clients/slack"
+	"github.com/markliederbach/qrkdns/pkg/clients/webhook"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// WebhookClientOptions is used by testing to inject a mock client option
+	WebhookClientOptions = []webhook.LoadOption{}
+	// SlackClientOptions is used by testing to inject a mock client option
+	SlackClientOptions = []slack.LoadOption{}
+	// EmailClientOptions is used by testing to inject a mock client option
+	EmailClientOptions = []email.LoadOption{}
+)
+
+const (
+	// NotifyWebhookURLFlag wraps the name of the command flag
+	NotifyWebhookURLFlag string = "notify-webhook-url"
+
+	// NotifyWebhookTemplateFlag wraps the name of the command flag
+	NotifyWebhookTemplateFlag string = "notify-webhook-template"
+
+	// NotifySlackURLFlag wraps the name of the command flag
+	NotifySlackURLFlag string = "notify-slack-url"
+
+	// NotifySMTPHostFlag wraps the name of the command flag
+	NotifySMTPHostFlag string = "notify-smtp-host"
+
+	// NotifySMTPPortFlag wraps the name of the command flag
+	NotifySMTPPortFlag string = "notify-smtp-port"
+
+	// NotifySMTPUsernameFlag wraps the name of the command flag
+	NotifySMTPUsernameFlag string = "notify-smtp-username"
+
+	// NotifySMTPPasswordFlag wraps the name of the command flag
+	NotifySMTPPasswordFlag string = "notify-smtp-password"
+
+	// NotifyEmailFromFlag wraps the name of the command flag
+	NotifyEmailFromFlag string = "notify-email-from"
+
+	// NotifyEmailToFlag wraps the name of the command flag
+	NotifyEmailToFlag string = "notify-email-to"
+
+	// NotifyDedupeWindowFlag wraps the name of the command flag
+	NotifyDedupeWindowFlag string = "notify-dedupe-window"
+
+	// NotifyRateLimitFlag wraps the name of the command flag
+	NotifyRateLimitFlag string = "notify-rate-limit"
+
+	// NotifyFailureThresholdFlag wraps the name of the command flag
+	NotifyFailureThresholdFlag string = "notify-failure-threshold"
+)
+
+// notifyFlags returns the flags used to configure notifications
+func notifyFlags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:    NotifyWebhookURLFlag,
+			Usage:   "Generic webhook receiving a JSON POST for each notification",
+			EnvVars: []string{"NOTIFY_WEBHOOK_URL"},
+		},
+		&cli.StringFlag{
+			Name:    NotifyWebhookTemplateFlag,
+			Usage:   "Go template used to render the webhook body (the event is the template data)",
+			EnvVars: []string{"NOTIFY_WEBHOOK_TEMPLATE"},
+			Value:   webhook.DefaultTemplate,
+		},
+		&cli.StringFlag{
+			Name:    NotifySlackURLFlag,
+			Usage:   "Slack-compatible incoming webhook URL",
+			EnvVars: []string{"NOTIFY_SLACK_URL"},
+		},
+		&cli.StringFlag{
+			Name:    NotifySMTPHostFlag,
+			Usage:   "SMTP server used to send email notifications",
+			EnvVars: []string{"NOTIFY_SMTP_HOST"},
+		},
+		&cli.IntFlag{
+			Name:    NotifySMTPPortFlag,
+			Usage:   "SMTP server port",
+			EnvVars: []string{"NOTIFY_SMTP_PORT"},
+			Value:   587,
+		},
+		&cli.StringFlag{
+			Name:    NotifySMTPUsernameFlag,
+			Usage:   "SMTP username (leave empty to skip authentication)",
+			EnvVars: []string{"NOTIFY_SMTP_USERNAME"},
+		},
+		&cli.StringFlag{
+			Name:    NotifyEmailFromFlag,
+			Usage:   "Sender address for email notifications",
+			EnvVars: []string{"NOTIFY_EMAIL_FROM"},
+		},
+		&cli.StringSliceFlag{
+			Name:    NotifyEmailToFlag,
+			Usage:   "Recipient addresses for email notifications",
+			EnvVars: []string{"NOTIFY_EMAIL_TO"},
+		},
+		&cli.DurationFlag{
+			Name:    NotifyDedupeWindowFlag,
+			Usage:   "Identical notifications within this window are dropped",
+			EnvVars: []string{"NOTIFY_DEDUPE_WINDOW"},
+			Value:   time.Hour,
+		},
+		&cli.IntFlag{
+			Name:    NotifyRateLimitFlag,
+			Usage:   "Maximum notifications sent per hour (0 disables the limit)",
+			EnvVars: []string{"NOTIFY_RATE_LIMIT"},
+			Value:   10,
+		},
+		&cli.IntFlag{
+			Name:    NotifyFailureThresholdFlag,
+			Usage:   "Consecutive sync failures required before a failure is notified",
+			EnvVars: []string{"NOTIFY_FAILURE_THRESHOLD"},
+			Value:   3,
+		},
+	}
+	return flagsOf(flags, secretFlags(
+		&cli.StringFlag{
+			Name:    NotifySMTPPasswordFlag,
+			Usage:   "SMTP password",
+			EnvVars: []string{"NOTIFY_SMTP_PASSWORD"},
+		},
+		"SMTP password",
+	))
+}
+
+// buildNotifier creates a notification dispatcher for every configured backend
+func buildNotifier(c *cli.Context) (*notify.DefaultClient, error) {
+	notifiers := []notify.Notifier{}
+
+	if url := c.String(NotifyWebhookURLFlag); url != "" {
+		client, err := webhook.NewClient(url, c.String(NotifyWebhookTemplateFlag), WebhookClientOptions...)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, client)
+	}
+
+	if url := c.String(NotifySlackURLFlag); url != "" {
+		client, err := slack.NewClient(url, SlackClientOptions...)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, client)
+	}
+
+	if host := c.String(NotifySMTPHostFlag); host != "" {
+		options, err := stringsOrError(c, "using email notifications", NotifyEmailFromFlag)
+		if err != nil {
+			return nil, err
+		}
+		emailOptions := []email.LoadOption{}
+		if username := c.String(NotifySMTPUsernameFlag); username != "" {
+			password, err := resolveSecret(c, NotifySMTPPasswordFlag)
+			if err != nil {
+				return nil, err
+			}
+			emailOptions = append(emailOptions, email.WithPlainAuth(username, password))
+		}
+		emailOptions = append(emailOptions, EmailClientOptions...)
+		client, err := email.NewClient(
+			host,
+			c.Int(NotifySMTPPortFlag),
+			options[NotifyEmailFromFlag],
+			c.StringSlice(NotifyEmailToFlag),
+			emailOptions...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, client)
+	}
+
+	return notify.NewClient(
+		notifiers,
+		notify.WithDedupeWindow(c.Duration(NotifyDedupeWindowFlag)),
+		notify.WithRateLimit(c.Int(NotifyRateLimitFlag), time.Hour),
+		notify.WithFailureThreshold(c.Int(NotifyFailureThresholdFlag)),
+		notify.WithStateDir(c.String(StateDirFlag)),
+	)
+}
+
+// eventsFromResult derives the notifications worth sending for an applied record
+func eventsFromResult(providerType string, ipAddress string, result dns.ApplyResult) []notify.Event {
+	events := []notify.Event{}
+	name := result.Record.Name
+
+	if oldIP := result.OldContent(); result.Created && oldIP != "" {
+		// The old record(s) were replaced, which is reported as a single change
+		return append(events, notify.Event{
+			Type:     notify.EventTypeIPChanged,
+			Name:     name,
+			Provider: providerType,
+			OldIP:    oldIP,
+			NewIP:    ipAddress,
+		})
+	}
+
+	if result.Created {
+		events = append(events, notify.Event{
+			Type:     notify.EventTypeRecordCreated,
+			Name:     name,
+			Provider: providerType,
+			NewIP:    ipAddress,
+		})
+	}
+
+	for _, record := range result.Deleted {
+		events = append(events, notify.Event{
+			Type:     notify.EventTypeRecordDeleted,
+			Name:     name,
+			Provider: providerType,
+			OldIP:    record.Content,
+		})
+	}
+	return events
+}
+
+// failureEvent builds the notification sent when a sync fails
+func failureEvent(c *cli.Context, failures int, err error) notify.Event {
+	return notify.Event{
+		Type:     notify.EventTypeSyncFailed,
+		Name:     recordName(c),
+		Provider: c.String(ProviderTypeFlag),
+		Error:    secrets.DefaultRedactor.Redact(err.Error()),
+		Failures: failures,
+	}
+}
@@ -0,0 +1,424 @@
+package controllers_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/email"
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+	"github.com/markliederbach/qrkdns/pkg/clients/secrets"
+	"github.com/markliederbach/qrkdns/pkg/clients/slack"
+	"github.com/markliederbach/qrkdns/pkg/clients/webhook"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// recordingHTTPClient captures the bodies of every request it receives
+type recordingHTTPClient struct {
+	bodies []string
+}
+
+func (r *recordingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.bodies = append(r.bodies, string(body))
+	return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+}
+
+func ipResponse(address string) *http.Response {
+	return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(address))}
+}
+
+func TestNotify(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	recorder := &recordingHTTPClient{}
+	controllers.SlackClientOptions = append(
+		controllers.SlackClientOptions,
+		func(client *slack.DefaultClient) error {
+			client.Client = recorder
+			return nil
+		},
+	)
+	controllers.WebhookClientOptions = append(
+		controllers.WebhookClientOptions,
+		func(client *webhook.DefaultClient) error {
+			client.Client = recorder
+			return nil
+		},
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	baseEnv := func(extra map[string]string) map[string]string {
+		env := map[string]string{
+			"NETWORK_ID":            "bar",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+		}
+		for key, value := range extra {
+			env[key] = value
+		}
+		return env
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "notifies when the ip changes",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				recorder.bodies = []string{}
+
+				env := envy.MockEnv{}
+				err := env.Load(baseEnv(map[string]string{"NOTIFY_SLACK_URL": "http://slack"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				oldRecord := cloudflare.BuildDNSARecord("bar", "foo.net", "9.9.9.9")
+				oldRecord.ID = "old"
+				newRecord := cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4")
+				newRecord.ID = "new"
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{cloudflare.ToCloudFlareDNSRecord(oldRecord)})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: cloudflare.ToCloudFlareDNSRecord(newRecord)})).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(recorder.bodies).To(HaveLen(1))
+				g.Expect(recorder.bodies[0]).To(ContainSubstring("bar.foo.net changed from 9.9.9.9 to 1.2.3.4"))
+			},
+		},
+		{
+			testCase: "notifies about created and deleted records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				recorder.bodies = []string{}
+
+				env := envy.MockEnv{}
+				err := env.Load(baseEnv(map[string]string{"NOTIFY_WEBHOOK_URL": "http://hook"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				newRecord := cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4")
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: cloudflare.ToCloudFlareDNSRecord(newRecord)})).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(recorder.bodies).To(HaveLen(1))
+				g.Expect(recorder.bodies[0]).To(ContainSubstring(`"type":"record_created"`))
+
+				keptRecord := cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4")
+				keptRecord.ID = "kept"
+				extraRecord := cloudflare.BuildDNSARecord("bar", "foo.net", "5.5.5.5")
+				extraRecord.ID = "extra"
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{
+					cloudflare.ToCloudFlareDNSRecord(keptRecord),
+					cloudflare.ToCloudFlareDNSRecord(extraRecord),
+				})).To(Succeed())
+
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(recorder.bodies).To(HaveLen(2))
+				g.Expect(recorder.bodies[1]).To(ContainSubstring(`"type":"record_deleted"`))
+			},
+		},
+		{
+			testCase: "notifies about failed syncs",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				recorder.bodies = []string{}
+
+				env := envy.MockEnv{}
+				err := env.Load(baseEnv(map[string]string{
+					"NOTIFY_WEBHOOK_URL":       "http://hook",
+					"NOTIFY_FAILURE_THRESHOLD": "1",
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				// Failures don't leak secrets through the notifications
+				secrets.DefaultRedactor.Add("n0tify-secret")
+				g.Expect(envy.AddErrorReturns("Do", fmt.Errorf("offline n0tify-secret"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("offline n0tify-secret"))
+				g.Expect(recorder.bodies).To(HaveLen(1))
+				g.Expect(recorder.bodies[0]).To(ContainSubstring(`"type":"sync_failed"`))
+				g.Expect(recorder.bodies[0]).To(ContainSubstring(`"error":"offline [REDACTED]"`))
+			},
+		},
+		{
+			testCase: "counts failures across one-shot syncs",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				recorder.bodies = []string{}
+
+				stateDir := tt.TempDir()
+				env := envy.MockEnv{}
+				err := env.Load(baseEnv(map[string]string{
+					"NOTIFY_WEBHOOK_URL": "http://hook",
+					"STATE_DIR":          stateDir,
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				for i := 0; i < 3; i++ {
+					g.Expect(envy.AddErrorReturns("Do", fmt.Errorf("offline"))).To(Succeed())
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+					g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError("offline"))
+				}
+				g.Expect(recorder.bodies).To(HaveLen(1))
+				g.Expect(recorder.bodies[0]).To(ContainSubstring(`"failures":3`))
+
+				// A successful sync resets the count
+				record := cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4")
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{cloudflare.ToCloudFlareDNSRecord(record)})).To(Succeed())
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+
+				g.Expect(envy.AddErrorReturns("Do", fmt.Errorf("offline"))).To(Succeed())
+				app = controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError("offline"))
+				g.Expect(recorder.bodies).To(HaveLen(1))
+
+				// Failing to save the count doesn't hide the sync error
+				g.Expect(os.Mkdir(filepath.Join(stateDir, notify.FileName+".tmp"), 0o700)).To(Succeed())
+				g.Expect(envy.AddErrorReturns("Do", fmt.Errorf("offline"))).To(Succeed())
+				app = controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError("offline"))
+			},
+		},
+		{
+			testCase: "returns error reading the notification state",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				g.Expect(os.WriteFile(filepath.Join(stateDir, notify.FileName), []byte("{"), 0o600)).To(Succeed())
+				env := envy.MockEnv{}
+				err := env.Load(baseEnv(map[string]string{"STATE_DIR": stateDir}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError(ContainSubstring(notify.FileName)))
+			},
+		},
+		{
+			testCase: "logs notification delivery errors without failing",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(baseEnv(map[string]string{
+					"NOTIFY_SMTP_HOST":     "smtp.foo.net",
+					"NOTIFY_SMTP_USERNAME": "user",
+					"NOTIFY_EMAIL_FROM":    "qrkdns@foo.net",
+					"NOTIFY_EMAIL_TO":      "ops@foo.net",
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				oldEmailClientOptions := controllers.EmailClientOptions
+				defer func() {
+					controllers.EmailClientOptions = oldEmailClientOptions
+				}()
+				controllers.EmailClientOptions = append(
+					controllers.EmailClientOptions,
+					func(client *email.DefaultClient) error {
+						client.Client = &mocks.MockSMTPClient{}
+						return nil
+					},
+				)
+
+				newRecord := cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4")
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: cloudflare.ToCloudFlareDNSRecord(newRecord)})).To(Succeed())
+				g.Expect(envy.AddErrorReturns("SendMail", fmt.Errorf("relay denied"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+		},
+		{
+			testCase: "reads the SMTP password from a command and redacts it from logs",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				output := &bytes.Buffer{}
+				log.SetOutput(output)
+				defer log.SetOutput(os.Stderr)
+
+				env := envy.MockEnv{}
+				err := env.Load(baseEnv(map[string]string{
+					"NOTIFY_SMTP_HOST":             "smtp.foo.net",
+					"NOTIFY_SMTP_USERNAME":         "user",
+					"NOTIFY_SMTP_PASSWORD_COMMAND": "echo sm7p-pass",
+					"NOTIFY_EMAIL_FROM":            "qrkdns@foo.net",
+					"NOTIFY_EMAIL_TO":              "ops@foo.net",
+					"PRE_SYNC_HOOK":                "echo using sm7p-pass",
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				var auth smtp.Auth
+				oldEmailClientOptions := controllers.EmailClientOptions
+				defer func() {
+					controllers.EmailClientOptions = oldEmailClientOptions
+				}()
+				controllers.EmailClientOptions = append(
+					controllers.EmailClientOptions,
+					func(client *email.DefaultClient) error {
+						auth = client.Auth
+						client.Client = &mocks.MockSMTPClient{}
+						return nil
+					},
+				)
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4"))})).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+
+				g.Expect(auth).NotTo(BeNil())
+				_, credentials, err := auth.Start(&smtp.ServerInfo{Name: "smtp.foo.net", TLS: true})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(string(credentials)).To(Equal("\x00user\x00sm7p-pass"))
+				g.Expect(output.String()).To(ContainSubstring(`"command":"echo using [REDACTED]"`))
+				g.Expect(output.String()).NotTo(ContainSubstring("sm7p-pass"))
+
+				// Errors reading the password fail the sync
+				env.Restore()
+				env = envy.MockEnv{}
+				g.Expect(env.Load(baseEnv(map[string]string{
+					"NOTIFY_SMTP_HOST":             "smtp.foo.net",
+					"NOTIFY_SMTP_USERNAME":         "user",
+					"NOTIFY_SMTP_PASSWORD_COMMAND": "exit 1",
+					"NOTIFY_EMAIL_FROM":            "qrkdns@foo.net",
+					"NOTIFY_EMAIL_TO":              "ops@foo.net",
+				}))).To(Succeed())
+				app = controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError("running notify-smtp-password command: exit status 1"))
+			},
+		},
+		{
+			testCase: "returns error for email notifications without a sender",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(baseEnv(map[string]string{
+					"NOTIFY_SMTP_HOST": "smtp.foo.net",
+					"NOTIFY_EMAIL_TO":  "ops@foo.net",
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("options [--notify-email-from] are required when using email notifications"))
+			},
+		},
+		{
+			testCase: "returns error for email notifications without recipients",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(baseEnv(map[string]string{
+					"NOTIFY_SMTP_HOST":  "smtp.foo.net",
+					"NOTIFY_EMAIL_FROM": "qrkdns@foo.net",
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("at least one recipient is required"))
+			},
+		},
+		{
+			testCase: "returns error for invalid webhook template",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(baseEnv(map[string]string{
+					"NOTIFY_WEBHOOK_URL":      "http://hook",
+					"NOTIFY_WEBHOOK_TEMPLATE": "{{ .Nope ",
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "returns error from slack client",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(baseEnv(map[string]string{"NOTIFY_SLACK_URL": "http://slack"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				oldSlackClientOptions := controllers.SlackClientOptions
+				defer func() {
+					controllers.SlackClientOptions = oldSlackClientOptions
+				}()
+				controllers.SlackClientOptions = append(
+					controllers.SlackClientOptions,
+					func(client *slack.DefaultClient) error {
+						return fmt.Errorf("boo")
+					},
+				)
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("boo"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,184 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// PrefixHostFlag wraps the name of the command flag
+	PrefixHostFlag string = "prefix-host"
+
+	// PrefixSourceFlag wraps the name of the command flag
+	PrefixSourceFlag string = "prefix-source"
+
+	// PrefixLengthFlag wraps the name of the command flag
+	PrefixLengthFlag string = "prefix-length"
+
+	// PrefixServiceURLFlag wraps the name of the command flag
+	PrefixServiceURLFlag string = "prefix-service-url"
+)
+
+// prefixFlags returns the flags used to publish the addresses of hosts in
+// the IPv6 prefix delegated to the network
+func prefixFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    PrefixHostFlag,
+			Usage:   "Host receiving an AAAA record made of the delegated prefix and its interface identifier, as <name>=<suffix>, e.g., nas=::10 (repeatable)",
+			EnvVars: []string{"PREFIX_HOSTS"},
+		},
+		&cli.StringFlag{
+			Name:    PrefixSourceFlag,
+			Usage:   fmt.Sprintf("Source of an IPv6 address within the delegated prefix (%v, %v or %v:<name>)", ip.SourceHTTP, ip.SourceInterface, ip.SourceInterface),
+			EnvVars: []string{"PREFIX_SOURCE"},
+			Value:   ip.SourceInterface,
+		},
+		&cli.IntFlag{
+			Name:    PrefixLengthFlag,
+			Usage:   "Length of the delegated prefix taken from the discovered address",
+			EnvVars: []string{"PREFIX_LENGTH"},
+			Value:   64,
+		},
+		&cli.StringFlag{
+			Name:    PrefixServiceURLFlag,
+			Usage:   fmt.Sprintf("Web service answering with the IPv6 address of this host, with --%v %v", PrefixSourceFlag, ip.SourceHTTP),
+			EnvVars: []string{"PREFIX_SERVICE_URL"},
+			Value:   "https://api6.ipify.org",
+		},
+	}
+}
+
+// prefixHost is a host addressed within the delegated prefix
+type prefixHost struct {
+	// name is relative to the domain, in its A-label form
+	name   string
+	suffix netip.Addr
+}
+
+// delegation publishes the addresses of hosts in the delegated prefix
+type delegation struct {
+	hosts  []prefixHost
+	length int
+}
+
+// buildDelegation creates the delegation from the command flags, or
+// returns nil if no host is configured
+func buildDelegation(c *cli.Context) (*delegation, error) {
+	specs := c.StringSlice(PrefixHostFlag)
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	length := c.Int(PrefixLengthFlag)
+	if length < 1 || length > 127 {
+		return nil, fmt.Errorf("--%v must be between 1 and 127", PrefixLengthFlag)
+	}
+	if _, _, err := ip.ParseSource(c.String(PrefixSourceFlag)); err != nil {
+		return nil, err
+	}
+
+	// A CNAME can't share its name with the AAAA records
+	_, aliases, err := managedNames(c)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, alias := range aliases {
+		seen[alias] = true
+	}
+
+	d := &delegation{hosts: []prefixHost{}, length: length}
+	for _, spec := range specs {
+		name, suffix, found := strings.Cut(spec, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid prefix host %q, expected <name>=<suffix>", spec)
+		}
+		names, err := asciiNames(c.String(DomainFlag), []string{strings.TrimSpace(name)})
+		if err != nil {
+			return nil, err
+		}
+		if seen[names[0]] {
+			return nil, fmt.Errorf("name %v is configured more than once", dns.ToUnicode(dns.FQDN(names[0], domainName(c))))
+		}
+		seen[names[0]] = true
+
+		parsed, err := ip.ParseSuffix(strings.TrimSpace(suffix), length)
+		if err != nil {
+			return nil, err
+		}
+		d.hosts = append(d.hosts, prefixHost{name: names[0], suffix: parsed})
+	}
+	return d, nil
+}
+
+// syncPrefix discovers the delegated prefix and points the AAAA record of
+// every host to its address within it
+func (s *syncer) syncPrefix(ctx context.Context, c *cli.Context) error {
+	address, err := discoverIPv6(ctx, c)
+	if err != nil {
+		return err
+	}
+	prefix, err := ip.Prefix(address, s.delegation.length)
+	if err != nil {
+		log.WithError(err).Error("Failed to read the delegated prefix")
+		return err
+	}
+	contextLog := log.WithField("prefix", prefix.String())
+
+	dnsClient, err := buildDNSProvider(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build DNS client")
+		return err
+	}
+	for _, host := range s.delegation.hosts {
+		name := dns.FQDN(host.name, domainName(c))
+		hostAddress := ip.Combine(prefix, host.suffix).String()
+		result, err := dns.ApplyRecordSet(ctx, dnsClient, dns.RecordTypeAAAA, name, []string{hostAddress})
+		if err != nil {
+			contextLog.WithError(err).WithField("name", dns.ToUnicode(name)).Error("Failed to apply host AAAA record")
+			return err
+		}
+		s.record(historyFromSet(c.String(ProviderTypeFlag), result)...)
+		if result.Changed() {
+			contextLog.WithFields(log.Fields{"name": dns.ToUnicode(name), "address": hostAddress}).Info("Host AAAA record applied")
+		}
+
+		err = dns.EnsureOwnership(ctx, dnsClient, name, c.String(OwnerIDFlag))
+		if err != nil {
+			log.WithError(err).Error("Failed to record ownership")
+			return err
+		}
+	}
+	contextLog.WithField("hosts", len(s.delegation.hosts)).Debug("Delegated prefix synced")
+	return nil
+}
+
+// discoverIPv6 returns an IPv6 address of the host according to the prefix
+// source
+func discoverIPv6(ctx context.Context, c *cli.Context) (string, error) {
+	// The source is validated while building the delegation
+	source := c.String(PrefixSourceFlag)
+	kind, name, _ := ip.ParseSource(source)
+	if kind == ip.SourceHTTP {
+		return discoverIP(ctx, c, c.String(PrefixServiceURLFlag))
+	}
+
+	client, err := ip.NewLocalClient(name, LocalIPClientOptions...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build local IP client")
+		return "", err
+	}
+	address, err := client.GetLocalIPv6Address(ctx)
+	if err != nil {
+		log.WithError(err).WithField("source", source).Error("Failed to get local IPv6 address")
+		return "", err
+	}
+	return address, nil
+}
@@ -0,0 +1,209 @@
+package controllers_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+// withInterfacePrefixes makes every interface hold the addresses, given
+// with their prefix length
+func withInterfacePrefixes(addresses ...string) func() {
+	options := controllers.LocalIPClientOptions
+	controllers.LocalIPClientOptions = []ip.LocalLoadOption{func(client *ip.LocalClient) error {
+		client.InterfaceAddrs = func(name string) ([]net.Addr, error) {
+			addrs := []net.Addr{}
+			for _, address := range addresses {
+				parsed, network, err := net.ParseCIDR(address)
+				if err != nil {
+					return nil, err
+				}
+				network.IP = parsed
+				addrs = append(addrs, network)
+			}
+			return addrs, nil
+		}
+		return nil
+	}}
+	return func() { controllers.LocalIPClientOptions = options }
+}
+
+func TestPrefixDelegation(t *testing.T) {
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	// sync runs a single sync of home.foo.net and the hosts of the prefix
+	sync := func(g *WithT, sdkClient cloudflare.SDKClient, extra map[string]string) error {
+		values := map[string]string{
+			"NETWORK_ID":            "home",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"CLOUDFLARE_ZONE_ID":    "zone",
+			"PREFIX_HOSTS":          "nas=::10,printer=::a:b:c:d",
+			"PREFIX_SOURCE":         "interface:eth0",
+		}
+		for key, value := range extra {
+			values[key] = value
+		}
+		env := envy.MockEnv{}
+		g.Expect(env.Load(values)).To(Succeed())
+		defer env.Restore()
+		defer withSDKClient(sdkClient)()
+
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+		return app.Run([]string{"qrkdns", "sync"})
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "publishes the addresses of the hosts within the delegated prefix",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &memorySDKClient{records: []sdk.DNSRecord{
+					{ID: "old", Type: "AAAA", Name: "nas.foo.net", Content: "2001:db8:9:9::10"},
+				}}
+				restore := withInterfacePrefixes("fe80::1/64", "fd00::1/64", "2001:db8:1:2::1/64")
+				defer restore()
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, nil)).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeAAAA)).To(Equal([]string{
+					"nas.foo.net 2001:db8:1:2::10",
+					"printer.foo.net 2001:db8:1:2:a:b:c:d",
+				}))
+				g.Expect(sdkClient.published(dns.RecordTypeTXT)).To(ContainElements(
+					ContainSubstring("_qrkdns.nas.foo.net"),
+					ContainSubstring("_qrkdns.printer.foo.net"),
+				))
+
+				// A rotated prefix moves every host
+				restore()
+				defer withInterfacePrefixes("2001:db8:1:3::1/64")()
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, nil)).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeAAAA)).To(Equal([]string{
+					"nas.foo.net 2001:db8:1:3::10",
+					"printer.foo.net 2001:db8:1:3:a:b:c:d",
+				}))
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{"home.foo.net 5.6.7.8"}))
+			},
+		},
+		{
+			testCase: "discovers the delegated prefix from an IP service",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &memorySDKClient{}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"), ipResponse("2001:db8:1:2::abcd"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, map[string]string{
+					"PREFIX_SOURCE": "http",
+					"PREFIX_LENGTH": "56",
+					"PREFIX_HOSTS":  "nas=0:0:0:5::10",
+				})).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeAAAA)).To(Equal([]string{"nas.foo.net 2001:db8:1:5::10"}))
+			},
+		},
+		{
+			testCase: "returns error publishing the hosts",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				defer withInterfacePrefixes("fd00::1/64")()
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(sync(g, &memorySDKClient{}, nil)).To(MatchError("interface eth0 has no global IPv6 address"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"), ipResponse("fd00::1"))).To(Succeed())
+				g.Expect(sync(g, &memorySDKClient{}, map[string]string{"PREFIX_SOURCE": "http"})).To(MatchError(`"fd00::1" is not a global IPv6 address`))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"), ipResponse("2001:db8::1"))).To(Succeed())
+				sdkClient := &memorySDKClient{
+					records:   []sdk.DNSRecord{{ID: "old", Type: "AAAA", Name: "nas.foo.net", Content: "2001:db8:9:9::10"}},
+					deleteErr: errors.New("boom"),
+				}
+				g.Expect(sync(g, sdkClient, map[string]string{"PREFIX_SOURCE": "http"})).To(MatchError("boom"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"), ipResponse("2001:db8::1"))).To(Succeed())
+				g.Expect(sync(g, &ownerlessSDKClient{}, map[string]string{"PREFIX_SOURCE": "http", "PREFIX_HOSTS": "www=::10"})).To(MatchError("boom"))
+			},
+		},
+		{
+			testCase: "publishes the hosts when the public sync fails",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				defer withInterfacePrefixes("2001:db8:1:2::1/64")()
+				sdkClient := &memorySDKClient{}
+				g.Expect(envy.AddErrorReturns("Do", errors.New("offline"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, nil)).To(MatchError("offline"))
+				g.Expect(sdkClient.published(dns.RecordTypeAAAA)).To(Equal([]string{
+					"nas.foo.net 2001:db8:1:2::10",
+					"printer.foo.net 2001:db8:1:2:a:b:c:d",
+				}))
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "returns error building the clients",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				// Both views fail to build their DNS client
+				restore := withInterfacePrefixes("2001:db8:1:2::1/64")
+				err := sync(g, &memorySDKClient{}, map[string]string{"PROVIDER": "foo"})
+				g.Expect(err).To(MatchError("unsupported DNS client: foo\nunsupported DNS client: foo"))
+				restore()
+
+				options := controllers.LocalIPClientOptions
+				controllers.LocalIPClientOptions = []ip.LocalLoadOption{func(client *ip.LocalClient) error {
+					return errors.New("boom")
+				}}
+				defer func() { controllers.LocalIPClientOptions = options }()
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				g.Expect(sync(g, &memorySDKClient{}, nil)).To(MatchError("boom"))
+			},
+		},
+		{
+			testCase: "returns error for invalid prefix settings",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for message, extra := range map[string]map[string]string{
+					`invalid prefix host "nas", expected <name>=<suffix>`:                           {"PREFIX_HOSTS": "nas"},
+					`invalid interface identifier "nope", expected an IPv6 suffix such as ::10`:     {"PREFIX_HOSTS": "nas=nope"},
+					"interface identifier 0:0:0:5::10 doesn't fit in the host part of a /64 prefix": {"PREFIX_HOSTS": "nas=0:0:0:5::10"},
+					"--prefix-length must be between 1 and 127":                                     {"PREFIX_LENGTH": "128"},
+					`invalid IP source "nope", expected http, interface or interface:<name>`:        {"PREFIX_SOURCE": "nope"},
+					"invalid name -nas.foo.net: label -nas starts or ends with a hyphen":            {"PREFIX_HOSTS": "-nas=::10"},
+					"name nas.foo.net is configured more than once":                                 {"PREFIX_HOSTS": "nas=::10,nas=::11"},
+					"name www.foo.net is configured more than once":                                 {"PREFIX_HOSTS": "www=::10", "CNAME_NAMES": "www"},
+					"CNAME records can't alias the wildcard *.home.foo.net":                         {"NETWORK_ID": "*.home", "CNAME_NAMES": "www"},
+				} {
+					err := sync(g, &memorySDKClient{}, extra)
+					g.Expect(err).To(MatchError(message), message)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/dyndns"
+	"github.com/markliederbach/qrkdns/pkg/clients/election"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// RecordNameFlag wraps the name of the command flag
+	RecordNameFlag string = "name"
+
+	// RecordTypeFlag wraps the name of the command flag
+	RecordTypeFlag string = "type"
+
+	// RecordOwnerFlag wraps the name of the command flag
+	RecordOwnerFlag string = "owner"
+
+	// YesFlag wraps the name of the command flag
+	YesFlag string = "yes"
+
+	// DryRunFlag wraps the name of the command flag
+	DryRunFlag string = "dry-run"
+)
+
+// ownedRecord is a record annotated with the qrkdns owner of its name
+type ownedRecord struct {
+	dns.Record
+	Owner string `json:"owner"`
+}
+
+// RecordsCommand returns the command group used to manage records directly
+func RecordsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "records",
+		Usage: "Inspect and clean up records in the provider",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List records in the zone",
+				Flags: flagsOf(
+					providerFlags(),
+					[]cli.Flag{
+						&cli.StringFlag{
+							Name:  RecordNameFlag,
+							Usage: "Only list records with this name (relative to the domain or fully qualified)",
+						},
+						&cli.StringFlag{
+							Name:  RecordTypeFlag,
+							Usage: "Only list records of this type (e.g., A)",
+						},
+						&cli.StringFlag{
+							Name:  RecordOwnerFlag,
+							Usage: "Only list records managed by this owner ID",
+						},
+						outputFlag(),
+					},
+				),
+				Action: listRecords,
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete every record with the given name",
+				ArgsUsage: "<name>",
+				Flags: flagsOf(
+					providerFlags(),
+					[]cli.Flag{
+						&cli.StringFlag{
+							Name:  RecordTypeFlag,
+							Usage: "Only delete records of this type (e.g., A)",
+						},
+						yesFlag(),
+					},
+				),
+				Action: deleteRecords,
+			},
+			{
+				Name:  "prune",
+				Usage: "Delete records managed by this owner whose names are no longer configured",
+				Flags: flagsOf(
+					recordFlags(),
+					providerFlags(),
+					failoverFlags(),
+					prefixFlags(),
+					internalFlags(),
+					[]cli.Flag{
+						&cli.BoolFlag{
+							Name:  DryRunFlag,
+							Usage: "Only print the records that would be deleted",
+						},
+						&cli.StringFlag{
+							Name:    UsersFileFlag,
+							Usage:   "Users file of the dyndns server, whose hosts are kept",
+							EnvVars: []string{"DYNDNS_USERS_FILE"},
+						},
+						yesFlag(),
+					},
+				),
+				Action: pruneRecords,
+			},
+			{
+				Name:      "follow",
+				Usage:     "Point the records still serving an old address, except the names managed by qrkdns, to the new one",
+				ArgsUsage: "<old-ip>",
+				Flags: flagsOf(
+					recordFlags(),
+					providerFlags(),
+					[]cli.Flag{
+						followNameFlag(),
+						&cli.StringFlag{
+							Name:  ToFlag,
+							Usage: "New address of the records. Defaults to the address published for the network ID",
+						},
+						&cli.BoolFlag{
+							Name:  DryRunFlag,
+							Usage: "Only print the records that would be updated",
+						},
+						yesFlag(),
+					},
+				),
+				Action: followRecords,
+			},
+		},
+	}
+}
+
+// yesFlag returns the flag skipping confirmation prompts
+func yesFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:    YesFlag,
+		Aliases: []string{"y"},
+		Usage:   "Don't ask for confirmation",
+	}
+}
+
+// listRecords prints the records in the zone, annotated with their owner
+func listRecords(c *cli.Context) error {
+	ctx, cancel, err := withTimeout(c)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	dnsClient, err := buildDNSProvider(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build DNS client")
+		return err
+	}
+
+	filter := dns.RecordFilter{Type: dns.RecordType(strings.ToUpper(c.String(RecordTypeFlag)))}
+	if name := c.String(RecordNameFlag); name != "" {
+		filter.Name = qualifyName(name, c.String(DomainFlag))
+	}
+
+	records, err := listOwnedRecords(ctx, dnsClient, filter)
+	if err != nil {
+		return err
+	}
+
+	owner := c.String(RecordOwnerFlag)
+	filtered := []ownedRecord{}
+	for _, record := range records {
+		if owner == "" || record.Owner == owner {
+			filtered = append(filtered, record)
+		}
+	}
+	return writeRecords(c.App.Writer, c.String(OutputFlag), filtered)
+}
+
+// deleteRecords deletes the records with the given name after confirmation
+func deleteRecords(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected exactly one record name")
+	}
+
+	ctx, cancel, err := withTimeout(c)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	dnsClient, err := buildDNSProvider(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build DNS client")
+		return err
+	}
+
+	name := qualifyName(c.Args().First(), c.String(DomainFlag))
+	recordType := dns.RecordType(strings.ToUpper(c.String(RecordTypeFlag)))
+
+	records, err := dnsClient.ListRecords(ctx, dns.RecordFilter{Name: name, Type: recordType})
+	if err != nil {
+		return err
+	}
+	if recordType == "" {
+		// Deleting the whole name also removes its ownership record
+		ownership, err := dnsClient.ListRecords(ctx, dns.RecordFilter{Name: dns.OwnershipRecordName(name), Type: dns.RecordTypeTXT})
+		if err != nil {
+			return err
+		}
+		records = append(records, ownership...)
+	}
+
+	return deleteWithConfirmation(ctx, c, dnsClient, records)
+}
+
+// pruneRecords deletes the records qrkdns created for the names managed by
+// this owner that are no longer configured
+func pruneRecords(c *cli.Context) error {
+	// Every agent left on the default owner ID would claim the records of
+	// the others, so pruning needs an owner ID unique to this agent
+	if !c.IsSet(OwnerIDFlag) {
+		return fmt.Errorf("--%v must be set to an ID unique to this agent to prune records", OwnerIDFlag)
+	}
+
+	configured, err := configuredNames(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(c)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	dnsClient, err := buildDNSProvider(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build DNS client")
+		return err
+	}
+
+	records, err := dnsClient.ListRecords(ctx, dns.RecordFilter{})
+	if err != nil {
+		return err
+	}
+
+	owner := c.String(OwnerIDFlag)
+	stale := make(map[string]bool)
+	for name, recordOwner := range dns.Owners(records) {
+		if recordOwner == owner && !configured[name] {
+			stale[name] = true
+		}
+	}
+
+	// Only the records qrkdns creates are pruned, so that hand-made records
+	// of other types at a stale name survive
+	metadata := make(map[string]bool)
+	for name := range stale {
+		metadata[dns.LeaseRecordName(name)] = true
+		metadata[election.LockRecordName(name)] = true
+	}
+	plan := []dns.Record{}
+	for _, record := range records {
+		if name, recordOwner, ok := dns.ParseOwnership(record); ok {
+			if recordOwner == owner && stale[name] {
+				plan = append(plan, record)
+			}
+			continue
+		}
+		switch record.Type {
+		case dns.RecordTypeA, dns.RecordTypeAAAA, dns.RecordTypeCNAME:
+			if stale[record.Name] {
+				plan = append(plan, record)
+			}
+		case dns.RecordTypeTXT:
+			if metadata[record.Name] {
+				plan = append(plan, record)
+			}
+		}
+	}
+
+	if c.Bool(DryRunFlag) {
+		return writeRecords(c.App.Writer, OutputFormatTable, toOwnedRecords(plan, owner))
+	}
+	return deleteWithConfirmation(ctx, c, dnsClient, plan)
+}
+
+// configuredNames returns the fully qualified names qrkdns is configured to
+// publish records for
+func configuredNames(c *cli.Context) (map[string]bool, error) {
+	names, aliases, err := managedNames(c)
+	if err != nil {
+		return nil, err
+	}
+	names = append(names, aliases...)
+	internal, err := internalNames(c, names)
+	if err != nil {
+		return nil, err
+	}
+	if c.String(FailoverNameFlag) != "" {
+		failoverNames, err := asciiNames(c.String(DomainFlag), []string{c.String(FailoverNameFlag)})
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, failoverNames...)
+	}
+	delegation, err := buildDelegation(c)
+	if err != nil {
+		return nil, err
+	}
+	if delegation != nil {
+		for _, host := range delegation.hosts {
+			names = append(names, host.name)
+		}
+	}
+
+	configured := make(map[string]bool)
+	for _, name := range names {
+		configured[dns.FQDN(name, domainName(c))] = true
+	}
+	// Internal names of another zone never match the listed records
+	for _, name := range internal {
+		configured[dns.FQDN(name, asciiName(internalDomain(c)))] = true
+	}
+	if c.String(UsersFileFlag) != "" {
+		users, err := dyndns.LoadUsers(c.String(UsersFileFlag))
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			for _, host := range user.Hosts {
+				configured[host] = true
+			}
+		}
+	}
+	return configured, nil
+}
+
+// deleteWithConfirmation prints the records, asks for confirmation, then deletes them
+func deleteWithConfirmation(ctx context.Context, c *cli.Context, dnsClient dns.Provider, records []dns.Record) error {
+	if len(records) == 0 {
+		fmt.Fprintln(c.App.Writer, "No records to delete")
+		return nil
+	}
+
+	if err := writeRecords(c.App.Writer, OutputFormatTable, toOwnedRecords(records, "")); err != nil {
+		return err
+	}
+
+	if err := confirmOrAbort(c, fmt.Sprintf("Delete %v record(s)?", len(records))); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		log.WithField("record", record).Info("Deleting record")
+		if err := dnsClient.DeleteRecord(ctx, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listOwnedRecords lists records matching filter, annotated with their owner.
+// Ownership records themselves are left out.
+func listOwnedRecords(ctx context.Context, dnsClient dns.Provider, filter dns.RecordFilter) ([]ownedRecord, error) {
+	records, err := dnsClient.ListRecords(ctx, filter)
+	if err != nil {
+		return []ownedRecord{}, err
+	}
+
+	ownership, err := dnsClient.ListRecords(ctx, dns.RecordFilter{Type: dns.RecordTypeTXT})
+	if err != nil {
+		return []ownedRecord{}, err
+	}
+	owners := dns.Owners(ownership)
+
+	results := []ownedRecord{}
+	for _, record := range records {
+		if _, _, ok := dns.ParseOwnership(record); ok {
+			continue
+		}
+		results = append(results, ownedRecord{Record: record, Owner: owners[record.Name]})
+	}
+	return results, nil
+}
+
+// toOwnedRecords annotates every record with the same owner
+func toOwnedRecords(records []dns.Record, owner string) []ownedRecord {
+	results := []ownedRecord{}
+	for _, record := range records {
+		results = append(results, ownedRecord{Record: record, Owner: owner})
+	}
+	return results
+}
+
+// writeRecords prints records in the requested format
+func writeRecords(w io.Writer, format string, records []ownedRecord) error {
+	switch format {
+	case OutputFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case OutputFormatTable:
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "NAME\tTYPE\tCONTENT\tTTL\tOWNER")
+		for _, record := range records {
+			fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\n", dns.ToUnicode(record.Name), record.Type, record.Content, record.TTL, orDash(record.Owner))
+		}
+		return table.Flush()
+	default:
+		return fmt.Errorf("unsupported output format: %v", format)
+	}
+}
+
+// confirmOrAbort asks for confirmation unless --yes was given, returning
+// an error if the answer was no
+func confirmOrAbort(c *cli.Context, question string) error {
+	if c.Bool(YesFlag) {
+		return nil
+	}
+	confirmed, err := confirm(inputReader(c), c.App.Writer, question)
+	if err != nil {
+		return err
+	}
+	if !confirmed {
+		return fmt.Errorf("aborted")
+	}
+	return nil
+}
+
+// confirm asks a yes/no question, defaulting to no
+func confirm(r io.Reader, w io.Writer, question string) (bool, error) {
+	fmt.Fprintf(w, "%v [y/N]: ", question)
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// inputReader returns the reader of the top-level app, since subcommand apps
+// don't inherit it
+func inputReader(c *cli.Context) io.Reader {
+	reader := c.App.Reader
+	for _, ctx := range c.Lineage() {
+		if ctx.App != nil && ctx.App.Reader != nil {
+			reader = ctx.App.Reader
+		}
+	}
+	return reader
+}
+
+// qualifyName returns the fully qualified form of a name relative to the domain
+func qualifyName(name, domain string) string {
+	name, domain = asciiName(strings.TrimSuffix(name, ".")), asciiName(domain)
+	if name == domain || strings.HasSuffix(name, "."+domain) {
+		return name
+	}
+	return fmt.Sprintf("%v.%v", name, domain)
+}
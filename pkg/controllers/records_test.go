@@ -0,0 +1,491 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/election"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+type failingReadWriter struct{}
+
+func (failingReadWriter) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("closed")
+}
+
+func (failingReadWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("closed")
+}
+
+func TestRecords(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	recordsEnv := map[string]string{
+		"NETWORK_ID":            "bar",
+		"DOMAIN_NAME":           "foo.net",
+		"CLOUDFLARE_ACCOUNT_ID": "foo",
+		"CLOUDFLARE_API_TOKEN":  "bar",
+		"OWNER_ID":              "qrkdns",
+	}
+
+	newRecordsApp := func(input string, output *bytes.Buffer) *cli.App {
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.RecordsCommand()})
+		app.Reader = strings.NewReader(input)
+		app.Writer = output
+		return app
+	}
+
+	aRecord := func(id, name, content string) sdk.DNSRecord {
+		return sdk.DNSRecord{ID: id, Type: "A", Name: name, Content: content, TTL: 1}
+	}
+	ownershipRecord := func(id, name, owner string) sdk.DNSRecord {
+		return sdk.DNSRecord{ID: id, Type: "TXT", Name: dns.OwnershipRecordName(name), Content: dns.OwnershipContent(owner), TTL: 1}
+	}
+
+	zone := []sdk.DNSRecord{
+		aRecord("1", "bar.foo.net", "1.2.3.4"),
+		ownershipRecord("2", "bar.foo.net", "qrkdns"),
+		aRecord("3", "old.foo.net", "5.6.7.8"),
+		ownershipRecord("4", "old.foo.net", "qrkdns"),
+		aRecord("5", "other.foo.net", "9.9.9.9"),
+		ownershipRecord("6", "other.foo.net", "someone-else"),
+		aRecord("7", "manual.foo.net", "4.4.4.4"),
+	}
+	ownership := []sdk.DNSRecord{zone[1], zone[3], zone[5]}
+
+	tests := []testRunner{
+		{
+			testCase: "lists records with their owners",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", zone, ownership)).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRecordsApp("", output).Run([]string{"qrkdns", "records", "list"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).To(MatchRegexp(`bar\.foo\.net\s+A\s+1\.2\.3\.4\s+1\s+qrkdns`))
+				g.Expect(output.String()).To(MatchRegexp(`other\.foo\.net\s+A\s+9\.9\.9\.9\s+1\s+someone-else`))
+				g.Expect(output.String()).To(MatchRegexp(`manual\.foo\.net\s+A\s+4\.4\.4\.4\s+1\s+-`))
+				g.Expect(output.String()).NotTo(ContainSubstring(dns.OwnershipPrefix))
+			},
+		},
+		{
+			testCase: "filters listed records by owner",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", zone, ownership)).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRecordsApp("", output).Run([]string{"qrkdns", "records", "list", "--owner", "qrkdns", "--type", "a", "--name", "old", "--output", "json"})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				records := []map[string]interface{}{}
+				g.Expect(json.Unmarshal(output.Bytes(), &records)).To(Succeed())
+				g.Expect(records).To(HaveLen(2))
+				g.Expect(records[0]["name"]).To(Equal("bar.foo.net"))
+				g.Expect(records[1]["name"]).To(Equal("old.foo.net"))
+				g.Expect(records[1]["owner"]).To(Equal("qrkdns"))
+			},
+		},
+		{
+			testCase: "returns error listing records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddErrorReturns("DNSRecords", fmt.Errorf("nope"))).To(Succeed())
+
+				err = newRecordsApp("", &bytes.Buffer{}).Run([]string{"qrkdns", "records", "list"})
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
+		{
+			testCase: "returns error listing ownership records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", zone)).To(Succeed())
+				g.Expect(envy.AddErrorReturns("DNSRecords", nil, fmt.Errorf("nope"))).To(Succeed())
+
+				err = newRecordsApp("", &bytes.Buffer{}).Run([]string{"qrkdns", "records", "list"})
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
+		{
+			testCase: "returns error for unsupported output format",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", zone, ownership)).To(Succeed())
+
+				err = newRecordsApp("", &bytes.Buffer{}).Run([]string{"qrkdns", "records", "list", "--output", "yaml"})
+				g.Expect(err).To(MatchError("unsupported output format: yaml"))
+			},
+		},
+		{
+			testCase: "returns configuration errors from every subcommand",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(map[string]string{
+					"NETWORK_ID":  "bar",
+					"DOMAIN_NAME": "foo.net",
+					"OWNER_ID":    "qrkdns",
+					"TIMEOUT":     "bad",
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				for _, args := range [][]string{{"list"}, {"delete", "old"}, {"prune"}} {
+					err = newRecordsApp("", &bytes.Buffer{}).Run(append([]string{"qrkdns", "records"}, args...))
+					g.Expect(err).To(MatchError(`time: invalid duration "bad"`))
+
+					err = newRecordsApp("", &bytes.Buffer{}).Run(append([]string{"qrkdns", "records", args[0], "--timeout", "1s"}, args[1:]...))
+					g.Expect(err).To(MatchError("options [--cf-account-id, --cf-api-token] are required when using cloudflare provider"))
+				}
+			},
+		},
+		{
+			testCase: "returns errors listing records to delete",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddErrorReturns("DNSRecords", fmt.Errorf("nope"))).To(Succeed())
+				err = newRecordsApp("", &bytes.Buffer{}).Run([]string{"qrkdns", "records", "delete", "old"})
+				g.Expect(err).To(MatchError("nope"))
+
+				g.Expect(envy.AddErrorReturns("DNSRecords", nil, fmt.Errorf("nope"))).To(Succeed())
+				err = newRecordsApp("", &bytes.Buffer{}).Run([]string{"qrkdns", "records", "delete", "old"})
+				g.Expect(err).To(MatchError("nope"))
+
+				g.Expect(envy.AddErrorReturns("DNSRecords", fmt.Errorf("nope"))).To(Succeed())
+				err = newRecordsApp("", &bytes.Buffer{}).Run([]string{"qrkdns", "records", "prune"})
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
+		{
+			testCase: "returns errors from the terminal",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{zone[2]}, []sdk.DNSRecord{zone[3]})).To(Succeed())
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.RecordsCommand()})
+				app.Reader = failingReadWriter{}
+				app.Writer = &bytes.Buffer{}
+				err = app.Run([]string{"qrkdns", "records", "delete", "old"})
+				g.Expect(err).To(MatchError("closed"))
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{zone[2]}, []sdk.DNSRecord{zone[3]})).To(Succeed())
+				app.Writer = failingReadWriter{}
+				err = app.Run([]string{"qrkdns", "records", "delete", "old"})
+				g.Expect(err).To(MatchError("closed"))
+			},
+		},
+		{
+			testCase: "aborts delete without confirmation",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{zone[2]}, []sdk.DNSRecord{zone[3]})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRecordsApp("n\n", output).Run([]string{"qrkdns", "records", "delete", "old"})
+				g.Expect(err).To(MatchError("aborted"))
+				g.Expect(output.String()).To(ContainSubstring("old.foo.net"))
+				g.Expect(output.String()).To(ContainSubstring("_qrkdns.old.foo.net"))
+				g.Expect(output.String()).To(ContainSubstring("Delete 2 record(s)? [y/N]"))
+			},
+		},
+		{
+			testCase: "deletes records after confirmation",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{zone[2]}, []sdk.DNSRecord{zone[3]})).To(Succeed())
+				// The second delete fails, proving both records were attempted
+				g.Expect(envy.AddErrorReturns("DeleteDNSRecord", nil, fmt.Errorf("nope"))).To(Succeed())
+
+				err = newRecordsApp("y\n", &bytes.Buffer{}).Run([]string{"qrkdns", "records", "delete", "old.foo.net"})
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
+		{
+			testCase: "deletes a single record type without prompting",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{zone[2]})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRecordsApp("", output).Run([]string{"qrkdns", "records", "delete", "--type", "a", "--yes", "old"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).NotTo(ContainSubstring("_qrkdns.old.foo.net"))
+				g.Expect(output.String()).NotTo(ContainSubstring("[y/N]"))
+			},
+		},
+		{
+			testCase: "requires a record name to delete",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				err = newRecordsApp("", &bytes.Buffer{}).Run([]string{"qrkdns", "records", "delete"})
+				g.Expect(err).To(MatchError("expected exactly one record name"))
+			},
+		},
+		{
+			testCase: "prune dry run lists only stale records of this owner",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", zone)).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRecordsApp("", output).Run([]string{"qrkdns", "records", "prune", "--dry-run"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).To(MatchRegexp(`\nold\.foo\.net\s+A\s+5\.6\.7\.8`))
+				g.Expect(output.String()).To(ContainSubstring("_qrkdns.old.foo.net"))
+				g.Expect(output.String()).NotTo(ContainSubstring("bar.foo.net"))
+				g.Expect(output.String()).NotTo(ContainSubstring("other.foo.net"))
+				g.Expect(output.String()).NotTo(ContainSubstring("manual.foo.net"))
+			},
+		},
+		{
+			testCase: "lists internationalized names in their U-label form",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{aRecord("1", "xn--br-via.foo.net", "1.2.3.4")}, []sdk.DNSRecord{})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRecordsApp("", output).Run([]string{"qrkdns", "records", "list", "--name", "BÄR"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).To(MatchRegexp(`\nbär\.foo\.net\s+A\s+1\.2\.3\.4`))
+
+				// Invalid names reach the provider as given, which rejects them
+				err = newRecordsApp("", &bytes.Buffer{}).Run([]string{"qrkdns", "records", "list", "--name", "xn--zz"})
+				g.Expect(err).To(MatchError(ContainSubstring("invalid name xn--zz.foo.net")))
+			},
+		},
+		{
+			testCase: "prune only deletes the records qrkdns creates",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", append([]sdk.DNSRecord{
+					{ID: "8", Type: "MX", Name: "old.foo.net", Content: "mail.foo.net", TTL: 1},
+					{ID: "9", Type: "TXT", Name: "old.foo.net", Content: "v=spf1 -all", TTL: 1},
+					{ID: "10", Type: "CNAME", Name: "old.foo.net", Content: "bar.foo.net", TTL: 1},
+					{ID: "11", Type: "TXT", Name: dns.LeaseRecordName("old.foo.net"), Content: dns.LeaseContent("a", "5.6.7.8", time.Unix(0, 0)), TTL: 1},
+					{ID: "12", Type: "TXT", Name: election.LockRecordName("old.foo.net"), Content: "heritage=qrkdns,holder=a,acquired=0,renewed=0", TTL: 1},
+					{ID: "13", Type: "TXT", Name: election.LockRecordName("bar.foo.net"), Content: "heritage=qrkdns,holder=a,acquired=0,renewed=0", TTL: 1},
+				}, zone...))).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRecordsApp("", output).Run([]string{"qrkdns", "records", "prune", "--dry-run"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).To(MatchRegexp(`\nold\.foo\.net\s+A\s+5\.6\.7\.8`))
+				g.Expect(output.String()).To(MatchRegexp(`\nold\.foo\.net\s+CNAME\s+bar\.foo\.net`))
+				g.Expect(output.String()).To(ContainSubstring("_qrkdns-lease.old.foo.net"))
+				g.Expect(output.String()).To(ContainSubstring("_qrkdns-leader.old.foo.net"))
+				g.Expect(output.String()).NotTo(ContainSubstring("MX"))
+				g.Expect(output.String()).NotTo(ContainSubstring("spf1"))
+				g.Expect(output.String()).NotTo(ContainSubstring("_qrkdns-leader.bar.foo.net"))
+			},
+		},
+		{
+			testCase: "prune keeps every configured name",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				prune := func(extra map[string]string, output *bytes.Buffer) error {
+					values := map[string]string{}
+					for key, value := range recordsEnv {
+						values[key] = value
+					}
+					for key, value := range extra {
+						values[key] = value
+					}
+					env := envy.MockEnv{}
+					g.Expect(env.Load(values)).To(Succeed())
+					defer env.Restore()
+					return newRecordsApp("", output).Run([]string{"qrkdns", "records", "prune", "--dry-run"})
+				}
+
+				for _, extra := range []map[string]string{{"ADDITIONAL_NAMES": "old"}, {"CNAME_NAMES": "old"}, {"FAILOVER_NAME": "old"}, {"PREFIX_HOSTS": "old=::10"}, {"INTERNAL_NAMES": "old"}} {
+					g.Expect(envy.AddObjectReturns("DNSRecords", zone)).To(Succeed())
+
+					output := &bytes.Buffer{}
+					g.Expect(prune(extra, output)).To(Succeed())
+					g.Expect(output.String()).NotTo(ContainSubstring("old.foo.net"))
+				}
+
+				err := prune(map[string]string{"ADDITIONAL_NAMES": "old."}, &bytes.Buffer{})
+				g.Expect(err).To(MatchError("invalid name old..foo.net: empty label"))
+
+				err = prune(map[string]string{"FAILOVER_NAME": "old."}, &bytes.Buffer{})
+				g.Expect(err).To(MatchError("invalid name old..foo.net: empty label"))
+
+				err = prune(map[string]string{"PREFIX_HOSTS": "old"}, &bytes.Buffer{})
+				g.Expect(err).To(MatchError(`invalid prefix host "old", expected <name>=<suffix>`))
+
+				err = prune(map[string]string{"INTERNAL_NAMES": "bar"}, &bytes.Buffer{})
+				g.Expect(err).To(MatchError("name bar.foo.net is in both views of the same zone, set --internal-domain or --internal-cf-zone-id"))
+
+				// The hosts updated by `qrkdns serve` are kept too
+				usersFile := filepath.Join(tt.TempDir(), "users")
+				g.Expect(os.WriteFile(usersFile, []byte("alice:secret:old.foo.net\n"), 0o600)).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", zone)).To(Succeed())
+				output := &bytes.Buffer{}
+				g.Expect(prune(map[string]string{"DYNDNS_USERS_FILE": usersFile}, output)).To(Succeed())
+				g.Expect(output.String()).NotTo(ContainSubstring("old.foo.net"))
+
+				err = prune(map[string]string{"DYNDNS_USERS_FILE": filepath.Join(tt.TempDir(), "missing")}, &bytes.Buffer{})
+				g.Expect(os.IsNotExist(err)).To(BeTrue())
+			},
+		},
+		{
+			testCase: "prune deletes stale records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", zone)).To(Succeed())
+				g.Expect(envy.AddErrorReturns("DeleteDNSRecord", nil, fmt.Errorf("nope"))).To(Succeed())
+
+				err = newRecordsApp("", &bytes.Buffer{}).Run([]string{"qrkdns", "records", "prune", "--yes"})
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
+		{
+			testCase: "prune requires an owner ID",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+				g.Expect(os.Unsetenv("OWNER_ID")).To(Succeed())
+
+				err = newRecordsApp("", &bytes.Buffer{}).Run([]string{"qrkdns", "records", "prune", "--dry-run"})
+				g.Expect(err).To(MatchError("--owner-id must be set to an ID unique to this agent to prune records"))
+			},
+		},
+		{
+			testCase: "prune reports nothing to delete",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(recordsEnv)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", zone[:2])).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRecordsApp("", output).Run([]string{"qrkdns", "records", "prune"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).To(Equal("No records to delete\n"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
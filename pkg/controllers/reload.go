@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"io"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/control"
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	"github.com/markliederbach/qrkdns/pkg/clients/scheduler"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// reload reads the configuration again and swaps it in once validated.
+// A sync in progress finishes with the configuration it started with, and
+// an invalid configuration is rejected, keeping the current one.
+func (a *agent) reload() error {
+	previous := configEnvironment.Values()
+	c, s, err := a.validate()
+	if err == nil && c.String(ScheduleFlag) != a.scheduler.CronSchedule {
+		err = a.scheduler.Replace(c.String(ScheduleFlag), a.scheduled, c)
+	}
+	if err != nil {
+		// Hooks inherit the environment, which must match what runs
+		_ = configEnvironment.Apply(previous)
+		log.WithError(err).Error("Rejected new configuration, keeping the current one")
+		a.reloaded(err)
+		return err
+	}
+
+	a.syncing.Lock()
+	a.mu.Lock()
+	s.inherit(a.syncer)
+	a.c, a.syncer = c, s
+	a.status.Schedule = c.String(ScheduleFlag)
+	a.status.Election = s.electionState()
+	a.mu.Unlock()
+	a.syncing.Unlock()
+
+	log.WithField("schedule", c.String(ScheduleFlag)).Info("Reloaded configuration")
+	a.reloaded(nil)
+	return nil
+}
+
+// validate parses the command line of `sync cron` again, along with the
+// current config file, and builds everything a sync needs from it
+func (a *agent) validate() (*cli.Context, *syncer, error) {
+	a.mu.Lock()
+	current := a.c
+	a.mu.Unlock()
+
+	c, err := reparse(current)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := scheduler.Validate(c.String(ScheduleFlag)); err != nil {
+		return nil, nil, err
+	}
+	names, aliases, err := managedNames(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := internalNames(c, append(names, aliases...)); err != nil {
+		return nil, nil, err
+	}
+	if _, _, err := ip.ParseSource(c.String(IPSourceFlag)); err != nil {
+		return nil, nil, err
+	}
+	if _, err := buildDNSProvider(c); err != nil {
+		return nil, nil, err
+	}
+	s, err := newSyncer(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, s, nil
+}
+
+// reloaded records the outcome of a reload in the status
+func (a *agent) reloaded(err error) {
+	reload := &control.Reload{Time: time.Now()}
+	if err != nil {
+		reload.Error = err.Error()
+	}
+	a.mu.Lock()
+	a.status.LastReload = reload
+	a.mu.Unlock()
+}
+
+// reparse runs the command line of the `sync cron` context again, in a
+// fresh app, returning the new context of `sync cron`. Global flags keep
+// their values, as the config file is read after them.
+func reparse(c *cli.Context) (*cli.Context, error) {
+	// The last context of the lineage only wraps the Go context
+	lineage := c.Lineage()
+	root := lineage[len(lineage)-2]
+
+	var reparsed *cli.Context
+	command := SyncCommand()
+	for _, subcommand := range command.Subcommands {
+		if subcommand.Name == "cron" {
+			subcommand.Action = func(c *cli.Context) error {
+				reparsed = c
+				return nil
+			}
+		}
+	}
+	app := NewQrkDNSApp(root.App.Version, []*cli.Command{command})
+	app.Writer = io.Discard
+	app.ErrWriter = io.Discard
+
+	args := append([]string{
+		root.App.Name,
+		"--" + LogLevelFlag, root.String(LogLevelFlag),
+		"--" + ConfigFileFlag, root.String(ConfigFileFlag),
+	}, root.Args().Slice()...)
+	if err := app.RunContext(c.Context, args); err != nil {
+		return nil, err
+	}
+	return reparsed, nil
+}
+
+// inherit carries the state tracked across syncs over from the syncer
+// being replaced, so a reload neither resets the failure count nor
+// announces the current address again
+func (s *syncer) inherit(previous *syncer) {
+	s.failures = previous.failures
+	s.lastIP = previous.lastIP
+	if s.observedIP == "" {
+		s.observedIP = previous.observedIP
+	}
+	s.rejections = previous.rejections
+	s.rejectedIP = previous.rejectedIP
+	s.suppressions = previous.suppressions
+	s.addUnfollowed(previous.unfollowed...)
+	s.inheritElector(previous)
+	s.inheritHealth(previous)
+}
@@ -0,0 +1,332 @@
+//go:build !windows
+
+package controllers_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/control"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/election"
+	"github.com/markliederbach/qrkdns/pkg/clients/health"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+func TestReload(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	tests := []testRunner{
+		{
+			testCase: "reloads the configuration on SIGHUP and syncs on SIGUSR1",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				config := filepath.Join(tt.TempDir(), "qrkdns.env")
+				writeConfig := func(lines ...string) {
+					g.Expect(os.WriteFile(config, []byte(strings.Join(lines, "\n")), 0o600)).To(Succeed())
+				}
+				writeConfig("NETWORK_ID=home", "SCHEDULE='*/5 * * * *'")
+
+				blocking, _, stop := startAgent(tt, g, map[string]string{
+					"CONFIG_FILE": config,
+					"NETWORK_ID":  "",
+					"SCHEDULE":    "",
+				})
+				defer stop()
+
+				// ctl reads no config file, leaving the environment as the
+				// test found it once done
+				ctl := func(args ...string) string {
+					output := &bytes.Buffer{}
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.CtlCommand()})
+					app.Writer = output
+					g.Expect(app.Run(append([]string{"qrkdns", "--config-file", "", "ctl"}, args...))).To(Succeed())
+					return output.String()
+				}
+				status := func() control.Status {
+					status := control.Status{}
+					g.Expect(json.Unmarshal([]byte(ctl("status", "--output", "json")), &status)).To(Succeed())
+					return status
+				}
+				reload := func() control.Reload {
+					last := status().LastReload
+					g.Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(Succeed())
+					var reload *control.Reload
+					g.Eventually(func() bool {
+						reload = status().LastReload
+						return reload != nil && (last == nil || reload.Time.After(last.Time))
+					}, 5*time.Second, 10*time.Millisecond).Should(BeTrue())
+					return *reload
+				}
+
+				g.Expect(status().Schedule).To(Equal("*/5 * * * *"))
+
+				writeConfig("NETWORK_ID=office", "SCHEDULE='*/10 * * * *'")
+				g.Expect(reload().Error).To(BeEmpty())
+				g.Expect(status().Schedule).To(Equal("*/10 * * * *"))
+				g.Expect(blocking.replaced()).To(Equal([]string{"*/10 * * * *"}))
+				g.Expect(ctl("status")).To(MatchRegexp(`Last reload: \S+ applied\n`))
+
+				// Both the schedule and signals sync with the new configuration
+				queueSync(g, "office", "1.2.3.4")
+				g.Expect(blocking.run()).To(Succeed())
+				queueSync(g, "office", "1.2.3.5")
+				g.Expect(syscall.Kill(os.Getpid(), syscall.SIGUSR1)).To(Succeed())
+				g.Eventually(func() int { return status().Runs }, 5*time.Second, 10*time.Millisecond).Should(Equal(2))
+				run := status().LastRun
+				g.Expect(run.Trigger).To(Equal(control.TriggerSignal))
+				g.Expect(run.Error).To(BeEmpty())
+				g.Expect(run.Results[0].Record.Name).To(Equal("office.foo.net"))
+
+				// The job stays put while the schedule doesn't change
+				g.Expect(reload().Error).To(BeEmpty())
+				g.Expect(blocking.replaced()).To(HaveLen(1))
+
+				schedule := "SCHEDULE='*/10 * * * *'"
+				for message, lines := range map[string][]string{
+					"cron expression failed to be parsed":                    {"NETWORK_ID=office", "SCHEDULE=nope"},
+					`Required flag "network-id" not set`:                     {"SCHEDULE='*/15 * * * *'"},
+					"name office.foo.net is configured more than once":       {"NETWORK_ID=office", "CNAME_NAMES=office", schedule},
+					"unsupported DNS client: nope":                           {"NETWORK_ID=office", "PROVIDER=nope", schedule},
+					`invalid IP source "nope"`:                               {"NETWORK_ID=office", "IP_SOURCE=nope", schedule},
+					"name office.foo.net is in both views of the same zone":  {"NETWORK_ID=office", "INTERNAL_NAMES=office", schedule},
+					`invalid CIDR "nope"`:                                    {"NETWORK_ID=office", "GUARD_DENY_CIDRS=nope", schedule},
+					"config file " + config + ": line 1: expected KEY=VALUE": {"nope"},
+				} {
+					writeConfig(lines...)
+					g.Expect(reload().Error).To(ContainSubstring(message), message)
+					g.Expect(status().Schedule).To(Equal("*/10 * * * *"), message)
+				}
+				g.Expect(ctl("status")).To(MatchRegexp(`Last reload: \S+ rejected: .+\n`))
+				g.Expect(blocking.replaced()).To(HaveLen(1))
+
+				// The previous configuration keeps running
+				queueSync(g, "office", "1.2.3.6")
+				g.Expect(blocking.run()).To(Succeed())
+				g.Expect(status().LastRun.Results[0].Record.Name).To(Equal("office.foo.net"))
+			},
+		},
+		{
+			testCase: "keeps the leadership across reloads",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				config := filepath.Join(tt.TempDir(), "qrkdns.env")
+				lock := filepath.Join(tt.TempDir(), "qrkdns.lock")
+				writeConfig := func(lines ...string) {
+					g.Expect(os.WriteFile(config, []byte(strings.Join(lines, "\n")), 0o600)).To(Succeed())
+				}
+				writeConfig("NETWORK_ID=home", "ELECTION=file:"+lock)
+
+				blocking, _, stop := startAgent(tt, g, map[string]string{
+					"CONFIG_FILE": config,
+					"NETWORK_ID":  "",
+				})
+				defer stop()
+
+				status := func() control.Status {
+					output := &bytes.Buffer{}
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.CtlCommand()})
+					app.Writer = output
+					g.Expect(app.Run([]string{"qrkdns", "--config-file", "", "ctl", "status", "--output", "json"})).To(Succeed())
+					status := control.Status{}
+					g.Expect(json.Unmarshal(output.Bytes(), &status)).To(Succeed())
+					return status
+				}
+				reload := func() {
+					last := status().LastReload
+					g.Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(Succeed())
+					g.Eventually(func() bool {
+						reload := status().LastReload
+						return reload != nil && reload.Error == "" && (last == nil || reload.Time.After(last.Time))
+					}, 5*time.Second, 10*time.Millisecond).Should(BeTrue())
+				}
+
+				queueSync(g, "home", "1.2.3.4")
+				g.Expect(blocking.run()).To(Succeed())
+				g.Expect(status().Election.Role).To(Equal(election.RoleLeader))
+
+				// The new configuration takes over the lock held by the previous one
+				reload()
+				g.Expect(status().Election.Role).To(Equal(election.RoleLeader))
+				queueSync(g, "home", "1.2.3.5")
+				g.Expect(blocking.run()).To(Succeed())
+				g.Expect(status().Election.Role).To(Equal(election.RoleLeader))
+				g.Expect(status().LastRun.IP).To(Equal("1.2.3.5"))
+
+				// Disabling the election releases the lock
+				writeConfig("NETWORK_ID=home")
+				reload()
+				g.Expect(status().Election).To(BeNil())
+				lease, err := election.NewFileLock(lock).Acquire(context.Background(), "other", time.Minute, time.Now())
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(lease.Holder).To(Equal("other"))
+			},
+		},
+		{
+			testCase: "drops the previous lock even when it can't be released",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &lockSDKClient{}
+				defer withSDKClient(sdkClient)()
+
+				config := filepath.Join(tt.TempDir(), "qrkdns.env")
+				writeConfig := func(lines ...string) {
+					g.Expect(os.WriteFile(config, []byte(strings.Join(lines, "\n")), 0o600)).To(Succeed())
+				}
+				writeConfig("NETWORK_ID=home", "ELECTION=dns")
+
+				blocking, ctl, stop := startAgent(tt, g, map[string]string{
+					"CONFIG_FILE":        config,
+					"NETWORK_ID":         "",
+					"AGENT_ID":           "paris",
+					"CLOUDFLARE_ZONE_ID": "zone",
+				})
+				defer stop()
+
+				status := func() control.Status {
+					output, err := ctl("status", "--output", "json")
+					g.Expect(err).NotTo(HaveOccurred())
+					status := control.Status{}
+					g.Expect(json.Unmarshal([]byte(output), &status)).To(Succeed())
+					return status
+				}
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(blocking.run()).To(Succeed())
+				g.Expect(status().Election.Role).To(Equal(election.RoleLeader))
+
+				// The lease is left to expire, and the new configuration still applies
+				sdkClient.failLocks.Store(true)
+				writeConfig("NETWORK_ID=home")
+				g.Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(Succeed())
+				g.Eventually(func() *control.Reload { return status().LastReload }, 5*time.Second, 10*time.Millisecond).ShouldNot(BeNil())
+				g.Expect(status().LastReload.Error).To(BeEmpty())
+				g.Expect(status().Election).To(BeNil())
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.5"))).To(Succeed())
+				g.Expect(blocking.run()).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{"home.foo.net 1.2.3.5"}))
+				g.Expect(sdkClient.published(dns.RecordTypeTXT)).To(ContainElement(HavePrefix(election.LockRecordName("home.foo.net"))))
+			},
+		},
+		{
+			testCase: "keeps the health of failover addresses across reloads",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				checker := &fakeChecker{}
+				defer withChecker(checker)()
+				sdkClient := &memorySDKClient{}
+				defer withSDKClient(sdkClient)()
+
+				config := filepath.Join(tt.TempDir(), "qrkdns.env")
+				failover := []string{
+					"FAILOVER_NAME=www",
+					"FAILOVER_ADDRESSES=1.2.3.4",
+					"FAILOVER_BACKUP=9.9.9.9",
+					"HEALTH_CHECK=tcp:443",
+					"HEALTH_INTERVAL=10ms",
+					"UNHEALTHY_THRESHOLD=1",
+				}
+				writeConfig := func(lines ...string) {
+					g.Expect(os.WriteFile(config, []byte(strings.Join(lines, "\n")), 0o600)).To(Succeed())
+				}
+				writeConfig(append([]string{"NETWORK_ID=home"}, failover...)...)
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				_, _, stop := startAgent(tt, g, map[string]string{
+					"CONFIG_FILE":        config,
+					"NETWORK_ID":         "",
+					"CLOUDFLARE_ZONE_ID": "zone",
+				})
+				defer stop()
+
+				status := func() control.Status {
+					output := &bytes.Buffer{}
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.CtlCommand()})
+					app.Writer = output
+					g.Expect(app.Run([]string{"qrkdns", "--config-file", "", "ctl", "status", "--output", "json"})).To(Succeed())
+					status := control.Status{}
+					g.Expect(json.Unmarshal(output.Bytes(), &status)).To(Succeed())
+					return status
+				}
+				reload := func() {
+					last := status().LastReload
+					g.Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(Succeed())
+					g.Eventually(func() bool {
+						reload := status().LastReload
+						return reload != nil && reload.Error == "" && (last == nil || reload.Time.After(last.Time))
+					}, 5*time.Second, 10*time.Millisecond).Should(BeTrue())
+				}
+
+				// The first checks sync right away
+				g.Eventually(func() int { return status().Runs }, 5*time.Second, 10*time.Millisecond).Should(Equal(1))
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.6.7.8"))).To(Succeed())
+				checker.fail("1.2.3.4")
+				g.Eventually(func() int { return status().Runs }, 5*time.Second, 10*time.Millisecond).Should(Equal(2))
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{
+					"home.foo.net 5.6.7.8",
+					"www.foo.net 9.9.9.9",
+				}))
+
+				// The new configuration neither forgets the failure nor syncs
+				// again to find it out
+				writeConfig(append([]string{"NETWORK_ID=home", "HEALTHY_THRESHOLD=5"}, failover...)...)
+				reload()
+				g.Expect(status().Health).To(HaveLen(1))
+				g.Expect(status().Health[0].Status).To(Equal(health.StatusUnhealthy))
+				g.Expect(status().Health[0].Transitions).To(Equal(1))
+				g.Expect(status().Runs).To(Equal(2))
+
+				writeConfig("NETWORK_ID=home", "HEALTH_INTERVAL=10ms")
+				reload()
+				g.Expect(status().Health).To(BeEmpty())
+			},
+		},
+		{
+			testCase: "returns error for unreadable config file",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				config := filepath.Join(tt.TempDir(), "missing.env")
+				env := envy.MockEnv{}
+				g.Expect(env.Load(map[string]string{"CONFIG_FILE": config})).To(Succeed())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err := app.Run([]string{"qrkdns", "sync", "cron"})
+				g.Expect(err).To(MatchError(ContainSubstring("config file " + config + ": open")))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
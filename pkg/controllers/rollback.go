@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"text/tabwriter"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// ToFlag wraps the name of the command flag
+	ToFlag string = "to"
+)
+
+// rollbackAction is a single step of a rollback plan
+type rollbackAction struct {
+	Action string
+	Record dns.Record
+}
+
+// RollbackCommand returns the command restoring records from the history
+func RollbackCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "rollback",
+		Usage:     "Restore the records of a name as they were before a recorded change",
+		ArgsUsage: "<name>",
+		Flags: flagsOf(
+			providerFlags(),
+			stateFlags(),
+			[]cli.Flag{
+				&cli.StringFlag{
+					Name:  ToFlag,
+					Usage: "Restore the records as they were at this time (RFC 3339, a date, or a duration ago such as 24h), or the last ones serving this IP. Defaults to undoing the latest change",
+				},
+				yesFlag(),
+			},
+		),
+		Action: rollback,
+	}
+}
+
+// rollback restores the snapshot selected by --to after showing the plan and
+// asking for confirmation
+func rollback(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected exactly one record name")
+	}
+
+	options, err := stringsOrError(c, "rolling back", StateDirFlag)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(c)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	dnsClient, err := buildDNSProvider(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build DNS client")
+		return err
+	}
+
+	historyClient, err := history.NewClient(options[StateDirFlag], HistoryClientOptions...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build history client")
+		return err
+	}
+
+	name := qualifyName(c.Args().First(), c.String(DomainFlag))
+	entries, err := historyClient.Query(history.Filter{
+		Name: name,
+		Types: []history.EntryType{
+			history.EntryTypeRecordCreated,
+			history.EntryTypeRecordUpdated,
+			history.EntryTypeRecordDeleted,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	target, err := rollbackTarget(entries, name, c.String(ToFlag), historyClient.Now())
+	if err != nil {
+		return err
+	}
+
+	// Snapshots hold the records of the type that changed, A unless recorded
+	recordType := dns.RecordTypeA
+	if target.Record != nil {
+		recordType = target.Record.Type
+	}
+	current, err := dnsClient.ListRecords(ctx, dns.RecordFilter{Name: name, Type: recordType})
+	if err != nil {
+		return err
+	}
+
+	plan := rollbackPlan(current, target.Snapshot)
+	if len(plan) == 0 {
+		fmt.Fprintf(c.App.Writer, "Records for %v already match the snapshot from %v\n", name, target.Time.Format(time.RFC3339))
+		return nil
+	}
+
+	fmt.Fprintf(c.App.Writer, "Restoring %v to its records before the change at %v\n", name, target.Time.Format(time.RFC3339))
+	if err := writeRollbackPlan(c.App.Writer, plan); err != nil {
+		return err
+	}
+
+	if err := confirmOrAbort(c, fmt.Sprintf("Apply %v change(s)?", len(plan))); err != nil {
+		return err
+	}
+
+	providerType := c.String(ProviderTypeFlag)
+	changes := []history.Entry{}
+	for _, step := range plan {
+		contextLog := log.WithField("record", step.Record)
+		record := step.Record
+		entry := history.Entry{Name: name, Provider: providerType, Snapshot: current}
+
+		switch step.Action {
+		case "create":
+			contextLog.Info("Recreating record")
+			created, err := dnsClient.CreateRecord(ctx, record)
+			if err != nil {
+				return err
+			}
+			entry.Type = history.EntryTypeRecordCreated
+			entry.NewIP = created.Content
+			entry.Record = &created
+		case "delete":
+			contextLog.Info("Deleting record")
+			if err := dnsClient.DeleteRecord(ctx, record); err != nil {
+				return err
+			}
+			entry.Type = history.EntryTypeRecordDeleted
+			entry.OldIP = record.Content
+			entry.Record = &record
+		}
+		changes = append(changes, entry)
+	}
+
+	// Recording the rollback lets it be rolled back in turn
+	if err := historyClient.Append(changes...); err != nil {
+		log.WithError(err).Warn("Failed to record rollback in history")
+	}
+	return nil
+}
+
+// rollbackTarget picks the entry whose snapshot should be restored. Entries
+// must be sorted by time. By default the latest change is undone, a time
+// selects the first change after it, and an IP selects the latest snapshot
+// serving it.
+func rollbackTarget(entries []history.Entry, name, to string, now time.Time) (history.Entry, error) {
+	if len(entries) == 0 {
+		return history.Entry{}, fmt.Errorf("no recorded changes for %v", name)
+	}
+
+	if to == "" {
+		return entries[len(entries)-1], nil
+	}
+
+	if net.ParseIP(to) != nil {
+		for i := len(entries) - 1; i >= 0; i-- {
+			for _, record := range entries[i].Snapshot {
+				if record.Content == to {
+					return entries[i], nil
+				}
+			}
+		}
+		return history.Entry{}, fmt.Errorf("no recorded snapshot of %v serving %v", name, to)
+	}
+
+	at, err := parseTime(to, now)
+	if err != nil {
+		return history.Entry{}, err
+	}
+	for _, entry := range entries {
+		if entry.Time.After(at) {
+			return entry, nil
+		}
+	}
+	return history.Entry{}, fmt.Errorf("no recorded changes for %v after %v", name, at.Format(time.RFC3339))
+}
+
+// rollbackPlan returns the steps turning the current records into the snapshot.
+// Missing records are created before extra ones are deleted, so the name keeps
+// resolving throughout.
+func rollbackPlan(current, snapshot []dns.Record) []rollbackAction {
+	creates := []rollbackAction{}
+	for _, record := range snapshot {
+		if !containsRecord(current, record) {
+			record.ID = ""
+			creates = append(creates, rollbackAction{Action: "create", Record: record})
+		}
+	}
+
+	deletes := []rollbackAction{}
+	for _, record := range current {
+		if !containsRecord(snapshot, record) {
+			deletes = append(deletes, rollbackAction{Action: "delete", Record: record})
+		}
+	}
+	return append(creates, deletes...)
+}
+
+// containsRecord returns true if an equal record, ignoring IDs, is in records
+func containsRecord(records []dns.Record, record dns.Record) bool {
+	for _, candidate := range records {
+		if candidate.Equal(record, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeRollbackPlan prints the rollback steps as a table
+func writeRollbackPlan(w io.Writer, plan []rollbackAction) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ACTION\tNAME\tTYPE\tCONTENT\tTTL\tPROXIED")
+	for _, step := range plan {
+		record := step.Record
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\t%v\n", step.Action, record.Name, record.Type, record.Content, record.TTL, yesNo(record.Proxied))
+	}
+	return table.Flush()
+}
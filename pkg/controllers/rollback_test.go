@@ -0,0 +1,392 @@
+package controllers_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+// readerFunc runs a function before answering a prompt
+type readerFunc struct {
+	before func()
+	reader io.Reader
+}
+
+func (r readerFunc) Read(p []byte) (int, error) {
+	r.before()
+	return r.reader.Read(p)
+}
+
+func TestRollback(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	now := time.Date(2021, 9, 3, 0, 0, 0, 0, time.UTC)
+	historyOptions := controllers.HistoryClientOptions
+	controllers.HistoryClientOptions = []history.LoadOption{
+		func(client *history.DefaultClient) error {
+			client.Now = func() time.Time { return now }
+			return nil
+		},
+	}
+	defer func() { controllers.HistoryClientOptions = historyOptions }()
+
+	rollbackEnv := func(stateDir string) map[string]string {
+		return map[string]string{
+			"NETWORK_ID":            "bar",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"STATE_DIR":             stateDir,
+		}
+	}
+
+	newRollbackApp := func(input io.Reader, output io.Writer) *cli.App {
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.RollbackCommand()})
+		app.Reader = input
+		app.Writer = output
+		return app
+	}
+
+	aRecord := func(id, content string) sdk.DNSRecord {
+		return sdk.DNSRecord{ID: id, Type: "A", Name: "bar.foo.net", Content: content, TTL: 1}
+	}
+	snapshot := func(records ...sdk.DNSRecord) []dns.Record {
+		results := []dns.Record{}
+		for _, record := range records {
+			results = append(results, dns.Record{ID: record.ID, Type: dns.RecordType(record.Type), Name: record.Name, Content: record.Content, TTL: record.TTL})
+		}
+		return results
+	}
+
+	// 1.1.1.1 was published two days ago, replaced by 2.2.2.2 and then by 3.3.3.3
+	writeHistory := func(g *WithT, stateDir string) {
+		client, err := history.NewClient(stateDir)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(client.Append(
+			history.Entry{Time: now.Add(-48 * time.Hour), Type: history.EntryTypeRecordCreated, Name: "bar.foo.net", NewIP: "1.1.1.1"},
+			history.Entry{Time: now.Add(-12 * time.Hour), Type: history.EntryTypeRecordCreated, Name: "bar.foo.net", OldIP: "1.1.1.1", NewIP: "2.2.2.2", Snapshot: snapshot(aRecord("1", "1.1.1.1"))},
+			history.Entry{Time: now.Add(-12 * time.Hour), Type: history.EntryTypeRecordDeleted, Name: "bar.foo.net", OldIP: "1.1.1.1", Snapshot: snapshot(aRecord("1", "1.1.1.1"))},
+			history.Entry{Time: now.Add(-1 * time.Hour), Type: history.EntryTypeRecordCreated, Name: "bar.foo.net", OldIP: "2.2.2.2", NewIP: "3.3.3.3", Snapshot: snapshot(aRecord("2", "2.2.2.2"))},
+			history.Entry{Time: now.Add(-1 * time.Hour), Type: history.EntryTypeRecordDeleted, Name: "bar.foo.net", OldIP: "2.2.2.2", Snapshot: snapshot(aRecord("2", "2.2.2.2"))},
+			history.Entry{Time: now.Add(-1 * time.Hour), Type: history.EntryTypeIPChanged, Name: "bar.foo.net", OldIP: "2.2.2.2", NewIP: "3.3.3.3"},
+		)).To(Succeed())
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "undoes the latest change after confirmation",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				writeHistory(g, stateDir)
+
+				env := envy.MockEnv{}
+				err := env.Load(rollbackEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{aRecord("3", "3.3.3.3")})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: aRecord("4", "2.2.2.2")})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRollbackApp(strings.NewReader("y\n"), output).Run([]string{"qrkdns", "rollback", "bar"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).To(ContainSubstring("Restoring bar.foo.net to its records before the change at 2021-09-02T23:00:00Z"))
+				g.Expect(output.String()).To(MatchRegexp(`create\s+bar\.foo\.net\s+A\s+2\.2\.2\.2\s+1\s+no\n`))
+				g.Expect(output.String()).To(MatchRegexp(`delete\s+bar\.foo\.net\s+A\s+3\.3\.3\.3\s+1\s+no\n`))
+				g.Expect(output.String()).To(ContainSubstring("Apply 2 change(s)? [y/N]"))
+
+				client, err := history.NewClient(stateDir)
+				g.Expect(err).NotTo(HaveOccurred())
+				entries, err := client.Query(history.Filter{})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(HaveLen(8))
+
+				// The rollback is recorded so that it can be undone in turn
+				g.Expect(entries[6].Type).To(Equal(history.EntryTypeRecordCreated))
+				g.Expect(entries[6].NewIP).To(Equal("2.2.2.2"))
+				g.Expect(entries[6].Record.ID).To(Equal("4"))
+				g.Expect(entries[6].Snapshot).To(Equal(snapshot(aRecord("3", "3.3.3.3"))))
+				g.Expect(entries[7].Type).To(Equal(history.EntryTypeRecordDeleted))
+				g.Expect(entries[7].OldIP).To(Equal("3.3.3.3"))
+			},
+		},
+		{
+			testCase: "restores records of the type that changed",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				aaaaRecord := func(id, content string) sdk.DNSRecord {
+					return sdk.DNSRecord{ID: id, Type: "AAAA", Name: "bar.foo.net", Content: content, TTL: 1}
+				}
+				created := snapshot(aaaaRecord("6", "2001:db8:1::10"))[0]
+				client, err := history.NewClient(stateDir)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(client.Append(
+					history.Entry{Time: now.Add(-1 * time.Hour), Type: history.EntryTypeRecordCreated, Name: "bar.foo.net", NewIP: "2001:db8:1::10", Record: &created, Snapshot: snapshot(aaaaRecord("5", "2001:db8::10"))},
+				)).To(Succeed())
+
+				env := envy.MockEnv{}
+				g.Expect(env.Load(rollbackEnv(stateDir))).To(Succeed())
+				defer env.Restore()
+				sdkClient := &memorySDKClient{records: []sdk.DNSRecord{aRecord("3", "3.3.3.3"), aaaaRecord("6", "2001:db8:1::10")}, nextID: 6}
+				defer withSDKClient(sdkClient)()
+
+				err = newRollbackApp(strings.NewReader("y\n"), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "bar"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{"bar.foo.net 3.3.3.3"}))
+				g.Expect(sdkClient.published(dns.RecordTypeAAAA)).To(Equal([]string{"bar.foo.net 2001:db8::10"}))
+			},
+		},
+		{
+			testCase: "restores the last snapshot serving an ip",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				writeHistory(g, stateDir)
+
+				env := envy.MockEnv{}
+				err := env.Load(rollbackEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{aRecord("3", "3.3.3.3")})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRollbackApp(strings.NewReader("n\n"), output).Run([]string{"qrkdns", "rollback", "--to", "1.1.1.1", "bar"})
+				g.Expect(err).To(MatchError("aborted"))
+				g.Expect(output.String()).To(ContainSubstring("before the change at 2021-09-02T12:00:00Z"))
+				g.Expect(output.String()).To(MatchRegexp(`create\s+bar\.foo\.net\s+A\s+1\.1\.1\.1`))
+			},
+		},
+		{
+			testCase: "restores the records as they were at a time",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				writeHistory(g, stateDir)
+
+				env := envy.MockEnv{}
+				err := env.Load(rollbackEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				// Before anything was published, so everything is deleted
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{aRecord("3", "3.3.3.3")})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRollbackApp(strings.NewReader(""), output).Run([]string{"qrkdns", "rollback", "--to", "2021-08-31", "--yes", "bar.foo.net"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).To(ContainSubstring("before the change at 2021-09-01T00:00:00Z"))
+				g.Expect(output.String()).To(MatchRegexp(`delete\s+bar\.foo\.net\s+A\s+3\.3\.3\.3`))
+				g.Expect(output.String()).NotTo(ContainSubstring("create"))
+				g.Expect(output.String()).NotTo(ContainSubstring("[y/N]"))
+			},
+		},
+		{
+			testCase: "does nothing when the records already match",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				writeHistory(g, stateDir)
+
+				env := envy.MockEnv{}
+				err := env.Load(rollbackEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{aRecord("2", "2.2.2.2")})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newRollbackApp(strings.NewReader(""), output).Run([]string{"qrkdns", "rollback", "--to", "6h", "bar"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).To(Equal("Records for bar.foo.net already match the snapshot from 2021-09-02T23:00:00Z\n"))
+			},
+		},
+		{
+			testCase: "returns errors selecting the snapshot",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				env := envy.MockEnv{}
+				err := env.Load(rollbackEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback"})
+				g.Expect(err).To(MatchError("expected exactly one record name"))
+
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "bar"})
+				g.Expect(err).To(MatchError("no recorded changes for bar.foo.net"))
+
+				writeHistory(g, stateDir)
+
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "--to", "9.9.9.9", "bar"})
+				g.Expect(err).To(MatchError("no recorded snapshot of bar.foo.net serving 9.9.9.9"))
+
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "--to", "30m", "bar"})
+				g.Expect(err).To(MatchError("no recorded changes for bar.foo.net after 2021-09-02T23:30:00Z"))
+
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "--to", "yesterday", "bar"})
+				g.Expect(err).To(MatchError(`invalid time "yesterday": expected RFC 3339, a date (YYYY-MM-DD) or a duration`))
+
+				g.Expect(os.WriteFile(filepath.Join(stateDir, history.FileName), []byte("nope\n"), 0o600)).To(Succeed())
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "bar"})
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "returns configuration errors",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(map[string]string{
+					"NETWORK_ID":  "bar",
+					"DOMAIN_NAME": "foo.net",
+					"TIMEOUT":     "bad",
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "bar"})
+				g.Expect(err).To(MatchError("options [--state-dir] are required when rolling back"))
+
+				stateDir := tt.TempDir()
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "--state-dir", stateDir, "bar"})
+				g.Expect(err).To(MatchError(`time: invalid duration "bad"`))
+
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "--state-dir", stateDir, "--timeout", "1s", "bar"})
+				g.Expect(err).To(MatchError("options [--cf-account-id, --cf-api-token] are required when using cloudflare provider"))
+			},
+		},
+		{
+			testCase: "returns errors building the history",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(rollbackEnv(tt.TempDir()))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				controllers.HistoryClientOptions = append(controllers.HistoryClientOptions, func(client *history.DefaultClient) error {
+					return fmt.Errorf("boo")
+				})
+				defer func() { controllers.HistoryClientOptions = controllers.HistoryClientOptions[:1] }()
+
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "bar"})
+				g.Expect(err).To(MatchError("boo"))
+			},
+		},
+		{
+			testCase: "returns errors from the provider",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				writeHistory(g, stateDir)
+
+				env := envy.MockEnv{}
+				err := env.Load(rollbackEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddErrorReturns("DNSRecords", fmt.Errorf("nope"))).To(Succeed())
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "bar"})
+				g.Expect(err).To(MatchError("nope"))
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{aRecord("3", "3.3.3.3")})).To(Succeed())
+				g.Expect(envy.AddErrorReturns("CreateDNSRecord", fmt.Errorf("nope"))).To(Succeed())
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "--yes", "bar"})
+				g.Expect(err).To(MatchError("nope"))
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{aRecord("3", "3.3.3.3")})).To(Succeed())
+				g.Expect(envy.AddErrorReturns("DeleteDNSRecord", fmt.Errorf("nope"))).To(Succeed())
+				err = newRollbackApp(strings.NewReader(""), &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "--yes", "--to", "2021-08-31", "bar"})
+				g.Expect(err).To(MatchError("nope"))
+			},
+		},
+		{
+			testCase: "returns errors from the terminal",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				writeHistory(g, stateDir)
+
+				env := envy.MockEnv{}
+				err := env.Load(rollbackEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{aRecord("3", "3.3.3.3")})).To(Succeed())
+				err = newRollbackApp(failingReadWriter{}, &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "bar"})
+				g.Expect(err).To(MatchError("closed"))
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{aRecord("3", "3.3.3.3")})).To(Succeed())
+				err = newRollbackApp(strings.NewReader(""), failingReadWriter{}).Run([]string{"qrkdns", "rollback", "bar"})
+				g.Expect(err).To(MatchError("closed"))
+			},
+		},
+		{
+			testCase: "keeps the rollback when the history can't be written",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stateDir := tt.TempDir()
+				writeHistory(g, stateDir)
+
+				env := envy.MockEnv{}
+				err := env.Load(rollbackEnv(stateDir))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				// The history is replaced by a directory while waiting for confirmation
+				path := filepath.Join(stateDir, history.FileName)
+				input := readerFunc{
+					before: func() {
+						g.Expect(os.Remove(path)).To(Succeed())
+						g.Expect(os.Mkdir(path, 0o700)).To(Succeed())
+					},
+					reader: strings.NewReader("y\n"),
+				}
+
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{aRecord("3", "3.3.3.3")})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: aRecord("4", "2.2.2.2")})).To(Succeed())
+
+				err = newRollbackApp(input, &bytes.Buffer{}).Run([]string{"qrkdns", "rollback", "bar"})
+				g.Expect(err).NotTo(HaveOccurred())
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"github.com/markliederbach/qrkdns/pkg/clients/secrets"
+	"github.com/markliederbach/qrkdns/pkg/clients/vault"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// SecretsClientOptions is used by testing to inject a mock client option
+	SecretsClientOptions = []secrets.LoadOption{}
+
+	// SecretFlags lists the flags holding secrets. Each can also be read
+	// from a file (--<flag>-file or <ENV>_FILE) or from the output of a
+	// command (--<flag>-command or <ENV>_COMMAND).
+	SecretFlags = []string{
+		CloudflareAPITokenFlag,
+		CloudflareAPIKeyFlag,
+		VaultTokenFlag,
+		VaultSecretIDFlag,
+		IPServiceHMACKeyFlag,
+		ControlTokenFlag,
+		NotifySMTPPasswordFlag,
+	}
+)
+
+const (
+	// CloudflareAPITokenFileFlag wraps the name of the command flag
+	CloudflareAPITokenFileFlag string = CloudflareAPITokenFlag + secretFileSuffix
+
+	// CloudflareAPITokenCommandFlag wraps the name of the command flag
+	CloudflareAPITokenCommandFlag string = CloudflareAPITokenFlag + secretCommandSuffix
+
+	// secretFileSuffix names the flag reading a secret from a file
+	secretFileSuffix string = "-file"
+
+	// secretCommandSuffix names the flag reading a secret from a command
+	secretCommandSuffix string = "-command"
+)
+
+// secretFlags returns the flag holding a secret along with the flags
+// reading it from a file or a command
+func secretFlags(flag *cli.StringFlag, description string) []cli.Flag {
+	fileEnvVars := []string{}
+	commandEnvVars := []string{}
+	for _, envVar := range flag.EnvVars {
+		fileEnvVars = append(fileEnvVars, envVar+"_FILE")
+		commandEnvVars = append(commandEnvVars, envVar+"_COMMAND")
+	}
+	return []cli.Flag{
+		flag,
+		&cli.StringFlag{
+			Name:    flag.Name + secretFileSuffix,
+			Usage:   "File containing the " + description + ", re-read on every sync (e.g., a Docker or Kubernetes secret)",
+			EnvVars: fileEnvVars,
+		},
+		&cli.StringFlag{
+			Name:    flag.Name + secretCommandSuffix,
+			Usage:   "Command printing the " + description + " to stdout, run on every sync (e.g., pass show cloudflare)",
+			EnvVars: commandEnvVars,
+		},
+	}
+}
+
+// isSecretFlag returns true if the flag holds a secret
+func isSecretFlag(name string) bool {
+	for _, secretFlag := range SecretFlags {
+		if name == secretFlag {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSecret reads a secret from its flag, file or command, following
+// vault:// references. The secret is redacted from every log line from
+// then on.
+func resolveSecret(c *cli.Context, name string) (string, error) {
+	backend := &vaultBackend{c: c}
+	opts := append([]secrets.LoadOption{}, SecretsClientOptions...)
+	client, err := secrets.NewClient(append(opts, secrets.WithBackend(vault.Scheme, backend))...)
+	if err != nil {
+		return "", err
+	}
+	backend.resolver = client
+	return client.Resolve(c.Context, secretSource(c, name))
+}
+
+// secretSource returns the sources of the secret flag
+func secretSource(c *cli.Context, name string) secrets.Source {
+	return secrets.Source{
+		Name:    name,
+		Value:   c.String(name),
+		File:    c.String(name + secretFileSuffix),
+		Command: c.String(name + secretCommandSuffix),
+	}
+}
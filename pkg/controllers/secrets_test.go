@@ -0,0 +1,161 @@
+package controllers_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/secrets"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+func TestSecrets(t *testing.T) {
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// Record the token each Cloudflare client is built with
+	tokens := []string{}
+	rotate := func() {}
+	cloudflareOptions := controllers.CloudflareClientOptions
+	controllers.CloudflareClientOptions = []cloudflare.LoadOption{
+		func(client *cloudflare.DefaultClient) error {
+			tokens = append(tokens, client.Client.(*sdk.API).APIToken)
+			rotate()
+			return nil
+		},
+		withMockSDKClient,
+	}
+	defer func() { controllers.CloudflareClientOptions = cloudflareOptions }()
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	secretEnv := func(extra map[string]string) map[string]string {
+		env := map[string]string{
+			"NETWORK_ID":            "bar",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"SCHEDULE":              "* * * * *",
+		}
+		for key, value := range extra {
+			env[key] = value
+		}
+		return env
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "re-reads the token file on every sync",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				tokens = []string{}
+
+				path := filepath.Join(tt.TempDir(), "token")
+				g.Expect(os.WriteFile(path, []byte("token123\n"), 0o600)).To(Succeed())
+				rotate = func() {
+					g.Expect(os.WriteFile(path, []byte("token456\n"), 0o600)).To(Succeed())
+				}
+				defer func() { rotate = func() {} }()
+
+				env := envy.MockEnv{}
+				err := env.Load(secretEnv(map[string]string{"CLOUDFLARE_API_TOKEN_FILE": path}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				repeating, restore := withRepeatingScheduler(2)
+				defer restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"), ipResponse("1.2.3.4"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync", "cron"})).To(Succeed())
+				g.Expect(repeating.errors).To(Equal([]error{nil, nil}))
+				g.Expect(tokens).To(Equal([]string{"token123", "token456"}))
+			},
+		},
+		{
+			testCase: "reads the token from a command and redacts it from logs",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				tokens = []string{}
+
+				output := &bytes.Buffer{}
+				log.SetOutput(output)
+				defer log.SetOutput(os.Stderr)
+
+				env := envy.MockEnv{}
+				err := env.Load(secretEnv(map[string]string{
+					"CLOUDFLARE_API_TOKEN_COMMAND": "echo s3cr3t-token",
+					"PRE_SYNC_HOOK":                "echo using s3cr3t-token",
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+				g.Expect(tokens).To(Equal([]string{"s3cr3t-token"}))
+				g.Expect(output.String()).To(ContainSubstring(`"command":"echo using [REDACTED]"`))
+				g.Expect(output.String()).NotTo(ContainSubstring("s3cr3t-token"))
+			},
+		},
+		{
+			testCase: "returns errors reading the token",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(secretEnv(map[string]string{"CLOUDFLARE_API_TOKEN_COMMAND": "exit 1"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError("running cf-api-token command: exit status 1"))
+
+				// Without any source, the token is reported as missing
+				env.Restore()
+				env = envy.MockEnv{}
+				err = env.Load(secretEnv(map[string]string{}))
+				g.Expect(err).NotTo(HaveOccurred())
+
+				app = controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError(ContainSubstring("--cf-api-token")))
+			},
+		},
+		{
+			testCase: "returns errors building the secrets client",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(secretEnv(map[string]string{"CLOUDFLARE_API_TOKEN": "bar"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				options := controllers.SecretsClientOptions
+				controllers.SecretsClientOptions = append(options, func(client *secrets.DefaultClient) error {
+					return errors.New("foo")
+				})
+				defer func() { controllers.SecretsClientOptions = options }()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError("foo"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/dyndns"
+	"github.com/markliederbach/qrkdns/pkg/clients/guard"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// DynDNSServerOptions is used by testing to inject a mock server option
+	DynDNSServerOptions = []dyndns.LoadOption{}
+)
+
+const (
+	// ListenAddressFlag wraps the name of the command flag
+	ListenAddressFlag string = "listen"
+
+	// TLSCertFileFlag wraps the name of the command flag
+	TLSCertFileFlag string = "tls-cert"
+
+	// TLSKeyFileFlag wraps the name of the command flag
+	TLSKeyFileFlag string = "tls-key"
+
+	// UsersFileFlag wraps the name of the command flag
+	UsersFileFlag string = "users-file"
+
+	// TrustedProxiesFlag wraps the name of the command flag
+	TrustedProxiesFlag string = "trusted-proxy"
+)
+
+// ServeCommand returns the command running a dyndns2-compatible update
+// server, for routers that can't run qrkdns themselves
+func ServeCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve",
+		Usage: "Run a dyndns2-compatible server applying the updates sent by routers",
+		Flags: flagsOf(
+			providerFlags(),
+			serveFlags(),
+			tlsFlags(),
+			notifyFlags(),
+			stateFlags(),
+			guardFlags(),
+		),
+		Action: serve,
+	}
+}
+
+// serveFlags returns the flags used to configure the update server
+func serveFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    ListenAddressFlag,
+			Usage:   "Address the server listens on",
+			EnvVars: []string{"LISTEN_ADDRESS"},
+			Value:   ":8080",
+		},
+		&cli.StringFlag{
+			Name:     UsersFileFlag,
+			Usage:    "File holding one user:password:host1,host2 line per user allowed to send updates",
+			EnvVars:  []string{"DYNDNS_USERS_FILE"},
+			Required: true,
+		},
+		trustedProxiesFlag(),
+	}
+}
+
+// tlsFlags returns the flags used to serve HTTPS
+func tlsFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    TLSCertFileFlag,
+			Usage:   "Certificate file used to serve HTTPS. Requires --tls-key",
+			EnvVars: []string{"TLS_CERT_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    TLSKeyFileFlag,
+			Usage:   "Private key file used to serve HTTPS. Requires --tls-cert",
+			EnvVars: []string{"TLS_KEY_FILE"},
+		},
+	}
+}
+
+// trustedProxiesFlag returns the flag listing the trusted reverse proxies
+func trustedProxiesFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:    TrustedProxiesFlag,
+		Usage:   "Addresses or CIDRs of reverse proxies trusted to report the address of their clients (X-Forwarded-For, PROXY protocol)",
+		EnvVars: []string{"TRUSTED_PROXIES"},
+	}
+}
+
+// tlsFiles returns the certificate and key files used to serve HTTPS, which
+// must be set together
+func tlsFiles(c *cli.Context) (string, string, error) {
+	certFile, keyFile := c.String(TLSCertFileFlag), c.String(TLSKeyFileFlag)
+	if (certFile == "") != (keyFile == "") {
+		return "", "", fmt.Errorf("options [--%v, --%v] must be set together", TLSCertFileFlag, TLSKeyFileFlag)
+	}
+	return certFile, keyFile, nil
+}
+
+// dynDNSUpdater applies the updates received by the server to the DNS
+// provider, sharing the notifier, history and guard of a syncer. Updates
+// are applied one at a time.
+type dynDNSUpdater struct {
+	*syncer
+	c         *cli.Context
+	dnsClient dns.Provider
+	mu        sync.Mutex
+}
+
+// serve runs the update server until the command's context is done
+func serve(c *cli.Context) error {
+	certFile, keyFile, err := tlsFiles(c)
+	if err != nil {
+		return err
+	}
+
+	users, err := dyndns.LoadUsers(c.String(UsersFileFlag))
+	if err != nil {
+		log.WithError(err).Error("Failed to load users")
+		return err
+	}
+	for _, user := range users {
+		for _, host := range user.Hosts {
+			name, ok := dns.RelativeName(host, domainName(c))
+			if !ok {
+				return fmt.Errorf("host %v of user %v is outside of %v", dns.ToUnicode(host), user.Name, c.String(DomainFlag))
+			}
+			if err := dns.ValidateName(name, domainName(c)); err != nil {
+				return err
+			}
+		}
+	}
+
+	updater, err := newDynDNSUpdater(c)
+	if err != nil {
+		return err
+	}
+
+	opts := append([]dyndns.LoadOption{dyndns.WithTrustedProxies(c.StringSlice(TrustedProxiesFlag))}, DynDNSServerOptions...)
+	server, err := dyndns.NewServer(users, updater, opts...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build dyndns server")
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"address": c.String(ListenAddressFlag),
+		"users":   len(users),
+		"tls":     certFile != "",
+	}).Info("Serving dyndns2 updates")
+	return server.ListenAndServe(c.Context, c.String(ListenAddressFlag), certFile, keyFile)
+}
+
+// newDynDNSUpdater builds the provider and the other dependencies of the
+// updates up front, so that a bad configuration fails at startup
+func newDynDNSUpdater(c *cli.Context) (*dynDNSUpdater, error) {
+	dnsClient, err := buildDNSProvider(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build DNS client")
+		return nil, err
+	}
+	notifier, err := buildNotifier(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build notifier")
+		return nil, err
+	}
+	historyClient, err := buildHistory(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build history client")
+		return nil, err
+	}
+	guardClient, err := buildGuard(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build publication guard")
+		return nil, err
+	}
+
+	return &dynDNSUpdater{
+		syncer: &syncer{
+			notifier: notifier,
+			history:  historyClient,
+			guard:    guardClient,
+		},
+		c:         c,
+		dnsClient: dnsClient,
+	}, nil
+}
+
+// Update implements dyndns.Updater, publishing the address of a hostname
+// that was checked against the user's hosts
+func (u *dynDNSUpdater) Update(ctx context.Context, user, hostname, ipAddress string) (bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	providerType := u.c.String(ProviderTypeFlag)
+	contextLog := log.WithFields(log.Fields{"user": user, "name": dns.ToUnicode(hostname)})
+
+	// Rejected addresses must never reach the provider
+	err := u.guard.Check(ipAddress)
+	if err != nil {
+		var rejection *guard.Rejection
+		if errors.As(err, &rejection) {
+			u.rejectUpdate(ctx, user, hostname, rejection)
+		}
+		return false, err
+	}
+
+	// Hosts were checked to be inside the domain at startup
+	name, _ := dns.RelativeName(hostname, domainName(u.c))
+	result, err := u.dnsClient.ApplyDNSARecord(ctx, name, ipAddress)
+	if err != nil {
+		contextLog.WithError(err).Error("Failed to apply DNS A record")
+		return false, err
+	}
+	u.record(historyFromResult(providerType, result)...)
+
+	// Mark the record as managed so it can be found by `records prune`
+	err = dns.EnsureOwnership(ctx, u.dnsClient, result.Record.Name, u.c.String(OwnerIDFlag))
+	if err != nil {
+		contextLog.WithError(err).Error("Failed to record ownership")
+		return false, err
+	}
+
+	for _, event := range eventsFromResult(providerType, ipAddress, result) {
+		u.notify(ctx, event)
+	}
+	return result.Changed(), nil
+}
+
+// rejectUpdate logs, records and notifies an address refused by the guard
+func (u *dynDNSUpdater) rejectUpdate(ctx context.Context, user, hostname string, rejection *guard.Rejection) {
+	log.WithFields(log.Fields{
+		"user":   user,
+		"name":   dns.ToUnicode(hostname),
+		"ip":     rejection.IP,
+		"reason": rejection.Reason,
+	}).Warn("Refusing to publish address")
+
+	u.record(history.Entry{
+		Type:     history.EntryTypeIPRejected,
+		Name:     hostname,
+		Provider: u.c.String(ProviderTypeFlag),
+		Source:   "dyndns2:" + user,
+		NewIP:    rejection.IP,
+		Reason:   rejection.Reason,
+	})
+	if u.c.Bool(GuardNotifyFlag) {
+		u.notify(ctx, notify.Event{
+			Type:     notify.EventTypeIPRejected,
+			Name:     hostname,
+			Provider: u.c.String(ProviderTypeFlag),
+			NewIP:    rejection.IP,
+			Error:    rejection.Reason,
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	"github.com/markliederbach/qrkdns/pkg/clients/webhook"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+// freeAddress returns a local address nothing listens on
+func freeAddress(g *WithT) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).NotTo(HaveOccurred())
+	address := listener.Addr().String()
+	g.Expect(listener.Close()).To(Succeed())
+	return address
+}
+
+// dynDNSUpdate sends an update to a running server, retrying until it's up
+func dynDNSUpdate(g *WithT, address, query string) string {
+	request, err := http.NewRequest(http.MethodGet, "http://"+address+"/nic/update?"+query, nil)
+	g.Expect(err).NotTo(HaveOccurred())
+	request.SetBasicAuth("router", "secret")
+
+	var response *http.Response
+	g.Eventually(func() error {
+		response, err = http.DefaultClient.Do(request)
+		return err
+	}, 5*time.Second, 10*time.Millisecond).Should(Succeed())
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	g.Expect(err).NotTo(HaveOccurred())
+	return string(body)
+}
+
+func TestServe(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+
+	recorder := &recordingHTTPClient{}
+	webhookOptions := controllers.WebhookClientOptions
+	controllers.WebhookClientOptions = []webhook.LoadOption{
+		func(client *webhook.DefaultClient) error {
+			client.Client = recorder
+			return nil
+		},
+	}
+	defer func() { controllers.WebhookClientOptions = webhookOptions }()
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	serveEnv := func(tt *testing.T, users string, extra map[string]string) map[string]string {
+		path := filepath.Join(tt.TempDir(), "users")
+		if err := os.WriteFile(path, []byte(users), 0o600); err != nil {
+			tt.Fatal(err)
+		}
+		env := map[string]string{
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"DYNDNS_USERS_FILE":     path,
+			"NOTIFY_WEBHOOK_URL":    "http://hook",
+		}
+		for key, value := range extra {
+			env[key] = value
+		}
+		return env
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "applies updates sent by routers",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				recorder.bodies = []string{}
+
+				stateDir := tt.TempDir()
+				address := freeAddress(g)
+				env := envy.MockEnv{}
+				err := env.Load(serveEnv(tt, "router:secret:home.foo.net,foo.net\n", map[string]string{
+					"LISTEN_ADDRESS": address,
+					"STATE_DIR":      stateDir,
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				applied := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("home", "foo.net", "1.2.3.4"))
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("CreateDNSRecord", &sdk.DNSRecordResponse{Result: applied})).To(Succeed())
+
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() {
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.ServeCommand()})
+					done <- app.RunContext(ctx, []string{"qrkdns", "serve"})
+				}()
+
+				g.Expect(dynDNSUpdate(g, address, "hostname=home.foo.net&myip=1.2.3.4")).To(Equal("good 1.2.3.4\n"))
+
+				// Rejected addresses never reach the provider
+				g.Expect(dynDNSUpdate(g, address, "hostname=home.foo.net&myip=10.0.0.1")).To(Equal("911\n"))
+				g.Expect(dynDNSUpdate(g, address, "hostname=other.foo.net&myip=1.2.3.4")).To(Equal("nohost\n"))
+
+				// Provider errors may be retried later
+				g.Expect(envy.AddErrorReturns("DNSRecords", errors.New("boom"))).To(Succeed())
+				g.Expect(dynDNSUpdate(g, address, "hostname=foo.net&myip=1.2.3.4")).To(Equal("911\n"))
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+				g.Expect(envy.AddErrorReturns("DNSRecords", nil, errors.New("boom"))).To(Succeed())
+				g.Expect(dynDNSUpdate(g, address, "hostname=foo.net&myip=1.2.3.4")).To(Equal("911\n"))
+
+				cancel()
+				g.Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+
+				g.Expect(recorder.bodies).To(HaveLen(2))
+				g.Expect(recorder.bodies[0]).To(ContainSubstring(`"type":"record_created"`))
+				g.Expect(recorder.bodies[1]).To(ContainSubstring(`"type":"ip_rejected"`))
+
+				client, err := history.NewClient(stateDir)
+				g.Expect(err).NotTo(HaveOccurred())
+				entries, err := client.Query(history.Filter{Types: []history.EntryType{history.EntryTypeIPRejected}})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(entries).To(HaveLen(1))
+				g.Expect(entries[0].Source).To(Equal("dyndns2:router"))
+				g.Expect(entries[0].Name).To(Equal("home.foo.net"))
+			},
+		},
+		{
+			testCase: "returns error for invalid configuration",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for users, message := range map[string]string{
+					"router:secret:home.other.net": "host home.other.net of user router is outside of foo.net",
+					"router:secret:ho_me.foo.net":  "invalid name ho_me.foo.net: label ho_me contains '_'",
+					"router":                       "line 1: expected user:password:hosts",
+				} {
+					env := envy.MockEnv{}
+					err := env.Load(serveEnv(tt, users, nil))
+					g.Expect(err).NotTo(HaveOccurred())
+
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.ServeCommand()})
+					err = app.Run([]string{"qrkdns", "serve"})
+					env.Restore()
+					g.Expect(err).To(MatchError(message), users)
+				}
+
+				stopped, cancel := context.WithCancel(context.Background())
+				cancel()
+				historyOptions := controllers.HistoryClientOptions
+				controllers.HistoryClientOptions = append(controllers.HistoryClientOptions, func(client *history.DefaultClient) error {
+					return fmt.Errorf("boo")
+				})
+				defer func() { controllers.HistoryClientOptions = historyOptions }()
+				for _, test := range []struct {
+					extra   map[string]string
+					message string
+				}{
+					{map[string]string{"NOTIFY_SMTP_HOST": "smtp.foo.net", "NOTIFY_EMAIL_TO": "ops@foo.net"}, "options [--notify-email-from] are required when using email notifications"},
+					{map[string]string{"STATE_DIR": tt.TempDir()}, "boo"},
+					{map[string]string{"GUARD_DENY_CIDRS": "nope"}, `invalid CIDR "nope"`},
+				} {
+					env := envy.MockEnv{}
+					err := env.Load(serveEnv(tt, "router:secret:home.foo.net", test.extra))
+					g.Expect(err).NotTo(HaveOccurred())
+
+					// A configuration that builds would stop serving right away
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.ServeCommand()})
+					err = app.RunContext(stopped, []string{"qrkdns", "serve"})
+					env.Restore()
+					g.Expect(err).To(MatchError(test.message))
+				}
+
+				env := envy.MockEnv{}
+				err := env.Load(serveEnv(tt, "router:secret:home.foo.net", map[string]string{"TLS_CERT_FILE": "cert.pem"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.ServeCommand()})
+				err = app.Run([]string{"qrkdns", "serve"})
+				g.Expect(err).To(MatchError("options [--tls-cert, --tls-key] must be set together"))
+
+				err = app.Run([]string{"qrkdns", "serve", "--tls-key", "key.pem", "--trusted-proxy", "nope"})
+				g.Expect(err).To(MatchError("invalid trusted proxy nope"))
+
+				err = app.Run([]string{"qrkdns", "serve", "--tls-key", "key.pem", "--cf-account-id", ""})
+				g.Expect(err).To(MatchError("options [--cf-account-id, --cf-api-token] are required when using cloudflare provider"))
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
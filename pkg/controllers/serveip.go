@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"github.com/markliederbach/qrkdns/pkg/clients/echo"
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// EchoServerOptions is used by testing to inject a mock server option
+	EchoServerOptions = []echo.LoadOption{}
+)
+
+const (
+	// ProxyProtocolFlag wraps the name of the command flag
+	ProxyProtocolFlag string = "proxy-protocol"
+
+	// IPServiceHMACKeyFlag wraps the name of the command flag
+	IPServiceHMACKeyFlag string = "ip-service-hmac-key"
+)
+
+// ServeIPCommand returns the command answering with the caller's address,
+// replacing third-party IP services
+func ServeIPCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "serve-ip",
+		Usage: "Run a server answering with the caller's IP address, for use as --ip-service-url",
+		Flags: flagsOf(
+			[]cli.Flag{
+				&cli.StringSliceFlag{
+					Name:    ListenAddressFlag,
+					Usage:   "Addresses the server listens on. IPv4 and IPv6 addresses only listen on their own family (e.g., 0.0.0.0:8080,[::]:8080)",
+					EnvVars: []string{"LISTEN_ADDRESS"},
+					Value:   cli.NewStringSlice(":8080"),
+				},
+				trustedProxiesFlag(),
+				&cli.BoolFlag{
+					Name:    ProxyProtocolFlag,
+					Usage:   "Read the PROXY protocol header (version 1 or 2) of connections from trusted proxies",
+					EnvVars: []string{"PROXY_PROTOCOL"},
+				},
+			},
+			tlsFlags(),
+			hmacKeyFlags(),
+			vaultFlags(),
+		),
+		Action: serveIP,
+	}
+}
+
+// hmacKeyFlags returns the flags of the key signing the answers of the
+// IP service
+func hmacKeyFlags() []cli.Flag {
+	return secretFlags(
+		&cli.StringFlag{
+			Name:    IPServiceHMACKeyFlag,
+			Usage:   "Key signing the answers of `qrkdns serve-ip`, shared by the server and its clients",
+			EnvVars: []string{"IP_SERVICE_HMAC_KEY"},
+		},
+		"IP service HMAC key",
+	)
+}
+
+// serveIP runs the echo server until the command's context is done
+func serveIP(c *cli.Context) error {
+	certFile, keyFile, err := tlsFiles(c)
+	if err != nil {
+		return err
+	}
+	key, err := hmacKey(c)
+	if err != nil {
+		return err
+	}
+
+	opts := append([]echo.LoadOption{
+		echo.WithTrustedProxies(c.StringSlice(TrustedProxiesFlag)),
+		echo.WithProxyProtocol(c.Bool(ProxyProtocolFlag)),
+		echo.WithHMACKey(key),
+	}, EchoServerOptions...)
+	server, err := echo.NewServer(opts...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build echo server")
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"addresses": c.StringSlice(ListenAddressFlag),
+		"tls":       certFile != "",
+		"signed":    key != "",
+	}).Info("Serving IP addresses")
+	return server.ListenAndServe(c.Context, c.StringSlice(ListenAddressFlag), certFile, keyFile)
+}
+
+// hmacKey returns the key signing the answers of the IP service, if any
+func hmacKey(c *cli.Context) (string, error) {
+	source := secretSource(c, IPServiceHMACKeyFlag)
+	if !source.IsSet() {
+		return "", nil
+	}
+	return resolveSecret(c, IPServiceHMACKeyFlag)
+}
+
+// ipClientOptions returns the options building the IP client, verifying
+// the signature of answers when an HMAC key is set
+func ipClientOptions(c *cli.Context) ([]ip.LoadOption, error) {
+	key, err := hmacKey(c)
+	if err != nil {
+		return nil, err
+	}
+	opts := []ip.LoadOption{}
+	if key != "" {
+		opts = append(opts, ip.WithHMACKey(key))
+	}
+	return append(opts, IPClientOptions...), nil
+}
@@ -0,0 +1,153 @@
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/echo"
+	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+func TestServeIP(t *testing.T) {
+	cloudflareOptions := controllers.CloudflareClientOptions
+	controllers.CloudflareClientOptions = append(controllers.CloudflareClientOptions, withMockSDKClient)
+	defer func() { controllers.CloudflareClientOptions = cloudflareOptions }()
+
+	// The IP client talks to the server for real
+	ipOptions := controllers.IPClientOptions
+	controllers.IPClientOptions = []ip.LoadOption{}
+	defer func() { controllers.IPClientOptions = ipOptions }()
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	tests := []testRunner{
+		{
+			testCase: "answers with signed addresses",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				address := freeAddress(g)
+				env := envy.MockEnv{}
+				err := env.Load(map[string]string{
+					"LISTEN_ADDRESS":      address,
+					"IP_SERVICE_HMAC_KEY": "s3cret",
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() {
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.ServeIPCommand()})
+					done <- app.RunContext(ctx, []string{"qrkdns", "serve-ip"})
+				}()
+
+				request, err := http.NewRequest(http.MethodGet, "http://"+address+"/", nil)
+				g.Expect(err).NotTo(HaveOccurred())
+				request.Header.Set(ip.NonceHeader, "abc")
+				var response *http.Response
+				g.Eventually(func() error {
+					response, err = http.DefaultClient.Do(request)
+					return err
+				}, 5*time.Second, 10*time.Millisecond).Should(Succeed())
+				body, err := io.ReadAll(response.Body)
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(response.Body.Close()).To(Succeed())
+				g.Expect(string(body)).To(Equal("127.0.0.1\n"))
+				g.Expect(response.Header.Get(ip.SignatureHeader)).To(Equal(ip.Sign([]byte("s3cret"), "abc", "127.0.0.1")))
+
+				// Syncing with another key rejects the answer before touching DNS
+				syncEnv := envy.MockEnv{}
+				err = syncEnv.Load(map[string]string{
+					"NETWORK_ID":            "xxx",
+					"DOMAIN_NAME":           "foo.net",
+					"CLOUDFLARE_ACCOUNT_ID": "foo",
+					"CLOUDFLARE_API_TOKEN":  "bar",
+					"IP_SERVICE_URL":        "http://" + address + "/",
+					"IP_SERVICE_HMAC_KEY":   "other",
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+				defer syncEnv.Restore()
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("answer of http://" + address + "/ is not signed with the expected key"))
+
+				cancel()
+				g.Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+			},
+		},
+		{
+			testCase: "returns error for invalid configuration",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				stopped, cancel := context.WithCancel(context.Background())
+				cancel()
+				serveIP := func(args ...string) error {
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.ServeIPCommand()})
+					return app.RunContext(stopped, append([]string{"qrkdns", "serve-ip", "--listen", freeAddress(g)}, args...))
+				}
+
+				for _, test := range []struct {
+					args    []string
+					message string
+				}{
+					{[]string{"--tls-cert", "cert.pem"}, "options [--tls-cert, --tls-key] must be set together"},
+					{[]string{"--trusted-proxy", "nope"}, "invalid trusted proxy nope"},
+					{[]string{"--ip-service-hmac-key-command", "exit 1"}, ""},
+				} {
+					err := serveIP(test.args...)
+					if test.message == "" {
+						g.Expect(err).To(HaveOccurred())
+					} else {
+						g.Expect(err).To(MatchError(test.message))
+					}
+				}
+
+				options := controllers.EchoServerOptions
+				controllers.EchoServerOptions = []echo.LoadOption{func(server *echo.DefaultServer) error {
+					return errors.New("foo")
+				}}
+				defer func() { controllers.EchoServerOptions = options }()
+				err := serveIP()
+				g.Expect(err).To(MatchError("foo"))
+
+				// A configuration that builds stops serving right away
+				controllers.EchoServerOptions = options
+				err = serveIP()
+				g.Expect(err).NotTo(HaveOccurred())
+
+				env := envy.MockEnv{}
+				err = env.Load(map[string]string{
+					"NETWORK_ID":            "xxx",
+					"DOMAIN_NAME":           "foo.net",
+					"CLOUDFLARE_ACCOUNT_ID": "foo",
+					"CLOUDFLARE_API_TOKEN":  "bar",
+				})
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+				for _, command := range []*cli.Command{controllers.SyncCommand(), controllers.DoctorCommand()} {
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{command})
+					err = app.Run([]string{"qrkdns", command.Name, "--ip-service-hmac-key-command", "exit 1"})
+					g.Expect(err).To(HaveOccurred(), command.Name)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// SharedFlag wraps the name of the command flag
+	SharedFlag string = "shared"
+
+	// AgentIDFlag wraps the name of the command flag
+	AgentIDFlag string = "agent-id"
+
+	// LeaseFlag wraps the name of the command flag
+	LeaseFlag string = "lease"
+)
+
+var (
+	// Hostname is used by testing to inject a failing hostname lookup
+	Hostname = os.Hostname
+)
+
+// sharedFlags returns the flags used to share names with agents at other sites
+func sharedFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:    SharedFlag,
+			Usage:   "Share the names with agents at other sites, each publishing its own A record next to the others",
+			EnvVars: []string{"SHARED"},
+		},
+		&cli.DurationFlag{
+			Name:    LeaseFlag,
+			Usage:   "How long the record of an agent outlives its last heartbeat before any agent removes it",
+			EnvVars: []string{"LEASE"},
+			Value:   15 * time.Minute,
+		},
+	}
+}
+
+// agentIDFlag returns the flag identifying this agent among the agents
+// sharing names or electing a leader
+func agentIDFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    AgentIDFlag,
+		Usage:   "Identifier of this agent among the agents sharing the names or electing a leader. Defaults to the hostname",
+		EnvVars: []string{"AGENT_ID"},
+	}
+}
+
+// agentID returns the identifier of this agent, the hostname by default
+func agentID(c *cli.Context) (string, error) {
+	agent := c.String(AgentIDFlag)
+	if agent == "" {
+		hostname, err := Hostname()
+		if err != nil {
+			return "", err
+		}
+		agent = hostname
+	}
+	if strings.ContainsAny(agent, `,=" `) {
+		return "", fmt.Errorf("invalid agent ID %q", agent)
+	}
+	return agent, nil
+}
+
+// sharing identifies this agent among the agents sharing the names
+type sharing struct {
+	agent string
+	lease time.Duration
+}
+
+// buildSharing reads the shared-name mode from the command flags, or
+// returns nil if the names aren't shared
+func buildSharing(c *cli.Context) (*sharing, error) {
+	if !c.Bool(SharedFlag) {
+		return nil, nil
+	}
+	if c.Duration(LeaseFlag) <= 0 {
+		return nil, fmt.Errorf("--%v must be positive", LeaseFlag)
+	}
+	// Shared names resolve to every agent's address
+	if c.Bool(VerifyFlag) {
+		return nil, fmt.Errorf("--%v can't be combined with --%v", VerifyFlag, SharedFlag)
+	}
+
+	agent, err := agentID(c)
+	if err != nil {
+		return nil, err
+	}
+	return &sharing{agent: agent, lease: c.Duration(LeaseFlag)}, nil
+}
+
+// applyARecord publishes the address to a name, next to the addresses of
+// the other agents when the names are shared
+func (s *syncer) applyARecord(ctx context.Context, c *cli.Context, dnsClient dns.Provider, name, ipAddress string) (dns.ApplyResult, error) {
+	if s.sharing == nil {
+		return dnsClient.ApplyDNSARecord(ctx, name, ipAddress)
+	}
+
+	result, err := dns.ApplySharedARecord(
+		ctx, dnsClient, dns.FQDN(name, domainName(c)), ipAddress, s.sharing.agent, s.sharing.lease, time.Now(),
+	)
+	if err != nil {
+		return dns.ApplyResult{}, err
+	}
+	for _, record := range result.Deleted {
+		log.WithFields(log.Fields{
+			"name":  dns.ToUnicode(record.Name),
+			"ip":    record.Content,
+			"agent": s.sharing.agent,
+		}).Info("Removed shared A record")
+	}
+	return result, nil
+}
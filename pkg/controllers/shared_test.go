@@ -0,0 +1,245 @@
+package controllers_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+// leasePattern matches the heartbeat time of a lease
+var leasePattern = regexp.MustCompile(`renewed=\d+`)
+
+// memorySDKClient keeps the records of a zone in memory
+type memorySDKClient struct {
+	mocks.MockCloudflareSDKClient
+	records []sdk.DNSRecord
+	nextID  int
+	// deleteErr is returned when deleting records
+	deleteErr error
+}
+
+func (c *memorySDKClient) DNSRecords(ctx context.Context, zoneID string, rr sdk.DNSRecord) ([]sdk.DNSRecord, error) {
+	results := []sdk.DNSRecord{}
+	for _, record := range c.records {
+		if (rr.Name == "" || record.Name == rr.Name) && (rr.Type == "" || record.Type == rr.Type) {
+			results = append(results, record)
+		}
+	}
+	return results, nil
+}
+
+func (c *memorySDKClient) CreateDNSRecord(ctx context.Context, zoneID string, rr sdk.DNSRecord) (*sdk.DNSRecordResponse, error) {
+	c.nextID++
+	rr.ID = fmt.Sprint(c.nextID)
+	c.records = append(c.records, rr)
+	return &sdk.DNSRecordResponse{Result: rr}, nil
+}
+
+func (c *memorySDKClient) UpdateDNSRecord(ctx context.Context, zoneID string, recordID string, rr sdk.DNSRecord) error {
+	if err := c.MockCloudflareSDKClient.UpdateDNSRecord(ctx, zoneID, recordID, rr); err != nil {
+		return err
+	}
+	for i, record := range c.records {
+		if record.ID == recordID {
+			rr.ID = recordID
+			c.records[i] = rr
+		}
+	}
+	return nil
+}
+
+func (c *memorySDKClient) DeleteDNSRecord(ctx context.Context, zoneID string, recordID string) error {
+	if c.deleteErr != nil {
+		return c.deleteErr
+	}
+	for i, record := range c.records {
+		if record.ID == recordID {
+			c.records = append(c.records[:i], c.records[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// published lists the records of a type as "name content"
+func (c *memorySDKClient) published(recordType dns.RecordType) []string {
+	results := []string{}
+	for _, record := range c.records {
+		if record.Type == string(recordType) {
+			results = append(results, fmt.Sprintf("%v %v", record.Name, record.Content))
+		}
+	}
+	sort.Strings(results)
+	return results
+}
+
+func TestShared(t *testing.T) {
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	// sync runs a single sync of an agent sharing www.foo.net
+	sync := func(g *WithT, sdkClient *memorySDKClient, extra map[string]string) error {
+		values := map[string]string{
+			"NETWORK_ID":            "www",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"CLOUDFLARE_ZONE_ID":    "zone",
+			"SHARED":                "true",
+		}
+		for key, value := range extra {
+			values[key] = value
+		}
+		env := envy.MockEnv{}
+		g.Expect(env.Load(values)).To(Succeed())
+		defer env.Restore()
+
+		options := controllers.CloudflareClientOptions
+		controllers.CloudflareClientOptions = append(options, func(client *cloudflare.DefaultClient) error {
+			client.Client = sdkClient
+			return nil
+		})
+		defer func() { controllers.CloudflareClientOptions = options }()
+
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+		return app.Run([]string{"qrkdns", "sync"})
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "publishes one record per agent",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &memorySDKClient{}
+				for agent, ip := range map[string]string{"paris": "1.2.3.4", "berlin": "5.6.7.8"} {
+					g.Expect(envy.AddObjectReturns("Do", ipResponse(ip))).To(Succeed())
+					g.Expect(sync(g, sdkClient, map[string]string{"AGENT_ID": agent})).To(Succeed())
+				}
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{
+					"www.foo.net 1.2.3.4",
+					"www.foo.net 5.6.7.8",
+				}))
+
+				// A new address only replaces the record of its agent
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.5"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, map[string]string{"AGENT_ID": "paris"})).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{
+					"www.foo.net 1.2.3.5",
+					"www.foo.net 5.6.7.8",
+				}))
+
+				leases := []string{}
+				for _, record := range sdkClient.published(dns.RecordTypeTXT) {
+					if !strings.HasPrefix(record, dns.LeasePrefix) {
+						continue
+					}
+					leases = append(leases, leasePattern.ReplaceAllString(record, "renewed=<now>"))
+				}
+				g.Expect(leases).To(Equal([]string{
+					"_qrkdns-lease.www.foo.net heritage=qrkdns,agent=berlin,ip=5.6.7.8,renewed=<now>",
+					"_qrkdns-lease.www.foo.net heritage=qrkdns,agent=paris,ip=1.2.3.5,renewed=<now>",
+				}))
+			},
+		},
+		{
+			testCase: "removes the records of agents whose lease expired",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				expired := time.Now().Add(-time.Hour)
+				sdkClient := &memorySDKClient{records: []sdk.DNSRecord{
+					{ID: "a", Type: "A", Name: "www.foo.net", Content: "5.6.7.8"},
+					{ID: "b", Type: "TXT", Name: "_qrkdns-lease.www.foo.net", Content: dns.LeaseContent("berlin", "5.6.7.8", expired)},
+				}}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, map[string]string{"AGENT_ID": "paris", "LEASE": "30m"})).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeA)).To(Equal([]string{"www.foo.net 1.2.3.4"}))
+			},
+		},
+		{
+			testCase: "identifies the agent by its hostname",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				hostname, err := os.Hostname()
+				g.Expect(err).NotTo(HaveOccurred())
+
+				sdkClient := &memorySDKClient{}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(sync(g, sdkClient, nil)).To(Succeed())
+				g.Expect(sdkClient.published(dns.RecordTypeTXT)).To(ContainElement(
+					ContainSubstring(fmt.Sprintf("agent=%v,", hostname)),
+				))
+
+				// Without a hostname, the agent needs an ID
+				defer func(hostname func() (string, error)) { controllers.Hostname = hostname }(controllers.Hostname)
+				controllers.Hostname = func() (string, error) {
+					return "", errors.New("no hostname")
+				}
+				err = sync(g, &memorySDKClient{}, nil)
+				g.Expect(err).To(MatchError("no hostname"))
+				g.Expect(sync(g, &memorySDKClient{}, map[string]string{"AGENT_ID": "paris"})).To(Succeed())
+			},
+		},
+		{
+			testCase: "returns error applying shared records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				sdkClient := &memorySDKClient{
+					records: []sdk.DNSRecord{
+						{ID: "a", Type: "A", Name: "www.foo.net", Content: "1.2.3.4"},
+						{ID: "b", Type: "TXT", Name: "_qrkdns-lease.www.foo.net", Content: dns.LeaseContent("paris", "1.2.3.4", time.Now())},
+					},
+					deleteErr: errors.New("boom"),
+				}
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.5"))).To(Succeed())
+				err := sync(g, sdkClient, map[string]string{"AGENT_ID": "paris"})
+				g.Expect(err).To(MatchError("boom"))
+			},
+		},
+		{
+			testCase: "returns error for invalid shared settings",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				for message, extra := range map[string]map[string]string{
+					"--lease must be positive":                 {"LEASE": "0s"},
+					"--verify can't be combined with --shared": {"VERIFY": "true"},
+					`invalid agent ID "paris,berlin"`:          {"AGENT_ID": "paris,berlin"},
+				} {
+					err := sync(g, &memorySDKClient{}, extra)
+					g.Expect(err).To(MatchError(message), message)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, func(tt *testing.T) {
+			test.runner(tt)
+		})
+	}
+}
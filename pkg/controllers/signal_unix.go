@@ -0,0 +1,43 @@
+//go:build !windows
+
+package controllers
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/control"
+	log "github.com/sirupsen/logrus"
+)
+
+// watchSignals reloads the configuration on SIGHUP and syncs right away
+// on SIGUSR1, until the returned function is called. Signals are handled
+// one at a time.
+func watchSignals(a *agent) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGUSR1)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				if sig == syscall.SIGHUP {
+					log.Info("Reloading configuration")
+					_ = a.reload()
+					continue
+				}
+				log.Info("Sync requested by signal")
+				_, _ = a.sync(control.TriggerSignal)
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
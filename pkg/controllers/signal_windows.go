@@ -0,0 +1,9 @@
+//go:build windows
+
+package controllers
+
+// watchSignals does nothing, as Windows has neither SIGHUP nor SIGUSR1.
+// Syncs can still be requested through the control API.
+func watchSignals(a *agent) func() {
+	return func() {}
+}
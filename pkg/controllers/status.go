@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dampening"
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/resolver"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// ResolverClientOptions is used by testing to inject a mock client option
+	ResolverClientOptions = []resolver.LoadOption{}
+)
+
+const (
+	// ResolverFlag wraps the name of the command flag
+	ResolverFlag string = "resolver"
+
+	// OutputFlag wraps the name of the command flag
+	OutputFlag string = "output"
+
+	// OutputFormatTable prints human-readable tables
+	OutputFormatTable string = "table"
+
+	// OutputFormatJSON prints machine-readable JSON
+	OutputFormatJSON string = "json"
+
+	// StatusExitCodeDrift is returned by the status command when the
+	// published records don't match the discovered IP. Errors exit with 1.
+	StatusExitCodeDrift int = 2
+)
+
+// recordStatus compares the discovered IP with what is published
+type recordStatus struct {
+	Name              string       `json:"name"`
+	DiscoveredIP      string       `json:"discovered_ip"`
+	ProviderRecords   []dns.Record `json:"provider_records"`
+	ResolvedAddresses []string     `json:"resolved_addresses"`
+	ProviderInSync    bool         `json:"provider_in_sync"`
+	ResolverInSync    bool         `json:"resolver_in_sync"`
+	InSync            bool         `json:"in_sync"`
+	// Dampening holds the change waiting to be published, if any
+	Dampening *dampening.State `json:"dampening,omitempty"`
+}
+
+// StatusCommand returns the command reporting whether the published
+// records match the discovered IP, without changing anything
+func StatusCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "status",
+		Usage: "Compare the discovered IP with the provider's records and public DNS",
+		Flags: flagsOf(
+			syncFlags(),
+			stateFlags(),
+			sharedFlags(),
+			[]cli.Flag{
+				resolverFlag(),
+				outputFlag(),
+			},
+		),
+		Action: status,
+	}
+}
+
+// outputFlag returns the flag selecting the output format
+func outputFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    OutputFlag,
+		Aliases: []string{"o"},
+		Usage:   fmt.Sprintf("Output format (one of: %v, %v)", OutputFormatTable, OutputFormatJSON),
+		EnvVars: []string{"OUTPUT"},
+		Value:   OutputFormatTable,
+	}
+}
+
+// status runs IP discovery, reads the provider's records and resolves
+// every name receiving the IP through public DNS, then reports whether
+// they agree
+func status(c *cli.Context) error {
+	names, _, err := managedNames(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, err := withTimeout(c)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	dnsClient, err := buildDNSProvider(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build DNS client")
+		return err
+	}
+
+	resolverClient, err := resolver.NewClient(c.String(ResolverFlag), ResolverClientOptions...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build resolver client")
+		return err
+	}
+
+	externalIP, err := discoverAddress(ctx, c, c.String(IPSourceFlag))
+	if err != nil {
+		return err
+	}
+
+	statuses := []recordStatus{}
+	inSync := true
+	for _, networkID := range names {
+		records, err := dnsClient.GetDNSARecords(ctx, networkID)
+		if err != nil {
+			log.WithError(err).Error("Failed to list DNS A records")
+			return err
+		}
+
+		name := dns.FQDN(networkID, domainName(c))
+		resolved, err := resolverClient.LookupA(ctx, name)
+		if err != nil {
+			log.WithError(err).Error("Failed to resolve record")
+			return err
+		}
+
+		result := compareStatus(name, externalIP, records, resolved, c.Bool(SharedFlag))
+		inSync = inSync && result.InSync
+		statuses = append(statuses, result)
+	}
+
+	// Dampening holds back the IP of every name at once
+	statuses[0].Dampening, err = dampeningState(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to read dampening state")
+		return err
+	}
+	if err = writeStatus(c.App.Writer, c.String(OutputFlag), statuses); err != nil {
+		return err
+	}
+
+	if !inSync {
+		return cli.Exit("published records are out of sync", StatusExitCodeDrift)
+	}
+	return nil
+}
+
+// compareStatus determines whether the provider and resolver agree with the
+// discovered IP. Shared names are in sync as long as they include it.
+func compareStatus(name, externalIP string, records []dns.Record, resolved []string, shared bool) recordStatus {
+	result := recordStatus{
+		Name:              name,
+		DiscoveredIP:      externalIP,
+		ProviderRecords:   records,
+		ResolvedAddresses: resolved,
+	}
+	published := []string{}
+	for _, record := range records {
+		published = append(published, record.Content)
+	}
+	result.ProviderInSync = includesAddress(published, externalIP, shared)
+	result.ResolverInSync = includesAddress(resolved, externalIP, shared)
+	result.InSync = result.ProviderInSync && result.ResolverInSync
+	return result
+}
+
+// includesAddress checks that the addresses are exactly the expected one,
+// or that they include it when shared
+func includesAddress(addresses []string, expected string, shared bool) bool {
+	if !shared {
+		return len(addresses) == 1 && addresses[0] == expected
+	}
+	for _, address := range addresses {
+		if address == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// dampeningState returns the persisted dampening state, or nil if no
+// change is pending or was ever held back
+func dampeningState(c *cli.Context) (*dampening.State, error) {
+	stateDir := c.String(StateDirFlag)
+	if stateDir == "" {
+		return nil, nil
+	}
+	client, err := dampening.NewClient(stateDir, dampening.Policy{}, DampeningClientOptions...)
+	if err != nil {
+		return nil, err
+	}
+	state, err := client.State()
+	if err != nil || (state.Candidate == "" && state.Suppressed == 0) {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// writeStatus prints the statuses in the requested format
+func writeStatus(w io.Writer, format string, statuses []recordStatus) error {
+	switch format {
+	case OutputFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(statuses)
+	case OutputFormatTable:
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "NAME\tDISCOVERED IP\tPROVIDER RECORDS\tRESOLVED\tIN SYNC")
+		for _, status := range statuses {
+			contents := []string{}
+			for _, record := range status.ProviderRecords {
+				contents = append(contents, record.Content)
+			}
+			fmt.Fprintf(
+				table,
+				"%v\t%v\t%v\t%v\t%v\n",
+				dns.ToUnicode(status.Name),
+				status.DiscoveredIP,
+				listOrDash(contents),
+				listOrDash(status.ResolvedAddresses),
+				yesNo(status.InSync),
+			)
+		}
+		for _, status := range statuses {
+			writeDampening(table, dns.ToUnicode(status.Name), status.Dampening)
+		}
+		return table.Flush()
+	default:
+		return fmt.Errorf("unsupported output format: %v", format)
+	}
+}
+
+// writeDampening prints the change waiting to be published and the
+// changes held back so far
+func writeDampening(w io.Writer, name string, state *dampening.State) {
+	if state == nil {
+		return
+	}
+	fmt.Fprintln(w)
+	if state.Candidate != "" {
+		fmt.Fprintf(
+			w,
+			"Pending change for %v: %v observed %v times since %v\n",
+			name,
+			state.Candidate,
+			state.Observations,
+			state.FirstSeen.Format(time.RFC3339),
+		)
+	}
+	fmt.Fprintf(w, "Suppressed changes: %v\n", state.Suppressed)
+	if state.LastSuppressed != nil {
+		fmt.Fprintf(w, "Last suppressed: %v (%v)\n", state.LastSuppressed.Format(time.RFC3339), state.LastReason)
+	}
+}
+
+// listOrDash joins values with commas, or returns a dash for an empty list
+func listOrDash(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	return strings.Join(values, ",")
+}
+
+// orDash renders an optional value for tables
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// yesNo renders a boolean for tables
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,292 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/resolver"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+func withMockResolver(client *resolver.DefaultClient) error {
+	client.Client = &mocks.MockResolver{}
+	return nil
+}
+
+func TestStatus(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+	controllers.ResolverClientOptions = append(
+		controllers.ResolverClientOptions,
+		withMockResolver,
+	)
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	statusEnv := func(extra map[string]string) map[string]string {
+		env := map[string]string{
+			"NETWORK_ID":            "bar",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+		}
+		for key, value := range extra {
+			env[key] = value
+		}
+		return env
+	}
+
+	newStatusApp := func(output *bytes.Buffer) *cli.App {
+		app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.StatusCommand()})
+		app.Writer = output
+		return app
+	}
+
+	inSyncRecord := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4"))
+
+	tests := []testRunner{
+		{
+			testCase: "reports records in sync as a table",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(statusEnv(map[string]string{}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{inSyncRecord})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("LookupIP", []net.IP{net.ParseIP("1.2.3.4")})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newStatusApp(output).Run([]string{"qrkdns", "status"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).To(ContainSubstring("NAME"))
+				g.Expect(output.String()).To(MatchRegexp(`bar\.foo\.net\s+1\.2\.3\.4\s+1\.2\.3\.4\s+1\.2\.3\.4\s+yes`))
+			},
+		},
+		{
+			testCase: "reports drift as json with a distinct exit code",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(statusEnv(map[string]string{"OUTPUT": "json"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("5.5.5.5"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{inSyncRecord})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("LookupIP", []net.IP{net.ParseIP("1.2.3.4")})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newStatusApp(output).Run([]string{"qrkdns", "status"})
+				exitCoder, ok := err.(cli.ExitCoder)
+				g.Expect(ok).To(BeTrue())
+				g.Expect(exitCoder.ExitCode()).To(Equal(controllers.StatusExitCodeDrift))
+
+				statuses := []map[string]interface{}{}
+				g.Expect(json.Unmarshal(output.Bytes(), &statuses)).To(Succeed())
+				g.Expect(statuses).To(HaveLen(1))
+				g.Expect(statuses[0]["discovered_ip"]).To(Equal("5.5.5.5"))
+				g.Expect(statuses[0]["in_sync"]).To(BeFalse())
+			},
+		},
+		{
+			testCase: "reports shared names including the IP as in sync",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(statusEnv(map[string]string{"SHARED": "true", "OUTPUT": "json"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				otherRecord := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("bar", "foo.net", "5.6.7.8"))
+				for ip, inSync := range map[string]bool{"1.2.3.4": true, "9.9.9.9": false} {
+					g.Expect(envy.AddObjectReturns("Do", ipResponse(ip))).To(Succeed())
+					g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{inSyncRecord, otherRecord})).To(Succeed())
+					g.Expect(envy.AddObjectReturns("LookupIP", []net.IP{net.ParseIP("5.6.7.8"), net.ParseIP("1.2.3.4")})).To(Succeed())
+
+					output := &bytes.Buffer{}
+					err = newStatusApp(output).Run([]string{"qrkdns", "status"})
+					g.Expect(err == nil).To(Equal(inSync), ip)
+
+					statuses := []map[string]interface{}{}
+					g.Expect(json.Unmarshal(output.Bytes(), &statuses)).To(Succeed())
+					g.Expect(statuses[0]["provider_in_sync"]).To(Equal(inSync), ip)
+					g.Expect(statuses[0]["resolver_in_sync"]).To(Equal(inSync), ip)
+				}
+			},
+		},
+		{
+			testCase: "reports every name receiving the IP",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(statusEnv(map[string]string{"ADDITIONAL_NAMES": "@", "CNAME_NAMES": "www"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				apexRecord := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("@", "foo.net", "5.6.7.8"))
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{inSyncRecord}, []sdk.DNSRecord{apexRecord})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("LookupIP", []net.IP{net.ParseIP("1.2.3.4")}, []net.IP{net.ParseIP("5.6.7.8")})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newStatusApp(output).Run([]string{"qrkdns", "status"})
+				exitCoder, ok := err.(cli.ExitCoder)
+				g.Expect(ok).To(BeTrue())
+				g.Expect(exitCoder.ExitCode()).To(Equal(controllers.StatusExitCodeDrift))
+				g.Expect(output.String()).To(MatchRegexp(`bar\.foo\.net\s+1\.2\.3\.4\s+1\.2\.3\.4\s+1\.2\.3\.4\s+yes`))
+				g.Expect(output.String()).To(MatchRegexp(`\nfoo\.net\s+1\.2\.3\.4\s+5\.6\.7\.8\s+5\.6\.7\.8\s+no`))
+			},
+		},
+		{
+			testCase: "displays internationalized names in their U-label form",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(statusEnv(map[string]string{"NETWORK_ID": "bär", "DOMAIN_NAME": "bücher.example"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				record := cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("xn--br-via", "xn--bcher-kva.example", "1.2.3.4"))
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{record})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("LookupIP", []net.IP{net.ParseIP("1.2.3.4")})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newStatusApp(output).Run([]string{"qrkdns", "status"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(output.String()).To(MatchRegexp(`bär\.bücher\.example\s+1\.2\.3\.4\s+1\.2\.3\.4\s+1\.2\.3\.4\s+yes`))
+			},
+		},
+		{
+			testCase: "returns error for invalid names",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(statusEnv(map[string]string{"NETWORK_ID": "bar_baz"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				err = newStatusApp(&bytes.Buffer{}).Run([]string{"qrkdns", "status"})
+				g.Expect(err).To(MatchError("invalid name bar_baz.foo.net: label bar_baz contains '_'"))
+			},
+		},
+		{
+			testCase: "shows missing records in the table",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(statusEnv(map[string]string{}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("LookupIP", []net.IP{})).To(Succeed())
+
+				output := &bytes.Buffer{}
+				err = newStatusApp(output).Run([]string{"qrkdns", "status"})
+				g.Expect(err).To(HaveOccurred())
+				g.Expect(output.String()).To(MatchRegexp(`bar\.foo\.net\s+1\.2\.3\.4\s+-\s+-\s+no`))
+			},
+		},
+		{
+			testCase: "returns error for unsupported output format",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(statusEnv(map[string]string{"OUTPUT": "yaml"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+
+				err = newStatusApp(&bytes.Buffer{}).Run([]string{"qrkdns", "status"})
+				g.Expect(err).To(MatchError("unsupported output format: yaml"))
+			},
+		},
+		{
+			testCase: "returns errors from each lookup",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(statusEnv(map[string]string{}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := newStatusApp(&bytes.Buffer{})
+
+				g.Expect(envy.AddErrorReturns("Do", fmt.Errorf("offline"))).To(Succeed())
+				g.Expect(app.Run([]string{"qrkdns", "status"})).To(MatchError("offline"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddErrorReturns("DNSRecords", fmt.Errorf("forbidden"))).To(Succeed())
+				g.Expect(app.Run([]string{"qrkdns", "status"})).To(MatchError("forbidden"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddErrorReturns("LookupIP", fmt.Errorf("servfail"))).To(Succeed())
+				g.Expect(app.Run([]string{"qrkdns", "status"})).To(MatchError("servfail"))
+			},
+		},
+		{
+			testCase: "returns errors building clients",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(statusEnv(map[string]string{"TIMEOUT": "bad"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := newStatusApp(&bytes.Buffer{})
+				g.Expect(app.Run([]string{"qrkdns", "status"})).To(MatchError("time: invalid duration \"bad\""))
+
+				g.Expect(envy.AddErrorReturns("ListZonesContext", fmt.Errorf("no zone"))).To(Succeed())
+				g.Expect(app.Run([]string{"qrkdns", "status", "--timeout", "1s"})).To(MatchError("no zone"))
+
+				oldResolverClientOptions := controllers.ResolverClientOptions
+				defer func() {
+					controllers.ResolverClientOptions = oldResolverClientOptions
+				}()
+				controllers.ResolverClientOptions = append(
+					controllers.ResolverClientOptions,
+					func(client *resolver.DefaultClient) error {
+						return fmt.Errorf("boo")
+					},
+				)
+				g.Expect(app.Run([]string{"qrkdns", "status", "--timeout", "1s"})).To(MatchError("boo"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -2,13 +2,21 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/control"
+	"github.com/markliederbach/qrkdns/pkg/clients/dampening"
 	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/election"
+	"github.com/markliederbach/qrkdns/pkg/clients/guard"
+	"github.com/markliederbach/qrkdns/pkg/clients/history"
+	"github.com/markliederbach/qrkdns/pkg/clients/hooks"
 	"github.com/markliederbach/qrkdns/pkg/clients/ip"
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
 	"github.com/markliederbach/qrkdns/pkg/clients/scheduler"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -27,6 +35,12 @@ const (
 	// NetworkIDFlag wraps the name of the command flag
 	NetworkIDFlag string = "network-id"
 
+	// AdditionalNamesFlag wraps the name of the command flag
+	AdditionalNamesFlag string = "additional-name"
+
+	// CNAMEFlag wraps the name of the command flag
+	CNAMEFlag string = "cname"
+
 	// DomainFlag wraps the name of the command flag
 	DomainFlag string = "domain"
 
@@ -39,6 +53,15 @@ const (
 	// CloudflareAPITokenFlag wraps the name of the command flag
 	CloudflareAPITokenFlag string = "cf-api-token"
 
+	// CloudflareAPIKeyFlag wraps the name of the command flag
+	CloudflareAPIKeyFlag string = "cf-api-key"
+
+	// CloudflareAPIEmailFlag wraps the name of the command flag
+	CloudflareAPIEmailFlag string = "cf-api-email"
+
+	// CloudflareZoneIDFlag wraps the name of the command flag
+	CloudflareZoneIDFlag string = "cf-zone-id"
+
 	// IPServiceURLFlag wraps the name of the command flag
 	IPServiceURLFlag string = "ip-service-url"
 
@@ -47,6 +70,9 @@ const (
 
 	// ScheduleFlag wraps the name of the command flag
 	ScheduleFlag string = "schedule"
+
+	// OwnerIDFlag wraps the name of the command flag
+	OwnerIDFlag string = "owner-id"
 )
 
 // SyncCommand returns
@@ -55,126 +81,613 @@ func SyncCommand() *cli.Command {
 		Name:    "sync",
 		Aliases: []string{"s"},
 		Usage:   "Sync this host's external IP to Cloudflare",
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:     NetworkIDFlag,
-				Aliases:  []string{"n"},
-				Usage:    "Identifier used for the subdomain",
-				EnvVars:  []string{"NETWORK_ID"},
-				Required: true,
-			},
-			&cli.StringFlag{
-				Name:     DomainFlag,
-				Aliases:  []string{"d"},
-				Usage:    "Base domain used when constructing the host's subdomain",
-				EnvVars:  []string{"DOMAIN_NAME"},
-				Required: true,
-			},
-			&cli.StringFlag{
-				Name:    ProviderTypeFlag,
-				Aliases: []string{"p"},
-				Usage:   fmt.Sprintf("Type of provider to use (one of: %v)", getSupportedProvidersString()),
-				EnvVars: []string{"PROVIDER"},
-				Value:   string(dns.ProviderTypeCloudflare),
-			},
-			&cli.StringFlag{
-				Name:    CloudflareAccountIDFlag,
-				Aliases: []string{"a"},
-				Usage:   "Cloudflare Account ID used in conjunction with the API token",
-				EnvVars: []string{"CLOUDFLARE_ACCOUNT_ID"},
-			},
-			&cli.StringFlag{
-				Name:    CloudflareAPITokenFlag,
-				Aliases: []string{"t"},
-				Usage:   "Cloudflare API token providing scoped permisions for DNS management",
-				EnvVars: []string{"CLOUDFLARE_API_TOKEN"},
-			},
-			&cli.StringFlag{
-				Name:    IPServiceURLFlag,
-				Aliases: []string{"i"},
-				Usage:   "Web service to retrieve external IP address",
-				EnvVars: []string{"IP_SERVICE_URL"},
-				Value:   "http://checkip.amazonaws.com",
-			},
-			&cli.StringFlag{
-				Name:    TimeoutFlag,
-				Usage:   "Timeout as a duration string (e.g., 5s). Empty/Unset means no timeout",
-				Value:   "",
-				EnvVars: []string{"TIMEOUT"},
-			},
-		},
+		Flags: flagsOf(
+			syncFlags(),
+			notifyFlags(),
+			hookFlags(),
+			verifyFlags(),
+			stateFlags(),
+			guardFlags(),
+			dampeningFlags(),
+			internalFlags(),
+			sharedFlags(),
+			electionFlags(),
+			[]cli.Flag{agentIDFlag()},
+			failoverFlags(),
+			followFlags(),
+			prefixFlags(),
+		),
 		Action: syncOnce,
 		Subcommands: []*cli.Command{
 			{
 				Name:  "cron",
 				Usage: "Run the sync on a recurring schedule",
-				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:     ScheduleFlag,
-						Usage:    "Cron pattern",
-						EnvVars:  []string{"SCHEDULE"},
-						Required: true,
+				Flags: flagsOf(
+					[]cli.Flag{
+						&cli.StringFlag{
+							Name:     ScheduleFlag,
+							Usage:    "Cron pattern",
+							EnvVars:  []string{"SCHEDULE"},
+							Required: true,
+						},
 					},
-				},
+					controlFlags(),
+				),
 				Action: syncCron,
 			},
 		},
 	}
 }
 
+// syncFlags returns the core flags shared by commands that discover
+// the external IP and talk to a provider
+func syncFlags() []cli.Flag {
+	return flagsOf(
+		recordFlags(),
+		providerFlags(),
+		discoveryFlags(),
+	)
+}
+
+// recordFlags returns the flags describing the managed record
+func recordFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:     NetworkIDFlag,
+			Aliases:  []string{"n"},
+			Usage:    "Identifier used for the subdomain (@ for the zone apex, *.office for a wildcard)",
+			EnvVars:  []string{"NETWORK_ID"},
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:    AdditionalNamesFlag,
+			Usage:   "Other names, relative to the domain, also receiving the discovered IP (repeatable)",
+			EnvVars: []string{"ADDITIONAL_NAMES"},
+		},
+		&cli.StringSliceFlag{
+			Name:    CNAMEFlag,
+			Usage:   "Names, relative to the domain, maintained as CNAME records aliasing the network ID's name (repeatable)",
+			EnvVars: []string{"CNAME_NAMES"},
+		},
+	}
+}
+
+// managedNames returns the validated names receiving the discovered IP,
+// starting with the network ID, and the names aliasing it. All names are
+// relative to the domain, in their A-label form.
+func managedNames(c *cli.Context) ([]string, []string, error) {
+	domain := c.String(DomainFlag)
+	names, err := asciiNames(domain, append([]string{c.String(NetworkIDFlag)}, c.StringSlice(AdditionalNamesFlag)...))
+	if err != nil {
+		return nil, nil, err
+	}
+	aliases, err := asciiNames(domain, c.StringSlice(CNAMEFlag))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A CNAME can't share its name with any other record
+	seen := make(map[string]bool)
+	for _, name := range append(append([]string{}, names...), aliases...) {
+		fqdn := dns.FQDN(name, domainName(c))
+		if seen[fqdn] {
+			return nil, nil, fmt.Errorf("name %v is configured more than once", dns.ToUnicode(fqdn))
+		}
+		seen[fqdn] = true
+	}
+	if len(aliases) > 0 && strings.HasPrefix(names[0], dns.WildcardLabel) {
+		return nil, nil, fmt.Errorf("CNAME records can't alias the wildcard %v", dns.ToUnicode(recordName(c)))
+	}
+	return names, aliases, nil
+}
+
+// asciiNames validates names relative to the domain and returns their
+// A-label form
+func asciiNames(domain string, names []string) ([]string, error) {
+	results := []string{}
+	for _, name := range names {
+		if err := dns.ValidateName(name, domain); err != nil {
+			return nil, err
+		}
+		results = append(results, asciiName(name))
+	}
+	return results, nil
+}
+
+// asciiName returns the A-label form of a name, or the name as given if it
+// can't be converted. Invalid names are reported where they are validated.
+func asciiName(name string) string {
+	ascii, err := dns.ToASCII(name)
+	if err != nil {
+		return name
+	}
+	return ascii
+}
+
+// domainName returns the A-label form of the domain
+func domainName(c *cli.Context) string {
+	return asciiName(c.String(DomainFlag))
+}
+
+// providerFlags returns the flags used to build a DNS provider
+func providerFlags() []cli.Flag {
+	return flagsOf([]cli.Flag{
+		&cli.StringFlag{
+			Name:     DomainFlag,
+			Aliases:  []string{"d"},
+			Usage:    "Base domain used when constructing the host's subdomain",
+			EnvVars:  []string{"DOMAIN_NAME"},
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    ProviderTypeFlag,
+			Aliases: []string{"p"},
+			Usage:   fmt.Sprintf("Type of provider to use (one of: %v)", getSupportedProvidersString()),
+			EnvVars: []string{"PROVIDER"},
+			Value:   string(dns.ProviderTypeCloudflare),
+		},
+		&cli.StringFlag{
+			Name:    CloudflareAccountIDFlag,
+			Aliases: []string{"a"},
+			Usage:   "Cloudflare Account ID owning the zone, used to scope the zone lookup",
+			EnvVars: []string{"CLOUDFLARE_ACCOUNT_ID"},
+		},
+	}, secretFlags(
+		&cli.StringFlag{
+			Name:    CloudflareAPITokenFlag,
+			Aliases: []string{"t"},
+			Usage:   "Cloudflare API token providing scoped permisions for DNS management",
+			EnvVars: []string{"CLOUDFLARE_API_TOKEN"},
+		},
+		"Cloudflare API token",
+	), secretFlags(
+		&cli.StringFlag{
+			Name:    CloudflareAPIKeyFlag,
+			Usage:   "Cloudflare Global API Key, used along with --cf-api-email when no API token is set",
+			EnvVars: []string{"CLOUDFLARE_API_KEY"},
+		},
+		"Cloudflare Global API Key",
+	), []cli.Flag{
+		&cli.StringFlag{
+			Name:    CloudflareAPIEmailFlag,
+			Usage:   "Email address of the Cloudflare account owning the Global API Key",
+			EnvVars: []string{"CLOUDFLARE_API_EMAIL"},
+		},
+		&cli.StringFlag{
+			Name:    CloudflareZoneIDFlag,
+			Usage:   "Cloudflare zone ID, skipping the zone lookup for tokens without zone read permission",
+			EnvVars: []string{"CLOUDFLARE_ZONE_ID"},
+		},
+		&cli.StringFlag{
+			Name:    OwnerIDFlag,
+			Usage:   "Identifier written to ownership records so qrkdns can tell which records it manages",
+			EnvVars: []string{"OWNER_ID"},
+			Value:   "qrkdns",
+		},
+		&cli.StringFlag{
+			Name:    TimeoutFlag,
+			Usage:   "Timeout as a duration string (e.g., 5s). Empty/Unset means no timeout",
+			Value:   "",
+			EnvVars: []string{"TIMEOUT"},
+		},
+	}, vaultFlags())
+}
+
+// discoveryFlags returns the flags used to discover the external IP
+func discoveryFlags() []cli.Flag {
+	return flagsOf([]cli.Flag{
+		&cli.StringFlag{
+			Name:    IPServiceURLFlag,
+			Aliases: []string{"i"},
+			Usage:   "Web service to retrieve external IP address",
+			EnvVars: []string{"IP_SERVICE_URL"},
+			Value:   "http://checkip.amazonaws.com",
+		},
+		&cli.StringFlag{
+			Name:    IPSourceFlag,
+			Usage:   fmt.Sprintf("Source of the published address (%v, %v or %v:<name>)", ip.SourceHTTP, ip.SourceInterface, ip.SourceInterface),
+			EnvVars: []string{"IP_SOURCE"},
+			Value:   ip.SourceHTTP,
+		},
+	}, hmacKeyFlags())
+}
+
+// syncer carries the state that must survive between scheduled syncs
+type syncer struct {
+	notifier *notify.DefaultClient
+	hooks    hooks.DefaultClient
+	history  *history.DefaultClient
+	guard    *guard.DefaultClient
+	dampener *dampening.DefaultClient
+	// sharing is set when the names are shared with other agents
+	sharing *sharing
+	// elector is set when only the elected agent updates the records
+	elector *election.DefaultClient
+	// failover is set when a name publishes the healthy addresses among
+	// a set of candidates
+	failover *failover
+	// delegation is set when hosts are addressed within the delegated
+	// IPv6 prefix
+	delegation *delegation
+	// followNames holds the name patterns of the records following the
+	// address changes
+	followNames []string
+	// unfollowed holds the previous addresses still served by records
+	// that haven't followed the address changes
+	unfollowed []string
+	failures   int
+	lastIP     string
+	// observedIP is the last discovered address, restored from the history
+	observedIP string
+	// rejections counts the addresses refused by the guard
+	rejections int
+	// rejectedIP is the last refused address, cleared once one is accepted
+	rejectedIP string
+	// suppressions counts the changes held back by the dampening policy
+	suppressions int
+}
+
+// newSyncer builds the long-lived dependencies of a sync
+func newSyncer(c *cli.Context) (*syncer, error) {
+	notifier, err := buildNotifier(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build notifier")
+		return nil, err
+	}
+	hookClient, err := buildHooks(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build hooks")
+		return nil, err
+	}
+	historyClient, err := buildHistory(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build history client")
+		return nil, err
+	}
+	guardClient, err := buildGuard(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build publication guard")
+		return nil, err
+	}
+	dampener, err := buildDampener(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build dampener")
+		return nil, err
+	}
+	sharing, err := buildSharing(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to configure shared names")
+		return nil, err
+	}
+	elector, err := buildElector(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to build election client")
+		return nil, err
+	}
+	failover, err := buildFailover(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to configure failover")
+		return nil, err
+	}
+	delegation, err := buildDelegation(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to configure prefix delegation")
+		return nil, err
+	}
+	followNames, err := followPatterns(c)
+	if err != nil {
+		log.WithError(err).Error("Failed to configure the records following the address")
+		return nil, err
+	}
+
+	s := &syncer{
+		notifier:    notifier,
+		hooks:       hookClient,
+		history:     historyClient,
+		guard:       guardClient,
+		dampener:    dampener,
+		sharing:     sharing,
+		elector:     elector,
+		failover:    failover,
+		delegation:  delegation,
+		followNames: followNames,
+	}
+	if historyClient != nil {
+		s.observedIP, err = historyClient.LastIP(recordName(c))
+		if err == nil && c.Bool(FollowIPFlag) {
+			var previous string
+			previous, err = previousIP(c, historyClient)
+			s.addUnfollowed(previous)
+		}
+		if err != nil {
+			log.WithError(err).Error("Failed to read history")
+			return nil, err
+		}
+	}
+	// One-shot syncs pick up the failures of the previous runs
+	s.failures, err = notifier.Failures()
+	if err != nil {
+		log.WithError(err).Error("Failed to read notification state")
+		return nil, err
+	}
+	return s, nil
+}
+
 // syncOnce performs a single sync task. Each sync consists of
 // retrieving the external IP Address of this host and applying
 // the result as a DNS A record to the specified provider through a
 // dedicated API client.
 func syncOnce(c *cli.Context) error {
-	var cancel context.CancelFunc
+	s, err := newSyncer(c)
+	if err != nil {
+		return err
+	}
+	return s.run(c)
+}
 
-	ctx := c.Context
-	ipServiceURL := c.String(IPServiceURLFlag)
-	networkID := c.String(NetworkIDFlag)
-	timeoutString := c.String(TimeoutFlag)
+// run performs a sync and notifies about its outcome
+func (s *syncer) run(c *cli.Context) error {
+	_, _, err := s.apply(c)
+	return err
+}
 
-	if timeoutString != "" {
-		timeoutDuration, err := time.ParseDuration(timeoutString)
-		if err != nil {
-			log.WithError(err).Error("Failed to parse timeout duration")
-			return err
+// apply performs a sync and notifies about its outcome, returning the
+// published address and the result of each A record, for the public view
+// and the internal view, if any
+func (s *syncer) apply(c *cli.Context) (control.View, *control.View, error) {
+	public, internal, err := s.sync(c)
+	var rejection *guard.Rejection
+	if errors.As(err, &rejection) {
+		// A rejected address is the guard working, not a failing sync
+		s.reject(c, rejection)
+		return control.View{}, nil, err
+	}
+	if err != nil {
+		s.failures++
+		s.saveFailures()
+		s.notify(c.Context, failureEvent(c, s.failures, err))
+		return control.View{}, nil, err
+	}
+	s.failures = 0
+	s.saveFailures()
+
+	views := []control.View{public}
+	if internal != nil {
+		views = append(views, *internal)
+	}
+	for _, view := range views {
+		for _, result := range view.Results {
+			for _, event := range eventsFromResult(c.String(ProviderTypeFlag), view.IP, result) {
+				s.notify(c.Context, event)
+			}
 		}
-		log.WithField("timeout", timeoutDuration).Debug("Setting timeout")
-		ctx, cancel = context.WithTimeout(c.Context, timeoutDuration)
-		defer cancel()
 	}
+	return public, internal, nil
+}
+
+// sync publishes the public view, then the internal view, if any
+func (s *syncer) sync(c *cli.Context) (control.View, *control.View, error) {
+	names, aliases, err := managedNames(c)
+	if err != nil {
+		return control.View{}, nil, err
+	}
+	internalNames, err := internalNames(c, append(append([]string{}, names...), aliases...))
+	if err != nil {
+		return control.View{}, nil, err
+	}
+
+	ctx, cancel, err := withTimeout(c)
+	if err != nil {
+		return control.View{}, nil, err
+	}
+	defer cancel()
+
+	if s.elector != nil {
+		leading, err := s.elect(ctx)
+		if err != nil || !leading {
+			return control.View{}, nil, err
+		}
+	}
+
+	externalIP, results, err := s.syncPublic(ctx, c, names, aliases)
+	var internal *control.View
+	if err == nil {
+		// The internal view is published even while dampening holds the
+		// public address back
+		internal, err = s.syncInternal(ctx, c, internalNames)
+	}
+	// The failover addresses and the delegated prefix don't depend on the
+	// discovered address, so they are published even when the other views
+	// fail
+	if s.failover != nil {
+		err = errors.Join(err, s.syncFailover(ctx, c))
+	}
+	if s.delegation != nil {
+		err = errors.Join(err, s.syncPrefix(ctx, c))
+	}
+	if err != nil {
+		return control.View{}, nil, err
+	}
+	return control.View{IP: externalIP, Results: results}, internal, nil
+}
 
+// syncPublic retrieves the external IP address and applies it to the
+// names, returning the result of each A record, and points the aliases to
+// the network ID's name. No address is returned when dampening holds the
+// change back.
+func (s *syncer) syncPublic(ctx context.Context, c *cli.Context, names, aliases []string) (string, []dns.ApplyResult, error) {
 	dnsClient, err := buildDNSProvider(c)
 	if err != nil {
 		log.WithError(err).Error("Failed to build DNS client")
-		return err
+		return "", nil, err
 	}
 
-	ipClient, err := ip.NewClient(ipServiceURL, IPClientOptions...)
+	externalIP, err := discoverAddress(ctx, c, c.String(IPSourceFlag))
 	if err != nil {
-		log.WithError(err).Error("Failed to build IP client")
-		return err
+		return "", nil, err
 	}
 
-	externalIP, err := ipClient.GetExternalIPAddress(ctx)
+	log.WithField("externalIP", externalIP).Debug("External IP address retrieved")
+
+	// Rejected addresses must never reach the provider
+	err = s.guard.Check(externalIP)
 	if err != nil {
-		log.WithError(err).Error("Failed to get external IP address")
-		return err
+		return "", nil, err
+	}
+	s.rejectedIP = ""
+
+	observedIP := s.observedIP
+	if externalIP != s.observedIP {
+		s.record(history.Entry{
+			Type:     history.EntryTypeIPChanged,
+			Name:     recordName(c),
+			Provider: c.String(ProviderTypeFlag),
+			Source:   sourceName(c, c.String(IPSourceFlag)),
+			OldIP:    s.observedIP,
+			NewIP:    externalIP,
+		})
+		s.observedIP = externalIP
 	}
 
-	log.WithField("externalIP", externalIP).Debug("External IP address retrieved")
+	// Flapping addresses are held back until they are stable
+	if s.dampener != nil {
+		publish, err := s.dampen(ctx, c, dnsClient, externalIP)
+		if err != nil || !publish {
+			return "", nil, err
+		}
+	}
 
-	_, err = dnsClient.ApplyDNSARecord(ctx, networkID, externalIP)
+	hookEnv := hooks.Env{
+		OldIP:      s.lastIP,
+		NewIP:      externalIP,
+		RecordName: recordName(c),
+		Provider:   c.String(ProviderTypeFlag),
+	}
+	// Until this process has synced, the previous address is the published
+	// one, or else the last one in the history
+	if hookEnv.OldIP == "" && c.String(PreSyncHookFlag) != "" {
+		hookEnv.OldIP = publishedIP(ctx, dnsClient, recordName(c), observedIP)
+	}
+	err = s.hooks.Run(ctx, hooks.TypePreSync, c.String(PreSyncHookFlag), hookEnv)
 	if err != nil {
-		log.WithError(err).Error("Failed to apply DNS A record")
-		return err
+		return "", nil, err
+	}
+
+	results := []dns.ApplyResult{}
+	for _, name := range names {
+		result, err := s.applyARecord(ctx, c, dnsClient, name, externalIP)
+		if err != nil {
+			log.WithError(err).Error("Failed to apply DNS A record")
+			return "", nil, err
+		}
+		s.record(historyFromResult(c.String(ProviderTypeFlag), result)...)
+
+		// Mark the record as managed so it can be found by `records prune`
+		err = dns.EnsureOwnership(ctx, dnsClient, result.Record.Name, c.String(OwnerIDFlag))
+		if err != nil {
+			log.WithError(err).Error("Failed to record ownership")
+			return "", nil, err
+		}
+		results = append(results, result)
+	}
+	s.lastIP = externalIP
+	if s.dampener != nil {
+		if err := s.dampener.Published(externalIP); err != nil {
+			log.WithError(err).Warn("Failed to update dampening state")
+		}
+	}
+
+	if c.Bool(FollowIPFlag) {
+		for _, result := range results {
+			s.addUnfollowed(result.OldContent())
+		}
+	}
+
+	err = applyAliases(ctx, c, dnsClient, aliases)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if c.Bool(FollowIPFlag) {
+		err = s.followIP(ctx, c, dnsClient, externalIP)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	for _, result := range results {
+		if !result.Changed() {
+			continue
+		}
+		hookEnv.OldIP = result.OldContent()
+		hookEnv.RecordName = result.Record.Name
+		err = s.hooks.Run(ctx, hooks.TypePostChange, c.String(PostChangeHookFlag), hookEnv)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if c.Bool(VerifyFlag) {
+			err = verifyPropagation(ctx, c, result.Record.Name, externalIP)
+			if err != nil {
+				return "", nil, err
+			}
+		}
 	}
 
 	log.Info("Sync complete")
+	return externalIP, results, nil
+}
+
+// applyAliases points the CNAME records of the aliases to the network
+// ID's name
+func applyAliases(ctx context.Context, c *cli.Context, dnsClient dns.Provider, aliases []string) error {
+	target := recordName(c)
+	for _, alias := range aliases {
+		name := dns.FQDN(alias, domainName(c))
+		result, err := dns.ApplyCNAME(ctx, dnsClient, name, target)
+		if err != nil {
+			log.WithError(err).WithField("alias", dns.ToUnicode(name)).Error("Failed to apply CNAME record")
+			return err
+		}
+		if result.Changed() {
+			log.WithFields(log.Fields{"alias": dns.ToUnicode(name), "target": dns.ToUnicode(target)}).Info("CNAME record applied")
+		}
+
+		err = dns.EnsureOwnership(ctx, dnsClient, name, c.String(OwnerIDFlag))
+		if err != nil {
+			log.WithError(err).Error("Failed to record ownership")
+			return err
+		}
+	}
 	return nil
 }
 
+// record appends entries to the history, if enabled, logging rather than
+// failing on errors
+func (s *syncer) record(entries ...history.Entry) {
+	if s.history == nil || len(entries) == 0 {
+		return
+	}
+	if err := s.history.Append(entries...); err != nil {
+		log.WithError(err).Warn("Failed to write history")
+	}
+}
+
+// saveFailures persists the failure count, logging rather than failing on
+// errors
+func (s *syncer) saveFailures() {
+	if err := s.notifier.SetFailures(s.failures); err != nil {
+		log.WithError(err).Warn("Failed to save notification state")
+	}
+}
+
+// notify sends an event, logging rather than failing on delivery errors
+func (s *syncer) notify(ctx context.Context, event notify.Event) {
+	if err := s.notifier.Notify(ctx, event); err != nil {
+		log.WithError(err).WithField("event", event).Warn("Failed to send notification")
+	}
+}
+
 // syncCron runs the syncOnce task at the specified cron schedule
 func syncCron(c *cli.Context) error {
 	scheduleCron := c.String(ScheduleFlag)
@@ -186,12 +699,31 @@ func syncCron(c *cli.Context) error {
 		return err
 	}
 
+	// The syncer is shared across runs so failures and notifications
+	// are tracked for the lifetime of the process
+	s, err := newSyncer(c)
+	if err != nil {
+		return err
+	}
+
 	clientScheduler := client.GetScheduler()
 
-	_, err = clientScheduler.Do(syncOnce, c)
+	a := newAgent(c, s, &client)
+	_, err = clientScheduler.Do(a.scheduled, c)
+	if err != nil {
+		return err
+	}
+
+	stopSignals := watchSignals(a)
+	defer stopSignals()
+	stopHealth := watchHealth(a)
+	defer stopHealth()
+
+	stop, err := serveControl(c, a)
 	if err != nil {
 		return err
 	}
+	defer stop()
 
 	cronLog.Info("Running cron scheduler")
 	clientScheduler.StartBlocking() // does not return
@@ -199,9 +731,53 @@ func syncCron(c *cli.Context) error {
 	return nil
 }
 
+// withTimeout returns a context bounded by the configured timeout, if any
+func withTimeout(c *cli.Context) (context.Context, context.CancelFunc, error) {
+	timeoutString := c.String(TimeoutFlag)
+	if timeoutString == "" {
+		return c.Context, func() {}, nil
+	}
+
+	timeoutDuration, err := time.ParseDuration(timeoutString)
+	if err != nil {
+		log.WithError(err).Error("Failed to parse timeout duration")
+		return c.Context, func() {}, err
+	}
+	log.WithField("timeout", timeoutDuration).Debug("Setting timeout")
+	ctx, cancel := context.WithTimeout(c.Context, timeoutDuration)
+	return ctx, cancel, nil
+}
+
+// discoverIP retrieves the external IP address of this host from the IP
+// service
+func discoverIP(ctx context.Context, c *cli.Context, serviceURL string) (string, error) {
+	opts, err := ipClientOptions(c)
+	if err != nil {
+		return "", err
+	}
+	ipClient, err := ip.NewClient(serviceURL, opts...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build IP client")
+		return "", err
+	}
+
+	externalIP, err := ipClient.GetExternalIPAddress(ctx)
+	if err != nil {
+		log.WithError(err).Error("Failed to get external IP address")
+		return "", err
+	}
+	return externalIP, nil
+}
+
 // buildDNSProvider determines which provider to create and returns
 // an instantiated provider client
 func buildDNSProvider(c *cli.Context) (dns.Provider, error) {
+	return buildZoneProvider(c, c.String(DomainFlag), c.String(CloudflareZoneIDFlag))
+}
+
+// buildZoneProvider returns a provider client of the domain, or of the
+// zone ID if set
+func buildZoneProvider(c *cli.Context, domain, zoneID string) (dns.Provider, error) {
 	var dnsClient dns.Provider
 	var err error
 
@@ -210,26 +786,15 @@ func buildDNSProvider(c *cli.Context) (dns.Provider, error) {
 
 	switch dns.ProviderType(providerType) {
 	case dns.ProviderTypeCloudflare:
-		var cloudflareOptions map[string]string
-
-		domain := c.String(DomainFlag)
-		cloudflareOptions, err = stringsOrError(
-			c,
-			fmt.Sprintf("using %s provider", dns.ProviderTypeCloudflare),
-			CloudflareAccountIDFlag,
-			CloudflareAPITokenFlag,
-		)
+		var accountID string
+		var cloudflareOptions []cloudflare.LoadOption
+
+		accountID, cloudflareOptions, err = cloudflareClientOptions(c, zoneID)
 		if err != nil {
 			return dnsClient, err
 		}
 
-		dnsClient, err = cloudflare.NewClientWithToken(
-			ctx,
-			cloudflareOptions[CloudflareAccountIDFlag],
-			domain,
-			cloudflareOptions[CloudflareAPITokenFlag],
-			CloudflareClientOptions...,
-		)
+		dnsClient, err = cloudflare.NewClient(ctx, accountID, domain, cloudflareOptions...)
 		if err != nil {
 			return dnsClient, err
 		}
@@ -239,6 +804,53 @@ func buildDNSProvider(c *cli.Context) (dns.Provider, error) {
 	return dnsClient, nil
 }
 
+// cloudflareClientOptions reads the account ID and credentials of the
+// Cloudflare provider, returning the options building the client of the
+// zone ID, if set. An API token is preferred over the Global API Key and
+// email.
+func cloudflareClientOptions(c *cli.Context, zoneID string) (string, []cloudflare.LoadOption, error) {
+	whenMessage := fmt.Sprintf("using %s provider", dns.ProviderTypeCloudflare)
+	opts := []cloudflare.LoadOption{}
+	var options map[string]string
+	var err error
+
+	token, key := secretSource(c, CloudflareAPITokenFlag), secretSource(c, CloudflareAPIKeyFlag)
+	if !token.IsSet() && (key.IsSet() || c.String(CloudflareAPIEmailFlag) != "") {
+		options, err = stringsOrError(c, whenMessage+" with an API key", CloudflareAccountIDFlag, CloudflareAPIKeyFlag, CloudflareAPIEmailFlag)
+		if err != nil {
+			return "", opts, err
+		}
+		opts = append(opts, cloudflare.WithAPIKey(options[CloudflareAPIKeyFlag], options[CloudflareAPIEmailFlag]))
+	} else {
+		options, err = stringsOrError(c, whenMessage, CloudflareAccountIDFlag, CloudflareAPITokenFlag)
+		if err != nil {
+			return "", opts, err
+		}
+		opts = append(opts, cloudflare.WithAPIToken(options[CloudflareAPITokenFlag]))
+	}
+
+	// An explicit zone ID skips the lookup, for tokens without zone read permission
+	if zoneID != "" {
+		opts = append(opts, cloudflare.WithZoneID(zoneID))
+	}
+	return options[CloudflareAccountIDFlag], append(opts, CloudflareClientOptions...), nil
+}
+
+// flagsOf concatenates groups of flags into a single list
+func flagsOf(groups ...[]cli.Flag) []cli.Flag {
+	flags := []cli.Flag{}
+	for _, group := range groups {
+		flags = append(flags, group...)
+	}
+	return flags
+}
+
+// recordName returns the fully qualified name of the network ID's record,
+// in its A-label form
+func recordName(c *cli.Context) string {
+	return dns.FQDN(asciiName(c.String(NetworkIDFlag)), domainName(c))
+}
+
 // getSupportedProvidersString returns the supported provider types
 // as a comma-separated string
 func getSupportedProvidersString() string {
@@ -250,12 +862,20 @@ func getSupportedProvidersString() string {
 }
 
 // stringsOrError attempts to load a list of options from the CLI, and reports
-// back any missing presumably required options
+// back any missing presumably required options. Secrets are also read from
+// their file or command, and any option can reference a value in Vault.
 func stringsOrError(c *cli.Context, whenMessage string, options ...string) (map[string]string, error) {
 	results := make(map[string]string)
 	missingOptions := []string{}
 	for _, option := range options {
 		value := c.String(option)
+		if isSecretFlag(option) || isVaultReference(value) {
+			var err error
+			value, err = resolveSecret(c, option)
+			if err != nil {
+				return make(map[string]string), err
+			}
+		}
 		if value == "" {
 			missingOptions = append(missingOptions, option)
 			continue
@@ -1,10 +1,12 @@
 package controllers_test
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
 	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/go-co-op/gocron"
 	"github.com/markliederbach/go-envy"
 	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
 	"github.com/markliederbach/qrkdns/pkg/clients/ip"
@@ -20,6 +22,17 @@ func withMockSDKClient(client *cloudflare.DefaultClient) error {
 	return nil
 }
 
+// recordingSDKClient records the records created through the mock
+type recordingSDKClient struct {
+	mocks.MockCloudflareSDKClient
+	created []sdk.DNSRecord
+}
+
+func (c *recordingSDKClient) CreateDNSRecord(ctx context.Context, zoneID string, rr sdk.DNSRecord) (*sdk.DNSRecordResponse, error) {
+	c.created = append(c.created, rr)
+	return &sdk.DNSRecordResponse{Result: rr}, nil
+}
+
 func withMockHTTPClient(client *ip.DefaultClient) error {
 	client.Client = &mocks.MockHTTPClient{}
 	return nil
@@ -30,6 +43,46 @@ func withMockSchedulerClient(client *scheduler.DefaultClient) error {
 	return nil
 }
 
+// repeatingScheduler runs the scheduled job a fixed number of times,
+// collecting the errors of every run
+type repeatingScheduler struct {
+	runs   int
+	job    func(*cli.Context) error
+	ctx    *cli.Context
+	errors []error
+}
+
+func (s *repeatingScheduler) Do(jobFun interface{}, params ...interface{}) (*gocron.Job, error) {
+	s.job = jobFun.(func(*cli.Context) error)
+	s.ctx = params[0].(*cli.Context)
+	return &gocron.Job{}, nil
+}
+
+func (s *repeatingScheduler) Cron(cronExpression string) *gocron.Scheduler {
+	return nil
+}
+
+func (s *repeatingScheduler) Clear() {}
+
+func (s *repeatingScheduler) StartBlocking() {
+	for i := 0; i < s.runs; i++ {
+		s.errors = append(s.errors, s.job(s.ctx))
+	}
+}
+
+// withRepeatingScheduler replaces the scheduler for the duration of a test
+func withRepeatingScheduler(runs int) (*repeatingScheduler, func()) {
+	repeating := &repeatingScheduler{runs: runs}
+	options := controllers.SchedulerClientOptions
+	controllers.SchedulerClientOptions = []scheduler.LoadOption{
+		func(client *scheduler.DefaultClient) error {
+			client.Client = repeating
+			return nil
+		},
+	}
+	return repeating, func() { controllers.SchedulerClientOptions = options }
+}
+
 func TestSync(t *testing.T) {
 	controllers.CloudflareClientOptions = append(
 		controllers.CloudflareClientOptions,
@@ -158,7 +211,7 @@ func TestSync(t *testing.T) {
 				defer env.Restore()
 
 				err = envy.AddErrorReturns(
-					"ZoneIDByName",
+					"ListZonesContext",
 					fmt.Errorf("baz"),
 				)
 				g.Expect(err).NotTo(HaveOccurred())
@@ -200,6 +253,273 @@ func TestSync(t *testing.T) {
 				g.Expect(err).To(MatchError("options [--cf-account-id, --cf-api-token] are required when using cloudflare provider"))
 			},
 		},
+		{
+			testCase: "authenticates with the Global API Key",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(
+					map[string]string{
+						"NETWORK_ID":            "xxx",
+						"DOMAIN_NAME":           "foo.bar",
+						"CLOUDFLARE_ACCOUNT_ID": "foo",
+						"CLOUDFLARE_API_KEY":    "key123",
+						"CLOUDFLARE_API_EMAIL":  "user@foo.bar",
+						"CLOUDFLARE_ZONE_ID":    "zone123",
+						"TIMEOUT":               "1s",
+					},
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				// Record the client before the SDK client is mocked
+				var authType cloudflare.AuthType
+				var zoneID, apiKey, apiEmail string
+				options := controllers.CloudflareClientOptions
+				controllers.CloudflareClientOptions = append([]cloudflare.LoadOption{
+					func(client *cloudflare.DefaultClient) error {
+						authType, zoneID = client.AuthType, client.ZoneID
+						apiKey, apiEmail = client.Client.(*sdk.API).APIKey, client.Client.(*sdk.API).APIEmail
+						return nil
+					},
+				}, options...)
+				defer func() { controllers.CloudflareClientOptions = options }()
+
+				// Would otherwise fail the zone lookup
+				g.Expect(envy.AddErrorReturns("ListZonesContext", fmt.Errorf("baz"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp(
+					"version123",
+					[]*cli.Command{controllers.SyncCommand()},
+				)
+
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(authType).To(Equal(cloudflare.AuthTypeAPIKey))
+				g.Expect(zoneID).To(Equal("zone123"))
+				g.Expect(apiKey).To(Equal("key123"))
+				g.Expect(apiEmail).To(Equal("user@foo.bar"))
+
+				// Drain the unused error
+				_, err = (&mocks.MockCloudflareSDKClient{}).ListZonesContext(context.Background())
+				g.Expect(err).To(MatchError("baz"))
+			},
+		},
+		{
+			testCase: "returns error for missing Global API Key flags",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(
+					map[string]string{
+						"NETWORK_ID":            "xxx",
+						"DOMAIN_NAME":           "foo.bar",
+						"CLOUDFLARE_ACCOUNT_ID": "foo",
+						"CLOUDFLARE_API_KEY":    "key123",
+						"TIMEOUT":               "1s",
+					},
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp(
+					"version123",
+					[]*cli.Command{controllers.SyncCommand()},
+				)
+
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("options [--cf-account-id, --cf-api-key, --cf-api-email] are required when using cloudflare provider with an API key"))
+			},
+		},
+		{
+			testCase: "applies the IP to every name and aliases it",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(
+					map[string]string{
+						"NETWORK_ID":            "@",
+						"ADDITIONAL_NAMES":      "*.office,bar",
+						"CNAME_NAMES":           "www",
+						"DOMAIN_NAME":           "foo.bar",
+						"CLOUDFLARE_ACCOUNT_ID": "foo",
+						"CLOUDFLARE_API_TOKEN":  "bar",
+						"TIMEOUT":               "1s",
+					},
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				sdkClient := &recordingSDKClient{}
+				options := controllers.CloudflareClientOptions
+				controllers.CloudflareClientOptions = append(options, func(client *cloudflare.DefaultClient) error {
+					client.Client = sdkClient
+					return nil
+				})
+				defer func() { controllers.CloudflareClientOptions = options }()
+
+				// Each name and its ownership record are listed once
+				empty := []interface{}{}
+				for i := 0; i < 8; i++ {
+					empty = append(empty, []sdk.DNSRecord{})
+				}
+				g.Expect(envy.AddObjectReturns("DNSRecords", empty...)).To(Succeed())
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp(
+					"version123",
+					[]*cli.Command{controllers.SyncCommand()},
+				)
+
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				created := []string{}
+				for _, record := range sdkClient.created {
+					created = append(created, fmt.Sprintf("%v %v %v", record.Type, record.Name, record.Content))
+				}
+				g.Expect(created).To(Equal([]string{
+					"A foo.bar 1.2.3.4",
+					"TXT _qrkdns.foo.bar heritage=qrkdns,owner=qrkdns",
+					"A *.office.foo.bar 1.2.3.4",
+					"TXT _qrkdns._wildcard.office.foo.bar heritage=qrkdns,owner=qrkdns",
+					"A bar.foo.bar 1.2.3.4",
+					"TXT _qrkdns.bar.foo.bar heritage=qrkdns,owner=qrkdns",
+					"CNAME www.foo.bar foo.bar",
+					"TXT _qrkdns.www.foo.bar heritage=qrkdns,owner=qrkdns",
+				}))
+			},
+		},
+		{
+			testCase: "publishes internationalized names in their A-label form",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(
+					map[string]string{
+						"NETWORK_ID":            "Bär",
+						"CNAME_NAMES":           "www",
+						"DOMAIN_NAME":           "bücher.example",
+						"CLOUDFLARE_ACCOUNT_ID": "foo",
+						"CLOUDFLARE_API_TOKEN":  "bar",
+					},
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				sdkClient := &recordingSDKClient{}
+				options := controllers.CloudflareClientOptions
+				controllers.CloudflareClientOptions = append(options, func(client *cloudflare.DefaultClient) error {
+					client.Client = sdkClient
+					return nil
+				})
+				defer func() { controllers.CloudflareClientOptions = options }()
+
+				empty := []interface{}{}
+				for i := 0; i < 4; i++ {
+					empty = append(empty, []sdk.DNSRecord{})
+				}
+				g.Expect(envy.AddObjectReturns("DNSRecords", empty...)).To(Succeed())
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp(
+					"version123",
+					[]*cli.Command{controllers.SyncCommand()},
+				)
+
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).NotTo(HaveOccurred())
+
+				created := []string{}
+				for _, record := range sdkClient.created {
+					created = append(created, fmt.Sprintf("%v %v %v", record.Type, record.Name, record.Content))
+				}
+				g.Expect(created).To(Equal([]string{
+					"A xn--br-via.xn--bcher-kva.example 1.2.3.4",
+					"TXT _qrkdns.xn--br-via.xn--bcher-kva.example heritage=qrkdns,owner=qrkdns",
+					"CNAME www.xn--bcher-kva.example xn--br-via.xn--bcher-kva.example",
+					"TXT _qrkdns.www.xn--bcher-kva.example heritage=qrkdns,owner=qrkdns",
+				}))
+			},
+		},
+		{
+			testCase: "returns errors applying CNAME records",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(
+					map[string]string{
+						"NETWORK_ID":            "xxx",
+						"CNAME_NAMES":           "www",
+						"DOMAIN_NAME":           "foo.bar",
+						"CLOUDFLARE_ACCOUNT_ID": "foo",
+						"CLOUDFLARE_API_TOKEN":  "bar",
+					},
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp(
+					"version123",
+					[]*cli.Command{controllers.SyncCommand()},
+				)
+
+				// The A record and its ownership are listed first
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddErrorReturns("DNSRecords", nil, nil, fmt.Errorf("baz"))).To(Succeed())
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError("baz"))
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddErrorReturns("DNSRecords", nil, nil, nil, fmt.Errorf("qux"))).To(Succeed())
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError("qux"))
+			},
+		},
+		{
+			testCase: "returns error for invalid names",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				tests := map[string]map[string]string{
+					"invalid name bar-.foo.bar: label bar- starts or ends with a hyphen": {"NETWORK_ID": "bar-"},
+					"invalid name a.*.foo.bar: label * contains '*'":                     {"ADDITIONAL_NAMES": "a.*"},
+					"name foo.bar is configured more than once":                          {"NETWORK_ID": "@", "CNAME_NAMES": "@"},
+					"name xxx.foo.bar is configured more than once":                      {"ADDITIONAL_NAMES": "XXX"},
+					"CNAME records can't alias the wildcard *.foo.bar":                   {"NETWORK_ID": "*", "CNAME_NAMES": "www"},
+					"name bär.foo.bar is configured more than once":                      {"NETWORK_ID": "xn--br-via", "ADDITIONAL_NAMES": "BÄR"},
+					"invalid name xn--zz.foo.bar: label xn--zz can't be converted to an A-label: idna: invalid label \"zz\"": {
+						"CNAME_NAMES": "xn--zz",
+					},
+				}
+				for message, extra := range tests {
+					values := map[string]string{
+						"NETWORK_ID":            "xxx",
+						"DOMAIN_NAME":           "foo.bar",
+						"CLOUDFLARE_ACCOUNT_ID": "foo",
+						"CLOUDFLARE_API_TOKEN":  "bar",
+					}
+					for key, value := range extra {
+						values[key] = value
+					}
+					env := envy.MockEnv{}
+					g.Expect(env.Load(values)).To(Succeed())
+
+					app := controllers.NewQrkDNSApp(
+						"version123",
+						[]*cli.Command{controllers.SyncCommand()},
+					)
+					err := app.Run([]string{"qrkdns", "sync"})
+					env.Restore()
+					g.Expect(err).To(MatchError(message))
+				}
+			},
+		},
 		{
 			testCase: "returns error for unsupported DNS provider",
 			runner: func(tt *testing.T) {
@@ -313,6 +633,7 @@ func TestSync(t *testing.T) {
 				g.Expect(err).NotTo(HaveOccurred())
 				defer env.Restore()
 
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
 				err = envy.AddErrorReturns(
 					"DNSRecords",
 					fmt.Errorf("baz"),
@@ -324,6 +645,36 @@ func TestSync(t *testing.T) {
 					[]*cli.Command{controllers.SyncCommand()},
 				)
 
+				err = app.Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("baz"))
+			},
+		},
+		{
+			testCase: "returns error from recording ownership",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(
+					map[string]string{
+						"NETWORK_ID":            "xxx",
+						"DOMAIN_NAME":           "foo.bar",
+						"CLOUDFLARE_ACCOUNT_ID": "foo",
+						"CLOUDFLARE_API_TOKEN":  "bar",
+					},
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+				g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+				g.Expect(envy.AddErrorReturns("DNSRecords", nil, fmt.Errorf("baz"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp(
+					"version123",
+					[]*cli.Command{controllers.SyncCommand()},
+				)
+
 				err = app.Run([]string{"qrkdns", "sync"})
 				g.Expect(err).To(MatchError("baz"))
 			},
@@ -440,6 +791,34 @@ func TestSyncCron(t *testing.T) {
 				g.Expect(err).To(MatchError("foo"))
 			},
 		},
+		{
+			testCase: "returns error from notifier",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(
+					map[string]string{
+						"NETWORK_ID":            "xxx",
+						"DOMAIN_NAME":           "foo.bar",
+						"CLOUDFLARE_ACCOUNT_ID": "foo",
+						"CLOUDFLARE_API_TOKEN":  "bar",
+						"SCHEDULE":              "* * * * *",
+						"NOTIFY_SMTP_HOST":      "smtp.foo.bar",
+					},
+				)
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				app := controllers.NewQrkDNSApp(
+					"version123",
+					[]*cli.Command{controllers.SyncCommand()},
+				)
+
+				err = app.Run([]string{"qrkdns", "sync", "cron"})
+				g.Expect(err).To(MatchError("options [--notify-email-from] are required when using email notifications"))
+			},
+		},
 	}
 	for _, test := range tests {
 		test := test
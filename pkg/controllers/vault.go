@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/secrets"
+	"github.com/markliederbach/qrkdns/pkg/clients/vault"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// VaultClientOptions is used by testing to inject a mock client option
+	VaultClientOptions = []vault.LoadOption{}
+)
+
+const (
+	// VaultAddressFlag wraps the name of the command flag
+	VaultAddressFlag string = "vault-addr"
+
+	// VaultNamespaceFlag wraps the name of the command flag
+	VaultNamespaceFlag string = "vault-namespace"
+
+	// VaultAuthMethodFlag wraps the name of the command flag
+	VaultAuthMethodFlag string = "vault-auth-method"
+
+	// VaultAuthMountFlag wraps the name of the command flag
+	VaultAuthMountFlag string = "vault-auth-mount"
+
+	// VaultTokenFlag wraps the name of the command flag
+	VaultTokenFlag string = "vault-token"
+
+	// VaultRoleIDFlag wraps the name of the command flag
+	VaultRoleIDFlag string = "vault-role-id"
+
+	// VaultSecretIDFlag wraps the name of the command flag
+	VaultSecretIDFlag string = "vault-secret-id"
+
+	// VaultKubernetesRoleFlag wraps the name of the command flag
+	VaultKubernetesRoleFlag string = "vault-k8s-role"
+
+	// VaultKubernetesTokenPathFlag wraps the name of the command flag
+	VaultKubernetesTokenPathFlag string = "vault-k8s-token-path"
+
+	// vaultMetadataKey stores the Vault client in the app metadata, so its
+	// token and leases outlive a single sync
+	vaultMetadataKey string = "vault"
+)
+
+// vaultFlags returns the flags used to read provider options from Vault
+func vaultFlags() []cli.Flag {
+	methods := []string{}
+	for _, method := range vault.SupportedAuthMethods {
+		methods = append(methods, string(method))
+	}
+	return flagsOf([]cli.Flag{
+		&cli.StringFlag{
+			Name:    VaultAddressFlag,
+			Usage:   "Address of the Vault server used to resolve vault://<path>#<key> option values",
+			EnvVars: []string{"VAULT_ADDR"},
+		},
+		&cli.StringFlag{
+			Name:    VaultNamespaceFlag,
+			Usage:   "Vault Enterprise namespace",
+			EnvVars: []string{"VAULT_NAMESPACE"},
+		},
+		&cli.StringFlag{
+			Name:    VaultAuthMethodFlag,
+			Usage:   fmt.Sprintf("Method used to log in to Vault (one of: %v)", strings.Join(methods, ", ")),
+			EnvVars: []string{"VAULT_AUTH_METHOD"},
+			Value:   string(vault.AuthMethodToken),
+		},
+		&cli.StringFlag{
+			Name:    VaultAuthMountFlag,
+			Usage:   "Path the Vault auth method is mounted at. Empty/Unset uses the method's name",
+			EnvVars: []string{"VAULT_AUTH_MOUNT"},
+		},
+	}, secretFlags(
+		&cli.StringFlag{
+			Name:    VaultTokenFlag,
+			Usage:   "Vault token used by the token auth method",
+			EnvVars: []string{"VAULT_TOKEN"},
+		},
+		"Vault token",
+	), []cli.Flag{
+		&cli.StringFlag{
+			Name:    VaultRoleIDFlag,
+			Usage:   "Role ID used by the approle auth method",
+			EnvVars: []string{"VAULT_ROLE_ID"},
+		},
+	}, secretFlags(
+		&cli.StringFlag{
+			Name:    VaultSecretIDFlag,
+			Usage:   "Secret ID used by the approle auth method",
+			EnvVars: []string{"VAULT_SECRET_ID"},
+		},
+		"Vault AppRole secret ID",
+	), []cli.Flag{
+		&cli.StringFlag{
+			Name:    VaultKubernetesRoleFlag,
+			Usage:   "Role used by the kubernetes auth method",
+			EnvVars: []string{"VAULT_K8S_ROLE"},
+		},
+		&cli.StringFlag{
+			Name:    VaultKubernetesTokenPathFlag,
+			Usage:   "Service account token used by the kubernetes auth method",
+			EnvVars: []string{"VAULT_K8S_TOKEN_PATH"},
+			Value:   vault.DefaultJWTPath,
+		},
+	})
+}
+
+// vaultBackend resolves vault:// references, building the Vault client on
+// first use
+type vaultBackend struct {
+	c *cli.Context
+	// resolver reads the credentials of the Vault client
+	resolver secrets.Resolver
+	building bool
+}
+
+// Read implements secrets.Backend
+func (v *vaultBackend) Read(ctx context.Context, reference string) (string, error) {
+	// Vault's own credentials can't be stored in Vault
+	if v.building {
+		return "", fmt.Errorf("vault credentials can't reference Vault")
+	}
+	v.building = true
+	client, err := buildVaultClient(v.c, v.resolver)
+	v.building = false
+	if err != nil {
+		return "", err
+	}
+	return client.Read(ctx, reference)
+}
+
+// buildVaultClient returns the Vault client of the app, creating it from
+// the command flags the first time. Reusing it across the syncs of
+// sync cron keeps its token and leases, which are renewed as needed.
+func buildVaultClient(c *cli.Context, resolver secrets.Resolver) (*vault.DefaultClient, error) {
+	if client, ok := c.App.Metadata[vaultMetadataKey].(*vault.DefaultClient); ok {
+		return client, nil
+	}
+	if c.String(VaultAddressFlag) == "" {
+		return nil, fmt.Errorf("option --%v is required when using %v:// references", VaultAddressFlag, vault.Scheme)
+	}
+
+	token, err := resolver.Resolve(c.Context, secretSource(c, VaultTokenFlag))
+	if err != nil {
+		return nil, err
+	}
+	secretID, err := resolver.Resolve(c.Context, secretSource(c, VaultSecretIDFlag))
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := vault.NewClient(
+		c.String(VaultAddressFlag),
+		vault.Auth{
+			Method:   vault.AuthMethod(c.String(VaultAuthMethodFlag)),
+			Mount:    c.String(VaultAuthMountFlag),
+			Token:    token,
+			RoleID:   c.String(VaultRoleIDFlag),
+			SecretID: secretID,
+			Role:     c.String(VaultKubernetesRoleFlag),
+			JWTPath:  c.String(VaultKubernetesTokenPathFlag),
+		},
+		append([]vault.LoadOption{vault.WithNamespace(c.String(VaultNamespaceFlag))}, VaultClientOptions...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	c.App.Metadata[vaultMetadataKey] = client
+	return client, nil
+}
+
+// isVaultReference returns true if the value is read from Vault
+func isVaultReference(value string) bool {
+	return strings.HasPrefix(value, vault.Scheme+"://")
+}
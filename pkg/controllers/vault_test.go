@@ -0,0 +1,188 @@
+package controllers_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/vault"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+func TestVault(t *testing.T) {
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// Record the credentials each Cloudflare client is built with
+	credentials := []string{}
+	rotate := func() {}
+	cloudflareOptions := controllers.CloudflareClientOptions
+	controllers.CloudflareClientOptions = []cloudflare.LoadOption{
+		func(client *cloudflare.DefaultClient) error {
+			credentials = append(credentials, client.AccountID+":"+client.Client.(*sdk.API).APIToken)
+			rotate()
+			return nil
+		},
+		withMockSDKClient,
+	}
+	defer func() { controllers.CloudflareClientOptions = cloudflareOptions }()
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	server := mocks.NewMockVaultServer()
+	defer server.Close()
+	server.AddToken("root")
+	server.AddAppRole("role123", "secret123")
+	server.SetKV1("kv/dns", map[string]interface{}{"account_id": "account123"})
+
+	vaultEnv := func(extra map[string]string) map[string]string {
+		env := map[string]string{
+			"NETWORK_ID":            "bar",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "vault://kv/dns#account_id",
+			"CLOUDFLARE_API_TOKEN":  "vault://secret/data/dns#cf_token",
+			"VAULT_ADDR":            server.URL,
+			"SCHEDULE":              "* * * * *",
+		}
+		for key, value := range extra {
+			env[key] = value
+		}
+		return env
+	}
+
+	logins := func() int {
+		n := 0
+		for _, request := range server.Requests() {
+			if request == "POST auth/approle/login" {
+				n++
+			}
+		}
+		return n
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "reads provider options from Vault on every sync",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				credentials = []string{}
+				server.SetKV2("secret/data/dns", map[string]interface{}{"cf_token": "token123"})
+				rotate = func() {
+					server.SetKV2("secret/data/dns", map[string]interface{}{"cf_token": "token456"})
+				}
+				defer func() { rotate = func() {} }()
+
+				path := filepath.Join(tt.TempDir(), "secret-id")
+				g.Expect(os.WriteFile(path, []byte("secret123\n"), 0o600)).To(Succeed())
+
+				env := envy.MockEnv{}
+				err := env.Load(vaultEnv(map[string]string{
+					"VAULT_AUTH_METHOD":    "approle",
+					"VAULT_ROLE_ID":        "role123",
+					"VAULT_SECRET_ID_FILE": path,
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				repeating, restore := withRepeatingScheduler(2)
+				defer restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"), ipResponse("1.2.3.4"))).To(Succeed())
+
+				before := logins()
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync", "cron"})).To(Succeed())
+				g.Expect(repeating.errors).To(Equal([]error{nil, nil}))
+				g.Expect(credentials).To(Equal([]string{"account123:token123", "account123:token456"}))
+
+				// The Vault client, and its token, are kept between syncs
+				g.Expect(logins() - before).To(Equal(1))
+			},
+		},
+		{
+			testCase: "reads provider options with a Vault token",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				credentials = []string{}
+				server.SetKV2("secret/data/dns", map[string]interface{}{"cf_token": "token123"})
+
+				env := envy.MockEnv{}
+				err := env.Load(vaultEnv(map[string]string{
+					"VAULT_TOKEN_COMMAND": "echo root",
+					"VAULT_NAMESPACE":     "team",
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(Succeed())
+				g.Expect(credentials).To(Equal([]string{"account123:token123"}))
+				g.Expect(server.Namespace()).To(Equal("team"))
+			},
+		},
+		{
+			testCase: "returns errors reading from Vault",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+				server.SetKV2("secret/data/dns", map[string]interface{}{"cf_token": "token123"})
+
+				tests := map[string]map[string]string{
+					"option --vault-addr is required when using vault:// references": {"VAULT_ADDR": "", "VAULT_TOKEN": "root"},
+					"token auth requires a Vault token":                              {},
+					"running vault-token command: exit status 1":                     {"VAULT_TOKEN_COMMAND": "exit 1"},
+					"running vault-secret-id command: exit status 1":                 {"VAULT_TOKEN": "root", "VAULT_SECRET_ID_COMMAND": "exit 1"},
+					"vault credentials can't reference Vault":                        {"VAULT_TOKEN": "vault://secret/data/vault#token"},
+					`reading cf-account-id from vault://kv/dns#missing: key "missing" not found in Vault secret kv/dns`: {
+						"VAULT_TOKEN":           "root",
+						"CLOUDFLARE_ACCOUNT_ID": "vault://kv/dns#missing",
+					},
+				}
+				for message, extra := range tests {
+					env := envy.MockEnv{}
+					g.Expect(env.Load(vaultEnv(extra))).To(Succeed())
+
+					app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+					err := app.Run([]string{"qrkdns", "sync"})
+					env.Restore()
+					g.Expect(err).To(MatchError(ContainSubstring(message)))
+				}
+			},
+		},
+		{
+			testCase: "returns errors building the Vault client",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(vaultEnv(map[string]string{"VAULT_TOKEN": "root"}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				options := controllers.VaultClientOptions
+				controllers.VaultClientOptions = append(options, func(client *vault.DefaultClient) error {
+					return errors.New("foo")
+				})
+				defer func() { controllers.VaultClientOptions = options }()
+
+				app := controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+				g.Expect(app.Run([]string{"qrkdns", "sync"})).To(MatchError(ContainSubstring("foo")))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
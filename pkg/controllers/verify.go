@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/markliederbach/qrkdns/pkg/clients/dns"
+	"github.com/markliederbach/qrkdns/pkg/clients/propagation"
+	"github.com/markliederbach/qrkdns/pkg/clients/resolver"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// PropagationClientOptions is used by testing to inject a mock client option
+	PropagationClientOptions = []propagation.LoadOption{}
+)
+
+const (
+	// VerifyFlag wraps the name of the command flag
+	VerifyFlag string = "verify"
+
+	// VerifyTimeoutFlag wraps the name of the command flag
+	VerifyTimeoutFlag string = "verify-timeout"
+
+	// VerifyIntervalFlag wraps the name of the command flag
+	VerifyIntervalFlag string = "verify-interval"
+
+	// VerifyNameserversFlag wraps the name of the command flag
+	VerifyNameserversFlag string = "verify-nameservers"
+
+	// VerifyPublicResolversFlag wraps the name of the command flag
+	VerifyPublicResolversFlag string = "verify-public-resolvers"
+)
+
+// resolverFlag returns the flag selecting the nameserver used for lookups
+func resolverFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    ResolverFlag,
+		Usage:   "Nameserver (host:port) used to resolve the record. Empty uses the system resolver",
+		EnvVars: []string{"RESOLVER"},
+		Value:   "1.1.1.1:53",
+	}
+}
+
+// verifyFlags returns the flags used to configure propagation verification
+func verifyFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:    VerifyFlag,
+			Usage:   "After a change, wait until the authoritative nameservers serve the new record",
+			EnvVars: []string{"VERIFY"},
+		},
+		&cli.DurationFlag{
+			Name:    VerifyTimeoutFlag,
+			Usage:   "Maximum time to wait for the change to propagate",
+			EnvVars: []string{"VERIFY_TIMEOUT"},
+			Value:   2 * time.Minute,
+		},
+		&cli.DurationFlag{
+			Name:    VerifyIntervalFlag,
+			Usage:   "Time between two rounds of queries",
+			EnvVars: []string{"VERIFY_INTERVAL"},
+			Value:   5 * time.Second,
+		},
+		&cli.StringSliceFlag{
+			Name:    VerifyNameserversFlag,
+			Usage:   "Nameservers (host:port) to query instead of discovering the zone's NS records",
+			EnvVars: []string{"VERIFY_NAMESERVERS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    VerifyPublicResolversFlag,
+			Usage:   "Public resolvers (host:port) that must also serve the change (e.g., 1.1.1.1:53,8.8.8.8:53)",
+			EnvVars: []string{"VERIFY_PUBLIC_RESOLVERS"},
+		},
+		resolverFlag(),
+	}
+}
+
+// verifyPropagation waits until every nameserver serves expected for name,
+// logging the status of each one
+func verifyPropagation(ctx context.Context, c *cli.Context, name, expected string) error {
+	discovery, err := resolver.NewClient(c.String(ResolverFlag), ResolverClientOptions...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build resolver client")
+		return err
+	}
+
+	client, err := propagation.NewClient(discovery, c.Duration(VerifyIntervalFlag), PropagationClientOptions...)
+	if err != nil {
+		log.WithError(err).Error("Failed to build propagation client")
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Duration(VerifyTimeoutFlag))
+	defer cancel()
+
+	servers := c.StringSlice(VerifyNameserversFlag)
+	if len(servers) == 0 {
+		servers, err = client.Nameservers(ctx, domainName(c))
+		if err != nil {
+			log.WithError(err).Error("Failed to discover authoritative nameservers")
+			return err
+		}
+	}
+	servers = append(servers, c.StringSlice(VerifyPublicResolversFlag)...)
+
+	log.WithField("servers", servers).Debug("Verifying propagation")
+	result, err := client.Verify(ctx, name, expected, servers)
+	for _, server := range result.Servers {
+		log.WithFields(log.Fields{
+			"server":   server.Server,
+			"answers":  server.Answers,
+			"in_sync":  server.InSync,
+			"attempts": server.Attempts,
+			"error":    server.Error,
+		}).Info("Propagation status")
+	}
+	if err != nil {
+		log.WithError(err).Error("Record did not propagate")
+		return err
+	}
+
+	log.WithField("record", dns.ToUnicode(name)).Info("Record propagated to every nameserver")
+	return nil
+}
@@ -0,0 +1,218 @@
+package controllers_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	sdk "github.com/cloudflare/cloudflare-go"
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/cloudflare"
+	"github.com/markliederbach/qrkdns/pkg/clients/propagation"
+	"github.com/markliederbach/qrkdns/pkg/clients/resolver"
+	"github.com/markliederbach/qrkdns/pkg/controllers"
+	"github.com/markliederbach/qrkdns/pkg/mocks"
+	. "github.com/onsi/gomega"
+	"github.com/urfave/cli/v2"
+)
+
+func TestVerify(t *testing.T) {
+	controllers.CloudflareClientOptions = append(
+		controllers.CloudflareClientOptions,
+		withMockSDKClient,
+	)
+	controllers.IPClientOptions = append(
+		controllers.IPClientOptions,
+		withMockHTTPClient,
+	)
+
+	// Lookups go to local nameservers rather than the mock resolver
+	resolverOptions := controllers.ResolverClientOptions
+	controllers.ResolverClientOptions = []resolver.LoadOption{}
+	defer func() { controllers.ResolverClientOptions = resolverOptions }()
+
+	// disable help text for tests
+	cli.AppHelpTemplate = ""
+
+	verifyEnv := func(extra map[string]string) map[string]string {
+		env := map[string]string{
+			"NETWORK_ID":            "bar",
+			"DOMAIN_NAME":           "foo.net",
+			"CLOUDFLARE_ACCOUNT_ID": "foo",
+			"CLOUDFLARE_API_TOKEN":  "bar",
+			"VERIFY":                "true",
+			"VERIFY_INTERVAL":       "10ms",
+		}
+		for key, value := range extra {
+			env[key] = value
+		}
+		return env
+	}
+
+	// queueChange makes the next sync create the record with the new IP
+	queueChange := func(g *WithT) {
+		g.Expect(envy.AddObjectReturns("Do", ipResponse("1.2.3.4"))).To(Succeed())
+		g.Expect(envy.AddObjectReturns("DNSRecords", []sdk.DNSRecord{})).To(Succeed())
+		g.Expect(envy.AddObjectReturns(
+			"CreateDNSRecord",
+			&sdk.DNSRecordResponse{
+				Result: cloudflare.ToCloudFlareDNSRecord(cloudflare.BuildDNSARecord("bar", "foo.net", "1.2.3.4")),
+			},
+		)).To(Succeed())
+	}
+
+	newSyncApp := func() *cli.App {
+		return controllers.NewQrkDNSApp("version123", []*cli.Command{controllers.SyncCommand()})
+	}
+
+	tests := []testRunner{
+		{
+			testCase: "verifies the change against the configured nameservers",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				server, err := mocks.NewMockDNSServer()
+				g.Expect(err).NotTo(HaveOccurred())
+				defer server.Close()
+				server.SetA("bar.foo.net", "1.2.3.4")
+
+				env := envy.MockEnv{}
+				err = env.Load(verifyEnv(map[string]string{"VERIFY_NAMESERVERS": server.Address}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				queueChange(g)
+
+				err = newSyncApp().Run([]string{"qrkdns", "sync"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(server.Queries()).To(BeNumerically(">", 0))
+			},
+		},
+		{
+			testCase: "discovers the authoritative nameservers and checks public resolvers",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				authoritative, err := mocks.NewMockDNSServer()
+				g.Expect(err).NotTo(HaveOccurred())
+				defer authoritative.Close()
+				authoritative.SetNS("foo.net", "ns1.foo.net")
+				authoritative.SetA("ns1.foo.net", "127.0.0.1")
+				authoritative.SetA("bar.foo.net", "1.2.3.4")
+
+				public, err := mocks.NewMockDNSServer()
+				g.Expect(err).NotTo(HaveOccurred())
+				defer public.Close()
+				public.SetA("bar.foo.net", "1.2.3.4")
+
+				_, port, err := net.SplitHostPort(authoritative.Address)
+				g.Expect(err).NotTo(HaveOccurred())
+
+				propagationOptions := controllers.PropagationClientOptions
+				controllers.PropagationClientOptions = []propagation.LoadOption{
+					func(client *propagation.DefaultClient) error {
+						client.Port = port
+						return nil
+					},
+				}
+				defer func() { controllers.PropagationClientOptions = propagationOptions }()
+
+				env := envy.MockEnv{}
+				err = env.Load(verifyEnv(map[string]string{
+					"RESOLVER":                authoritative.Address,
+					"VERIFY_PUBLIC_RESOLVERS": public.Address,
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				queueChange(g)
+
+				err = newSyncApp().Run([]string{"qrkdns", "sync"})
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(public.Queries()).To(BeNumerically(">", 0))
+			},
+		},
+		{
+			testCase: "returns error when a nameserver keeps serving the old address",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				server, err := mocks.NewMockDNSServer()
+				g.Expect(err).NotTo(HaveOccurred())
+				defer server.Close()
+				server.SetA("bar.foo.net", "5.6.7.8")
+
+				env := envy.MockEnv{}
+				err = env.Load(verifyEnv(map[string]string{
+					"VERIFY_NAMESERVERS": server.Address,
+					"VERIFY_TIMEOUT":     "100ms",
+				}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				queueChange(g)
+
+				err = newSyncApp().Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError(fmt.Sprintf("record bar.foo.net not served by [%v] before deadline", server.Address)))
+			},
+		},
+		{
+			testCase: "returns error discovering nameservers",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				server, err := mocks.NewMockDNSServer()
+				g.Expect(err).NotTo(HaveOccurred())
+				defer server.Close()
+				server.SetNS("foo.net")
+
+				env := envy.MockEnv{}
+				err = env.Load(verifyEnv(map[string]string{"RESOLVER": server.Address}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				queueChange(g)
+
+				err = newSyncApp().Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(HaveOccurred())
+			},
+		},
+		{
+			testCase: "returns errors building clients",
+			runner: func(tt *testing.T) {
+				g := NewGomegaWithT(tt)
+
+				env := envy.MockEnv{}
+				err := env.Load(verifyEnv(map[string]string{}))
+				g.Expect(err).NotTo(HaveOccurred())
+				defer env.Restore()
+
+				controllers.ResolverClientOptions = []resolver.LoadOption{
+					func(client *resolver.DefaultClient) error {
+						return fmt.Errorf("boo")
+					},
+				}
+				queueChange(g)
+				err = newSyncApp().Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("boo"))
+				controllers.ResolverClientOptions = []resolver.LoadOption{}
+
+				propagationOptions := controllers.PropagationClientOptions
+				controllers.PropagationClientOptions = []propagation.LoadOption{
+					func(client *propagation.DefaultClient) error {
+						return fmt.Errorf("boo")
+					},
+				}
+				defer func() { controllers.PropagationClientOptions = propagationOptions }()
+
+				queueChange(g)
+				err = newSyncApp().Run([]string{"qrkdns", "sync"})
+				g.Expect(err).To(MatchError("boo"))
+			},
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.testCase, test.runner)
+	}
+}
@@ -29,6 +29,26 @@ var (
 
 	// DefaultZoneID is used as the default option for the corresponding function
 	DefaultZoneID string = "zone1234"
+
+	// DefaultAPITokenVerifyBody is used as the default option for the corresponding function
+	DefaultAPITokenVerifyBody sdk.APITokenVerifyBody = sdk.APITokenVerifyBody{
+		ID:     "token1234",
+		Status: "active",
+	}
+
+	// DefaultUser is used as the default option for the corresponding function
+	DefaultUser sdk.User = sdk.User{
+		ID:    "user1234",
+		Email: "user@foo.net",
+	}
+
+	// DefaultZone is used as the default option for the corresponding function
+	DefaultZone sdk.Zone = sdk.Zone{
+		ID:          DefaultZoneID,
+		Name:        "foo.net",
+		Permissions: []string{"#dns_records:read", "#dns_records:edit", "#zone:read"},
+		Account:     sdk.Account{ID: "foo"},
+	}
 )
 
 // MockCloudflareSDKClient mocks the internal client from Cloudflare
@@ -36,12 +56,15 @@ type MockCloudflareSDKClient struct{}
 
 func init() {
 	sdkFunctions := []string{
-		"ZoneIDByName",
+		"ListZonesContext",
 		"DNSRecords",
 		"DNSRecord",
 		"CreateDNSRecord",
 		"UpdateDNSRecord",
 		"DeleteDNSRecord",
+		"VerifyAPIToken",
+		"ZoneDetails",
+		"UserDetails",
 	}
 	for _, functionName := range sdkFunctions {
 		envy.ObjectChannels[functionName] = make(chan interface{}, 100)
@@ -51,16 +74,16 @@ func init() {
 	}
 }
 
-// ZoneIDByName implements corresponding client function
-func (c *MockCloudflareSDKClient) ZoneIDByName(zoneName string) (string, error) {
-	functionName := "ZoneIDByName"
+// ListZonesContext implements corresponding client function
+func (c *MockCloudflareSDKClient) ListZonesContext(ctx context.Context, opts ...sdk.ReqOption) (sdk.ZonesResponse, error) {
+	functionName := "ListZonesContext"
 	obj := envy.GetObject(functionName)
 	err := envy.GetError(functionName)
 	switch obj := obj.(type) {
-	case string:
+	case sdk.ZonesResponse:
 		return obj, err
 	default:
-		return DefaultZoneID, err
+		return sdk.ZonesResponse{Result: []sdk.Zone{DefaultZone}}, err
 	}
 }
 
@@ -117,6 +140,45 @@ func (c *MockCloudflareSDKClient) DeleteDNSRecord(ctx context.Context, zoneID st
 	return err
 }
 
+// VerifyAPIToken implements corresponding client function
+func (c *MockCloudflareSDKClient) VerifyAPIToken(ctx context.Context) (sdk.APITokenVerifyBody, error) {
+	functionName := "VerifyAPIToken"
+	obj := envy.GetObject(functionName)
+	err := envy.GetError(functionName)
+	switch obj := obj.(type) {
+	case sdk.APITokenVerifyBody:
+		return obj, err
+	default:
+		return DefaultAPITokenVerifyBody, err
+	}
+}
+
+// ZoneDetails implements corresponding client function
+func (c *MockCloudflareSDKClient) ZoneDetails(ctx context.Context, zoneID string) (sdk.Zone, error) {
+	functionName := "ZoneDetails"
+	obj := envy.GetObject(functionName)
+	err := envy.GetError(functionName)
+	switch obj := obj.(type) {
+	case sdk.Zone:
+		return obj, err
+	default:
+		return DefaultZone, err
+	}
+}
+
+// UserDetails implements corresponding client function
+func (c *MockCloudflareSDKClient) UserDetails(ctx context.Context) (sdk.User, error) {
+	functionName := "UserDetails"
+	obj := envy.GetObject(functionName)
+	err := envy.GetError(functionName)
+	switch obj := obj.(type) {
+	case sdk.User:
+		return obj, err
+	default:
+		return DefaultUser, err
+	}
+}
+
 func boolPtr(val bool) *bool {
 	return &val
 }
@@ -0,0 +1,164 @@
+package mocks
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	dnsTypeA  uint16 = 1
+	dnsTypeNS uint16 = 2
+
+	dnsHeaderLength int = 12
+)
+
+// MockDNSServer is a minimal UDP nameserver answering A and NS queries from memory.
+// It lets tests point real resolvers at a local server.
+type MockDNSServer struct {
+	// Address is the host:port the server listens on
+	Address string
+
+	conn    net.PacketConn
+	mu      sync.Mutex
+	records map[string]map[uint16][]string
+	queries int
+}
+
+// NewMockDNSServer starts a nameserver on a random loopback port
+func NewMockDNSServer() (*MockDNSServer, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := &MockDNSServer{
+		Address: conn.LocalAddr().String(),
+		conn:    conn,
+		records: make(map[string]map[uint16][]string),
+	}
+	go server.serve()
+	return server, nil
+}
+
+// SetA replaces the A records served for name
+func (s *MockDNSServer) SetA(name string, addresses ...string) {
+	s.set(name, dnsTypeA, addresses)
+}
+
+// SetNS replaces the NS records served for zone
+func (s *MockDNSServer) SetNS(zone string, hosts ...string) {
+	s.set(zone, dnsTypeNS, hosts)
+}
+
+// Queries returns the number of queries answered so far
+func (s *MockDNSServer) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+// Close stops the server
+func (s *MockDNSServer) Close() error {
+	return s.conn.Close()
+}
+
+func (s *MockDNSServer) set(name string, recordType uint16, values []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name = canonicalDNSName(name)
+	if _, ok := s.records[name]; !ok {
+		s.records[name] = make(map[uint16][]string)
+	}
+	s.records[name][recordType] = values
+}
+
+func (s *MockDNSServer) serve() {
+	buffer := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		if response := s.answer(buffer[:n]); response != nil {
+			_, _ = s.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+// answer builds the response to a single-question query
+func (s *MockDNSServer) answer(query []byte) []byte {
+	if len(query) < dnsHeaderLength {
+		return nil
+	}
+
+	labels := []string{}
+	offset := dnsHeaderLength
+	for offset < len(query) && query[offset] != 0 {
+		length := int(query[offset])
+		if offset+1+length > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[offset+1:offset+1+length]))
+		offset += 1 + length
+	}
+	// Skip the terminating zero, then the type and class
+	offset++
+	if offset+4 > len(query) {
+		return nil
+	}
+	questionType := binary.BigEndian.Uint16(query[offset:])
+	questionEnd := offset + 4
+
+	s.mu.Lock()
+	s.queries++
+	byType, found := s.records[canonicalDNSName(strings.Join(labels, "."))]
+	values := byType[questionType]
+	s.mu.Unlock()
+
+	// Response, authoritative, recursion available, echoing recursion desired
+	flags := uint16(0x8000|0x0400|0x0080) | binary.BigEndian.Uint16(query[2:])&0x0100
+	if !found {
+		flags |= 3 // NXDOMAIN
+	}
+
+	response := make([]byte, dnsHeaderLength, 512)
+	copy(response, query[:2])
+	binary.BigEndian.PutUint16(response[2:], flags)
+	binary.BigEndian.PutUint16(response[4:], 1)
+	binary.BigEndian.PutUint16(response[6:], uint16(len(values)))
+	response = append(response, query[dnsHeaderLength:questionEnd]...)
+
+	for _, value := range values {
+		var data []byte
+		switch questionType {
+		case dnsTypeA:
+			data = net.ParseIP(value).To4()
+		case dnsTypeNS:
+			data = encodeDNSName(value)
+		}
+		// Pointer to the name in the question, then type, class IN and a 60s TTL
+		response = append(response, 0xC0, byte(dnsHeaderLength))
+		response = binary.BigEndian.AppendUint16(response, questionType)
+		response = binary.BigEndian.AppendUint16(response, 1)
+		response = binary.BigEndian.AppendUint32(response, 60)
+		response = binary.BigEndian.AppendUint16(response, uint16(len(data)))
+		response = append(response, data...)
+	}
+	return response
+}
+
+func canonicalDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func encodeDNSName(name string) []byte {
+	encoded := []byte{}
+	for _, label := range strings.Split(canonicalDNSName(name), ".") {
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+	return append(encoded, 0)
+}
@@ -0,0 +1,49 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/hooks"
+)
+
+var (
+	// Assert mock runner matches the correct interface
+	_ hooks.CommandRunner = &MockCommandRunner{}
+
+	// DefaultCommandOutput is the default output returned by the mocked command
+	DefaultCommandOutput []byte = []byte("hook ran\n")
+)
+
+// MockCommandRunner mocks the shell used to run hooks and records the calls
+type MockCommandRunner struct {
+	Commands []string
+	Envs     [][]string
+}
+
+func init() {
+	sdkFunctions := []string{
+		"RunCommand",
+	}
+	for _, functionName := range sdkFunctions {
+		envy.ObjectChannels[functionName] = make(chan interface{}, 100)
+		envy.ErrorChannels[functionName] = make(chan error, 100)
+		envy.DefaultObjects[functionName] = struct{}{}
+		envy.DefaultErrors[functionName] = nil
+	}
+}
+
+// RunCommand implements corresponding client function
+func (c *MockCommandRunner) RunCommand(ctx context.Context, command string, env []string) ([]byte, error) {
+	functionName := "RunCommand"
+	c.Commands = append(c.Commands, command)
+	c.Envs = append(c.Envs, env)
+	obj := envy.GetObject(functionName)
+	err := envy.GetError(functionName)
+	switch obj := obj.(type) {
+	case []byte:
+		return obj, err
+	default:
+		return DefaultCommandOutput, err
+	}
+}
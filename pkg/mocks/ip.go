@@ -19,12 +19,6 @@ var (
 
 	// DefaultExternalIPAddress is the default IP address returned
 	DefaultExternalIPAddress = "1.2.3.4"
-
-	// DefaultDoResponse is the default response for this function
-	DefaultDoResponse *http.Response = &http.Response{
-		StatusCode: 200,
-		Body:       io.NopCloser(strings.NewReader(DefaultExternalIPAddress)),
-	}
 )
 
 // MockHTTPClient mocks the internal client for http.Client
@@ -66,6 +60,10 @@ func (c *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	case *http.Response:
 		return obj, err
 	default:
-		return DefaultDoResponse, err
+		// Every call gets its own body, as reading one drains it
+		return &http.Response{
+			StatusCode: 200,
+			Body:       io.NopCloser(strings.NewReader(DefaultExternalIPAddress)),
+		}, err
 	}
 }
@@ -0,0 +1,28 @@
+package mocks
+
+import (
+	"path/filepath"
+	"runtime"
+)
+
+const (
+	// MMDBASN is a GeoLite2 ASN database (IPv6, 24 bit records) with
+	// 1.2.3.0/24 and 8.8.8.0/24 in AS3320 (Deutsche Telekom AG), 5.6.7.0/24
+	// in AS9009 (M247 Europe SRL) and 2a00:1450::/32 in AS15169 (Google LLC)
+	MMDBASN string = "GeoLite2-ASN-Test.mmdb"
+
+	// MMDBCountry is a GeoLite2 Country database (IPv6) with 1.2.3.0/24 in
+	// DE and 8.8.8.0/24 in US
+	MMDBCountry string = "GeoLite2-Country-Test.mmdb"
+
+	// MMDBIPinfo is an IPinfo country_asn database (IPv4) with 1.2.3.0/24
+	// in AS3320 and DE, and 5.6.7.0/24 in AS3320 and NL
+	MMDBIPinfo string = "ipinfo-country_asn-Test.mmdb"
+)
+
+// MMDBFixture returns the path of a MaxMind DB file from the testdata
+// directory
+func MMDBFixture(name string) string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "testdata", name)
+}
@@ -0,0 +1,61 @@
+package mocks
+
+import (
+	"context"
+	"net/smtp"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/email"
+	"github.com/markliederbach/qrkdns/pkg/clients/notify"
+)
+
+var (
+	// Assert mock notifier matches the correct interface
+	_ notify.Notifier = &MockNotifier{}
+
+	// Assert mock client matches the correct interface
+	_ email.SMTPClient = &MockSMTPClient{}
+)
+
+// MockNotifier mocks a notification backend and records delivered events
+type MockNotifier struct {
+	Events []notify.Event
+}
+
+// MockSMTPClient mocks the internal mail sender
+type MockSMTPClient struct {
+	Messages [][]byte
+}
+
+func init() {
+	sdkFunctions := []string{
+		"Notify",
+		"SendMail",
+	}
+	for _, functionName := range sdkFunctions {
+		envy.ObjectChannels[functionName] = make(chan interface{}, 100)
+		envy.ErrorChannels[functionName] = make(chan error, 100)
+		envy.DefaultObjects[functionName] = struct{}{}
+		envy.DefaultErrors[functionName] = nil
+	}
+}
+
+// Notify implements corresponding client function
+func (c *MockNotifier) Notify(ctx context.Context, event notify.Event) error {
+	functionName := "Notify"
+	err := envy.GetError(functionName)
+	if err == nil {
+		c.Events = append(c.Events, event)
+	}
+	return err
+}
+
+// SendMail implements corresponding client function
+func (c *MockSMTPClient) SendMail(ctx context.Context, addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+	functionName := "SendMail"
+	err := envy.GetError(functionName)
+	if err == nil {
+		c.Messages = append(c.Messages, msg)
+	}
+	return err
+}
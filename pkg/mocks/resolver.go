@@ -0,0 +1,62 @@
+package mocks
+
+import (
+	"context"
+	"net"
+
+	"github.com/markliederbach/go-envy"
+	"github.com/markliederbach/qrkdns/pkg/clients/resolver"
+)
+
+var (
+	// Assert mock client matches the correct interface
+	_ resolver.Resolver = &MockResolver{}
+
+	// DefaultLookupIPResponse is the default response for this function
+	DefaultLookupIPResponse []net.IP = []net.IP{net.ParseIP(DefaultExternalIPAddress)}
+
+	// DefaultLookupNSResponse is the default response for this function
+	DefaultLookupNSResponse []*net.NS = []*net.NS{{Host: "ns1.foo.net."}}
+)
+
+// MockResolver mocks the internal DNS resolver
+type MockResolver struct{}
+
+func init() {
+	sdkFunctions := []string{
+		"LookupIP",
+		"LookupNS",
+	}
+	for _, functionName := range sdkFunctions {
+		envy.ObjectChannels[functionName] = make(chan interface{}, 100)
+		envy.ErrorChannels[functionName] = make(chan error, 100)
+		envy.DefaultObjects[functionName] = struct{}{}
+		envy.DefaultErrors[functionName] = nil
+	}
+}
+
+// LookupIP implements corresponding client function
+func (c *MockResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	functionName := "LookupIP"
+	obj := envy.GetObject(functionName)
+	err := envy.GetError(functionName)
+	switch obj := obj.(type) {
+	case []net.IP:
+		return obj, err
+	default:
+		return DefaultLookupIPResponse, err
+	}
+}
+
+// LookupNS implements corresponding client function
+func (c *MockResolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	functionName := "LookupNS"
+	obj := envy.GetObject(functionName)
+	err := envy.GetError(functionName)
+	switch obj := obj.(type) {
+	case []*net.NS:
+		return obj, err
+	default:
+		return DefaultLookupNSResponse, err
+	}
+}
@@ -40,5 +40,13 @@ func (c *MockSchedulerClient) Do(jobFun interface{}, params ...interface{}) (*go
 
 }
 
+// Cron implements corresponding client function
+func (c *MockSchedulerClient) Cron(cronExpression string) *gocron.Scheduler {
+	return nil
+}
+
+// Clear implements corresponding client function
+func (c *MockSchedulerClient) Clear() {}
+
 // StartBlocking implements corresponding client function
 func (c *MockSchedulerClient) StartBlocking() {}
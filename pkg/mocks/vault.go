@@ -0,0 +1,224 @@
+package mocks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// MockVaultServer is a minimal stand-in for the Vault HTTP API serving
+// secrets from memory. It supports token, AppRole and Kubernetes auth,
+// token lookup and renewal, lease renewal, and KV v1/v2 reads.
+type MockVaultServer struct {
+	// URL is the address of the server
+	URL string
+	// TokenTTL is the lease duration, in seconds, of issued tokens
+	TokenTTL int64
+
+	server    *httptest.Server
+	mu        sync.Mutex
+	tokens    map[string]bool
+	logins    map[string]string
+	secrets   map[string]mockVaultSecret
+	requests  []string
+	namespace string
+	issued    int
+}
+
+// mockVaultSecret is a secret stored by the mock server
+type mockVaultSecret struct {
+	data      map[string]interface{}
+	version2  bool
+	leaseTTL  int64
+	leaseID   string
+	renewable bool
+}
+
+// NewMockVaultServer starts a Vault stand-in on a random loopback port
+func NewMockVaultServer() *MockVaultServer {
+	s := &MockVaultServer{
+		TokenTTL: 3600,
+		tokens:   make(map[string]bool),
+		logins:   make(map[string]string),
+		secrets:  make(map[string]mockVaultSecret),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL
+	return s
+}
+
+// AddToken accepts token for every request
+func (s *MockVaultServer) AddToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[token] = true
+}
+
+// AddAppRole accepts logins with the role ID and secret ID
+func (s *MockVaultServer) AddAppRole(roleID, secretID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logins["approle:"+roleID] = secretID
+}
+
+// AddKubernetesRole accepts logins to role with the service account token jwt
+func (s *MockVaultServer) AddKubernetesRole(role, jwt string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logins["kubernetes:"+role] = jwt
+}
+
+// SetKV1 stores a KV version 1 secret at path (e.g., kv/dns)
+func (s *MockVaultServer) SetKV1(path string, data map[string]interface{}) {
+	s.set(path, mockVaultSecret{data: data})
+}
+
+// SetKV2 stores a KV version 2 secret at path, including its data/
+// segment (e.g., secret/data/dns)
+func (s *MockVaultServer) SetKV2(path string, data map[string]interface{}) {
+	s.set(path, mockVaultSecret{data: data, version2: true})
+}
+
+// SetLeased stores a dynamic secret at path with a renewable lease of ttl seconds
+func (s *MockVaultServer) SetLeased(path string, data map[string]interface{}, ttl int64) {
+	s.set(path, mockVaultSecret{data: data, leaseTTL: ttl, leaseID: path + "/lease", renewable: true})
+}
+
+// RevokeTokens rejects every token issued so far, as if they had expired
+func (s *MockVaultServer) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for token := range s.tokens {
+		if strings.HasPrefix(token, "s.issued") {
+			delete(s.tokens, token)
+		}
+	}
+}
+
+// Requests returns the "METHOD path" of every request received so far
+func (s *MockVaultServer) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.requests...)
+}
+
+// Namespace returns the namespace header of the last request
+func (s *MockVaultServer) Namespace() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.namespace
+}
+
+// Close stops the server
+func (s *MockVaultServer) Close() {
+	s.server.Close()
+}
+
+func (s *MockVaultServer) set(path string, secret mockVaultSecret) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[path] = secret
+}
+
+func (s *MockVaultServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	s.requests = append(s.requests, fmt.Sprintf("%v %v", r.Method, path))
+	s.namespace = r.Header.Get("X-Vault-Namespace")
+
+	body := map[string]string{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	if strings.HasPrefix(path, "auth/") && strings.HasSuffix(path, "/login") {
+		role, credential := body["role_id"], body["secret_id"]
+		key := "approle:" + role
+		if role == "" {
+			role, credential = body["role"], body["jwt"]
+			key = "kubernetes:" + role
+		}
+		expected, ok := s.logins[key]
+		if !ok || expected != credential {
+			s.write(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid credentials"}})
+			return
+		}
+		s.issued++
+		token := fmt.Sprintf("s.issued%v", s.issued)
+		s.tokens[token] = true
+		s.write(w, http.StatusOK, s.auth(token))
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if !s.tokens[token] {
+		s.write(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+
+	switch {
+	case path == "auth/token/lookup-self":
+		s.write(w, http.StatusOK, map[string]interface{}{
+			"data": map[string]interface{}{"ttl": s.TokenTTL, "renewable": s.TokenTTL > 0},
+		})
+	case path == "auth/token/renew-self":
+		s.write(w, http.StatusOK, s.auth(token))
+	case path == "sys/leases/renew":
+		for _, secret := range s.secrets {
+			if secret.leaseID != "" && secret.leaseID == body["lease_id"] {
+				s.write(w, http.StatusOK, map[string]interface{}{
+					"lease_id":       secret.leaseID,
+					"lease_duration": secret.leaseTTL,
+					"renewable":      secret.renewable,
+				})
+				return
+			}
+		}
+		s.write(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"lease not found or lease is not renewable"}})
+	default:
+		secret, ok := s.secrets[path]
+		switch {
+		case !ok:
+			s.write(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+		case secret.version2:
+			s.write(w, http.StatusOK, map[string]interface{}{
+				"data": map[string]interface{}{
+					"data":     secret.data,
+					"metadata": map[string]interface{}{"version": 1},
+				},
+			})
+		case secret.leaseID != "":
+			s.write(w, http.StatusOK, map[string]interface{}{
+				"lease_id":       secret.leaseID,
+				"lease_duration": secret.leaseTTL,
+				"renewable":      secret.renewable,
+				"data":           secret.data,
+			})
+		default:
+			// KV v1 returns its refresh interval as a lease duration
+			s.write(w, http.StatusOK, map[string]interface{}{
+				"lease_duration": 2764800,
+				"data":           secret.data,
+			})
+		}
+	}
+}
+
+func (s *MockVaultServer) auth(token string) map[string]interface{} {
+	return map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token":   token,
+			"lease_duration": s.TokenTTL,
+			"renewable":      true,
+		},
+	}
+}
+
+func (s *MockVaultServer) write(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}